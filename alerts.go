@@ -0,0 +1,280 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of alert rule
+const (
+	heardDropRule   = "heard-drop"   // A station's heard-percentage fell more than Threshold percent below its trailing average
+	minCheckinsRule = "min-checkins" // Fewer than Threshold stations took part in the net
+)
+
+// One alert rule, from an [[Alerts]] table in reception.cfg
+type alertRule struct {
+	Rule      string  // heardDropRule or minCheckinsRule
+	Threshold float64 // Percent drop for heardDropRule; minimum number of stations for minCheckinsRule
+	Window    int     // Number of previous sessions in the trailing average for heardDropRule (default 5)
+}
+
+// History of each station's heard-percentage on this run's frequency and map type, one map per session (keyed by
+// call sign), oldest session first. Rows of the history file for other frequencies and map types are kept as they
+// are, since their percentages aren't comparable with this run's.
+type stationHistory struct {
+	sessions []string
+	heardPct map[string]map[string]float64
+	others   [][]string
+}
+
+// Function checkAlerts records this session's statistics in the history file, if there is one, and then
+// evaluates the alert rules against them. Alerts are handed to notify. A relative history file path is taken from
+// the output directory.
+//
+// A session is one day's reports on one frequency, for one map type: running again on the same day replaces that
+// day's history rather than adding to it, so regenerating maps doesn't skew the trailing averages, while maps of
+// another band or receive maps keep a history of their own.
+func checkAlerts(reports map[string]map[string]string, receivers, transmitters map[string]bool, operators map[string]operatorData, icons map[string]image.Image) {
+	session := netDate()
+
+	current := make(map[string]float64)
+	for transmitter := range transmitters {
+		current[transmitter] = computeStats(transmitter, reports, operators, icons).heardPct
+	}
+
+	history := stationHistory{heardPct: make(map[string]map[string]float64)}
+	if cfg.HistoryFile != "" {
+		historyPath := cfg.HistoryFile
+		if !filepath.IsAbs(historyPath) {
+			historyPath = filepath.Join(cfg.OutputDirectory, historyPath) // Kept with the maps, like stats.csv
+		}
+		history = loadHistory(historyPath, cfg.Frequency, currentMapType())
+		saveHistory(historyPath, history, cfg.Frequency, currentMapType(), session, current)
+	}
+
+	// Everyone who appears in the reports took part in the net
+	checkins := make(map[string]bool)
+	for call := range receivers {
+		checkins[call] = true
+	}
+	for call := range transmitters {
+		checkins[call] = true
+	}
+
+	var alerts []string
+	for _, rule := range cfg.Alerts {
+		switch rule.Rule {
+		case heardDropRule:
+			alerts = append(alerts, heardDropAlerts(rule, history, session, current)...)
+		case minCheckinsRule:
+			if float64(len(checkins)) < rule.Threshold {
+				alerts = append(alerts, fmt.Sprintf("Only %d stations checked in (alert threshold is %.0f)", len(checkins), rule.Threshold))
+			}
+		default:
+			fatalf("unknown alert rule %q in reception.cfg; use %q or %q", rule.Rule, heardDropRule, minCheckinsRule)
+		}
+	}
+
+	notify(alerts)
+}
+
+// Function heardDropAlerts returns an alert for each station whose heard-percentage this session is more than
+// rule.Threshold percent below its average over the previous rule.Window sessions. Stations with no history
+// aren't checked.
+func heardDropAlerts(rule alertRule, history stationHistory, session string, current map[string]float64) []string {
+	window := rule.Window
+	if window <= 0 {
+		window = 5
+	}
+
+	// The trailing window is the most recent sessions before this one
+	var previous []string
+	for _, s := range history.sessions {
+		if s != session {
+			previous = append(previous, s)
+		}
+	}
+	if len(previous) > window {
+		previous = previous[len(previous)-window:]
+	}
+
+	var alerts []string
+	for _, call := range sortedKeysFloat(current) {
+		var sum float64
+		var n int
+		for _, s := range previous {
+			if pct, present := history.heardPct[s][call]; present {
+				sum += pct
+				n++
+			}
+		}
+		if n == 0 || sum == 0 {
+			continue
+		}
+
+		average := sum / float64(n)
+		drop := 100.0 * (average - current[call]) / average
+		if drop > rule.Threshold {
+			heard := "was heard by"
+			if cfg.RcvMapFlag {
+				heard = "heard"
+			}
+			alerts = append(alerts, fmt.Sprintf("%s %s %.0f%% of the roster, down %.0f%% from its trailing average of %.0f%%",
+				call, heard, current[call], drop, average))
+		}
+	}
+	return alerts
+}
+
+// Function notify delivers alerts: they're always printed, and also emailed if cfg.AlertEmail is set and posted
+// to Discord if cfg.DiscordAlerts is.
+// Failing to send an alert is reported but isn't fatal, since the maps have already been generated.
+func notify(alerts []string) {
+	if len(alerts) == 0 {
+		return
+	}
+
+	fmt.Println("\nALERTS:")
+	for _, alert := range alerts {
+		fmt.Println("  " + alert)
+	}
+
+	if cfg.AlertEmail != "" {
+		to := strings.Split(strings.ReplaceAll(cfg.AlertEmail, " ", ""), ",")
+		body := "Reception alerts for the " + cfg.Frequency + " net on " + netDate() + ":\n\n  " +
+			strings.Join(alerts, "\n  ") + "\n"
+		if err := sendMail(to, "Reception alerts: "+cfg.Frequency, body, nil); err != nil {
+			fmt.Println("Can't email alerts:", err)
+		}
+	}
+
+	if cfg.DiscordAlerts {
+		content := "**Reception alerts: " + cfg.Frequency + " net, " + netDate() + "**\n" + strings.Join(alerts, "\n")
+		if err := postDiscordMessage(content, nil); err != nil {
+			fmt.Println("Can't post alerts to Discord:", err)
+		}
+	}
+}
+
+// Function loadHistory reads the history file's sessions on a frequency, for a map type. Each record contains 5
+// values:
+//   - Session (date of the net, YYYY-MM-DD)
+//   - Frequency
+//   - Map type (xmit or rcvr)
+//   - Call sign
+//   - Percentage of the roster that heard the station, or that it heard on a receive map
+// A missing file is an empty history.
+func loadHistory(csvFile, frequency, mapType string) stationHistory {
+	history := stationHistory{heardPct: make(map[string]map[string]float64)}
+
+	f, err := os.Open(csvFile)
+	if os.IsNotExist(err) {
+		return history
+	}
+	if err != nil {
+		fatalln("couldn't open the history file:", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(bufio.NewReader(f))
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatalln("error reading history file", csvFile+":", err)
+		}
+		line, _ := r.FieldPos(0)
+		if len(record) < 5 {
+			fatalf("history file %s, line %d: only %d values: %v", csvFile, line, len(record), record)
+		}
+
+		pct, err := strconv.ParseFloat(record[4], 64)
+		if err != nil {
+			fatalf("history file %s, line %d: can't parse heard percentage: %s", csvFile, line, err)
+		}
+		if record[1] != frequency || record[2] != mapType {
+			history.others = append(history.others, record)
+			continue
+		}
+
+		session := record[0]
+		if history.heardPct[session] == nil {
+			history.heardPct[session] = make(map[string]float64)
+			history.sessions = append(history.sessions, session)
+		}
+		history.heardPct[session][record[3]] = pct
+	}
+
+	sort.Strings(history.sessions) // Dates sort chronologically
+	return history
+}
+
+// Function saveHistory rewrites the history file with this session's statistics on a frequency, for a map type,
+// in place of any earlier ones from the same session, frequency and map type.
+func saveHistory(csvFile string, history stationHistory, frequency, mapType, session string, current map[string]float64) {
+	rows := history.others
+	for _, s := range history.sessions {
+		if s == session {
+			continue
+		}
+		for _, call := range sortedKeysFloat(history.heardPct[s]) {
+			rows = append(rows, []string{s, frequency, mapType, call, strconv.FormatFloat(history.heardPct[s][call], 'f', 1, 64)})
+		}
+	}
+	for _, call := range sortedKeysFloat(current) {
+		rows = append(rows, []string{session, frequency, mapType, call, strconv.FormatFloat(current[call], 'f', 1, 64)})
+	}
+
+	// Dates sort chronologically, so this keeps the file in date order
+	sort.SliceStable(rows, func(i, j int) bool {
+		for c := 0; c < 4; c++ {
+			if rows[i][c] != rows[j][c] {
+				return rows[i][c] < rows[j][c]
+			}
+		}
+		return false
+	})
+
+	err := writeFileAtomic(csvFile, func(f io.Writer) error {
+		w := csv.NewWriter(f)
+		w.WriteAll(rows)
+		return w.Error()
+	})
+	if err != nil {
+		fatalln("couldn't write the history file:", err)
+	}
+}
+
+// Function sortedKeysFloat returns the keys of a map of call signs in alphabetical order
+func sortedKeysFloat(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Function TestHistorySameDay runs the net's maps again on the same day: receive maps, maps of another band, and
+// then the transmit maps a second time. Only the second transmit run may replace the first's history, and the
+// others mustn't be compared with it.
+func TestHistorySameDay(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history.csv")
+	defer func(saved config) { cfg = saved }(cfg)
+	cfg = config{}
+
+	const band, otherBand = "446.000 MHz Simplex", "146.535 MHz Simplex"
+	runs := []struct {
+		frequency, mapType, session string
+		heardPct                    float64
+	}{
+		{band, xmitMapType, "2024-03-05", 80},
+		{band, xmitMapType, "2024-03-12", 60},
+		{band, rcvrMapType, "2024-03-12", 20},
+		{otherBand, xmitMapType, "2024-03-12", 40},
+		{band, xmitMapType, "2024-03-12", 50},
+	}
+	for _, run := range runs {
+		history := loadHistory(file, run.frequency, run.mapType)
+		saveHistory(file, history, run.frequency, run.mapType, run.session, map[string]float64{"W6OWI": run.heardPct})
+	}
+
+	want := map[string]map[string]map[string]float64{
+		band + " " + xmitMapType:      {"2024-03-05": {"W6OWI": 80}, "2024-03-12": {"W6OWI": 50}},
+		band + " " + rcvrMapType:      {"2024-03-12": {"W6OWI": 20}},
+		otherBand + " " + xmitMapType: {"2024-03-12": {"W6OWI": 40}},
+	}
+	for _, key := range []struct{ frequency, mapType string }{{band, xmitMapType}, {band, rcvrMapType}, {otherBand, xmitMapType}} {
+		history := loadHistory(file, key.frequency, key.mapType)
+		if got := history.heardPct; !reflect.DeepEqual(got, want[key.frequency+" "+key.mapType]) {
+			t.Errorf("%s %s history = %v, want %v", key.frequency, key.mapType, got, want[key.frequency+" "+key.mapType])
+		}
+	}
+
+	// The receive maps' 20% is a different quantity from the transmit maps' 80%, not a drop
+	rule := alertRule{Rule: heardDropRule, Threshold: 30}
+	if alerts := heardDropAlerts(rule, loadHistory(file, band, rcvrMapType), "2024-03-12", map[string]float64{"W6OWI": 20}); len(alerts) > 0 {
+		t.Errorf("receive map alerts = %v, want none", alerts)
+	}
+	if alerts := heardDropAlerts(rule, loadHistory(file, band, xmitMapType), "2024-03-12", map[string]float64{"W6OWI": 50}); len(alerts) != 1 {
+		t.Errorf("transmit map alerts = %v, want one for the drop from 80%%", alerts)
+	}
+}
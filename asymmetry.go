@@ -0,0 +1,201 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+
+	"github.com/golang/freetype"
+)
+
+// Asymmetric path report and map in the output directory, and the report's columns
+const (
+	asymmetryFile    = "asymmetry.csv"
+	asymmetryMapFile = "asymmetry-map.png"
+)
+
+var asymmetryHeadings = []string{"Station A", "Station B", "A Heard by B", "B Heard by A", "Asymmetry"}
+
+// Kinds of asymmetric path, and the colors their lines are drawn in on the asymmetry map
+const (
+	oneWayPath   = "one-way"
+	lopsidedPath = "lopsided"
+)
+
+var (
+	oneWayColor   = color.RGBA{0xd0, 0x20, 0x20, 0xff}
+	lopsidedColor = color.RGBA{0xe0, 0x90, 0x10, 0xff}
+)
+
+// A pair of stations that don't hear each other equally well. Signals go better from a to b: b hears a, but a
+// doesn't hear b, or hears it much worse.
+type asymmetricPath struct {
+	a, b       string
+	aByB, bByA string // The report each was given by the other, or "" if it wasn't heard
+	kind       string // oneWayPath or lopsidedPath
+}
+
+// Function heardReport returns the report b gave for hearing a, whichever way round the reports were loaded, or ""
+// if b didn't hear a (or gave a report with no icon)
+func heardReport(reports map[string]map[string]string, icons map[string]image.Image, a, b string) string {
+	report := reports[a][b]
+	if cfg.RcvMapFlag {
+		report = reports[b][a]
+	}
+	if _, present := icons[report]; !present {
+		return ""
+	}
+	return report
+}
+
+// Function findAsymmetricPaths returns the pairs of stations where one hears the other but not the reverse, or
+// where the scores of their reports of each other differ by AsymmetryThreshold or more. Only stations that both
+// transmitted and listened are compared, since a station that didn't transmit can't have been heard.
+func findAsymmetricPaths(reports map[string]map[string]string, icons map[string]image.Image) []asymmetricPath {
+	listened := make(map[string]bool)
+	for _, heard := range reports {
+		for receiver := range heard {
+			listened[receiver] = true
+		}
+	}
+	var stations []string
+	for _, station := range sortedCalls(stationsIn(reports)) {
+		if _, spoke := reports[station]; spoke && listened[station] {
+			stations = append(stations, station)
+		}
+	}
+
+	var paths []asymmetricPath
+	for i, a := range stations {
+		for _, b := range stations[i+1:] {
+			p := asymmetricPath{a: a, b: b, aByB: heardReport(reports, icons, a, b), bByA: heardReport(reports, icons, b, a)}
+			qa, okA := reportScore(p.aByB)
+			qb, okB := reportScore(p.bByA)
+			switch {
+			case (p.aByB == "") != (p.bByA == ""):
+				p.kind = oneWayPath
+			case okA && okB && cfg.AsymmetryThreshold > 0 && math.Abs(qa-qb) >= float64(cfg.AsymmetryThreshold):
+				p.kind = lopsidedPath
+			default:
+				continue
+			}
+			if p.aByB == "" || worseReport(p.aByB, p.bByA) && p.bByA != "" {
+				p.a, p.b, p.aByB, p.bByA = p.b, p.a, p.bByA, p.aByB // So signals go better from a to b
+			}
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// Function writeAsymmetry lists the asymmetric paths in asymmetry.csv in the output directory, one-way ones
+// first, since they usually mean local noise or a power problem worth chasing. It returns the file's name relative
+// to the output directory.
+func writeAsymmetry(paths []asymmetricPath) string {
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, asymmetryFile), func(f io.Writer) error {
+		w := csv.NewWriter(f)
+		w.Write(asymmetryHeadings)
+		for _, kind := range []string{oneWayPath, lopsidedPath} {
+			for _, p := range paths {
+				if p.kind != kind {
+					continue
+				}
+				aByB, bByA := p.aByB, p.bByA
+				if bByA == "" {
+					bByA = "not heard"
+				}
+				w.Write([]string{p.a, p.b, aByB, bByA, p.kind})
+			}
+		}
+		w.Flush()
+		return w.Error()
+	})
+	if err != nil {
+		fatalln("couldn't write the asymmetric path report:", err)
+	}
+	return asymmetryFile
+}
+
+// Function writeAsymmetryMap draws the asymmetric paths on the base map: a line between each pair, red for
+// one-way paths and orange for lopsided ones, with an arrowhead at the station that hears the other better. It
+// returns the file's name relative to the output directory.
+func writeAsymmetryMap(baseMap image.Image, icons map[string]image.Image, operators map[string]operatorData, paths []asymmetricPath) string {
+	mapPtr := image.NewRGBA(baseMap.Bounds())
+	draw.Draw(mapPtr, mapPtr.Bounds(), baseMap, baseMap.Bounds().Min, draw.Src)
+
+	size := float64(cfg.IconSize)
+	plotted := make(map[string]operatorData)
+	for _, p := range paths {
+		from, to := lookupOperator(operators, p.a), lookupOperator(operators, p.b)
+		if from.callsign == "" || to.callsign == "" || from.pixel == to.pixel {
+			continue
+		}
+		plotted[from.callsign], plotted[to.callsign] = from, to
+
+		c := oneWayColor
+		if p.kind == lopsidedPath {
+			c = lopsidedColor
+		}
+		start, end := toPixelPoint(from.pixel), toPixelPoint(to.pixel)
+		length := math.Hypot(end.x-start.x, end.y-start.y)
+		dx, dy := (end.x-start.x)/length, (end.y-start.y)/length
+		tip := pixelPoint{end.x - dx*size*0.6, end.y - dy*size*0.6} // Just short of the icon
+		base := pixelPoint{tip.x - dx*size/2, tip.y - dy*size/2}
+		head := []pixelPoint{tip, {base.x - dy*size/4, base.y + dx*size/4}, {base.x + dy*size/4, base.y - dx*size/4}}
+		strokeLine(mapPtr, start, base, size/12, c, p.kind == lopsidedPath)
+		fillPolygons(mapPtr, [][]pixelPoint{head}, c)
+	}
+
+	ctxPtr := newContext(mapPtr)
+	for _, call := range sortedCalls(operatorCalls(plotted)) {
+		plotIcon(mapPtr, icons[cfg.TransIcon], cfg.TransIcon, plotted[call], ctxPtr)
+	}
+
+	legend := []string{"Asymmetric paths: " + cfg.Frequency,
+		"Red: heard one way only; orange, dashed: reports differ by " + strconv.Itoa(cfg.AsymmetryThreshold) + " or more",
+		"Arrows point to the station that hears the other better"}
+	_, fontSize := legendFont()
+	setFont(ctxPtr, legendFont)
+	ascent := int(fontSize*cfg.FontDPI/72.0 + 0.5)
+	for i, line := range legend {
+		pt := freetype.Pt(ascent, ascent*2+i*int(float64(ascent)*cfg.FontLineSpacing+0.5))
+		if _, err := ctxPtr.DrawString(line, pt); err != nil {
+			fatalln("can't plot asymmetry map legend", err)
+		}
+	}
+	if cfg.ScaleBarFlag {
+		drawScaleBar(mapPtr, newGeoref(baseMap).metersPerPixel())
+	}
+	if cfg.NorthArrowFlag {
+		drawNorthArrow(mapPtr)
+	}
+	drawAttribution(mapPtr)
+	drawWatermark(mapPtr)
+
+	meta := outputMetadata(legend[0], pngText{"Paths", fmt.Sprint(len(paths))})
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, asymmetryMapFile), func(w io.Writer) error { return encodePNG(w, mapPtr, meta) })
+	if err != nil {
+		fatalf("Failed to write asymmetry map: %s", err)
+	}
+	return asymmetryMapFile
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Temporary files being written, so they can be removed if the program is stopped partway through one
+var (
+	tempFiles     = make(map[string]bool)
+	tempFilesLock sync.Mutex
+)
+
+// Function writeFileAtomic creates a file by calling write to fill in its contents. The contents go into a
+// hidden temporary file in the same directory, which is renamed to the real name only once it has been
+// completely written, so anything watching or serving the output directory (an index page, a sync client, a
+// web server) never sees a half-written file, even if a long run is interrupted. If write fails, the
+// temporary file is removed and any existing file by that name is left as it was.
+func writeFileAtomic(name string, write func(io.Writer) error) (err error) {
+	dir, base := filepath.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return err
+	}
+	trackTempFile(tmp.Name(), true)
+	defer trackTempFile(tmp.Name(), false)
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(tmp)
+	if err = write(w); err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	// A file that's come out the same as the one already there is left as it was, with its old modification
+	// time, so rsync and other tools that go by the time don't copy it again
+	if sameContents(tmp.Name(), name) {
+		return os.Remove(tmp.Name())
+	}
+
+	// Temporary files are private to the user; the finished file should be as readable as any other
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// Function sameContents returns whether two files hold the same bytes. A file that can't be read isn't the same
+// as anything.
+func sameContents(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	if bInfo, err := os.Stat(b); err != nil || !bInfo.Mode().IsRegular() || bInfo.Size() != aInfo.Size() {
+		return false
+	}
+	aData, err := ioutil.ReadFile(a)
+	if err != nil {
+		return false
+	}
+	bData, err := ioutil.ReadFile(b)
+	return err == nil && bytes.Equal(aData, bData)
+}
+
+// Function trackTempFile notes that a temporary file is being written, or that it's been renamed or removed
+func trackTempFile(name string, writing bool) {
+	tempFilesLock.Lock()
+	defer tempFilesLock.Unlock()
+	if writing {
+		tempFiles[name] = true
+	} else {
+		delete(tempFiles, name)
+	}
+}
+
+// Function removeTempFiles removes the temporary files being written, before the program stops without finishing
+// them
+func removeTempFiles() {
+	tempFilesLock.Lock()
+	defer tempFilesLock.Unlock()
+	for name := range tempFiles {
+		os.Remove(name)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+)
+
+const badgeFile = "badge.png"
+
+// Colors used when drawing the coverage badge. The band at the top is colored by the coverage score.
+var (
+	badgeBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	badgeBorder     = color.RGBA{0xc0, 0xc0, 0xc0, 0xff}
+	badgeFaint      = color.RGBA{0x70, 0x70, 0x70, 0xff} // Less important text
+	badgeGood       = color.RGBA{0x2e, 0x9e, 0x44, 0xff} // Coverage of at least badgeGoodPct
+	badgeFair       = color.RGBA{0xe0, 0xa8, 0x00, 0xff} // Coverage of at least badgeFairPct
+	badgePoor       = color.RGBA{0xc8, 0x2a, 0x2a, 0xff}
+)
+
+// Coverage scores at which the badge turns from red to yellow to green
+const (
+	badgeGoodPct = 60.0
+	badgeFairPct = 30.0
+)
+
+// Function writeBadge draws a small "current net coverage" image, cfg.BadgeWidth pixels wide, for embedding in
+// a website sidebar: the coverage score, number of check-ins, frequency and date of the net. It's saved in the
+// output directory under a fixed name, so a page can link to it and always show the latest net. It returns the
+// file's name relative to the output directory.
+func writeBadge(coverage float64, checkins int) string {
+	badge := drawBadge(coverage, checkins)
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, badgeFile), func(w io.Writer) error { return encodePNG(w, badge, outputMetadata("Net coverage badge")) })
+	if err != nil {
+		fatalf("Failed to write badge file: %s", err)
+	}
+	return badgeFile
+}
+
+// Function drawBadge returns the coverage badge image. Everything is sized relative to the badge's width, so
+// the badge looks the same at any size.
+func drawBadge(coverage float64, checkins int) image.Image {
+	width := cfg.BadgeWidth
+	unit := float64(width) / 240 // Sizes below are for a 240 pixel wide badge
+
+	band := badgePoor
+	switch {
+	case coverage >= badgeGoodPct:
+		band = badgeGood
+	case coverage >= badgeFairPct:
+		band = badgeFair
+	}
+
+	// Each line of text: its size in pixels (at 240 pixels wide), color, and the space above it
+	type line struct {
+		text  string
+		size  float64
+		color color.Color
+		space float64
+	}
+	date, _ := time.Parse("2006-01-02", netDate())
+	lines := []line{
+		{"NET COVERAGE", 14, color.White, 7},
+		{fmt.Sprintf("%.0f%%", coverage), 44, band, 16},
+		{fmt.Sprintf("%d check-ins", checkins), 15, badgeFaint, 8},
+		{cfg.Frequency, 13, badgeFaint, 8},
+		{date.Format("January 2, 2006"), 13, badgeFaint, 6},
+	}
+	bandHeight := int(28*unit + 0.5)
+	height := 0.0
+	for _, l := range lines {
+		height += (l.space + l.size) * unit
+	}
+	height += 10 * unit
+
+	badgePtr := image.NewRGBA(image.Rect(0, 0, width, int(height+0.5)))
+	draw.Draw(badgePtr, badgePtr.Bounds(), &image.Uniform{badgeBorder}, image.Point{}, draw.Src)
+	draw.Draw(badgePtr, badgePtr.Bounds().Inset(1), &image.Uniform{badgeBackground}, image.Point{}, draw.Src)
+	draw.Draw(badgePtr, image.Rect(0, 0, width, bandHeight), &image.Uniform{band}, image.Point{}, draw.Src)
+
+	// Sizes are in pixels, so draw at 72 DPI, where a point is a pixel
+	ttf := loadFont()
+	ctxPtr := newContext(badgePtr)
+	ctxPtr.SetDPI(72)
+
+	y := 0.0
+	for _, l := range lines {
+		size := l.size * unit
+		y += l.space*unit + size
+		face := truetype.NewFace(ttf, &truetype.Options{Size: size, DPI: 72})
+		x := (width - font.MeasureString(face, l.text).Ceil()) / 2
+
+		ctxPtr.SetFontSize(size)
+		ctxPtr.SetSrc(&image.Uniform{l.color})
+		if _, err := ctxPtr.DrawString(l.text, freetype.Pt(x, int(y+0.5))); err != nil {
+			fatalln("can't draw badge text", err)
+		}
+	}
+
+	return badgePtr
+}
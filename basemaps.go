@@ -0,0 +1,117 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"math"
+
+	"github.com/golang/freetype"
+)
+
+// An extra base map to choose from, from a [[BaseMaps]] table in reception.cfg. As with MapFile, the corners
+// can be left out of a GeoTIFF or a map with a world file.
+type baseMapConfig struct {
+	MapFile       string    // File containing image of base map
+	MapNWCorner   []float64 // GPS lat-long coordinates of upper left corner of base map
+	MapSECorner   []float64 // GPS lat-long coordinates of lower right corner of base map
+	MapProjection string    // Projection the base map is in, as for the main base map
+	HillshadeFile string    // Hillshade image covering the same area as the base map, as for the main base map
+}
+
+// A base map ready to draw on: its image and corners, the conversion from GPS coordinates to its pixels, and
+// the output profiles for drawing maps on it, which are made just before the maps on it are drawn
+type baseMapChoice struct {
+	file               string
+	image              image.Image
+	nwCorner, seCorner []float64
+	projection         string
+	hillshadeFile      string
+	toPixel            func(gpsCoord) image.Point
+	profiles           []*profileRenderer
+}
+
+// The images a map is drawn on, and the context for drawing its labels. Each worker drawing maps has its own, so
+// maps can be drawn at the same time. The next map drawn on them only has to reset the parts the last one changed.
+type mapLayers struct {
+	outputMapPtr, textMapPtr *image.RGBA
+	textCtxPtr               *freetype.Context
+	dirty                    image.Rectangle // Part of outputMapPtr that differs from the base map
+	textDirty                image.Rectangle // Part of textMapPtr that isn't transparent
+}
+
+// Function newMapLayers returns layers to draw maps on a base map on, from pooled pixel buffers. Until the first
+// map is drawn their pixels are anything, so all of both are dirty.
+func newMapLayers(baseMap image.Image) *mapLayers {
+	bounds := baseMap.Bounds()
+	l := &mapLayers{outputMapPtr: newPooledRGBA(bounds), dirty: bounds, textDirty: bounds}
+	l.textMapPtr = newPooledRGBA(bounds) // Separate layer for labels so they're always on top of icons
+	l.textCtxPtr = newContext(l.textMapPtr)
+	return l
+}
+
+// Function loadBaseMaps loads the extra base maps in reception.cfg, returning them after the main one, which has
+// already been loaded. It leaves cfg and gpsToPixel set for the main base map.
+func loadBaseMaps(mainMap image.Image) []*baseMapChoice {
+	choices := []*baseMapChoice{{file: cfg.MapFile, image: mainMap, nwCorner: cfg.MapNWCorner, seCorner: cfg.MapSECorner,
+		projection: cfg.MapProjection, hillshadeFile: cfg.HillshadeFile, toPixel: gpsToPixel}}
+	for _, m := range cfg.BaseMaps {
+		cfg.MapFile, cfg.MapNWCorner, cfg.MapSECorner, cfg.MapProjection = m.MapFile, m.MapNWCorner, m.MapSECorner, m.MapProjection
+		c := &baseMapChoice{image: loadBaseMap(m.MapFile), hillshadeFile: m.HillshadeFile}
+		c.file, c.nwCorner, c.seCorner, c.projection = cfg.MapFile, cfg.MapNWCorner, cfg.MapSECorner, cfg.MapProjection
+		c.toPixel = newGpsToPixel(c.image)
+		choices = append(choices, c)
+	}
+	choices[0].use()
+	return choices
+}
+
+// Function chooseBaseMap returns the smallest base map that all the given locations are on, so dense areas get
+// a map of their own. If no base map has all of them, the main one is used.
+func chooseBaseMap(choices []*baseMapChoice, locations []gpsCoord) *baseMapChoice {
+	best, bestArea := choices[0], math.Inf(1)
+	for _, c := range choices {
+		nw, se := gpsCoord{c.nwCorner[0], c.nwCorner[1]}, gpsCoord{c.seCorner[0], c.seCorner[1]}
+		covers := true
+		for _, gps := range locations {
+			if gps.lat > nw.lat || gps.lat < se.lat || gps.long < nw.long || gps.long > se.long {
+				covers = false
+				break
+			}
+		}
+
+		// Degrees of longitude shrink away from the equator, so scale them to compare areas fairly
+		area := (nw.lat - se.lat) * (se.long - nw.long) * math.Cos((nw.lat+se.lat)/2*math.Pi/180)
+		if covers && area < bestArea {
+			best, bestArea = c, area
+		}
+	}
+	return best
+}
+
+// Function use makes this the base map that cfg and gpsToPixel refer to, so everything that places things on
+// the map places them on this one
+func (c *baseMapChoice) use() {
+	cfg.MapFile, cfg.MapNWCorner, cfg.MapSECorner, cfg.MapProjection = c.file, c.nwCorner, c.seCorner, c.projection
+	gpsToPixel = c.toPixel
+}
+
+// Function place returns a copy of a marker positioned on this base map
+func (c *baseMapChoice) place(m marker) marker {
+	if m.operator.callsign != "" {
+		m.operator.pixel = c.toPixel(m.operator.gps)
+	}
+	return m
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Beam heading of an operator whose antenna isn't directional, or who didn't give a heading
+const noBeam = -1.0
+
+// Color of the arrows showing which way directional antennas point
+var beamColor = color.RGBA{0x20, 0x20, 0x20, 0xff}
+
+// Function parseBeam reads a beam heading from the operator file, in degrees clockwise from true north. An
+// empty or negative value (such as -100, for no value) means the antenna isn't directional.
+func parseBeam(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return noBeam, nil
+	}
+	heading, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if heading < 0 {
+		return noBeam, nil
+	}
+	return math.Mod(heading, 360), nil
+}
+
+// Function drawBeam draws an arrow from the middle of an icon out past its edge, pointing the way an operator's
+// directional antenna points. It has a white outline, like the built-in icons, so it shows over any map.
+func drawBeam(mapPtr *image.RGBA, center image.Point, iconSize int, heading float64) {
+	size := float64(iconSize)
+	dx, dy := math.Sin(heading*math.Pi/180), -math.Cos(heading*math.Pi/180) // y increases downward
+	at := func(along, across float64) pixelPoint {
+		return pixelPoint{float64(center.X) + dx*along - dy*across, float64(center.Y) + dy*along + dx*across}
+	}
+
+	headLength, headWidth, shaftWidth := size/3, size/5, size/10
+	tip := size * 0.9
+	head := []pixelPoint{at(tip, 0), at(tip-headLength, -headWidth), at(tip-headLength, headWidth)}
+	outline := size / 12
+
+	strokeLine(mapPtr, at(0, 0), at(tip-headLength, 0), shaftWidth+2*outline, color.White, false)
+	strokeRing(mapPtr, head, 2*outline, color.White)
+	fillPolygons(mapPtr, [][]pixelPoint{head}, color.White)
+	strokeLine(mapPtr, at(0, 0), at(tip-headLength, 0), shaftWidth, beamColor, false)
+	fillPolygons(mapPtr, [][]pixelPoint{head}, beamColor)
+}
@@ -0,0 +1,216 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/zip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// A bundle being packed: the files to copy into it, and what the settings in reception.cfg that name them become
+type bundle struct {
+	sources map[string]string // File to copy into the bundle, by its "/"-separated name in the bundle
+	renamed map[string]string // Name in the bundle of each file or directory, by the value in reception.cfg naming it
+}
+
+// A line of reception.cfg setting a key to a string: the indent, the key, the equals sign, the quoted string and
+// whatever follows it
+var stringSetting = regexp.MustCompile(`^(\s*)([A-Za-z0-9_-]+)(\s*=\s*)("(?:[^"\\]|\\.)*"|'[^']*')(.*)$`)
+
+// Function bundleCommand packs reception.cfg and the base maps, icons, fonts and area files it names into one zip
+// file, with reception.cfg changed to find them where they are in it, so a net control station can be handed a
+// single file that makes the same maps wherever it's unpacked. The operator and report files are left out, since
+// they change from net to net, and so are the Google credentials, which shouldn't be handed around.
+func bundleCommand(args []string) {
+	flags := flag.NewFlagSet("bundle", flag.ExitOnError)
+	output := flags.String("o", "reception-bundle.zip", "File to write the bundle to")
+	program := flags.Bool("program", false, "Also put this program in the bundle, for stations that don't have it")
+	flags.Parse(args)
+
+	// Read the file again, since the bundle gets what's written in it, not the defaults or the command line's
+	file, _ := configFile(os.Args[1:])
+	text, err := os.ReadFile(file)
+	if err != nil {
+		fatalln("can't read", file, err)
+	}
+	var c config
+	if _, err := loadConfig(file, &c); err != nil {
+		fatalln("can't read", file, err)
+	}
+
+	b := &bundle{sources: make(map[string]string), renamed: make(map[string]string)}
+	if c.MapSource == "" || c.MapSource == mapSourceFile {
+		b.add(c.MapFile, "map", true)
+	}
+	b.add(c.HillshadeFile, "map", true)
+	for _, m := range c.BaseMaps {
+		b.add(m.MapFile, "map", true)
+		b.add(m.HillshadeFile, "map", true)
+	}
+	b.addDirectory(c.IconDirectory, "icons")
+	for _, font := range []string{c.FontFile, c.TitleFontFile, c.LegendFontFile, c.LabelFontFile} {
+		if _, err := os.Stat(localPath(font)); font != "" && err != nil {
+			warnf("not bundling font %s, so the built-in one will be used: %s", font, err)
+			continue
+		}
+		b.add(font, "fonts", false)
+	}
+	b.add(c.NeighborhoodFile, "areas", true)
+	b.add(c.BoundaryFile, "areas", true)
+	b.add(c.MailTemplate, "", false)
+	if *program {
+		exe, err := os.Executable()
+		if err != nil {
+			fatalln("can't find this program to bundle it", err)
+		}
+		b.sources["reception"+filepath.Ext(exe)] = exe
+	}
+
+	// Everything goes in a directory named after the bundle, so unpacking it doesn't scatter files about
+	top := strings.TrimSuffix(filepath.Base(*output), filepath.Ext(*output))
+	var names []string
+	for name := range b.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fatalStatus = exitDataError // Only writing the bundle can fail from here on
+	err = writeFileAtomic(*output, func(w io.Writer) error {
+		zw := zip.NewWriter(w)
+		cw, err := zw.CreateHeader(&zip.FileHeader{Name: path.Join(top, configFileName), Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(cw, b.rewriteConfig(string(text))); err != nil {
+			return err
+		}
+		for _, name := range names {
+			addFileToZip(zw, b.sources[name], path.Join(top, name))
+		}
+		return zw.Close()
+	})
+	if err != nil {
+		fatalf("Failed to write bundle %s: %s", *output, err)
+	}
+	fmt.Printf("Wrote %s with %s and %d files. Unzip it, add the operator and report files, and run reception in %s.\n",
+		*output, configFileName, len(names), top)
+}
+
+// Function add puts a file named by a setting into a directory of the bundle. If companions is set, so do the files
+// beside it with the same name but another extension, such as a base map's world file or a shapefile's .dbf file.
+// A file that's missing is fatal, since the bundle wouldn't work without it.
+func (b *bundle) add(value, dir string, companions bool) {
+	if _, done := b.renamed[value]; value == "" || done {
+		return
+	}
+	file := localPath(value)
+	if _, err := os.Stat(file); err != nil {
+		fatalln("can't bundle", value, err)
+	}
+	name := b.name(dir, filepath.Base(file), file)
+	b.renamed[value] = name
+	if !companions {
+		return
+	}
+
+	// A companion keeps its extension but takes the file's new name, in case a clash with another file changed it
+	stem := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)) + "."
+	newStem := strings.TrimSuffix(path.Base(name), path.Ext(name)) + "."
+	entries, err := os.ReadDir(filepath.Dir(file))
+	if err != nil {
+		fatalln("can't bundle", value, err)
+	}
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasPrefix(e.Name(), stem) && e.Name() != filepath.Base(file) {
+			b.name(dir, newStem+strings.TrimPrefix(e.Name(), stem), filepath.Join(filepath.Dir(file), e.Name()))
+		}
+	}
+}
+
+// Function addDirectory puts all the files in a directory named by a setting into a directory of the bundle
+func (b *bundle) addDirectory(value, dir string) {
+	if value == "" {
+		return
+	}
+	entries, err := os.ReadDir(localPath(value))
+	if err != nil {
+		fatalln("can't bundle", value, err)
+	}
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			b.name(dir, e.Name(), filepath.Join(localPath(value), e.Name()))
+		}
+	}
+	b.renamed[value] = dir
+}
+
+// Function name returns the name in the bundle for a file, and records where to copy it from. A different file
+// already given the same name makes this one's name start with a number, so files from different directories
+// with the same name don't replace each other.
+func (b *bundle) name(dir, base, source string) string {
+	name := path.Join(dir, base)
+	for i := 2; b.sources[name] != "" && b.sources[name] != source; i++ {
+		name = path.Join(dir, fmt.Sprintf("%d-%s", i, base))
+	}
+	b.sources[name] = source
+	return name
+}
+
+// Function rewriteConfig returns the text of reception.cfg with each setting that names a file or directory in the
+// bundle changed to its name in the bundle. The rest, comments and their alignment included, is left as it was.
+func (b *bundle) rewriteConfig(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		m := stringSetting.FindStringSubmatch(line)
+		if m == nil || !isPathSetting(m[2]) {
+			continue
+		}
+		var v struct{ Value string }
+		if _, err := toml.Decode("Value = "+m[4], &v); err != nil {
+			continue
+		}
+		name, found := b.renamed[v.Value]
+		if !found {
+			continue
+		}
+		quoted, rest := "'"+name+"'", m[5]
+		if pad := len(m[4]) - len(quoted); pad > 0 && strings.HasPrefix(strings.TrimSpace(rest), "#") {
+			rest = strings.Repeat(" ", pad) + rest
+		}
+		lines[i] = m[1] + m[2] + m[3] + quoted + rest
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Function isPathSetting returns whether a key in reception.cfg, however it's spelled, sets a file or directory
+func isPathSetting(key string) bool {
+	key = settingKey(key)
+	for name := range otherPathSettings {
+		if key == settingKey(name) {
+			return true
+		}
+	}
+	return strings.HasSuffix(key, "file") || strings.HasSuffix(key, "directory")
+}
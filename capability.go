@@ -0,0 +1,212 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Column headings of the station capability matrix
+var capabilityHeadings = []string{"Call Sign", "Bands", "Mode", "Power (W)", "Power Class", "Antenna Type", "Antenna Gain (dBi)", "Antenna Height (ft)"}
+
+// Function writeCapabilities writes the station capability matrix, derived from the operator file, into the
+// output directory as both capabilities.csv and capabilities.pdf. This is the station table ICS emergency
+// plans ask for: each station's bands, mode, power class and antenna. It returns the files' names relative to the
+// output directory.
+func writeCapabilities(operators map[string]operatorData) []string {
+	calls := make([]string, 0, len(operators))
+	for call := range operators {
+		calls = append(calls, call)
+	}
+	sort.Strings(calls)
+
+	rows := make([][]string, 0, len(calls))
+	for _, call := range calls {
+		rows = append(rows, capabilityRow(operators[call]))
+	}
+
+	const csvFile, pdfFile = "capabilities.csv", "capabilities.pdf"
+	writeCapabilitiesCSV(filepath.Join(cfg.OutputDirectory, csvFile), rows)
+	writeCapabilitiesPDF(filepath.Join(cfg.OutputDirectory, pdfFile), rows)
+	return []string{csvFile, pdfFile}
+}
+
+// Function capabilityRow returns one station's row of the capability matrix. Unknown values (-100 in the
+// operator file) are left blank.
+func capabilityRow(op operatorData) []string {
+	known := func(value float64, format string) string {
+		if value == -100.0 {
+			return ""
+		}
+		return fmt.Sprintf(format, value)
+	}
+
+	return []string{
+		op.callsign,
+		stationBands(op.antType),
+		netMode(cfg.Frequency),
+		known(op.xmitPwr, "%.0f"),
+		powerClass(op.xmitPwr),
+		op.antType,
+		known(op.antGain, "%.1f"),
+		known(op.antHeight, "%.0f"),
+	}
+}
+
+// Function powerClass returns the conventional class name for a transmitter power, in Watts
+func powerClass(watts float64) string {
+	switch {
+	case watts == -100.0:
+		return "Unknown"
+	case watts <= 5:
+		return "QRP"
+	case watts <= 25:
+		return "Low"
+	case watts <= 50:
+		return "Medium"
+	default:
+		return "High"
+	}
+}
+
+// Function stationBands returns the bands a station can work. The operator file doesn't list bands directly,
+// so we go by the antenna description: multi-band antennas are almost always sold as dual-band (2m/70cm) or
+// tri-band (2m/1.25m/70cm). Otherwise all we know is that the station worked the band we tested on.
+func stationBands(antType string) string {
+	ant := strings.ToLower(strings.ReplaceAll(antType, " ", "-"))
+	switch {
+	case strings.Contains(ant, "tri-band") || strings.Contains(ant, "triband"):
+		return "2m/1.25m/70cm"
+	case strings.Contains(ant, "dual-band") || strings.Contains(ant, "dualband"):
+		return "2m/70cm"
+	default:
+		return frequencyBand(cfg.Frequency)
+	}
+}
+
+// Function netMode returns the mode the net was tested in, which is all the operator file tells us about a
+// station's modes: the one named in Frequency, such as "146.520 MHz SSB", or if none is named, FM, the mode of
+// nets on the VHF and UHF bands. It's "" for a frequency that names no mode and isn't on those bands.
+func netMode(frequency string) string {
+	for _, field := range strings.Fields(strings.ToUpper(frequency)) {
+		switch field {
+		case "FM", "AM", "SSB", "USB", "LSB", "CW", "DMR", "D-STAR", "C4FM", "P25", "PACKET":
+			return field
+		}
+	}
+	if band := frequencyBand(frequency); band != "" && band != "10m" {
+		return "FM"
+	}
+	return ""
+}
+
+// Function frequencyBand returns the amateur band name for a frequency given in MHz, such as
+// "146.535 MHz Simplex", or "" if it isn't in a band we know of.
+func frequencyBand(frequency string) string {
+	fields := strings.Fields(frequency)
+	if len(fields) == 0 {
+		return ""
+	}
+	mhz, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return ""
+	}
+
+	bands := []struct {
+		name      string
+		low, high float64
+	}{
+		{"10m", 28, 29.7},
+		{"6m", 50, 54},
+		{"2m", 144, 148},
+		{"1.25m", 219, 225},
+		{"70cm", 420, 450},
+		{"33cm", 902, 928},
+		{"23cm", 1240, 1300},
+	}
+	for _, band := range bands {
+		if mhz >= band.low && mhz <= band.high {
+			return band.name
+		}
+	}
+	return ""
+}
+
+// Function writeCapabilitiesCSV writes the capability matrix as a CSV file with a heading row
+func writeCapabilitiesCSV(csvFile string, rows [][]string) {
+	err := writeFileAtomic(csvFile, func(f io.Writer) error {
+		w := csv.NewWriter(f)
+		w.Write(capabilityHeadings)
+		w.WriteAll(rows)
+		return w.Error()
+	})
+	if err != nil {
+		fatalf("Failed to write capability matrix file: %s", err)
+	}
+}
+
+// Function writeCapabilitiesPDF writes the capability matrix as a printable table, repeating the title and
+// headings on each page
+func writeCapabilitiesPDF(pdfFile string, rows [][]string) {
+	const (
+		fontSize    = 9.0
+		lineHeight  = 14.0
+		antColumn   = 5  // Column of the antenna descriptions
+		maxAntChars = 45 // Antenna descriptions longer than this are truncated to fit their column
+	)
+	columnX := []float64{0, 70, 160, 205, 260, 320, 540, 630} // Left edge of each column, relative to the margin
+
+	var doc pdfDoc
+	page := doc.newPage()
+	y := 0.0
+
+	startPage := func() {
+		y = pdfPageHeight - pdfMargin
+		pdfText(page, pdfMargin, y, 14, true, "Station Capability Matrix")
+		y -= lineHeight
+		pdfText(page, pdfMargin, y, fontSize, false, "Tested on "+cfg.Frequency+"; generated "+startTime.Format("2006-01-02"))
+		y -= 1.5 * lineHeight
+		for i, heading := range capabilityHeadings {
+			pdfText(page, pdfMargin+columnX[i], y, fontSize, true, heading)
+		}
+		pdfLine(page, pdfMargin, y-4, pdfPageWidth-pdfMargin, y-4)
+		y -= lineHeight
+	}
+	startPage()
+
+	for _, row := range rows {
+		if y < pdfMargin {
+			page = doc.newPage()
+			startPage()
+		}
+		for i, cell := range row {
+			if chars := []rune(cell); i == antColumn && len(chars) > maxAntChars {
+				cell = string(chars[:maxAntChars-3]) + "..."
+			}
+			pdfText(page, pdfMargin+columnX[i], y, fontSize, false, cell)
+		}
+		y -= lineHeight
+	}
+
+	if err := writeFileAtomic(pdfFile, doc.write); err != nil {
+		fatalf("Failed to write capability matrix file: %s", err)
+	}
+}
@@ -0,0 +1,466 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// A problem found in reception.cfg, and what to do about it
+type configProblem struct {
+	setting    string // Setting the problem is with, e.g. "MapNWCorner" or "Neighborhoods[2].SECorner"
+	problem    string
+	suggestion string // "" = none
+	warning    bool   // True = it'll work, but probably not as intended
+}
+
+// Function checkConfigCommand checks reception.cfg for the mistakes that otherwise only show up as an error
+// partway through a run, or as a setting quietly ignored: misspelled settings, files that aren't there, and map
+// corners in the wrong order. It reports all of them at once, rather than stopping at the first, and exits with
+// the status for a configuration error if there were any (other than warnings).
+func checkConfigCommand(args []string) {
+	flags := flag.NewFlagSet("check-config", flag.ExitOnError)
+	flags.Parse(args)
+
+	// Read the file again, since checking the keys needs what the TOML decoder found in it. The values are
+	// checked in cfg, so files given on the command line (e.g. -reports) are checked instead of the file's.
+	file, _ := configFile(os.Args[1:])
+	md, err := toml.DecodeFile(file, &config{})
+	if err != nil {
+		if pe, ok := err.(toml.ParseError); ok {
+			fmt.Println(pe.ErrorWithUsage())
+		} else {
+			fmt.Println(err)
+		}
+		os.Exit(exitConfigError)
+	}
+
+	problems := configKeyProblems(md)
+	problems = append(problems, configFileProblems()...)
+	problems = append(problems, configCornerProblems()...)
+	if cfg.OutputNameTemplate != "" && !strings.Contains(cfg.OutputNameTemplate, "{call}") {
+		problems = append(problems, configProblem{setting: "OutputNameTemplate", problem: "has no {call}, so every map would get the same name",
+			suggestion: "Put {call} in it, e.g. \"{call}-{type}-map\""})
+	}
+
+	errors := 0
+	for _, p := range problems {
+		kind := "Error"
+		if p.warning {
+			kind = "Warning"
+		} else {
+			errors++
+		}
+		fmt.Printf("%s: %s %s\n", kind, p.setting, p.problem)
+		if p.suggestion != "" {
+			fmt.Printf("    %s\n", p.suggestion)
+		}
+	}
+	if len(problems) == 0 {
+		fmt.Println(file, "looks fine")
+	} else {
+		fmt.Printf("%s: %d errors, %d warnings\n", file, errors, len(problems)-errors)
+	}
+	if errors > 0 {
+		os.Exit(exitConfigError)
+	}
+}
+
+// Function configKeyProblems finds the keys in reception.cfg that don't set anything, which would otherwise be
+// ignored, and suggests the setting each was probably meant to be. Keys set the settings they name whatever their
+// case, and with underscores or dashes between words, so "map_file" sets MapFile; those are only warned about, to
+// write them as the settings are documented.
+func configKeyProblems(md toml.MetaData) []configProblem {
+	var problems []configProblem
+	for _, key := range md.Keys() {
+		parent, known := settingType(key[:len(key)-1])
+		if !known || parent.Kind() != reflect.Struct {
+			continue // In a table that isn't a setting, which is reported for the table, or a key of a map
+		}
+		name := key[len(key)-1]
+		field, found := lookupSetting(parent, name)
+		switch {
+		case !found:
+			p := configProblem{setting: key.String(), problem: "isn't a setting, so it's ignored"}
+			if closest := closestSetting(name, parent); closest != "" {
+				p.suggestion = "Did you mean " + settingName(key[:len(key)-1], closest) + "?"
+			}
+			problems = append(problems, p)
+		case field.Name != name:
+			problems = append(problems, configProblem{setting: key.String(), warning: true,
+				problem:    "works, but isn't how the setting is written",
+				suggestion: "Write it as " + settingName(key[:len(key)-1], field.Name) + ", so it's easy to find in the documentation"})
+		}
+	}
+	return problems
+}
+
+// Function settingType returns the type of the setting a key in reception.cfg sets, e.g. neighborhood for
+// Neighborhoods, and reports whether there's a setting by that name. Array tables, such as [[Neighborhoods]],
+// give the type of each table.
+func settingType(key toml.Key) (reflect.Type, bool) {
+	t := reflect.TypeOf(config{})
+	for _, name := range key {
+		switch t.Kind() {
+		case reflect.Map:
+			return t.Elem(), true
+		case reflect.Struct:
+			field, found := lookupSetting(t, name)
+			if !found {
+				return t, false
+			}
+			t = field.Type
+		default:
+			return t, false
+		}
+		if t.Kind() == reflect.Slice {
+			t = t.Elem()
+		}
+	}
+	return t, true
+}
+
+// Function closestSetting returns the name of the setting in a table (a struct) most like a key that isn't a
+// setting, or "" if none is close
+func closestSetting(name string, table reflect.Type) string {
+	if table.Kind() != reflect.Struct {
+		return ""
+	}
+	closest, closestDistance := "", len(name)/4+2
+	for i := 0; i < table.NumField(); i++ {
+		field := table.Field(i)
+		if field.PkgPath != "" {
+			continue // Unexported, so TOML can't set it
+		}
+		if d := editDistance(settingKey(name), settingKey(field.Name)); d < closestDistance {
+			closest, closestDistance = field.Name, d
+		}
+	}
+	return closest
+}
+
+// Function editDistance returns the number of letters that must be added, removed or changed to turn one string
+// into another (the Levenshtein distance)
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = current[j-1] + 1
+			if d := previous[j] + 1; d < current[j] {
+				current[j] = d
+			}
+			if d := previous[j-1] + cost; d < current[j] {
+				current[j] = d
+			}
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+// Function settingName returns the full name of a setting in a table, e.g. Neighborhoods.Name
+func settingName(table toml.Key, name string) string {
+	return append(append(toml.Key{}, table...), name).String()
+}
+
+// Function configFileProblems checks that the files and directories named in reception.cfg are there, where
+// the settings that use them are turned on
+func configFileProblems() []configProblem {
+	var problems []configProblem
+	check := func(setting, file string, dir, warning bool) {
+		if p, ok := missingFile(setting, file, dir); !ok {
+			p.warning = warning
+			problems = append(problems, p)
+		}
+	}
+
+	check("OperatorFile", cfg.OperatorFile, false, false)
+	check("ReportFile", cfg.ReportFile, false, false)
+	if cfg.MapSource == "" || cfg.MapSource == mapSourceFile {
+		check("MapFile", cfg.MapFile, false, false)
+	}
+	problems = append(problems, iconTableProblems()...)
+	if !cfg.VectorIconFlag && cfg.Palette != paletteColorblind && len(cfg.Icons) > 0 {
+		for i, e := range cfg.Icons {
+			if cfg.IconDirectory != "" {
+				check(fmt.Sprintf("Icons[%d].File", i+1), filepath.Join(cfg.IconDirectory, e.File), false, false)
+			} else if _, err := fs.Stat(iconFiles(""), e.File); err != nil && e.File != "" {
+				problems = append(problems, configProblem{setting: fmt.Sprintf("Icons[%d].File", i+1),
+					problem: fmt.Sprintf("%q isn't one of the built-in icons", e.File), suggestion: "Set IconDirectory to use icons of your own"})
+			}
+		}
+	} else if !cfg.VectorIconFlag && cfg.Palette != paletteColorblind {
+		if cfg.IconDirectory == "" {
+			problems = append(problems, builtInIconProblems()...)
+		} else {
+			check("IconDirectory", cfg.IconDirectory, true, false)
+			if cfg.TransIcon != "" {
+				check("TransIcon", filepath.Join(cfg.IconDirectory, cfg.TransIcon+".png"), false, false)
+			}
+			if cfg.NoReportIcon != "" && cfg.NoReportIcon != "hollow" {
+				check("NoReportIcon", filepath.Join(cfg.IconDirectory, cfg.NoReportIcon+".png"), false, false)
+			}
+		}
+	}
+	if cfg.HillshadeFlag && cfg.HillshadeFile != "" {
+		check("HillshadeFile", cfg.HillshadeFile, false, false)
+	}
+
+	// A missing font only gets the built-in one instead
+	if cfg.FontFile != "" {
+		check("FontFile", cfg.FontFile, false, true)
+	}
+	optional := []struct {
+		setting, file string
+		warning       bool
+	}{
+		{"TitleFontFile", cfg.TitleFontFile, true},
+		{"LegendFontFile", cfg.LegendFontFile, true},
+		{"LabelFontFile", cfg.LabelFontFile, true},
+		{"MailTemplate", cfg.MailTemplate, false},
+		{"NeighborhoodFile", cfg.NeighborhoodFile, false},
+		{"BoundaryFile", cfg.BoundaryFile, false},
+	}
+	for _, o := range optional {
+		if o.file != "" {
+			check(o.setting, o.file, false, o.warning)
+		}
+	}
+	if cfg.DriveFlag || cfg.SheetFlag {
+		check("GoogleCredentials", cfg.GoogleCredentials, false, false)
+	}
+	for i, m := range cfg.BaseMaps {
+		check(fmt.Sprintf("BaseMaps[%d].MapFile", i+1), m.MapFile, false, false)
+		if cfg.HillshadeFlag && m.HillshadeFile != "" {
+			check(fmt.Sprintf("BaseMaps[%d].HillshadeFile", i+1), m.HillshadeFile, false, false)
+		}
+	}
+	return problems
+}
+
+// Function builtInIconProblems checks that the icons named in reception.cfg are among the default ones built into
+// the program, for when IconDirectory isn't set
+func builtInIconProblems() []configProblem {
+	var problems []configProblem
+	for _, icon := range []struct{ setting, name string }{{"TransIcon", cfg.TransIcon}, {"NoReportIcon", cfg.NoReportIcon}} {
+		if icon.name == "" || icon.name == noReportHollow {
+			continue
+		}
+		if _, err := fs.Stat(iconFiles(""), icon.name+".png"); err != nil {
+			problems = append(problems, configProblem{setting: icon.setting, problem: fmt.Sprintf("%q isn't one of the built-in icons", icon.name),
+				suggestion: "Set IconDirectory to use icons of your own"})
+		}
+	}
+	return problems
+}
+
+// Function iconTableProblems checks the [[Icons]] tables: each needs a Report and a File, no report can have two,
+// and TransIcon and NoReportIcon, which are looked up among their reports, need one
+func iconTableProblems() []configProblem {
+	if len(cfg.Icons) == 0 {
+		return nil
+	}
+	var problems []configProblem
+	seen := make(map[string]bool)
+	for i, e := range cfg.Icons {
+		setting := fmt.Sprintf("Icons[%d]", i+1)
+		switch {
+		case e.Report == "" || e.File == "":
+			problems = append(problems, configProblem{setting: setting, problem: "needs both a Report and a File"})
+		case seen[e.Report]:
+			problems = append(problems, configProblem{setting: setting, problem: fmt.Sprintf("report %q already has an icon", e.Report)})
+		}
+		seen[e.Report] = true
+	}
+	for _, icon := range []struct{ setting, name string }{{"TransIcon", cfg.TransIcon}, {"NoReportIcon", cfg.NoReportIcon}} {
+		if icon.name != "" && icon.name != noReportHollow && !seen[icon.name] {
+			problems = append(problems, configProblem{setting: icon.setting, problem: fmt.Sprintf("%q has no [[Icons]] table", icon.name),
+				suggestion: fmt.Sprintf("Add an [[Icons]] table with Report = %q", icon.name)})
+		}
+	}
+	return problems
+}
+
+// Function missingFile checks that a file (or directory, if dir is set) is there. If it isn't, it returns the
+// problem, suggesting a file whose name differs only in case if there is one, since that's an easy mistake to
+// make on Windows and macOS that only shows up on Linux.
+func missingFile(setting, file string, dir bool) (configProblem, bool) {
+	p := configProblem{setting: setting}
+	if file == "" {
+		p.problem = "isn't set"
+		return p, false
+	}
+
+	info, err := os.Stat(file)
+	switch {
+	case err == nil && info.IsDir() == dir:
+		return p, true
+	case err == nil && dir:
+		p.problem = fmt.Sprintf("%q is a file, not a directory", file)
+		return p, false
+	case err == nil:
+		p.problem = fmt.Sprintf("%q is a directory, not a file", file)
+		return p, false
+	}
+
+	p.problem = fmt.Sprintf("%q isn't there", file)
+	if infos, err := ioutil.ReadDir(filepath.Dir(file)); err == nil {
+		for _, info := range infos {
+			if strings.EqualFold(info.Name(), filepath.Base(file)) {
+				p.suggestion = fmt.Sprintf("Did you mean %q?", filepath.Join(filepath.Dir(file), info.Name()))
+				return p, false
+			}
+		}
+	}
+	if !filepath.IsAbs(file) {
+		if wd, err := os.Getwd(); err == nil {
+			p.suggestion = "Relative paths are from " + wd
+		}
+	}
+	return p, false
+}
+
+// Function configCornerProblems checks the corners of the base maps and neighborhoods: that there are two
+// coordinates, latitude first, and that the northwest corner is north and west of the southeast one
+func configCornerProblems() []configProblem {
+	var problems []configProblem
+	problems = append(problems, baseMapCornerProblems("", cfg.MapFile, cfg.MapSource, cfg.MapNWCorner, cfg.MapSECorner)...)
+	for i, m := range cfg.BaseMaps {
+		problems = append(problems, baseMapCornerProblems(fmt.Sprintf("BaseMaps[%d].", i+1), m.MapFile, mapSourceFile,
+			m.MapNWCorner, m.MapSECorner)...)
+	}
+
+	for i, n := range cfg.Neighborhoods {
+		prefix := fmt.Sprintf("Neighborhoods[%d]", i+1)
+		if n.Name != "" {
+			prefix = fmt.Sprintf("Neighborhoods[%d] (%s)", i+1, n.Name)
+		}
+		if len(n.Polygon) > 0 {
+			if len(n.Polygon) < 3 {
+				problems = append(problems, configProblem{setting: prefix + ".Polygon", problem: "has fewer than 3 corners",
+					suggestion: "List every corner of the boundary, as [[lat, long], [lat, long], ...]"})
+			}
+			for j, v := range n.Polygon {
+				problems = append(problems, coordinateProblems(fmt.Sprintf("%s.Polygon[%d]", prefix, j+1), v[:])...)
+			}
+			continue
+		}
+		if n.NWCorner == n.SECorner {
+			problems = append(problems, configProblem{setting: prefix, problem: "has neither a Polygon nor corners",
+				suggestion: "Give it NWCorner and SECorner, as [lat, long], or a Polygon"})
+			continue
+		}
+		problems = append(problems, cornerProblems(prefix+".NWCorner", prefix+".SECorner", n.NWCorner[:], n.SECorner[:])...)
+	}
+	return problems
+}
+
+// Function baseMapCornerProblems checks the corners of a base map. They can be left out of a GeoTIFF or a map
+// with a world file, which say where their corners are, and are ignored if they're given.
+func baseMapCornerProblems(prefix, mapFile, mapSource string, nw, se []float64) []configProblem {
+	ext := strings.ToLower(filepath.Ext(mapFile))
+	if (mapSource == "" || mapSource == mapSourceFile) && (ext == ".tif" || ext == ".tiff") {
+		return nil
+	}
+	if mapSource == "" || mapSource == mapSourceFile {
+		for _, name := range worldFileNames(mapFile) {
+			if _, err := os.Stat(name); err == nil {
+				return nil
+			}
+		}
+	}
+
+	var problems []configProblem
+	for _, c := range []struct {
+		setting string
+		corner  []float64
+	}{{prefix + "MapNWCorner", nw}, {prefix + "MapSECorner", se}} {
+		switch {
+		case len(c.corner) == 0:
+			problems = append(problems, configProblem{setting: c.setting, problem: "isn't set",
+				suggestion: "Give it as [lat, long], or put a world file next to the map so the corners are read from it"})
+		case len(c.corner) != 2:
+			problems = append(problems, configProblem{setting: c.setting,
+				problem: fmt.Sprintf("has %d coordinates instead of 2", len(c.corner)), suggestion: "Give it as [lat, long]"})
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return cornerProblems(prefix+"MapNWCorner", prefix+"MapSECorner", nw, se)
+}
+
+// Function cornerProblems checks a northwest and a southeast corner, both [lat, long]
+func cornerProblems(nwSetting, seSetting string, nw, se []float64) []configProblem {
+	problems := coordinateProblems(nwSetting, nw)
+	problems = append(problems, coordinateProblems(seSetting, se)...)
+	if len(problems) > 0 {
+		return problems // Comparing them would only pile on more confusing problems
+	}
+
+	both := nwSetting + " and " + seSetting
+	switch {
+	case nw[0] < se[0] && nw[1] > se[1]:
+		problems = append(problems, configProblem{setting: both, problem: "are swapped",
+			suggestion: fmt.Sprintf("Swap them: %s = %s, %s = %s", nwSetting, formatCorner(se), seSetting, formatCorner(nw))})
+	case nw[0] <= se[0]:
+		problems = append(problems, configProblem{setting: both, problem: "have the northwest corner no farther north than the southeast one",
+			suggestion: fmt.Sprintf("Swap the latitudes: %s = %s, %s = %s", nwSetting, formatCorner([]float64{se[0], nw[1]}),
+				seSetting, formatCorner([]float64{nw[0], se[1]}))})
+	case nw[1] >= se[1]:
+		problems = append(problems, configProblem{setting: both, problem: "have the northwest corner no farther west than the southeast one",
+			suggestion: fmt.Sprintf("Swap the longitudes: %s = %s, %s = %s (west longitudes are negative)", nwSetting,
+				formatCorner([]float64{nw[0], se[1]}), seSetting, formatCorner([]float64{se[0], nw[1]}))})
+	}
+	return problems
+}
+
+// Function coordinateProblems checks that a [lat, long] coordinate is one, and not [long, lat]
+func coordinateProblems(setting string, c []float64) []configProblem {
+	lat, long := c[0], c[1]
+	switch {
+	case (lat < -90 || lat > 90) && long >= -90 && long <= 90 && lat >= -180 && lat <= 180:
+		return []configProblem{{setting: setting, problem: fmt.Sprintf("%s looks like [long, lat]", formatCorner(c)),
+			suggestion: fmt.Sprintf("Give latitude first: %s", formatCorner([]float64{long, lat}))}}
+	case lat < -90 || lat > 90:
+		return []configProblem{{setting: setting, problem: fmt.Sprintf("has latitude %g, which isn't between -90 and 90", lat)}}
+	case long < -180 || long > 180:
+		return []configProblem{{setting: setting, problem: fmt.Sprintf("has longitude %g, which isn't between -180 and 180", long)}}
+	}
+	return nil
+}
+
+// Function formatCorner formats a coordinate the way it's written in reception.cfg
+func formatCorner(c []float64) string {
+	return fmt.Sprintf("[%g, %g]", c[0], c[1])
+}
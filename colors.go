@@ -0,0 +1,67 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// Color of text when reception.cfg doesn't give one
+var defaultTextColor = color.RGBA{0x10, 0x10, 0x10, 0xff}
+
+// Function parseColor parses a color written as in HTML, "#rrggbb", or with an alpha value, "#rrggbbaa"
+func parseColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 8 || err != nil {
+		return color.RGBA{}, fmt.Errorf("%q isn't a color; use \"#rrggbb\" or \"#rrggbbaa\"", s)
+	}
+	c := color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}
+	return color.RGBAModel.Convert(c).(color.RGBA), nil
+}
+
+// Function settingColor returns the color a setting in reception.cfg gives, or a default if it's empty
+func settingColor(setting, value string, def color.Color) color.Color {
+	if value == "" {
+		return def
+	}
+	c, err := parseColor(value)
+	if err != nil {
+		fatalf("bad %s in reception.cfg: %s", setting, err)
+	}
+	return c
+}
+
+// Function textColor returns the color of call signs and other text on the maps
+func textColor() color.Color {
+	return settingColor("TextColor", cfg.TextColor, defaultTextColor)
+}
+
+// Function legendColor returns the color of the legend in the corner of each map
+func legendColor() color.Color {
+	return settingColor("LegendColor", cfg.LegendColor, textColor())
+}
+
+// Function labelColor returns the color of the call sign next to an icon, which can depend on its report so
+// the quality of reception can be read from the labels too
+func labelColor(report string) color.Color {
+	return settingColor("LabelColors."+report, cfg.LabelColors[report], textColor())
+}
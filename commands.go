@@ -0,0 +1,73 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// A command that can be given on the command line in place of generating maps, e.g. "reception version"
+type command struct {
+	run         func(args []string) // Runs the command, given the command line arguments that follow its name
+	needsConfig bool                // True if the command can't run without reception.cfg
+	summary     string              // One-line description for the usage message
+}
+
+// Commands, keyed by the name used on the command line. This is filled in by init, since some commands
+// refer back to the table.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"bundle":       {bundleCommand, true, "Pack reception.cfg and the base maps, icons and fonts it names into one zip file to hand out"},
+		"check-config": {checkConfigCommand, false, "Check reception.cfg for misspelled settings, missing files and misordered corners"},
+		"compare":      {compareCommand, true, "Compare two bands side by side, from a report file for each: \"compare FILE1 FILE2\""},
+		"fetch":        {fetchCommand, true, "Add emailed reception reports from the IMAP mailbox to ReportFile; -regenerate redraws the maps"},
+		"mail":         {mailCommand, true, "Email each operator their maps; -dry-run lists what would be sent"},
+		"network":      {networkCommand, true, "Find groups, critical relays and a relay set from the reports, for relay planning"},
+		"operators":    {operatorsCommand, false, "Operator file tools; \"operators merge fileA fileB\" merges two rosters"},
+		"path":         {pathCommand, true, "Report how well two stations can hear each other: \"path CALL1 CALL2\""},
+		"reliability":  {reliabilityCommand, true, "Score how reliably each operator is heard, from a directory of past report files"},
+		"serve":        {serveCommand, true, "Serve maps over HTTP, drawn when asked for: /map/CALL?type=xmit"},
+		"trend":        {trendCommand, true, "Chart each station's coverage session by session, from a directory of dated report files"},
+		"version":      {versionCommand, false, "Print version and build information; -check also checks for a newer release"},
+	}
+}
+
+// Function lookupCommand returns the command with the given name, or exits with a usage message if there's
+// no such command.
+func lookupCommand(name string) command {
+	cmd, present := commands[name]
+	if !present {
+		fmt.Fprintf(os.Stderr, "Unknown command %q. Commands are:\n", name)
+		for _, cmdName := range sortedKeys(commands) {
+			fmt.Fprintf(os.Stderr, "  %-12s %s\n", cmdName, commands[cmdName].summary)
+		}
+		os.Exit(2)
+	}
+	return cmd
+}
+
+// Function sortedKeys returns the names of the commands in alphabetical order
+func sortedKeys(cmds map[string]command) []string {
+	names := make([]string, 0, len(cmds))
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,219 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/freetype"
+)
+
+// Band comparison report in the output directory, and its columns after the two bands' names
+const compareFile = "compare.csv"
+
+var compareHeadings = []string{"Transmitter", "Receiver"}
+
+// Color of the ring around stations heard on one band but not the other, and the gap between the two maps
+var (
+	oneBandColor = color.RGBA{0xd0, 0x20, 0xd0, 0xff}
+	compareGap   = 16
+)
+
+// Function compareCommand compares reception on two bands, from a report file for each (e.g. a 2 m net and a
+// 70 cm net run the same evening): for each transmitter in both, it draws the two maps side by side, ringing the
+// stations that heard it on one band but not the other, and lists both bands' reports in compare.csv.
+func compareCommand(args []string) {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	names := flags.String("bands", "", "Comma-separated names for the two bands, e.g. \"2 m,70 cm\"; default is the file names")
+	calls := flags.String("calls", "", "Comma-separated transmitters to compare; default is all that are in both files")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		fatalln("Usage: reception compare [-bands name,name] [-calls call,call...] REPORTFILE1 REPORTFILE2")
+	}
+	files := []string{flags.Arg(0), flags.Arg(1)}
+	bands := []string{strings.TrimSuffix(filepath.Base(files[0]), filepath.Ext(files[0])),
+		strings.TrimSuffix(filepath.Base(files[1]), filepath.Ext(files[1]))}
+	if *names != "" {
+		bands = strings.Split(*names, ",")
+		if len(bands) != 2 {
+			fatalln("-bands needs a name for each of the two report files")
+		}
+		bands[0], bands[1] = strings.TrimSpace(bands[0]), strings.TrimSpace(bands[1])
+	}
+
+	icons := loadIcons(cfg.IconDirectory)
+	baseMap := loadBaseMap(cfg.MapFile)
+	gpsToPixel = newGpsToPixel(baseMap)
+	fatalStatus = exitDataError
+	operators := loadOperators(cfg.OperatorFile)
+	sourceFiles = append([]string{cfg.OperatorFile, cfg.MapFile}, files...)
+	cfg.RcvMapFlag = false // Reports are always wanted as transmitter -> receiver here, whatever reception.cfg says
+
+	var reports [2]map[string]map[string]string
+	var transmitters [2]map[string]bool
+	for i, file := range files {
+		reports[i], _, transmitters[i] = loadReports(file)
+	}
+	compared := make(map[string]bool)
+	if *calls != "" {
+		for _, call := range strings.Split(*calls, ",") {
+			call = strings.ToUpper(strings.TrimSpace(call))
+			if !transmitters[0][call] || !transmitters[1][call] {
+				fatalf("%s didn't transmit in both report files", call)
+			}
+			compared[call] = true
+		}
+	} else {
+		for call := range transmitters[0] {
+			if transmitters[1][call] {
+				compared[call] = true
+			}
+		}
+	}
+	if len(compared) == 0 {
+		fatalln("No station transmitted in both report files")
+	}
+
+	if err := os.MkdirAll(cfg.OutputDirectory, 0755); err != nil {
+		fatalf("Failed to create output directory: %s", err)
+	}
+	writeComparison(bands, reports, compared)
+	fmt.Println("Wrote", filepath.Join(cfg.OutputDirectory, compareFile))
+	for _, call := range sortedCalls(compared) {
+		file := writeComparisonMap(baseMap, icons, operators, bands, reports, call)
+		fmt.Println("Wrote", filepath.Join(cfg.OutputDirectory, file))
+	}
+}
+
+// Function writeComparison lists, for each transmitter compared, every station that heard it on either band and
+// the report it gave on each, "" where it didn't hear it
+func writeComparison(bands []string, reports [2]map[string]map[string]string, compared map[string]bool) {
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, compareFile), func(w io.Writer) error {
+		out := csv.NewWriter(w)
+		out.Write(append(compareHeadings, bands...))
+		for _, transmitter := range sortedCalls(compared) {
+			receivers := make(map[string]bool)
+			for _, r := range reports {
+				for receiver := range r[transmitter] {
+					if receiver != transmitter {
+						receivers[receiver] = true
+					}
+				}
+			}
+			for _, receiver := range sortedCalls(receivers) {
+				out.Write([]string{transmitter, receiver, reports[0][transmitter][receiver], reports[1][transmitter][receiver]})
+			}
+		}
+		out.Flush()
+		return out.Error()
+	})
+	if err != nil {
+		fatalf("Failed to write band comparison: %s", err)
+	}
+}
+
+// Function writeComparisonMap draws a transmitter's map for each band side by side, with the stations that heard
+// it on only one band ringed on that band's map, and returns the file's name
+func writeComparisonMap(baseMap image.Image, icons map[string]image.Image, operators map[string]operatorData, bands []string,
+	reports [2]map[string]map[string]string, transmitter string) string {
+	heard := func(band int, receiver string) string {
+		report := reports[band][transmitter][receiver]
+		if _, present := icons[report]; !present || receiver == transmitter {
+			return ""
+		}
+		return report
+	}
+
+	bounds := baseMap.Bounds()
+	mapPtr := image.NewRGBA(image.Rect(0, 0, 2*bounds.Dx()+compareGap, bounds.Dy()))
+	draw.Draw(mapPtr, mapPtr.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	absent := noReportIcon(icons)
+	receivers := make(map[string]bool)
+	for _, r := range reports {
+		for receiver := range r[transmitter] {
+			receivers[receiver] = true
+		}
+	}
+	for band := range bands {
+		panel := image.NewRGBA(bounds)
+		draw.Draw(panel, bounds, baseMap, bounds.Min, draw.Src)
+		ctxPtr := newContext(panel)
+
+		count, only := 0, 0
+		for _, receiver := range sortedCalls(receivers) {
+			op := lookupOperator(operators, receiver)
+			if op.callsign == "" || receiver == transmitter {
+				continue
+			}
+			report := heard(band, receiver)
+			if report == "" {
+				if absent != nil {
+					plotIcon(panel, absent, cfg.NoReportIcon, op, ctxPtr)
+				}
+				continue
+			}
+			count++
+			if heard(1-band, receiver) == "" {
+				only++
+				ring := circle(toPixelPoint(op.pixel), float64(cfg.IconSize)*0.75)
+				strokeRing(panel, ring, float64(cfg.IconSize)/8, oneBandColor)
+			}
+			plotIcon(panel, icons[report], report, op, ctxPtr)
+		}
+		if op := lookupOperator(operators, transmitter); op.callsign != "" {
+			plotIcon(panel, icons[cfg.TransIcon], cfg.TransIcon, op, ctxPtr)
+		}
+
+		legend := []string{transmitter + " on " + bands[band],
+			fmt.Sprintf("Heard by %d stations, %d of them only on %s (ringed)", count, only, bands[band])}
+		_, fontSize := legendFont()
+		setFont(ctxPtr, legendFont)
+		ascent := int(fontSize*cfg.FontDPI/72.0 + 0.5)
+		for i, line := range legend {
+			pt := freetype.Pt(ascent, ascent*2+i*int(float64(ascent)*cfg.FontLineSpacing+0.5))
+			if _, err := ctxPtr.DrawString(line, pt); err != nil {
+				fatalln("can't plot band comparison legend", err)
+			}
+		}
+		if cfg.ScaleBarFlag {
+			drawScaleBar(panel, newGeoref(baseMap).metersPerPixel())
+		}
+		if cfg.NorthArrowFlag {
+			drawNorthArrow(panel)
+		}
+		drawAttribution(panel)
+		drawWatermark(panel)
+
+		offset := image.Pt(band*(bounds.Dx()+compareGap), 0)
+		draw.Draw(mapPtr, image.Rectangle{offset, offset.Add(bounds.Size())}, panel, bounds.Min, draw.Src)
+	}
+
+	file := transmitter + "-compare-map.png"
+	meta := outputMetadata(transmitter+": "+bands[0]+" and "+bands[1], pngText{"Transmitter", transmitter})
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, file), func(w io.Writer) error { return encodePNG(w, mapPtr, meta) })
+	if err != nil {
+		fatalf("Failed to write band comparison map: %s", err)
+	}
+	return file
+}
@@ -0,0 +1,214 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Name of the configuration file looked for when -config isn't given
+const configFileName = "reception.cfg"
+
+// Function configFile returns the configuration file to load: the one given with -config, or else the first
+// reception.cfg found in the current directory, the user's configuration directory (~/.config/reception on
+// Linux, or $XDG_CONFIG_HOME/reception) and the directory the program is in. If there isn't one anywhere it
+// returns reception.cfg, so the error names the file that's missing. Elsewhere is true if the file was found by
+// looking outside the current directory. The flags haven't been parsed yet when the file is loaded, since they
+// default to its settings, so -config is picked out of the arguments here.
+func configFile(args []string) (file string, elsewhere bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		switch {
+		case arg == name:
+			continue
+		case name == "config" && i+1 < len(args):
+			return args[i+1], false
+		case strings.HasPrefix(name, "config="):
+			return strings.TrimPrefix(name, "config="), false
+		}
+	}
+
+	if _, err := os.Stat(configFileName); err == nil {
+		return configFileName, false
+	}
+	var dirs []string
+	if dir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(dir, "reception"))
+	}
+	if exe, err := os.Executable(); err == nil {
+		if exe, err = filepath.EvalSymlinks(exe); err == nil {
+			dirs = append(dirs, filepath.Dir(exe))
+		}
+	}
+	for _, dir := range dirs {
+		file := filepath.Join(dir, configFileName)
+		if _, err := os.Stat(file); err == nil {
+			return file, true
+		}
+	}
+	return configFileName, false
+}
+
+// Function loadConfig reads a configuration file into c. Settings can be written in any case, or with underscores
+// or dashes between words, so MapFile, mapfile and map_file all set MapFile. It returns what the TOML decoder
+// found in the file, so settings it didn't know can be reported.
+func loadConfig(file string, c *config) (toml.MetaData, error) {
+	defaults := *c
+	md, err := toml.DecodeFile(file, c)
+	if err != nil {
+		return md, backslashHint(err)
+	}
+
+	// The TOML decoder ignores the case of keys, but not underscores, so if any keys it didn't know are settings
+	// written that way, they're renamed to the settings and the file decoded again
+	respelled := false
+	for _, key := range md.Undecoded() {
+		if _, known := settingType(key); known {
+			respelled = true
+		}
+	}
+	if !respelled {
+		return md, nil
+	}
+	var raw map[string]interface{}
+	if _, err := toml.DecodeFile(file, &raw); err != nil {
+		return md, err
+	}
+	canonicalKeys(raw, reflect.TypeOf(config{}))
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(raw); err != nil {
+		return md, err
+	}
+	*c = defaults
+	return toml.Decode(buf.String(), c)
+}
+
+// Function backslashHint adds a hint to an error reading a configuration file that has a Windows path in double
+// quotes, where TOML takes each backslash to start an escape sequence like \n
+func backslashHint(err error) error {
+	if msg := err.Error(); strings.Contains(msg, "escape") || strings.Contains(msg, `after '\`) {
+		return fmt.Errorf("%w; put Windows paths in single quotes, as in 'C:\\maps\\base.png', or double their backslashes", err)
+	}
+	return err
+}
+
+// Settings that name files or directories but whose names don't end in File or Directory
+var otherPathSettings = map[string]bool{"GoogleCredentials": true, "MailTemplate": true}
+
+// Function localPaths rewrites the settings in a configuration that name files or directories with this system's
+// separator, so a reception.cfg written on Windows works elsewhere and one written elsewhere works on Windows. The
+// settings are the strings in v named ...File or ...Directory, in v itself and in the tables within it.
+func localPaths(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		localPaths(v.Elem())
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			localPaths(v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field, value := v.Type().Field(i), v.Field(i)
+			if field.PkgPath != "" {
+				continue // Not a setting
+			}
+			isPath := strings.HasSuffix(field.Name, "File") || strings.HasSuffix(field.Name, "Directory") || otherPathSettings[field.Name]
+			if value.Kind() == reflect.String && isPath {
+				value.SetString(localPath(value.String()))
+			} else {
+				localPaths(value)
+			}
+		}
+	}
+}
+
+// Function localPath returns a path with either kind of separator, / or \, rewritten with this system's
+func localPath(path string) string {
+	return filepath.FromSlash(strings.ReplaceAll(path, `\`, "/"))
+}
+
+// Function canonicalKeys renames the keys of a TOML table that set the fields of a struct to the names of the
+// fields, and the keys of the tables within it likewise
+func canonicalKeys(table map[string]interface{}, t reflect.Type) {
+	for key, value := range table {
+		field, found := lookupSetting(t, key)
+		if !found {
+			continue
+		}
+		if field.Name != key {
+			if _, present := table[field.Name]; present {
+				continue // Set both ways; the decoder will take the right one
+			}
+			delete(table, key)
+			table[field.Name] = value
+		}
+
+		ft := field.Type
+		if ft.Kind() == reflect.Slice {
+			ft = ft.Elem()
+		}
+		if ft.Kind() != reflect.Struct {
+			continue
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			canonicalKeys(v, ft)
+		case []map[string]interface{}:
+			for _, t := range v {
+				canonicalKeys(t, ft)
+			}
+		}
+	}
+}
+
+// Function settingKey returns a key as it's compared to the names of settings: in lowercase, without underscores
+// or dashes
+func settingKey(key string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+}
+
+// Function lookupSetting returns the setting in a table (a struct) that a key sets, if there is one
+func lookupSetting(table reflect.Type, key string) (reflect.StructField, bool) {
+	if table.Kind() != reflect.Struct {
+		return reflect.StructField{}, false
+	}
+	return table.FieldByNameFunc(func(name string) bool { return settingKey(name) == settingKey(key) })
+}
+
+// Function warnUnknownSettings warns about the keys in a configuration file that aren't settings, which would
+// otherwise be ignored without a word, suggesting the setting each was probably meant to be
+func warnUnknownSettings(file string, md toml.MetaData) {
+	for _, key := range md.Undecoded() {
+		parent, known := settingType(key[:len(key)-1])
+		if !known {
+			continue // A key in a table that isn't a setting, which is warned about for the table
+		}
+		message := fmt.Sprintf("%s in %s isn't a setting, so it's ignored", key, file)
+		if name := closestSetting(key[len(key)-1], parent); name != "" {
+			message += fmt.Sprintf("; did you mean %s?", settingName(key[:len(key)-1], name))
+		}
+		warnf("%s", message)
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// Color of the contour lines between areas of different estimated reception
+var contourColor = color.RGBA{0x30, 0x30, 0x30, 0xff}
+
+// Function thresholds returns the estimated reports that separate one level of reception from the next: the
+// values halfway between each pair of reports receivers gave
+func (s *qualitySurface) thresholds() []float64 {
+	var t []float64
+	for i := 1; i < len(s.levels); i++ {
+		t = append(t, (s.levels[i-1]+s.levels[i])/2)
+	}
+	return t
+}
+
+// Function drawContours draws lines separating the areas where the estimated reception is at each level, e.g.
+// between good and fair, by marching squares on the estimate's grid. They go under the icons.
+func drawContours(dstPtr *image.RGBA, s *qualitySurface) {
+	if s == nil {
+		return
+	}
+	width := float64(cfg.IconSize) / 14
+	for _, t := range s.thresholds() {
+		for gy := 0; gy < s.rows-1; gy++ {
+			for gx := 0; gx < s.cols-1; gx++ {
+				for _, piece := range s.contourSegments(gx, gy, t) {
+					strokeLine(dstPtr, piece[0], piece[1], width, contourColor, false)
+				}
+			}
+		}
+	}
+}
+
+// Function contourSegments returns the pieces of the contour at threshold t that cross the grid square whose
+// upper left corner is at gx, gy. There are none if any corner has no estimate.
+func (s *qualitySurface) contourSegments(gx, gy int, t float64) [][2]pixelPoint {
+	// Corners clockwise from the upper left, and the edges between them
+	corners := [4][2]int{{gx, gy}, {gx + 1, gy}, {gx + 1, gy + 1}, {gx, gy + 1}}
+	var v [4]float64
+	for i, c := range corners {
+		if v[i] = s.value(c[0], c[1]); math.IsNaN(v[i]) {
+			return nil
+		}
+	}
+
+	// Where the contour crosses each edge, interpolating between the corners' values
+	var crossings []pixelPoint
+	for i := range corners {
+		j := (i + 1) % 4
+		if (v[i] < t) == (v[j] < t) {
+			continue
+		}
+		f := (t - v[i]) / (v[j] - v[i])
+		x, y := s.pixel(float64(corners[i][0])+f*float64(corners[j][0]-corners[i][0]),
+			float64(corners[i][1])+f*float64(corners[j][1]-corners[i][1]))
+		crossings = append(crossings, pixelPoint{x, y})
+	}
+
+	switch len(crossings) {
+	case 2:
+		return [][2]pixelPoint{{crossings[0], crossings[1]}}
+	case 4:
+		// A saddle: the contour crosses every edge, and the average of the corners decides which pairs of
+		// crossings it joins
+		if ((v[0]+v[1]+v[2]+v[3])/4 < t) == (v[0] < t) {
+			return [][2]pixelPoint{{crossings[0], crossings[1]}, {crossings[2], crossings[3]}}
+		}
+		return [][2]pixelPoint{{crossings[0], crossings[3]}, {crossings[1], crossings[2]}}
+	}
+	return nil
+}
+
+// A GeoJSON file of the areas at each level of estimated reception
+type contourCollection struct {
+	Type     string           `json:"type"`
+	Features []contourFeature `json:"features"`
+}
+
+type contourFeature struct {
+	Type       string            `json:"type"`
+	Properties map[string]string `json:"properties"`
+	Geometry   struct {
+		Type        string           `json:"type"`
+		Coordinates [][][][2]float64 `json:"coordinates"`
+	} `json:"geometry"`
+}
+
+// Function writeContours writes the areas at each level of estimated reception on a map as GeoJSON
+// MultiPolygons, for GIS programs, next to the map. Their edges follow the estimate's grid, heatmapStep pixels
+// apart. It returns the file's name relative to the output directory, or "" if there was no estimate to write.
+func writeContours(s *qualitySurface, ref georef, mapFile, transmitter string) string {
+	if s == nil {
+		return ""
+	}
+
+	collection := contourCollection{Type: "FeatureCollection", Features: []contourFeature{}}
+	for i, level := range s.levels {
+		lo, hi := math.Inf(-1), math.Inf(1)
+		if i > 0 {
+			lo = (s.levels[i-1] + level) / 2
+		}
+		if i < len(s.levels)-1 {
+			hi = (level + s.levels[i+1]) / 2
+		}
+		polygons := s.bandPolygons(lo, hi)
+		if len(polygons) == 0 {
+			continue
+		}
+
+		report := s.reports[level]
+		f := contourFeature{Type: "Feature", Properties: map[string]string{"transmitter": transmitter, "report": report}}
+		if name := reportName(report); name != "" {
+			f.Properties["name"] = name
+		}
+		f.Geometry.Type = "MultiPolygon"
+		for _, polygon := range polygons {
+			var rings [][][2]float64
+			for _, ring := range polygon {
+				rings = append(rings, ref.longLatRing(ring))
+			}
+			f.Geometry.Coordinates = append(f.Geometry.Coordinates, rings)
+		}
+		collection.Features = append(collection.Features, f)
+	}
+
+	encoded, err := json.MarshalIndent(collection, "", " ")
+	if err != nil {
+		fatalln("can't encode contours", err)
+	}
+	file := strings.TrimSuffix(mapFile, ".png") + "-contours.geojson"
+	err = writeFileAtomic(filepath.Join(cfg.OutputDirectory, file), func(w io.Writer) error { _, err := w.Write(encoded); return err })
+	if err != nil {
+		fatalf("Failed to write contour file: %s", err)
+	}
+	return file
+}
+
+// Function bandPolygons returns the outlines of the areas where the estimate is at least lo and less than hi, as
+// polygons made of an outer ring followed by any holes. Each grid point stands for the square heatmapStep pixels
+// across around it, so the outlines run along the sides of those squares.
+func (s *qualitySurface) bandPolygons(lo, hi float64) [][][]pixelPoint {
+	in := func(gx, gy int) bool {
+		v := s.value(gx, gy)
+		return !math.IsNaN(v) && v >= lo && v < hi
+	}
+
+	// Every side of a square in the band that isn't shared with another one is part of an outline. Going
+	// clockwise round each square (on the screen) makes the sides join up into clockwise outer rings and
+	// counterclockwise holes.
+	type vertex struct{ x, y int } // Corners of the squares, in half grid steps
+	next := make(map[vertex][]vertex)
+	for gy := 0; gy < s.rows; gy++ {
+		for gx := 0; gx < s.cols; gx++ {
+			if !in(gx, gy) {
+				continue
+			}
+			tl, tr := vertex{2*gx - 1, 2*gy - 1}, vertex{2*gx + 1, 2*gy - 1}
+			br, bl := vertex{2*gx + 1, 2*gy + 1}, vertex{2*gx - 1, 2*gy + 1}
+			for _, side := range []struct {
+				neighbor [2]int
+				from, to vertex
+			}{{[2]int{gx, gy - 1}, tl, tr}, {[2]int{gx + 1, gy}, tr, br}, {[2]int{gx, gy + 1}, br, bl}, {[2]int{gx - 1, gy}, bl, tl}} {
+				if !in(side.neighbor[0], side.neighbor[1]) {
+					next[side.from] = append(next[side.from], side.to)
+				}
+			}
+		}
+	}
+
+	// Follow the sides round into rings, leaving out corners partway along straight sides
+	var outer, holes [][]pixelPoint
+	for len(next) > 0 {
+		// Start from the top left, so the output doesn't change from run to run
+		start, first := vertex{}, true
+		for v := range next {
+			if first || v.y < start.y || (v.y == start.y && v.x < start.x) {
+				start, first = v, false
+			}
+		}
+		var ring []vertex
+		for v := start; ; {
+			ring = append(ring, v)
+			to := next[v][0]
+			if next[v] = next[v][1:]; len(next[v]) == 0 {
+				delete(next, v)
+			}
+			if v = to; v == start {
+				break
+			}
+		}
+
+		var points []pixelPoint
+		for i, v := range ring {
+			prev, following := ring[(i+len(ring)-1)%len(ring)], ring[(i+1)%len(ring)]
+			if (prev.x == v.x && v.x == following.x) || (prev.y == v.y && v.y == following.y) {
+				continue
+			}
+			x, y := s.pixel(float64(v.x)/2, float64(v.y)/2)
+			points = append(points, pixelPoint{x, y})
+		}
+		if ringArea(points) > 0 {
+			outer = append(outer, points)
+		} else {
+			holes = append(holes, points)
+		}
+	}
+
+	// Put each hole in the outer ring that surrounds it
+	polygons := make([][][]pixelPoint, len(outer))
+	for i, ring := range outer {
+		polygons[i] = [][]pixelPoint{ring}
+	}
+	for _, hole := range holes {
+		for i, ring := range outer {
+			if ringContains(ring, hole[0]) {
+				polygons[i] = append(polygons[i], hole)
+				break
+			}
+		}
+	}
+	return polygons
+}
+
+// Function ringArea returns the area of a ring of points, positive if it goes clockwise on the screen
+func ringArea(ring []pixelPoint) float64 {
+	area := 0.0
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		area += ring[j].x*ring[i].y - ring[i].x*ring[j].y
+	}
+	return area / 2
+}
+
+// Function ringContains reports whether a point is inside a ring. A point on a hole's outline is never on the
+// outline of the ring surrounding it, since the two are at least a grid square apart.
+func ringContains(ring []pixelPoint, p pixelPoint) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.y > p.y) != (b.y > p.y) && p.x < (b.x-a.x)*(p.y-a.y)/(b.y-a.y)+a.x {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Function longLatRing converts a ring of pixels on a map to a closed GeoJSON ring of [long, lat] points. The
+// ring's direction reverses, since latitude increases upward where pixels increase downward; clockwise outer
+// rings on the screen become counterclockwise, as GeoJSON prefers.
+func (ref georef) longLatRing(ring []pixelPoint) [][2]float64 {
+	var points [][2]float64
+	for _, p := range append(ring, ring[0]) {
+		gps, err := modelToGPS(ref.originX+p.x*ref.pixelWidth, ref.originY+p.y*ref.pixelHeight, ref.epsg)
+		if err != nil {
+			fatalln("can't convert contour to GPS coordinates", err)
+		}
+		points = append(points, [2]float64{roundCoord(gps.long), roundCoord(gps.lat)})
+	}
+	return points
+}
+
+// Function roundCoord rounds a coordinate in degrees to 7 decimal places, about a centimeter, to keep GeoJSON
+// files from filling up with meaningless digits
+func roundCoord(degrees float64) float64 {
+	return math.Round(degrees*1e7) / 1e7
+}
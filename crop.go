@@ -0,0 +1,65 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Smallest width and height of a cropped map in pixels, so even a map of a few stations next door to each other
+// has room for its legend
+const minCropSize = 600
+
+// Function cropArea returns the part of a base map a cropped map shows: the box around the transmitter and the
+// stations that reported, widened by cfg.CropMargin on every side for their icons and call signs
+func cropArea(bounds image.Rectangle, markers []marker, transmitter marker) image.Rectangle {
+	var area image.Rectangle
+	for _, m := range append(markers, transmitter) {
+		if m.operator.callsign != "" && !m.absent {
+			p := m.operator.pixel
+			area = area.Union(image.Rectangle{p, p.Add(image.Point{1, 1})})
+		}
+	}
+	if area.Empty() {
+		return bounds
+	}
+	area = area.Inset(-cfg.CropMargin)
+
+	if area.Dx() < minCropSize {
+		area.Min.X -= (minCropSize - area.Dx()) / 2
+		area.Max.X = area.Min.X + minCropSize
+	}
+	if area.Dy() < minCropSize {
+		area.Min.Y -= (minCropSize - area.Dy()) / 2
+		area.Max.Y = area.Min.Y + minCropSize
+	}
+	return area.Intersect(bounds)
+}
+
+// Function cropMap returns a copy of part of a base map, and copies of the markers moved to match, ready to draw
+// a map of just that part
+func cropMap(baseMap image.Image, area image.Rectangle, markers []marker, transmitter marker) (image.Image, []marker, marker) {
+	mapPtr := image.NewRGBA(image.Rect(0, 0, area.Dx(), area.Dy()))
+	draw.Draw(mapPtr, mapPtr.Bounds(), baseMap, area.Min, draw.Src)
+
+	moved := make([]marker, len(markers))
+	for i, m := range markers {
+		m.operator.pixel = m.operator.pixel.Sub(area.Min)
+		moved[i] = m
+	}
+	transmitter.operator.pixel = transmitter.operator.pixel.Sub(area.Min)
+	return mapPtr, moved, transmitter
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+	"reflect"
+
+	"github.com/BurntSushi/toml"
+)
+
+// The settings, icons and font that come with the program, built into it so a new user can make a first map with
+// just a base map, the two CSV files and a reception.cfg giving the base map's corners. Roboto is under the Apache
+// License, like the program.
+var (
+	//go:embed reception.cfg
+	defaultConfig string
+
+	//go:embed assets/icons/*.png
+	builtInIcons embed.FS
+
+	//go:embed assets/Roboto-Regular.ttf
+	builtInFont []byte
+)
+
+// Function loadDefaults sets c to the settings in the reception.cfg that comes with the program, so settings left
+// out of a configuration file get the values documented there rather than zeros. That file is an example, though,
+// so what it turns on stays off unless asked for: every true/false setting defaults to false. The example files it
+// names are left out too, so the icons and font default to the built-in ones, and a map has to be given its own base
+// map.
+func loadDefaults(c *config) {
+	if _, err := toml.Decode(defaultConfig, c); err != nil {
+		fatalln("can't read the built-in settings", err)
+	}
+	settings := reflect.ValueOf(c).Elem()
+	for i := 0; i < settings.NumField(); i++ {
+		if setting := settings.Field(i); setting.Kind() == reflect.Bool {
+			setting.SetBool(false)
+		}
+	}
+	c.IconDirectory, c.FontFile = "", ""
+	c.MapFile, c.MapNWCorner, c.MapSECorner = "", nil, nil
+}
+
+// Function iconFiles returns the icon files in a directory, or the built-in icons if no directory is given
+func iconFiles(dir string) fs.FS {
+	if dir == "" {
+		icons, err := fs.Sub(builtInIcons, "assets/icons")
+		if err != nil {
+			fatalln("can't find the built-in icons", err)
+		}
+		return icons
+	}
+	return os.DirFS(dir)
+}
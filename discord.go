@@ -0,0 +1,175 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// What a run posts to Discord
+const (
+	discordMaps    = "maps"    // Every map generated, after a summary of the run
+	discordSummary = "summary" // The who-hears-whom matrix and a summary of the run
+)
+
+// Discord's limits on a single webhook message
+const (
+	discordMaxFiles   = 10
+	discordMaxContent = 2000
+)
+
+// Function postToDiscord posts the results of a run to the Discord channel behind cfg.DiscordWebhook: a short
+// summary of the run's statistics, followed by either every map or just the matrix, depending on cfg.DiscordPost.
+// Maps go out in as many messages as Discord's attachment limit requires.
+func postToDiscord(results []mapResult, summary string, matrixFile string) {
+	var files []string
+	switch cfg.DiscordPost {
+	case discordMaps:
+		for _, result := range results {
+			files = append(files, filepath.Join(cfg.OutputDirectory, result.File))
+		}
+	case discordSummary:
+		files = []string{filepath.Join(cfg.OutputDirectory, matrixFile)}
+	default:
+		fatalf("unknown DiscordPost %q in reception.cfg; use %q or %q", cfg.DiscordPost, discordMaps, discordSummary)
+	}
+
+	content := summary
+	for first := true; first || len(files) > 0; first = false {
+		batch := files
+		if len(batch) > discordMaxFiles {
+			batch = batch[:discordMaxFiles]
+		}
+		files = files[len(batch):]
+
+		if err := postDiscordMessage(content, batch); err != nil {
+			fatalln("can't post to Discord:", err)
+		}
+		content = ""
+	}
+
+	fmt.Println("Posted results to Discord")
+}
+
+// Function discordSummaryText returns a few lines summarizing a run for Discord: how many maps there are, and
+// which transmitters were heard best and worst
+func discordSummaryText(transmitters map[string]bool, reports map[string]map[string]string, operators map[string]operatorData, icons map[string]image.Image) string {
+	var stats []transmitterStats
+	total := 0.0
+	for _, transmitter := range sortedCalls(transmitters) {
+		s := computeStats(transmitter, reports, operators, icons)
+		stats = append(stats, s)
+		total += s.heardPct
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].heardPct > stats[j].heardPct })
+
+	list := func(stats []transmitterStats) string {
+		var entries []string
+		for _, s := range stats {
+			entries = append(entries, fmt.Sprintf("%s (%.0f%%)", s.callsign, s.heardPct))
+		}
+		return strings.Join(entries, ", ")
+	}
+
+	lines := []string{fmt.Sprintf("**%s net, %s**", cfg.Frequency, netDate())}
+	if len(stats) == 0 {
+		return lines[0]
+	}
+	lines = append(lines, fmt.Sprintf("%d maps: %s", len(stats), mapTypeName(currentMapType())))
+	lines = append(lines, fmt.Sprintf("Average: %.0f%% of the roster", total/float64(len(stats))))
+
+	top := 3
+	if top > len(stats) {
+		top = len(stats)
+	}
+	lines = append(lines, "Best: "+list(stats[:top]))
+	if len(stats) > top {
+		bottom := len(stats) - top
+		if bottom > 3 {
+			bottom = 3
+		}
+		lines = append(lines, "Weakest: "+list(stats[len(stats)-bottom:]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Function postDiscordMessage posts a message, with files attached, to cfg.DiscordWebhook (or if that's empty,
+// the DISCORD_WEBHOOK environment variable, since the URL is all it takes to post to the channel). When Discord
+// says to slow down, it waits as long as asked and tries again.
+func postDiscordMessage(content string, files []string) error {
+	webhook := cfg.DiscordWebhook
+	if webhook == "" {
+		webhook = os.Getenv("DISCORD_WEBHOOK")
+	}
+	if webhook == "" {
+		return fmt.Errorf("no webhook; set DiscordWebhook or the DISCORD_WEBHOOK environment variable")
+	}
+	if len(content) > discordMaxContent {
+		cut := discordMaxContent - 3
+		for !utf8.RuneStart(content[cut]) {
+			cut-- // Back to the start of the character, so it isn't cut in half
+		}
+		content = content[:cut] + "..."
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	payload, _ := json.Marshal(map[string]string{"content": content})
+	mw.WriteField("payload_json", string(payload))
+	for i, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		part, _ := mw.CreateFormFile(fmt.Sprintf("files[%d]", i), filepath.Base(file))
+		part.Write(data)
+	}
+	mw.Close()
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	for {
+		resp, err := client.Post(webhook, mw.FormDataContentType(), bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return err
+		}
+		reply, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			var limit struct {
+				RetryAfter float64 `json:"retry_after"` // Seconds
+			}
+			json.Unmarshal(reply, &limit)
+			time.Sleep(time.Duration((limit.RetryAfter + 0.1) * float64(time.Second)))
+		case resp.StatusCode/100 != 2:
+			return fmt.Errorf("%s %s", resp.Status, reply)
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"path/filepath"
+	"strconv"
+)
+
+// Function writeDot writes the who-hears-whom graph to reception.dot in the output directory, for Graphviz and
+// other graph tools: a node for every station, and an edge from each transmitter to each station that heard it,
+// labeled with the report and colored like its icon. Reports with no icon aren't drawn, as on the maps. It
+// returns the file's name relative to the output directory.
+func writeDot(reports map[string]map[string]string, receivers, transmitters map[string]bool, icons map[string]image.Image) string {
+	const dotFile = "reception.dot"
+
+	iconColors := make(map[string]string)
+	for name, icon := range icons {
+		if c := iconColor(icon); c != nil {
+			rgba := color.RGBAModel.Convert(c).(color.RGBA)
+			iconColors[name] = fmt.Sprintf("#%02x%02x%02x", rgba.R, rgba.G, rgba.B)
+		}
+	}
+
+	stations := make(map[string]bool)
+	for call := range receivers {
+		stations[call] = true
+	}
+	for call := range transmitters {
+		stations[call] = true
+	}
+
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, dotFile), func(f io.Writer) error {
+		w := bufio.NewWriter(f)
+		fmt.Fprintf(w, "digraph reception {\n\tlabel=%s;\n\tnode [shape=box];\n", strconv.Quote("Who hears whom: "+cfg.Frequency))
+		for _, call := range sortedCalls(stations) {
+			fmt.Fprintf(w, "\t%s;\n", strconv.Quote(call))
+		}
+		for _, transmitter := range sortedCalls(transmitters) {
+			heard := make(map[string]bool)
+			for receiver := range reports[transmitter] {
+				heard[receiver] = true
+			}
+			for _, receiver := range sortedCalls(heard) {
+				report := reports[transmitter][receiver]
+				if _, present := icons[report]; !present || receiver == transmitter {
+					continue
+				}
+				from, to := transmitter, receiver
+				if cfg.RcvMapFlag {
+					from, to = receiver, transmitter
+				}
+				fmt.Fprintf(w, "\t%s -> %s [label=%s", strconv.Quote(from), strconv.Quote(to), strconv.Quote(report))
+				if c, present := iconColors[report]; present {
+					fmt.Fprintf(w, ", color=%s, fontcolor=%s", strconv.Quote(c), strconv.Quote(c))
+				}
+				fmt.Fprintln(w, "];")
+			}
+		}
+		fmt.Fprintln(w, "}")
+		return w.Flush()
+	})
+	if err != nil {
+		fatalf("Failed to write DOT file: %s", err)
+	}
+	return dotFile
+}
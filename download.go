@@ -0,0 +1,104 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Default location of the asset bundle (icons, font, sample config) attached to each GitHub release
+const defaultAssetsURL = "https://github.com/fthiess/reception/releases/latest/download/assets.zip"
+
+// Function downloadAssets fetches the zipped asset bundle and unpacks it into the current directory. Files
+// that already exist are left alone, so a club's customized icons or config are never overwritten.
+func downloadAssets(url string) {
+	fmt.Println("Downloading assets from", url)
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		fatalln("can't download assets", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fatalln("can't download assets:", resp.Status)
+	}
+
+	// The zip reader needs random access, so pull the whole bundle into memory; it's only a few hundred KB
+	bundle, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fatalln("can't download assets", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(bundle), int64(len(bundle)))
+	if err != nil {
+		fatalln("asset bundle isn't a valid zip file", err)
+	}
+
+	for _, zf := range zr.File {
+		// Refuse paths that would land outside the current directory
+		name := filepath.Clean(filepath.FromSlash(zf.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			fatalln("asset bundle contains an unsafe path:", zf.Name)
+		}
+
+		if zf.FileInfo().IsDir() {
+			if err := os.MkdirAll(name, 0755); err != nil {
+				fatalln("can't create directory", name, err)
+			}
+			continue
+		}
+
+		if _, err := os.Stat(name); err == nil {
+			fmt.Printf("Skipping %v: already exists\n", name)
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			fatalln("can't create directory", filepath.Dir(name), err)
+		}
+		extractFile(zf, name)
+		fmt.Println("Installed", name)
+	}
+
+	fmt.Println("Asset download completed!")
+}
+
+// Function extractFile copies one file out of a zip archive
+func extractFile(zf *zip.File, name string) {
+	r, err := zf.Open()
+	if err != nil {
+		fatalln("can't read", zf.Name, "from asset bundle", err)
+	}
+	defer r.Close()
+
+	w, err := os.Create(name)
+	if err != nil {
+		fatalln("can't create", name, err)
+	}
+	defer w.Close()
+
+	if _, err := io.Copy(w, r); err != nil {
+		fatalln("can't write", name, err)
+	}
+}
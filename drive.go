@@ -0,0 +1,129 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Google Drive API endpoints and constants
+const (
+	driveScope       = "https://www.googleapis.com/auth/drive"
+	driveFilesURL    = "https://www.googleapis.com/drive/v3/files?supportsAllDrives=true"
+	driveUploadURL   = "https://www.googleapis.com/upload/drive/v3/files?uploadType=multipart&supportsAllDrives=true"
+	driveFolderMedia = "application/vnd.google-apps.folder"
+)
+
+// Function uploadToDrive uploads files from the output directory into a new subfolder of the Drive folder
+// cfg.DriveFolderID, named for this run. File names are relative to the output directory; files in
+// subdirectories (such as the index page's thumbnails) go into matching Drive subfolders.
+func uploadToDrive(files []string) {
+	client := newGoogleClient(driveScope)
+
+	runFolder := createDriveFolder(client, startTime.Format("2006-01-02 15:04")+" "+cfg.Frequency, cfg.DriveFolderID)
+	folders := map[string]string{".": runFolder} // Drive folder ID for each local directory
+
+	// Function folderFor returns the Drive folder for a local directory, creating it (and its parents) if needed
+	var folderFor func(dir string) string
+	folderFor = func(dir string) string {
+		if id, present := folders[dir]; present {
+			return id
+		}
+		folders[dir] = createDriveFolder(client, path.Base(dir), folderFor(path.Dir(dir)))
+		return folders[dir]
+	}
+
+	for _, file := range files {
+		uploadDriveFile(client, file, folderFor(path.Dir(file)))
+	}
+
+	fmt.Printf("Uploaded %d files to Google Drive\n", len(files))
+}
+
+// Function createDriveFolder creates a folder inside a Drive folder and returns the new folder's ID
+func createDriveFolder(client *http.Client, name, parent string) string {
+	metadata, _ := json.Marshal(map[string]interface{}{
+		"name":     name,
+		"mimeType": driveFolderMedia,
+		"parents":  []string{parent},
+	})
+
+	resp, err := client.Post(driveFilesURL, "application/json", bytes.NewReader(metadata))
+	if err != nil {
+		fatalln("can't create Google Drive folder", name, err)
+	}
+	return driveFileID(resp, name)
+}
+
+// Function uploadDriveFile uploads one file from the output directory into a Drive folder
+func uploadDriveFile(client *http.Client, file, parent string) {
+	content, err := os.Open(filepath.Join(cfg.OutputDirectory, file))
+	if err != nil {
+		fatalln("can't open", file, "for upload", err)
+	}
+	defer content.Close()
+
+	// Drive's multipart upload is a multipart/related body: the file's metadata, then its content
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	metadata, _ := json.Marshal(map[string]interface{}{
+		"name":    path.Base(file),
+		"parents": []string{parent},
+	})
+	part, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=UTF-8"}})
+	part.Write(metadata)
+
+	mediaType := mime.TypeByExtension(filepath.Ext(file))
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+	part, _ = mw.CreatePart(textproto.MIMEHeader{"Content-Type": {mediaType}})
+	if _, err := io.Copy(part, content); err != nil {
+		fatalln("can't read", file, "for upload", err)
+	}
+	mw.Close()
+
+	resp, err := client.Post(driveUploadURL, "multipart/related; boundary="+mw.Boundary(), &body)
+	if err != nil {
+		fatalln("can't upload", file, "to Google Drive", err)
+	}
+	driveFileID(resp, file)
+}
+
+// Function driveFileID returns the ID of the file or folder a Drive API call created
+func driveFileID(resp *http.Response, name string) string {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fatalln("can't upload", name, "to Google Drive:", googleAPIError(resp))
+	}
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		fatalln("can't parse Google Drive response for", name, err)
+	}
+	return created.ID
+}
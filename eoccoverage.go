@@ -0,0 +1,203 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// EOC coverage report and map in the output directory, and the report's columns
+const (
+	eocCoverageFile    = "eoc-coverage.csv"
+	eocCoverageMapFile = "eoc-coverage-map.png"
+)
+
+var eocCoverageHeadings = []string{"Neighborhood", "Stations", "Heard by EOC", "Coverage (%)"}
+
+// How many of the stations in a neighborhood checked in, and how many of those the EOC heard
+type neighborhoodCoverage struct {
+	name            string
+	stations, heard int
+	polygon         []gpsCoord
+}
+
+// Function findEOCCoverage works out, for each neighborhood, how many of the stations in it that checked in could
+// reach the EOC: that is, were heard by EOCCallSign. The EOC itself isn't counted, wherever it is.
+func findEOCCoverage(reports map[string]map[string]string, icons map[string]image.Image, operators map[string]operatorData) []neighborhoodCoverage {
+	eoc := strings.ToUpper(cfg.EOCCallSign)
+	if eoc == "" {
+		fatal("EOCCoverageFlag needs the EOC's call sign in EOCCallSign")
+	}
+	stations := stationsIn(reports)
+	found := false
+	for call := range stations {
+		if call == eoc || baseCall(call) == eoc {
+			eoc, found = call, true
+			break
+		}
+	}
+	if !found {
+		warnf("the EOC (%s) isn't in the reports, so no neighborhood has any coverage", eoc)
+	}
+
+	var coverage []neighborhoodCoverage
+	for _, n := range cfg.Neighborhoods {
+		c := neighborhoodCoverage{name: n.Name, polygon: n.vertices()}
+		for _, call := range sortedCalls(stations) {
+			op := lookupOperator(operators, call)
+			if call == eoc || op.callsign == "" || !n.contains(op.gps) {
+				continue
+			}
+			c.stations++
+			if heardReport(reports, icons, call, eoc) != "" {
+				c.heard++
+			}
+		}
+		coverage = append(coverage, c)
+	}
+	return coverage
+}
+
+// Function percent returns the share of a neighborhood's stations the EOC heard, as a percentage, and false if
+// no station in it checked in
+func (c neighborhoodCoverage) percent() (float64, bool) {
+	if c.stations == 0 {
+		return 0, false
+	}
+	return 100 * float64(c.heard) / float64(c.stations), true
+}
+
+// Function writeEOCCoverage writes each neighborhood's coverage to eoc-coverage.csv, and returns the file's name
+func writeEOCCoverage(coverage []neighborhoodCoverage) string {
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, eocCoverageFile), func(w io.Writer) error {
+		out := csv.NewWriter(w)
+		out.Write(eocCoverageHeadings)
+		for _, c := range coverage {
+			pct := ""
+			if p, ok := c.percent(); ok {
+				pct = fmt.Sprintf("%.0f", p)
+			}
+			out.Write([]string{c.name, strconv.Itoa(c.stations), strconv.Itoa(c.heard), pct})
+		}
+		out.Flush()
+		return out.Error()
+	})
+	if err != nil {
+		fatalf("Failed to write EOC coverage: %s", err)
+	}
+	return eocCoverageFile
+}
+
+// Function coverageColor returns the translucent shade for a neighborhood with the given coverage: red for none,
+// through yellow at half, to green for all. Neighborhoods where no one checked in are gray.
+func coverageColor(pct float64, ok bool) color.NRGBA {
+	if !ok {
+		return color.NRGBA{0x80, 0x80, 0x80, 0x60}
+	}
+	red, yellow, green := [3]float64{0xd0, 0x20, 0x20}, [3]float64{0xe8, 0xc8, 0x20}, [3]float64{0x20, 0xa0, 0x40}
+	from, to, t := red, yellow, pct/50
+	if pct > 50 {
+		from, to, t = yellow, green, (pct-50)/50
+	}
+	var rgb [3]uint8
+	for i := range rgb {
+		rgb[i] = uint8(from[i] + (to[i]-from[i])*t + 0.5)
+	}
+	return color.NRGBA{rgb[0], rgb[1], rgb[2], 0x80}
+}
+
+// Function writeEOCCoverageMap shades each neighborhood on the base map by how many of its stations the EOC
+// heard, labels it with the percentage, and marks the EOC. It returns the file's name.
+func writeEOCCoverageMap(baseMap image.Image, icons map[string]image.Image, operators map[string]operatorData, coverage []neighborhoodCoverage) string {
+	mapPtr := image.NewRGBA(baseMap.Bounds())
+	draw.Draw(mapPtr, mapPtr.Bounds(), baseMap, baseMap.Bounds().Min, draw.Src)
+	face := truetype.NewFace(loadFont(), &truetype.Options{Size: cfg.FontSize, DPI: cfg.FontDPI})
+
+	for _, c := range coverage {
+		var outline []pixelPoint
+		var pixels [][2]float64
+		var area image.Rectangle
+		for _, v := range c.polygon {
+			p := gpsToPixel(v)
+			outline = append(outline, toPixelPoint(p))
+			pixels = append(pixels, [2]float64{float64(p.X), float64(p.Y)})
+			area = area.Union(image.Rectangle{p, p.Add(image.Point{1, 1})})
+		}
+		if !area.Overlaps(mapPtr.Bounds()) {
+			continue
+		}
+		pct, ok := c.percent()
+		fillPolygons(mapPtr, [][]pixelPoint{outline}, coverageColor(pct, ok))
+		strokeRing(mapPtr, outline, float64(cfg.IconSize)/12, neighborhoodBoundary)
+
+		label := []string{c.name, "no check-ins"}
+		if ok {
+			label[1] = fmt.Sprintf("%.0f%% (%d of %d)", pct, c.heard, c.stations)
+		}
+		cx, cy := polygonCenter(pixels, area)
+		lineHeight := face.Metrics().Height
+		d := font.Drawer{Dst: mapPtr, Src: &image.Uniform{neighborhoodBoundary}, Face: face}
+		for i, line := range label {
+			width := d.MeasureString(line)
+			d.Dot = fixed.Point26_6{X: fixed.Int26_6(cx*64) - width/2, Y: fixed.Int26_6(cy*64) + fixed.Int26_6(i)*lineHeight}
+			d.DrawString(line)
+		}
+	}
+
+	ctxPtr := newContext(mapPtr)
+	if eoc := lookupOperator(operators, strings.ToUpper(cfg.EOCCallSign)); eoc.callsign != "" {
+		plotIcon(mapPtr, icons[cfg.TransIcon], cfg.TransIcon, eoc, ctxPtr)
+	}
+
+	legend := []string{"Neighborhood stations heard by the EOC (" + strings.ToUpper(cfg.EOCCallSign) + "): " + cfg.Frequency,
+		"Red: none; yellow: half; green: all; gray: no one checked in"}
+	_, fontSize := legendFont()
+	setFont(ctxPtr, legendFont)
+	ascent := int(fontSize*cfg.FontDPI/72.0 + 0.5)
+	for i, line := range legend {
+		pt := freetype.Pt(ascent, ascent*2+i*int(float64(ascent)*cfg.FontLineSpacing+0.5))
+		if _, err := ctxPtr.DrawString(line, pt); err != nil {
+			fatalln("can't plot EOC coverage map legend", err)
+		}
+	}
+	if cfg.ScaleBarFlag {
+		drawScaleBar(mapPtr, newGeoref(baseMap).metersPerPixel())
+	}
+	if cfg.NorthArrowFlag {
+		drawNorthArrow(mapPtr)
+	}
+	drawAttribution(mapPtr)
+	drawWatermark(mapPtr)
+
+	meta := outputMetadata(legend[0], pngText{"Neighborhoods", fmt.Sprint(len(coverage))})
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, eocCoverageMapFile), func(w io.Writer) error { return encodePNG(w, mapPtr, meta) })
+	if err != nil {
+		fatalf("Failed to write EOC coverage map: %s", err)
+	}
+	return eocCoverageMapFile
+}
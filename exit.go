@@ -0,0 +1,97 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Exit statuses, so a script running reception can tell a clean run from one that needs a look
+const (
+	exitOK          = 0   // Everything went fine
+	exitWarnings    = 1   // The run finished, but with warnings; e.g. some stations were skipped
+	exitConfigError = 2   // reception.cfg or the command line is wrong, or names assets that can't be used
+	exitDataError   = 3   // The operator or report data couldn't be used, or output couldn't be made from it
+	exitInterrupted = 130 // Stopped with Ctrl-C or SIGTERM before all the maps were made (128 + SIGINT, as shells do)
+)
+
+// Exit status for a fatal error, which depends on how far the run got: until reception.cfg and the assets it
+// names are loaded, errors are in the configuration; after that, in the data
+var fatalStatus = exitConfigError
+
+// Warnings given this run, each with how many times it was given, for the summary at the end
+var (
+	warnings     = make(map[string]int)
+	warningsLock sync.Mutex
+)
+
+// Function warnf prints a warning, and notes it for the summary at the end of the run
+func warnf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	fmt.Println("Warning:", message)
+
+	warningsLock.Lock()
+	defer warningsLock.Unlock()
+	warnings[message]++
+}
+
+// Function fatalf logs an error and exits with the status for errors at this point in the run, like log.Fatalf
+func fatalf(format string, args ...interface{}) {
+	log.Output(2, fmt.Sprintf(format, args...))
+	os.Exit(fatalStatus)
+}
+
+// Function fatalln logs an error and exits with the status for errors at this point in the run, like log.Fatalln
+func fatalln(args ...interface{}) {
+	log.Output(2, fmt.Sprintln(args...))
+	os.Exit(fatalStatus)
+}
+
+// Function fatal logs an error and exits with the status for errors at this point in the run, like log.Fatal
+func fatal(args ...interface{}) {
+	log.Output(2, fmt.Sprint(args...))
+	os.Exit(fatalStatus)
+}
+
+// Function exitWithSummary lists the warnings given this run, if there were any, so they aren't lost among the
+// progress messages, and exits with the status saying whether there were
+func exitWithSummary() {
+	warningsLock.Lock()
+	defer warningsLock.Unlock()
+	if len(warnings) == 0 {
+		os.Exit(exitOK)
+	}
+
+	total := 0
+	var messages []string
+	for message, n := range warnings {
+		total += n
+		messages = append(messages, message)
+	}
+	sort.Strings(messages)
+	fmt.Printf("\nFinished with %d warnings:\n", total)
+	for _, message := range messages {
+		if n := warnings[message]; n > 1 {
+			fmt.Printf("  %s (%d times)\n", message, n)
+		} else {
+			fmt.Printf("  %s\n", message)
+		}
+	}
+	os.Exit(exitWarnings)
+}
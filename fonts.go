@@ -0,0 +1,175 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Fonts parsed so far, by the hash of the font file, so each font is only parsed once however many settings name
+// it, and one that's changed (while the server is running, say) is parsed again. Font files are only hashed again
+// when their size or modification time changes.
+var (
+	fonts     = make(map[string]*truetype.Font)
+	fontFiles = make(map[string]fontFile) // By file name
+	fontsLock sync.Mutex
+)
+
+// A font file as of when it was last hashed. Missing files are remembered too, so they're only warned about once.
+type fontFile struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+// The fonts for each kind of text, looked up once for all the maps drawn in a run rather than for every label
+// (see reloadFonts)
+var (
+	runFonts     textFonts
+	runFontsOnce sync.Once
+)
+
+type textFonts struct {
+	text, title, legend, label *truetype.Font
+}
+
+// Function currentFonts returns the fonts the settings name for each kind of text, loading them the first time
+// it's called in a run. Font settings left empty use FontFile.
+func currentFonts() textFonts {
+	runFontsOnce.Do(func() {
+		orText := func(file string) string {
+			if file == "" {
+				return cfg.FontFile
+			}
+			return file
+		}
+		runFonts = textFonts{
+			text:   loadFontFile(cfg.FontFile),
+			title:  loadFontFile(orText(cfg.TitleFontFile)),
+			legend: loadFontFile(orText(cfg.LegendFontFile)),
+			label:  loadFontFile(orText(cfg.LabelFontFile)),
+		}
+	})
+	return runFonts
+}
+
+// Function reloadFonts makes the next map drawn look its fonts up again, for a new run or when the server
+// reloads, so a font file that has changed is picked up. No maps may be being drawn when it's called.
+func reloadFonts() {
+	runFontsOnce = sync.Once{}
+}
+
+// Function loadFont returns the font for text that doesn't have a font of its own: FontFile
+func loadFont() *truetype.Font {
+	return currentFonts().text
+}
+
+// Function loadFontFile reads and parses a TTF font. If no file is given it uses the font built into the program,
+// and if the file is missing or isn't a TTF font it falls back on that, so a map can still be made.
+func loadFontFile(file string) *truetype.Font {
+	fontsLock.Lock()
+	defer fontsLock.Unlock()
+
+	var state fontFile
+	if info, err := os.Stat(file); err == nil {
+		state = fontFile{size: info.Size(), modTime: info.ModTime()}
+	}
+	if known, present := fontFiles[file]; present && known.size == state.size && known.modTime.Equal(state.modTime) {
+		return fonts[known.hash]
+	}
+
+	fontBytes := builtInFont
+	if file != "" {
+		var err error
+		if fontBytes, err = ioutil.ReadFile(file); err != nil {
+			warnf("can't open font file %s %v - using the built-in font", file, err)
+			fontBytes = builtInFont
+		}
+	}
+	state.hash = sha256Hex(fontBytes)
+	fontFiles[file] = state
+	if f, present := fonts[state.hash]; present {
+		return f
+	}
+
+	f, err := freetype.ParseFont(fontBytes)
+	if err != nil {
+		warnf("can't parse font file %s %v - using the built-in font", file, err)
+		if f, err = freetype.ParseFont(builtInFont); err != nil {
+			fatalln("can't parse the built-in font", err)
+		}
+	}
+	fonts[state.hash] = f
+	return f
+}
+
+// Function sizeOrDefault returns a font size in points, or the given default size if no size is given
+func sizeOrDefault(size, defaultSize float64) float64 {
+	if size == 0 {
+		return defaultSize
+	}
+	return size
+}
+
+// Function titleFont returns the font and size for map titles, twice the size of the rest of the text by default
+func titleFont() (*truetype.Font, float64) {
+	return currentFonts().title, sizeOrDefault(cfg.TitleFontSize, cfg.FontSize*2)
+}
+
+// Function legendFont returns the font and size for legends
+func legendFont() (*truetype.Font, float64) {
+	return currentFonts().legend, sizeOrDefault(cfg.LegendFontSize, cfg.FontSize)
+}
+
+// Function labelFont returns the font and size for the call signs next to icons
+func labelFont() (*truetype.Font, float64) {
+	return currentFonts().label, sizeOrDefault(cfg.LabelFontSize, cfg.FontSize)
+}
+
+// Function setFont switches a Freetype context to the font and size for one kind of text, e.g. setFont(ctxPtr,
+// legendFont)
+func setFont(ctxPtr *freetype.Context, kind func() (*truetype.Font, float64)) {
+	f, size := kind()
+	ctxPtr.SetFont(f)
+	ctxPtr.SetFontSize(size)
+}
+
+// Function wrapText breaks text into lines no wider than width, between words. A word too long for a line of
+// its own is left whole.
+func wrapText(face font.Face, text string, width fixed.Int26_6) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		switch {
+		case line == "":
+			line = word
+		case font.MeasureString(face, line+" "+word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	return append(lines, line)
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"image"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// Gap report in the output directory, and its columns
+const gapsFile = "gaps.csv"
+
+var gapsHeadings = []string{"Neighborhood", "Station", "Other Station", "Other Neighborhood", "Distance (km)", "Relay"}
+
+// Neighborhood name given to stations that aren't in any neighborhood
+const noNeighborhood = "(none)"
+
+// Function neighborhoodOf returns the name of the first neighborhood in reception.cfg a location is in, or
+// noNeighborhood
+func neighborhoodOf(gps gpsCoord) string {
+	for _, n := range cfg.Neighborhoods {
+		if n.contains(gps) {
+			return n.Name
+		}
+	}
+	return noNeighborhood
+}
+
+// Function writeGaps writes gaps.csv: every pair of operators on the roster who took part in the net but didn't
+// hear each other either way, grouped by neighborhood, with the distance between them and the best relay (if
+// any). A pair in two neighborhoods is listed once, under whichever comes first. It's where a drill planner
+// would put portable relays. It returns the file's name relative to the output directory.
+func writeGaps(reports map[string]map[string]string, operators map[string]operatorData, icons map[string]image.Image) string {
+	type station struct {
+		call, neighborhood string
+		op                 operatorData
+	}
+	var stations []station
+	for _, call := range sortedCalls(stationsIn(reports)) {
+		if op := lookupOperator(operators, call); op.callsign != "" {
+			stations = append(stations, station{call, neighborhoodOf(op.gps), op})
+		}
+	}
+
+	// Neighborhoods are in name order, with stations in none last
+	before := func(x, y string) bool {
+		if (x == noNeighborhood) != (y == noNeighborhood) {
+			return y == noNeighborhood
+		}
+		return x < y
+	}
+
+	var rows [][]string
+	for i, a := range stations {
+		for _, b := range stations[i+1:] {
+			if baseCall(a.call) == baseCall(b.call) || heardReport(reports, icons, a.call, b.call) != "" ||
+				heardReport(reports, icons, b.call, a.call) != "" {
+				continue
+			}
+			if before(b.neighborhood, a.neighborhood) {
+				a, b = b, a
+			}
+			relay, _, _ := bestRelay(reports, icons, a.call, b.call)
+			if relay == "" {
+				relay = "none"
+			}
+			km := strconv.FormatFloat(distanceMeters(a.op.gps, b.op.gps)/1000, 'f', 1, 64)
+			rows = append(rows, []string{a.neighborhood, a.call, b.call, b.neighborhood, km, relay})
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i][0] != rows[j][0] {
+			return before(rows[i][0], rows[j][0])
+		}
+		return rows[i][1] < rows[j][1]
+	})
+
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, gapsFile), func(f io.Writer) error {
+		w := csv.NewWriter(f)
+		w.Write(gapsHeadings)
+		w.WriteAll(rows)
+		return w.Error()
+	})
+	if err != nil {
+		fatalln("couldn't write the gap report:", err)
+	}
+	return gapsFile
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// Mean radius of the earth, in meters
+const earthRadius = 6371008.8
+
+// File in the output directory with the distance and bearing of every transmitter/receiver pair, and its columns
+const pathsFile = "paths.csv"
+
+var pathsHeadings = []string{"Date", "Frequency", "Map Type", "Transmitter", "Receiver", "Report", "Distance (km)", "Bearing (deg)",
+	"Path Loss (dB)", "Expected Signal (dBm)", "Expected Report", "Levels Below Expected"}
+
+// The compass points, clockwise from north, for describing bearings
+var compassPoints = []string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"}
+
+// Where a receiver is from a transmitter
+type pathGeometry struct {
+	transmitter, receiver string
+	report                string
+	meters                float64 // Great-circle distance
+	bearing               float64 // Initial bearing from the transmitter, in degrees clockwise from true north
+	budget                linkBudget
+	budgeted              bool // False if there's no link budget, for lack of a frequency or transmitter power
+}
+
+// Function distanceMeters returns the great-circle distance between two points, in meters
+func distanceMeters(a, b gpsCoord) float64 {
+	lat1, lat2 := a.lat*math.Pi/180, b.lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLong := (b.long - a.long) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLong/2)*math.Sin(dLong/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// Function bearing returns the initial great-circle bearing from one point to another, in degrees from true north
+func bearing(from, to gpsCoord) float64 {
+	lat1, lat2 := from.lat*math.Pi/180, to.lat*math.Pi/180
+	dLong := (to.long - from.long) * math.Pi / 180
+
+	y := math.Sin(dLong) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLong)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
+
+// Function radioHorizon returns the farthest apart, in meters, two antennas of the given heights (in feet) can be
+// and still have a line of sight over a smooth earth, allowing for the usual 4/3 refraction at VHF/UHF
+func radioHorizon(heightA, heightB float64) float64 {
+	const feetToMeters = 0.3048
+	return 4120 * (math.Sqrt(heightA*feetToMeters) + math.Sqrt(heightB*feetToMeters))
+}
+
+// Function compassPoint returns the nearest of the 16 compass points to a bearing, e.g. "NNE" for 20 degrees
+func compassPoint(degrees float64) string {
+	return compassPoints[int(math.Mod(degrees+360.0/32, 360)/(360.0/16))]
+}
+
+// Function pathLabel returns what to add to a receiver's label about where it is from the transmitter: its
+// distance if DistanceLabelFlag is set and its compass direction if BearingLabelFlag is, e.g. " 7.4 km NNE"
+func pathLabel(transmitter, receiver gpsCoord) string {
+	label := ""
+	if cfg.DistanceLabelFlag {
+		label += " " + formatDistance(distanceMeters(transmitter, receiver))
+	}
+	if cfg.BearingLabelFlag {
+		label += " " + compassPoint(bearing(transmitter, receiver))
+	}
+	return label
+}
+
+// Function formatDistance writes a distance in meters in cfg.DistanceUnits, kilometers or miles, e.g. "7.4 km"
+func formatDistance(meters float64) string {
+	switch cfg.DistanceUnits {
+	case "", "km":
+		return fmt.Sprintf("%.1f km", meters/1000)
+	case "mi":
+		return fmt.Sprintf("%.1f mi", meters/metersPerMile)
+	default:
+		fatalf("unknown DistanceUnits %q in reception.cfg; use \"km\" or \"mi\"", cfg.DistanceUnits)
+		return ""
+	}
+}
+
+// Function reportGeometry returns the distance and bearing of every receiver with a report for a transmitter,
+// in call sign order. Pairs where either station isn't in the operator file are left out.
+func reportGeometry(transmitter string, reports map[string]map[string]string, operators map[string]operatorData) []pathGeometry {
+	var paths []pathGeometry
+	from := lookupOperator(operators, transmitter)
+	if from.callsign == "" {
+		return paths
+	}
+	for receiver, report := range reports[transmitter] {
+		to := lookupOperator(operators, receiver)
+		if receiver == transmitter || to.callsign == "" {
+			continue
+		}
+		p := pathGeometry{transmitter: transmitter, receiver: receiver, report: report,
+			meters: distanceMeters(from.gps, to.gps), bearing: bearing(from.gps, to.gps)}
+
+		// On a receive map, the "transmitter" is the station listening
+		if cfg.RcvMapFlag {
+			p.budget, p.budgeted = estimateLink(to, from, p.meters)
+		} else {
+			p.budget, p.budgeted = estimateLink(from, to, p.meters)
+		}
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i].receiver < paths[j].receiver })
+	return paths
+}
+
+// Function writePaths records the distance and bearing of every receiver from each transmitter mapped this run
+// in paths.csv in the output directory, next to stats.csv, along with the report the link budget suggests and how
+// the actual report compares. As in stats.csv, rows from earlier runs are kept, except that a map made again on
+// the same day replaces its earlier rows. It returns the file's name relative to the
+// output directory.
+func writePaths(transmitters map[string]bool, reports map[string]map[string]string, operators map[string]operatorData) string {
+	pathsPath := filepath.Join(cfg.OutputDirectory, pathsFile)
+	date, mapType := netDate(), currentMapType()
+
+	var rows [][]string
+	for _, row := range loadStats(pathsPath) {
+		if len(row) == len(pathsHeadings) && !(row[0] == date && row[2] == mapType && transmitters[row[3]]) {
+			rows = append(rows, row)
+		}
+	}
+	for _, transmitter := range sortedCalls(transmitters) {
+		for _, p := range reportGeometry(transmitter, reports, operators) {
+			row := []string{date, cfg.Frequency, mapType, p.transmitter, p.receiver, p.report,
+				strconv.FormatFloat(p.meters/1000, 'f', 2, 64), strconv.FormatFloat(p.bearing, 'f', 0, 64), "", "", "", ""}
+			if p.budgeted {
+				row[8] = strconv.FormatFloat(p.budget.loss, 'f', 1, 64)
+				row[9] = strconv.FormatFloat(p.budget.signal, 'f', 1, 64)
+				row[10], row[11] = p.budget.expected, reportShortfall(p.report, p.budget)
+			}
+			rows = append(rows, row)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		for c := 0; c < 5; c++ {
+			if rows[i][c] != rows[j][c] {
+				return rows[i][c] < rows[j][c]
+			}
+		}
+		return false
+	})
+
+	err := writeFileAtomic(pathsPath, func(f io.Writer) error {
+		w := csv.NewWriter(f)
+		w.Write(pathsHeadings)
+		w.WriteAll(rows)
+		return w.Error()
+	})
+	if err != nil {
+		fatalln("couldn't write the paths file:", err)
+	}
+	return pathsFile
+}
@@ -0,0 +1,189 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Patterns for finding the EPSG code of a coordinate system in a GDAL .aux.xml file and in a .prj file. A .prj
+// file's own code comes last, after those of the datum and so on it's built from.
+var (
+	auxSRSPattern = regexp.MustCompile(`<SRS>\s*EPSG:(\d+)\s*</SRS>`)
+	prjPattern    = regexp.MustCompile(`AUTHORITY\["EPSG",\s*"(\d+)"\]\s*\]\s*$`)
+)
+
+// How a map image lines up with the world: the projected coordinates of the center of its upper left pixel, the
+// size of a pixel, and the coordinate system those are in. Apart from the projection itself, this is exactly what
+// a world file records.
+type georef struct {
+	originX, originY        float64 // Projected coordinates (meters) of the center of the upper left pixel
+	pixelWidth, pixelHeight float64 // Size of a pixel in meters; height is negative, since y increases downward
+	epsg                    int     // EPSG code of the coordinate system, e.g. 32610 for UTM zone 10N
+	proj                    projection
+}
+
+// Function newGeoref works out the georeferencing of a map image from the corners in reception.cfg, in the
+// coordinates of the base map's projection
+func newGeoref(mapImage image.Image) georef {
+	nw := gpsCoord{cfg.MapNWCorner[0], cfg.MapNWCorner[1]}
+	se := gpsCoord{cfg.MapSECorner[0], cfg.MapSECorner[1]}
+	proj := mapProjection(nw)
+	x0, y0 := proj.project(nw)
+	x1, y1 := proj.project(se)
+
+	return georef{
+		originX:     x0,
+		originY:     y0,
+		pixelWidth:  (x1 - x0) / float64(mapImage.Bounds().Dx()),
+		pixelHeight: (y1 - y0) / float64(mapImage.Bounds().Dy()),
+		epsg:        proj.epsg(),
+		proj:        proj,
+	}
+}
+
+// Function crop returns the georeferencing of part of a map image, starting at the given pixel
+func (ref georef) crop(min image.Point) georef {
+	ref.originX += float64(min.X) * ref.pixelWidth
+	ref.originY += float64(min.Y) * ref.pixelHeight
+	return ref
+}
+
+// Function metersPerPixel returns the distance on the ground across a pixel of the map, halfway down it
+func (ref georef) metersPerPixel() float64 {
+	middle := gpsCoord{(cfg.MapNWCorner[0] + cfg.MapSECorner[0]) / 2, (cfg.MapNWCorner[1] + cfg.MapSECorner[1]) / 2}
+	return math.Abs(ref.pixelWidth) * ref.proj.metersPerUnit(middle)
+}
+
+// Function writeWorldFiles writes the files GIS programs (QGIS, ArcGIS, GDAL) need to place a PNG image in the
+// right spot: a world file (.pgw) giving its position and scale, and a GDAL .aux.xml file giving its coordinate
+// system, which world files can't record. It returns their names, relative to the output directory like pngFile.
+func writeWorldFiles(pngFile string, ref georef) []string {
+	worldFile := strings.TrimSuffix(pngFile, ".png") + ".pgw"
+	auxFile := pngFile + ".aux.xml"
+
+	// A world file is six lines: x pixel size, two rotation terms, y pixel size, and the x and y of the center
+	// of the upper left pixel
+	world := fmt.Sprintf("%.10f\n0.0\n0.0\n%.10f\n%.4f\n%.4f\n", ref.pixelWidth, ref.pixelHeight, ref.originX, ref.originY)
+	aux := fmt.Sprintf("<PAMDataset>\n  <SRS>EPSG:%d</SRS>\n</PAMDataset>\n", ref.epsg)
+
+	for _, f := range []struct{ name, content string }{{worldFile, world}, {auxFile, aux}} {
+		err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, f.name), func(w io.Writer) error {
+			_, err := io.WriteString(w, f.content)
+			return err
+		})
+		if err != nil {
+			fatalf("Failed to write world file: %s", err)
+		}
+	}
+	return []string{worldFile, auxFile}
+}
+
+// Function readWorldFile looks for a world file next to a base map image (e.g. base-map.pgw for base-map.png)
+// and, if there is one, works out the GPS coordinates of the map's corners from it. World files don't say what
+// coordinate system they're in, so that's taken from a GDAL .aux.xml or .prj file next to the image; failing
+// those, coordinates that look like latitude and longitude are taken to be. It returns the corners and the EPSG
+// code of the coordinate system, and reports whether there was a world file.
+func readWorldFile(imageFile string, size image.Point) (nw, se gpsCoord, epsg int, found bool) {
+	var worldFile string
+	var content []byte
+	for _, name := range worldFileNames(imageFile) {
+		if data, err := ioutil.ReadFile(name); err == nil {
+			worldFile, content = name, data
+			break
+		}
+	}
+	if worldFile == "" {
+		return gpsCoord{}, gpsCoord{}, 0, false
+	}
+
+	fields := strings.Fields(string(content))
+	if len(fields) != 6 {
+		fatalf("world file %s should have 6 values, but has %d", worldFile, len(fields))
+	}
+	var v [6]float64
+	for i, field := range fields {
+		var err error
+		if v[i], err = strconv.ParseFloat(field, 64); err != nil {
+			fatalln("can't parse world file", worldFile, err)
+		}
+	}
+	if v[1] != 0 || v[2] != 0 {
+		fatalln("base map", imageFile, "is rotated, according to", worldFile, "; only north-up maps are supported")
+	}
+
+	// Like the corners in reception.cfg, a world file gives the center of the upper left pixel
+	epsg = worldFileEPSG(imageFile, looksLikeDegrees(v))
+	if epsg == 0 {
+		fatalf("can't tell what coordinate system %s is in; put a .prj or .aux.xml file next to %s saying", worldFile, imageFile)
+	}
+	nw, err := modelToGPS(v[4], v[5], epsg)
+	if err == nil {
+		se, err = modelToGPS(v[4]+float64(size.X)*v[0], v[5]+float64(size.Y)*v[3], epsg)
+	}
+	if err != nil {
+		fatalln("can't place", imageFile, "on the map using", worldFile+":", err)
+	}
+	fmt.Println("Using corners of base map from", worldFile)
+	return nw, se, epsg, true
+}
+
+// Function worldFileNames returns the names a world file for an image might have, in the order they're looked for
+func worldFileNames(imageFile string) []string {
+	ext := filepath.Ext(imageFile)
+	base := strings.TrimSuffix(imageFile, ext)
+	var names []string
+	if len(ext) >= 3 {
+		names = append(names, base+ext[:2]+ext[len(ext)-1:]+"w") // .png -> .pgw, .jpg -> .jgw
+	}
+	return append(names, imageFile+"w", base+ext+"w", base+".wld")
+}
+
+// Function looksLikeDegrees reports whether a world file's values look like latitude and longitude rather than meters
+func looksLikeDegrees(v [6]float64) bool {
+	return math.Abs(v[0]) < 1 && math.Abs(v[3]) < 1 && math.Abs(v[4]) <= 180 && math.Abs(v[5]) <= 90
+}
+
+// Function worldFileEPSG returns the EPSG code of the coordinate system of an image's world file, from the .aux.xml
+// or .prj file next to it, or EPSG:4326 (latitude and longitude) if there isn't one and the values look like it
+func worldFileEPSG(imageFile string, looksLikeDegrees bool) int {
+	if aux, err := ioutil.ReadFile(imageFile + ".aux.xml"); err == nil {
+		if m := auxSRSPattern.FindSubmatch(aux); m != nil {
+			epsg, _ := strconv.Atoi(string(m[1]))
+			return epsg
+		}
+	}
+	if prj, err := ioutil.ReadFile(strings.TrimSuffix(imageFile, filepath.Ext(imageFile)) + ".prj"); err == nil {
+		if m := prjPattern.FindSubmatch(prj); m != nil {
+			epsg, _ := strconv.Atoi(string(m[1]))
+			return epsg
+		}
+	} else if !os.IsNotExist(err) {
+		fatalln("can't read projection file for", imageFile, err)
+	}
+	if looksLikeDegrees {
+		return 4326
+	}
+	return 0
+}
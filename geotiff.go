@@ -0,0 +1,367 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/im7mortal/UTM"
+	"golang.org/x/image/tiff"
+)
+
+// TIFF field types
+const (
+	tiffASCII  = 2
+	tiffShort  = 3
+	tiffLong   = 4
+	tiffFloat  = 11
+	tiffDouble = 12
+)
+
+// TIFF and GeoTIFF tags we write
+const (
+	tagImageWidth       = 256
+	tagImageLength      = 257
+	tagBitsPerSample    = 258
+	tagCompression      = 259
+	tagPhotometric      = 262
+	tagImageDescription = 270
+	tagStripOffsets     = 273
+	tagSamplesPerPixel  = 277
+	tagRowsPerStrip     = 278
+	tagStripByteCounts  = 279
+	tagPlanarConfig     = 284
+	tagSoftware         = 305
+	tagDateTime         = 306
+	tagModelPixelScale  = 33550
+	tagModelTiepoint    = 33922
+	tagGeoKeyDirectory  = 34735
+)
+
+// GeoTIFF keys, and the values we give them
+const (
+	geoKeyModelType      = 1024 // 1 = projected coordinates
+	geoKeyRasterType     = 1025 // 1 = each pixel is an area, so the tie point is the upper left pixel's corner
+	geoKeyGeographicCS   = 2048 // EPSG code of the geographic coordinate system, for latitude and longitude
+	geoKeyProjectedCS    = 3072 // EPSG code of the coordinate system
+	geoKeyProjLinearUnit = 3076 // 9001 = meters
+)
+
+// Values of the GeoTIFF keys we read
+const (
+	modelTypeGeographic = 2 // Coordinates are longitude and latitude
+	rasterPixelIsPoint  = 2 // The tie point is the center of the upper left pixel, not its corner
+)
+
+// Rows of the image compressed together; TIFF readers prefer strips of around 8K or more
+const tiffRowsPerStrip = 16
+
+// One entry of a TIFF image file directory: a tag, its type, how many values it has, and the values encoded
+type tiffEntry struct {
+	tag, fieldType uint16
+	count          uint32
+	data           []byte
+}
+
+// Function writeGeoTIFF saves a map as a GeoTIFF file next to its PNG (e.g. K7ABC-xmit-map.tif), for GIS
+// programs and agencies that want georeferenced imagery, and returns its name relative to the output directory.
+func writeGeoTIFF(img image.Image, pngFile string, ref georef, description string) string {
+	tiffFile := strings.TrimSuffix(pngFile, ".png") + ".tif"
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, tiffFile), func(w io.Writer) error {
+		return encodeGeoTIFF(w, img, ref, description)
+	})
+	if err != nil {
+		fatalf("Failed to write GeoTIFF file: %s", err)
+	}
+	return tiffFile
+}
+
+// Function encodeGeoTIFF writes an image as a deflate-compressed RGB TIFF file with GeoTIFF tags placing it
+// according to ref. The file is laid out as the header, the image strips, the tag values too big to fit in the
+// directory, and finally the directory itself.
+func encodeGeoTIFF(w io.Writer, img image.Image, ref georef, description string) error {
+	le := binary.LittleEndian
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	width, height := rgba.Bounds().Dx(), rgba.Bounds().Dy()
+
+	var file bytes.Buffer
+	file.Write([]byte{'I', 'I', 42, 0, 0, 0, 0, 0}) // Little-endian; directory offset is filled in at the end
+
+	// Image data, a strip of rows at a time, with the alpha channel dropped since maps are opaque
+	var stripOffsets, stripCounts []uint32
+	row := make([]byte, width*3)
+	for y := 0; y < height; y += tiffRowsPerStrip {
+		stripOffsets = append(stripOffsets, uint32(file.Len()))
+		zw := zlib.NewWriter(&file)
+		for r := y; r < y+tiffRowsPerStrip && r < height; r++ {
+			pixels := rgba.Pix[r*rgba.Stride:]
+			for x := 0; x < width; x++ {
+				copy(row[x*3:x*3+3], pixels[x*4:x*4+3])
+			}
+			zw.Write(row)
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		stripCounts = append(stripCounts, uint32(file.Len())-stripOffsets[len(stripOffsets)-1])
+	}
+
+	// In PixelIsArea terms the tie point is the outside corner of the upper left pixel, half a pixel out from
+	// its center
+	tiepoint := []float64{0, 0, 0, ref.originX - ref.pixelWidth/2, ref.originY - ref.pixelHeight/2, 0}
+	geoKeys := []uint16{
+		1, 1, 0, 4, // Directory version, revision, minor revision, number of keys
+		geoKeyModelType, 0, 1, 1,
+		geoKeyRasterType, 0, 1, 1,
+		geoKeyProjectedCS, 0, 1, uint16(ref.epsg),
+		geoKeyProjLinearUnit, 0, 1, 9001,
+	}
+
+	entries := []tiffEntry{
+		tiffLongs(tagImageWidth, uint32(width)),
+		tiffLongs(tagImageLength, uint32(height)),
+		tiffShorts(tagBitsPerSample, 8, 8, 8),
+		tiffShorts(tagCompression, 8), // Deflate
+		tiffShorts(tagPhotometric, 2), // RGB
+		tiffText(tagImageDescription, description),
+		tiffLongs(tagStripOffsets, stripOffsets...),
+		tiffShorts(tagSamplesPerPixel, 3),
+		tiffLongs(tagRowsPerStrip, tiffRowsPerStrip),
+		tiffLongs(tagStripByteCounts, stripCounts...),
+		tiffShorts(tagPlanarConfig, 1), // RGB values together for each pixel
+		tiffText(tagSoftware, "reception "+version),
+		tiffText(tagDateTime, creationTime().Format("2006:01:02 15:04:05")),
+		tiffDoubles(tagModelPixelScale, ref.pixelWidth, -ref.pixelHeight, 0),
+		tiffDoubles(tagModelTiepoint, tiepoint...),
+		tiffShorts(tagGeoKeyDirectory, geoKeys...),
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	// Values that don't fit in the directory's four bytes go before it, each on a word boundary
+	valueOffsets := make([]uint32, len(entries))
+	for i, e := range entries {
+		if len(e.data) > 4 {
+			if file.Len()%2 == 1 {
+				file.WriteByte(0)
+			}
+			valueOffsets[i] = uint32(file.Len())
+			file.Write(e.data)
+		}
+	}
+
+	if file.Len()%2 == 1 {
+		file.WriteByte(0)
+	}
+	directory := uint32(file.Len())
+	binary.Write(&file, le, uint16(len(entries)))
+	for i, e := range entries {
+		binary.Write(&file, le, e.tag)
+		binary.Write(&file, le, e.fieldType)
+		binary.Write(&file, le, e.count)
+		if len(e.data) > 4 {
+			binary.Write(&file, le, valueOffsets[i])
+		} else {
+			var inline [4]byte
+			copy(inline[:], e.data)
+			file.Write(inline[:])
+		}
+	}
+	binary.Write(&file, le, uint32(0)) // No more directories
+
+	data := file.Bytes()
+	le.PutUint32(data[4:], directory)
+	_, err := w.Write(data)
+	return err
+}
+
+// Function tiffShorts returns a directory entry of 16 bit values
+func tiffShorts(tag uint16, values ...uint16) tiffEntry {
+	data := make([]byte, 2*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint16(data[2*i:], v)
+	}
+	return tiffEntry{tag, tiffShort, uint32(len(values)), data}
+}
+
+// Function tiffLongs returns a directory entry of 32 bit values
+func tiffLongs(tag uint16, values ...uint32) tiffEntry {
+	data := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(data[4*i:], v)
+	}
+	return tiffEntry{tag, tiffLong, uint32(len(values)), data}
+}
+
+// Function tiffDoubles returns a directory entry of 64 bit floating point values
+func tiffDoubles(tag uint16, values ...float64) tiffEntry {
+	data := make([]byte, 8*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint64(data[8*i:], math.Float64bits(v))
+	}
+	return tiffEntry{tag, tiffDouble, uint32(len(values)), data}
+}
+
+// Function tiffText returns a directory entry of ASCII text, which TIFF terminates with a NUL
+func tiffText(tag uint16, text string) tiffEntry {
+	data := append([]byte(text), 0)
+	return tiffEntry{tag, tiffASCII, uint32(len(data)), data}
+}
+
+// Function readGeoTIFF decodes a GeoTIFF base map and works out the GPS coordinates of its northwest and
+// southeast corners from the georeferencing embedded in it, so they don't have to be entered in reception.cfg.
+// Maps in WGS 84 latitude and longitude, WGS 84 / UTM and Web Mercator are understood; it returns the EPSG code
+// of the one the map is in.
+func readGeoTIFF(imageFile string) (mapImage image.Image, nw, se gpsCoord, epsg int) {
+	data, err := ioutil.ReadFile(imageFile)
+	if err != nil {
+		fatal("can't open", imageFile, err)
+	}
+	mapImage, err = tiff.Decode(bytes.NewReader(data))
+	if err != nil {
+		fatal("can't decode base map", imageFile, err)
+	}
+	tags, err := readTIFFTags(data)
+	if err != nil {
+		fatalln("can't read GeoTIFF tags from", imageFile, err)
+	}
+
+	scale, tiepoint, keyDirectory := tags[tagModelPixelScale], tags[tagModelTiepoint], tags[tagGeoKeyDirectory]
+	if len(scale) < 2 || len(tiepoint) < 6 || len(keyDirectory) < 4 {
+		fatalln(imageFile, "has no georeferencing we can use; it needs a tie point, a pixel scale and GeoTIFF keys")
+	}
+	keys := make(map[int]int)
+	for i := 4; i+3 < len(keyDirectory); i += 4 {
+		if keyDirectory[i+1] == 0 { // Stored in the directory itself, rather than in another tag
+			keys[int(keyDirectory[i])] = int(keyDirectory[i+3])
+		}
+	}
+
+	// The corners in reception.cfg are the centers of the corner pixels, as in a world file (see newGeoref), so
+	// work out where the center of the upper left pixel is, and from it the lower right corner
+	x0 := tiepoint[3] - tiepoint[0]*scale[0]
+	y0 := tiepoint[4] + tiepoint[1]*scale[1]
+	if keys[geoKeyRasterType] != rasterPixelIsPoint {
+		x0, y0 = x0+scale[0]/2, y0-scale[1]/2
+	}
+	x1 := x0 + float64(mapImage.Bounds().Dx())*scale[0]
+	y1 := y0 - float64(mapImage.Bounds().Dy())*scale[1]
+
+	epsg = keys[geoKeyProjectedCS]
+	if keys[geoKeyModelType] == modelTypeGeographic {
+		epsg = keys[geoKeyGeographicCS]
+	}
+	nw, err = modelToGPS(x0, y0, epsg)
+	if err == nil {
+		se, err = modelToGPS(x1, y1, epsg)
+	}
+	if err != nil {
+		fatalln("can't place", imageFile, "on the map:", err)
+	}
+	return mapImage, nw, se, epsg
+}
+
+// Function modelToGPS converts a GeoTIFF's model coordinates to GPS coordinates
+func modelToGPS(x, y float64, epsg int) (gpsCoord, error) {
+	switch {
+	case epsg == 4326: // WGS 84 latitude and longitude
+		return gpsCoord{y, x}, nil
+	case epsg == 3857: // Web Mercator, on a sphere the size of the WGS 84 equator
+		const radius = 6378137.0
+		lat := math.Atan(math.Sinh(y/radius)) * 180 / math.Pi
+		return gpsCoord{lat, x / radius * 180 / math.Pi}, nil
+	case epsg > 32600 && epsg <= 32660, epsg > 32700 && epsg <= 32760: // WGS 84 / UTM north and south
+		lat, long, err := UTM.ToLatLon(x, y, epsg%100, "", epsg < 32700)
+		return gpsCoord{lat, long}, err
+	case epsg == 0:
+		return gpsCoord{}, errors.New("its coordinate system isn't given")
+	default:
+		return gpsCoord{}, fmt.Errorf("its coordinate system, EPSG:%d, isn't supported; use WGS 84 (EPSG:4326), WGS 84 / UTM or Web Mercator (EPSG:3857)", epsg)
+	}
+}
+
+// Function readTIFFTags returns the numeric values of the tags in a TIFF file's first image file directory.
+// Values of every numeric type are returned as float64, which holds them all exactly; other tags are left out.
+func readTIFFTags(data []byte) (map[uint16][]float64, error) {
+	if len(data) < 8 {
+		return nil, errors.New("file too short")
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("not a TIFF file")
+	}
+
+	sizes := map[uint16]uint32{tiffShort: 2, tiffLong: 4, tiffFloat: 4, tiffDouble: 8}
+	directory := order.Uint32(data[4:])
+	if uint64(directory)+2 > uint64(len(data)) {
+		return nil, errors.New("image file directory is past the end of the file")
+	}
+	count := uint32(order.Uint16(data[directory:]))
+	if uint64(directory)+2+uint64(count)*12 > uint64(len(data)) {
+		return nil, errors.New("image file directory is past the end of the file")
+	}
+
+	tags := make(map[uint16][]float64)
+	for i := uint32(0); i < count; i++ {
+		entry := data[directory+2+i*12:]
+		tag, fieldType, n := order.Uint16(entry), order.Uint16(entry[2:]), order.Uint32(entry[4:])
+		size, numeric := sizes[fieldType]
+		if !numeric {
+			continue
+		}
+
+		// Values that fit in four bytes are in the entry itself; others are at the offset it gives
+		values := entry[8:12]
+		if uint64(size)*uint64(n) > 4 {
+			offset := order.Uint32(entry[8:])
+			if uint64(offset)+uint64(size)*uint64(n) > uint64(len(data)) {
+				return nil, fmt.Errorf("values of tag %d are past the end of the file", tag)
+			}
+			values = data[offset:]
+		}
+
+		for v := uint32(0); v < n; v++ {
+			switch fieldType {
+			case tiffShort:
+				tags[tag] = append(tags[tag], float64(order.Uint16(values[v*2:])))
+			case tiffLong:
+				tags[tag] = append(tags[tag], float64(order.Uint32(values[v*4:])))
+			case tiffFloat:
+				tags[tag] = append(tags[tag], float64(math.Float32frombits(order.Uint32(values[v*4:]))))
+			case tiffDouble:
+				tags[tag] = append(tags[tag], math.Float64frombits(order.Uint64(values[v*8:])))
+			}
+		}
+	}
+	return tags, nil
+}
@@ -0,0 +1,303 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A named area from a GIS file, such as a city, county or neighborhood. Each of its polygons is a list of rings
+// of [lat, long] points, the first ring being the polygon's outside and any others holes in it.
+type mapFeature struct {
+	name     string
+	polygons [][][][2]float64
+}
+
+// The parts of a GeoJSON FeatureCollection we use: each feature's name and the outline of its area
+type geoJSONFile struct {
+	Type     string
+	Features []struct {
+		Properties map[string]interface{}
+		Geometry   struct {
+			Type        string
+			Coordinates json.RawMessage
+		}
+	}
+}
+
+// The parts of a KML placemark we use: its name and the outer boundary of each of its polygons, as
+// "long,lat[,altitude]" triples separated by spaces
+type kmlPlacemark struct {
+	Name     string   `xml:"name"`
+	Polygons []string `xml:"Polygon>outerBoundaryIs>LinearRing>coordinates"`
+	Parts    []string `xml:"MultiGeometry>Polygon>outerBoundaryIs>LinearRing>coordinates"`
+}
+
+// Shapefile shape types with polygons or lines, which are stored the same way; the Z and M types have
+// elevations or measurements after the points, which we don't need
+var shapefilePolyTypes = map[int32]bool{3: true, 5: true, 13: true, 15: true, 23: true, 25: true}
+
+// Function readAreaFile reads the named areas in a GeoJSON (.geojson or .json), KML or ESRI shapefile (.shp),
+// which between them cover what most GIS programs, government open data sites and Google My Maps export.
+// Features that aren't areas, such as points, are left out.
+func readAreaFile(file string) []mapFeature {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		fatalln("can't open", file, err)
+	}
+
+	var features []mapFeature
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".geojson", ".json":
+		features, err = readGeoJSON(data)
+	case ".kml":
+		features, err = readKML(data)
+	case ".shp":
+		features, err = readShapefile(file, data)
+	default:
+		fatalf("%s should be GeoJSON (.geojson), KML (.kml) or a shapefile (.shp)", file)
+	}
+	if err != nil {
+		fatalln("can't read", file, err)
+	}
+	if len(features) == 0 {
+		fatalln("no areas found in", file)
+	}
+	return features
+}
+
+// Function readGeoJSON returns the areas in a GeoJSON FeatureCollection
+func readGeoJSON(data []byte) ([]mapFeature, error) {
+	var collection geoJSONFile
+	if err := json.Unmarshal(data, &collection); err != nil {
+		return nil, err
+	}
+	if collection.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("it should be a GeoJSON FeatureCollection, not a %s", collection.Type)
+	}
+
+	var features []mapFeature
+	for i, feature := range collection.Features {
+		var parts [][][][]float64 // Polygons, each a list of rings, each a list of [long, lat] points
+		var err error
+		switch feature.Geometry.Type {
+		case "Polygon":
+			var rings [][][]float64
+			err = json.Unmarshal(feature.Geometry.Coordinates, &rings)
+			parts = [][][][]float64{rings}
+		case "MultiPolygon":
+			err = json.Unmarshal(feature.Geometry.Coordinates, &parts)
+		default:
+			continue // Points, lines and so on aren't areas
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't parse the boundary of feature %d: %s", i+1, err)
+		}
+
+		f := mapFeature{name: featureName(feature.Properties)}
+		for _, rings := range parts {
+			var polygon [][][2]float64
+			for _, ring := range rings {
+				polygon = append(polygon, longLatRing(ring))
+			}
+			f.polygons = append(f.polygons, polygon)
+		}
+		features = append(features, f)
+	}
+	return features, nil
+}
+
+// Function readKML returns the areas in the placemarks of a KML file
+func readKML(data []byte) ([]mapFeature, error) {
+	var features []mapFeature
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break // End of the file
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "Placemark" {
+			continue
+		}
+		var placemark kmlPlacemark
+		if err := decoder.DecodeElement(&placemark, &start); err != nil {
+			return nil, err
+		}
+
+		f := mapFeature{name: strings.TrimSpace(placemark.Name)}
+		for _, coordinates := range append(placemark.Polygons, placemark.Parts...) {
+			var points [][]float64
+			for _, triple := range strings.Fields(coordinates) {
+				var point []float64
+				for _, value := range strings.Split(triple, ",") {
+					v, err := strconv.ParseFloat(value, 64)
+					if err != nil {
+						return nil, fmt.Errorf("can't parse coordinates of %q: %s", placemark.Name, err)
+					}
+					point = append(point, v)
+				}
+				points = append(points, point)
+			}
+			f.polygons = append(f.polygons, [][][2]float64{longLatRing(points)})
+		}
+		if len(f.polygons) > 0 {
+			features = append(features, f)
+		}
+	}
+	return features, nil
+}
+
+// Function readShapefile returns the areas in an ESRI shapefile, named from the .dbf file next to it. A shapefile
+// doesn't say which of an area's rings are holes, so they're all treated as separate polygons. Its coordinate
+// system comes from the .prj file next to it, as for a world file.
+func readShapefile(file string, data []byte) ([]mapFeature, error) {
+	if len(data) < 100 || binary.BigEndian.Uint32(data) != 9994 {
+		return nil, fmt.Errorf("not a shapefile")
+	}
+	looksLikeDegrees := true
+	for i, limit := range []float64{180, 90, 180, 90} { // The bounding box of all the shapes
+		looksLikeDegrees = looksLikeDegrees && math.Abs(math.Float64frombits(binary.LittleEndian.Uint64(data[36+i*8:]))) <= limit
+	}
+	epsg := worldFileEPSG(file, looksLikeDegrees)
+	if epsg == 0 {
+		return nil, fmt.Errorf("can't tell what coordinate system it's in; put a .prj file next to it saying")
+	}
+	names := readDBFNames(strings.TrimSuffix(file, filepath.Ext(file)) + ".dbf")
+
+	var features []mapFeature
+	for offset, record := 100, 0; offset+8 <= len(data); record++ {
+		length := int(binary.BigEndian.Uint32(data[offset+4:])) * 2 // In 16-bit words
+		content := data[offset+8:]
+		offset += 8 + length
+		if length < 44 || len(content) < length || !shapefilePolyTypes[int32(binary.LittleEndian.Uint32(content))] {
+			continue // Null shapes, points and so on
+		}
+
+		numParts := int(binary.LittleEndian.Uint32(content[36:]))
+		numPoints := int(binary.LittleEndian.Uint32(content[40:]))
+		points := 44 + numParts*4
+		if numParts < 1 || points+numPoints*16 > length {
+			return nil, fmt.Errorf("shape %d is damaged", record+1)
+		}
+
+		f := mapFeature{}
+		if record < len(names) {
+			f.name = names[record]
+		}
+		for part := 0; part < numParts; part++ {
+			start := int(binary.LittleEndian.Uint32(content[36+8+part*4:]))
+			end := numPoints
+			if part+1 < numParts {
+				end = int(binary.LittleEndian.Uint32(content[36+8+(part+1)*4:]))
+			}
+			if start < 0 || start > end || end > numPoints {
+				return nil, fmt.Errorf("shape %d is damaged", record+1)
+			}
+
+			var ring [][2]float64
+			for i := start; i < end; i++ {
+				at := content[points+i*16:]
+				x := math.Float64frombits(binary.LittleEndian.Uint64(at))
+				y := math.Float64frombits(binary.LittleEndian.Uint64(at[8:]))
+				gps, err := modelToGPS(x, y, epsg)
+				if err != nil {
+					return nil, err
+				}
+				ring = append(ring, [2]float64{gps.lat, gps.long})
+			}
+			f.polygons = append(f.polygons, [][][2]float64{openRing(ring)})
+		}
+		features = append(features, f)
+	}
+	return features, nil
+}
+
+// Function readDBFNames returns the name of each record in a shapefile's .dbf file, from its NAME field or
+// failing that the first field with "NAME" in its name, or nothing if there's no .dbf file
+func readDBFNames(file string) []string {
+	data, err := ioutil.ReadFile(file)
+	if err != nil || len(data) < 32 {
+		return nil
+	}
+	numRecords := int(binary.LittleEndian.Uint32(data[4:]))
+	headerLength := int(binary.LittleEndian.Uint16(data[8:]))
+	recordLength := int(binary.LittleEndian.Uint16(data[10:]))
+
+	// Field descriptors are 32 bytes each, after the 32-byte header, up to a 0x0D byte. Records are one field after
+	nameStart, nameLength := -1, 0
+	// field, and each record starts with a deletion flag
+	for at, fieldStart := 32, 1; at+32 <= len(data) && data[at] != 0x0d; at += 32 {
+		name := strings.ToUpper(string(bytes.TrimRight(data[at:at+11], "\x00")))
+		length := int(data[at+16])
+		if name == "NAME" || (nameStart < 0 && strings.Contains(name, "NAME")) {
+			nameStart, nameLength = fieldStart, length
+		}
+		fieldStart += length
+	}
+	if nameStart < 0 {
+		return nil
+	}
+
+	names := make([]string, numRecords)
+	for i := range names {
+		at := headerLength + i*recordLength + nameStart
+		if at+nameLength > len(data) {
+			break
+		}
+		names[i] = strings.TrimSpace(string(data[at : at+nameLength]))
+	}
+	return names
+}
+
+// Function featureName returns the name of a GeoJSON feature, from whichever of the usual properties it's in
+func featureName(properties map[string]interface{}) string {
+	for _, key := range []string{"name", "Name", "NAME", "title", "label"} {
+		if name, ok := properties[key].(string); ok && name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// Function longLatRing converts a GeoJSON or KML ring of [long, lat] points, which may have an altitude too,
+// into a ring of [lat, long] points
+func longLatRing(points [][]float64) [][2]float64 {
+	var ring [][2]float64
+	for _, p := range points {
+		if len(p) < 2 {
+			fatalln("boundary point has no latitude", p)
+		}
+		ring = append(ring, [2]float64{p[1], p[0]})
+	}
+	return openRing(ring)
+}
+
+// Function openRing returns a ring without its last point if that's the same as its first, as GIS files have it
+func openRing(ring [][2]float64) [][2]float64 {
+	if n := len(ring); n > 1 && ring[0] == ring[n-1] {
+		return ring[:n-1]
+	}
+	return ring
+}
@@ -0,0 +1,189 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Directory the golden-image test's settings and reference maps are in
+const goldenDirectory = "golden"
+
+// Number of maps drawn at the same time in the test's second drawing, more than one even on one core
+const goldenJobs = 4
+
+// Color the pixels that differ from a reference map are drawn in, on a faded copy of the map
+var goldenDiffColor = color.RGBA{0xff, 0x00, 0x00, 0xff}
+
+var updateGolden = flag.Bool("update", false, "Replace the reference maps in golden with the maps drawn now")
+
+// Function TestGolden draws the maps set up by the reception.cfg in the golden directory and checks that they
+// match the reference maps there pixel for pixel, so a change to how maps are drawn can't move an icon or a label
+// without anyone noticing. The maps are drawn with a fixed start time and the built-in font and icons unless the
+// settings name others, first one at a time and then all at once, since drawing them at the same time mustn't
+// change them either. With -update, the maps drawn one at a time become the new references.
+func TestGolden(t *testing.T) {
+	defer func(saved config) { cfg = saved }(cfg)
+	defer func(saved func(gpsCoord) image.Point) { gpsToPixel = saved }(gpsToPixel)
+	t.Setenv("SOURCE_DATE_EPOCH", "946684800") // 2000-01-01
+	defer func(saved time.Time) { startTime = saved }(startTime)
+	startTime = runStartTime()
+
+	var outputs []string
+	for _, jobs := range []int{1, goldenJobs} {
+		output, err := os.MkdirTemp("", "reception-golden-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		loadGoldenConfig(t, output, jobs)
+		generateMaps()
+		outputs = append(outputs, output)
+	}
+
+	if *updateGolden {
+		maps := goldenMaps(t, outputs[0])
+		for _, file := range maps {
+			data, err := os.ReadFile(filepath.Join(outputs[0], file))
+			if err == nil {
+				err = writeFileAtomic(filepath.Join(goldenDirectory, file), func(w io.Writer) error { _, err := w.Write(data); return err })
+			}
+			if err != nil {
+				t.Fatalf("can't update reference map: %s", err)
+			}
+		}
+		t.Logf("Updated %d reference maps in %s", len(maps), goldenDirectory)
+	}
+
+	// The maps drawn one at a time are checked against the references, and those drawn together against them
+	checked := goldenMaps(t, goldenDirectory)
+	if len(checked) == 0 {
+		t.Fatalf("no reference maps in %s; make them with go test -run TestGolden -update", goldenDirectory)
+	}
+	for _, file := range checked {
+		for _, output := range outputs {
+			if problem := compareGolden(filepath.Join(goldenDirectory, file), filepath.Join(output, file)); problem != "" {
+				t.Errorf("%s: %s", filepath.Join(output, file), problem)
+			}
+		}
+	}
+	for _, file := range goldenMaps(t, outputs[0]) {
+		if _, err := os.Stat(filepath.Join(goldenDirectory, file)); err != nil {
+			t.Errorf("%s has no reference map; add it with go test -run TestGolden -update", file)
+		}
+	}
+	if t.Failed() {
+		t.Logf("the maps drawn are in %s", strings.Join(outputs, " and "))
+		return
+	}
+	for _, output := range outputs {
+		os.RemoveAll(output)
+	}
+}
+
+// Function loadGoldenConfig sets cfg to the golden-image test's settings, on top of the built-in defaults as for
+// any reception.cfg, to draw maps into a directory the given number at a time
+func loadGoldenConfig(t *testing.T, output string, jobs int) {
+	cfg = config{}
+	loadDefaults(&cfg)
+	if _, err := loadConfig(filepath.Join(goldenDirectory, configFileName), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	localPaths(reflect.ValueOf(&cfg))
+	if cfg.Scale > 0 && cfg.Scale != 1 {
+		cfg = scaledSettings(cfg, cfg.Scale)
+	}
+	cfg.OutputDirectory, cfg.Jobs, cfg.Progress = output, jobs, progressNone
+}
+
+// Function goldenMaps returns the PNG files in a directory, sorted, leaving out the pictures of differences
+// the golden-image test leaves beside maps that don't match
+func goldenMaps(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var maps []string
+	for _, e := range entries {
+		if name := e.Name(); e.Type().IsRegular() && filepath.Ext(name) == ".png" && !strings.HasSuffix(name, "-diff.png") {
+			maps = append(maps, name)
+		}
+	}
+	sort.Strings(maps)
+	return maps
+}
+
+// Function compareGolden compares a map with its reference, returning what's wrong if they differ, or "" if every
+// pixel is the same. If they differ, a picture of where is written beside the map, as name-diff.png.
+func compareGolden(reference, file string) string {
+	want, err := decodePNGFile(reference)
+	if err != nil {
+		return err.Error()
+	}
+	got, err := decodePNGFile(file)
+	if err != nil {
+		return err.Error()
+	}
+	if want.Bounds() != got.Bounds() {
+		return fmt.Sprintf("size is %v instead of %v", got.Bounds().Size(), want.Bounds().Size())
+	}
+
+	// The picture of the differences is the map faded to a third, with the pixels that differ in red
+	bounds := got.Bounds()
+	diffPtr := image.NewRGBA(bounds)
+	var differ image.Rectangle
+	count := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			w, g := color.RGBAModel.Convert(want.At(x, y)), color.RGBAModel.Convert(got.At(x, y)).(color.RGBA)
+			if w != g {
+				diffPtr.SetRGBA(x, y, goldenDiffColor)
+				differ = differ.Union(image.Rect(x, y, x+1, y+1))
+				count++
+				continue
+			}
+			diffPtr.SetRGBA(x, y, color.RGBA{0xff - (0xff-g.R)/3, 0xff - (0xff-g.G)/3, 0xff - (0xff-g.B)/3, 0xff})
+		}
+	}
+	if count == 0 {
+		return ""
+	}
+	diffFile := strings.TrimSuffix(file, ".png") + "-diff.png"
+	if err := writeFileAtomic(diffFile, func(w io.Writer) error { return png.Encode(w, diffPtr) }); err != nil {
+		return fmt.Sprintf("%d pixels differ, between %v and %v (and can't write %s: %s)", count, differ.Min, differ.Max, diffFile, err)
+	}
+	return fmt.Sprintf("%d pixels differ, between %v and %v (see %s)", count, differ.Min, differ.Max, filepath.Base(diffFile))
+}
+
+// Function decodePNGFile reads a PNG image from a file
+func decodePNGFile(file string) (image.Image, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
@@ -0,0 +1,142 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The parts of a Google service account key file we need
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// Function newGoogleClient returns an HTTP client that authenticates to Google APIs as the service account in
+// cfg.GoogleCredentials, with access to the given OAuth scopes. The service account needs to have been given
+// access to whatever Drive folders or Sheets we touch, just as a person would.
+func newGoogleClient(scopes ...string) *http.Client {
+	keyJSON, err := ioutil.ReadFile(cfg.GoogleCredentials)
+	if err != nil {
+		fatalln("can't open Google credentials file", cfg.GoogleCredentials, err)
+	}
+	var key serviceAccountKey
+	if err := json.Unmarshal(keyJSON, &key); err != nil {
+		fatalln("can't parse Google credentials file", cfg.GoogleCredentials, err)
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	token := fetchGoogleToken(key, strings.Join(scopes, " "))
+	return &http.Client{
+		Timeout:   5 * time.Minute,
+		Transport: bearerTransport{token, http.DefaultTransport},
+	}
+}
+
+// Function fetchGoogleToken exchanges a signed JWT for an OAuth access token, per Google's service account flow.
+// Tokens last an hour, which is plenty for one run.
+func fetchGoogleToken(key serviceAccountKey, scope string) string {
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		fatalln("Google credentials file has no private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		fatalln("can't parse Google service account private key", err)
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		fatalln("Google service account private key isn't an RSA key")
+	}
+
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   key.ClientEmail,
+		"scope": scope,
+		"aud":   key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		fatalln("can't sign Google token request", err)
+	}
+	assertion := unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.PostForm(key.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		fatalln("can't get Google access token", err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		fatalln("can't parse Google access token", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		fatalln("can't get Google access token:", resp.Status, token.Error)
+	}
+	return token.AccessToken
+}
+
+// An http.RoundTripper that adds an OAuth bearer token to every request
+type bearerTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(req)
+}
+
+// Function googleAPIError turns an unsuccessful Google API response into an error that includes Google's
+// explanation, which is usually specific enough to fix the problem.
+func googleAPIError(resp *http.Response) error {
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return fmt.Errorf("%s: %s", resp.Status, body.Error.Message)
+}
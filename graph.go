@@ -0,0 +1,319 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The reports as a directed graph of who is heard by whom: an edge runs from each transmitter to every station
+// that heard it, with a report that has an icon. Every station in the reports is in the graph, heard or not.
+type reportGraph map[string]map[string]bool
+
+// Function networkCommand analyzes the reports as a network for relay planning: which groups of stations can get
+// messages to each other (directly or through relays), which stations are critical because others would be cut
+// off without them, and a small set of relay stations that between them are in two-way contact with everyone.
+// With -without it instead asks what if those stations were unavailable, writing a gap report and a map of who
+// would be cut off.
+func networkCommand(args []string) {
+	flags := flag.NewFlagSet("network", flag.ExitOnError)
+	reportFile := flags.String("reports", cfg.ReportFile, "Report file to analyze")
+	without := flags.String("without", "", "Comma-separated stations to take out, to see who would be cut off without them")
+	flags.Parse(args)
+
+	cfg.RcvMapFlag = false // Reports are always wanted as transmitter -> receiver here, whatever reception.cfg says
+	fatalStatus = exitDataError
+	reports, _, _ := loadReports(*reportFile)
+	icons := loadIcons(cfg.IconDirectory)
+	g := newReportGraph(reports, icons)
+	if *without != "" {
+		whatIfCommand(g, icons, *reportFile, *without)
+		return
+	}
+
+	links, twoWay := 0, 0
+	for from, heard := range g {
+		for to := range heard {
+			links++
+			if g[to][from] {
+				twoWay++
+			}
+		}
+	}
+	fmt.Printf("%d stations, %d links (%d of them two-way pairs)\n", len(g), links, twoWay/2)
+
+	var isolated []string
+	fmt.Println("Groups that can relay messages among themselves:")
+	for i, group := range g.components() {
+		if len(group) == 1 {
+			isolated = append(isolated, group[0])
+			continue
+		}
+		fmt.Printf("  %d. %d stations: %s\n", i+1, len(group), strings.Join(group, ", "))
+	}
+	if len(isolated) > 0 {
+		fmt.Printf("  Cut off (can't both reach and be reached by anyone): %s\n", strings.Join(isolated, ", "))
+	}
+
+	fmt.Println("Critical relays (others are cut off from their group without them):")
+	critical := g.criticalStations()
+	if len(critical) == 0 {
+		fmt.Println("  none")
+	}
+	var stations []string
+	for station := range critical {
+		stations = append(stations, station)
+	}
+	sort.Strings(stations)
+	for _, station := range stations {
+		fmt.Printf("  %s: cuts off %s\n", station, strings.Join(critical[station], ", "))
+	}
+
+	relays, unreachable := g.relaySet()
+	fmt.Printf("Relay set: %s\n", strings.Join(relays, ", "))
+	if len(unreachable) > 0 {
+		fmt.Printf("  No two-way contact with anyone: %s\n", strings.Join(unreachable, ", "))
+	}
+}
+
+// Function whatIfCommand prints and maps what happens to the net if some stations are unavailable
+func whatIfCommand(g reportGraph, icons map[string]image.Image, reportFile, without string) {
+	var removed []string
+	for _, call := range strings.Split(without, ",") {
+		call = strings.ToUpper(strings.TrimSpace(call))
+		if _, present := g[call]; !present {
+			fatalf("%s isn't in the reports", call)
+		}
+		removed = append(removed, call)
+	}
+	stations := whatIf(g, removed)
+
+	var cutOff []string
+	netBefore, netAfter := 0, 0
+	for _, s := range stations {
+		if s.wasInNet {
+			netBefore++
+		}
+		switch s.status {
+		case cutOffStation:
+			cutOff = append(cutOff, s.call)
+		case netStation:
+			netAfter++
+		}
+	}
+	fmt.Printf("Without %s, the net goes from %d stations to %d\n", strings.Join(removed, ", "), netBefore, netAfter)
+	if len(cutOff) == 0 {
+		fmt.Println("  No one else is cut off")
+	} else {
+		fmt.Printf("  Cut off: %s\n", strings.Join(cutOff, ", "))
+	}
+
+	baseMap := loadBaseMap(cfg.MapFile)
+	gpsToPixel = newGpsToPixel(baseMap)
+	operators := loadOperators(cfg.OperatorFile)
+	sourceFiles = []string{cfg.OperatorFile, cfg.MapFile, reportFile}
+	if err := os.MkdirAll(cfg.OutputDirectory, 0755); err != nil {
+		fatalf("Failed to create output directory: %s", err)
+	}
+	writeWhatIf(stations)
+	fmt.Println("Wrote", filepath.Join(cfg.OutputDirectory, whatIfFile))
+	writeWhatIfMap(baseMap, icons, operators, stations, removed)
+	fmt.Println("Wrote", filepath.Join(cfg.OutputDirectory, whatIfMapFile))
+}
+
+// Function newReportGraph builds the graph of who hears whom from a set of reports
+func newReportGraph(reports map[string]map[string]string, icons map[string]image.Image) reportGraph {
+	g := make(reportGraph)
+	for station := range stationsIn(reports) {
+		g[station] = make(map[string]bool)
+	}
+	for transmitter, heard := range reports {
+		for receiver, report := range heard {
+			if _, present := icons[report]; present && receiver != transmitter {
+				g[transmitter][receiver] = true
+			}
+		}
+	}
+	return g
+}
+
+// Function without returns a copy of the graph with a station taken out
+func (g reportGraph) without(station string) reportGraph {
+	rest := make(reportGraph)
+	for from, heard := range g {
+		if from == station {
+			continue
+		}
+		rest[from] = make(map[string]bool)
+		for to := range heard {
+			if to != station {
+				rest[from][to] = true
+			}
+		}
+	}
+	return rest
+}
+
+// Function within returns the part of the graph among a group of stations
+func (g reportGraph) within(group []string) reportGraph {
+	part := make(reportGraph)
+	for _, station := range group {
+		part[station] = make(map[string]bool)
+	}
+	for _, from := range group {
+		for to := range g[from] {
+			if _, member := part[to]; member {
+				part[from][to] = true
+			}
+		}
+	}
+	return part
+}
+
+// Function components returns the graph's strongly connected components: the groups of stations in which each
+// can get a message to every other, directly or through relays. Groups are largest first and each is in call
+// sign order. This is Tarjan's algorithm.
+func (g reportGraph) components() [][]string {
+	index := make(map[string]int)
+	low := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var groups [][]string
+
+	var visit func(station string)
+	visit = func(station string) {
+		index[station] = len(index)
+		low[station] = index[station]
+		stack = append(stack, station)
+		onStack[station] = true
+		for _, next := range sortedCalls(g[station]) {
+			if _, seen := index[next]; !seen {
+				visit(next)
+				if low[next] < low[station] {
+					low[station] = low[next]
+				}
+			} else if onStack[next] && index[next] < low[station] {
+				low[station] = index[next]
+			}
+		}
+
+		if low[station] == index[station] {
+			var group []string
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				group = append(group, top)
+				if top == station {
+					break
+				}
+			}
+			sort.Strings(group)
+			groups = append(groups, group)
+		}
+	}
+	for _, station := range g.stations() {
+		if _, seen := index[station]; !seen {
+			visit(station)
+		}
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		if len(groups[i]) != len(groups[j]) {
+			return len(groups[i]) > len(groups[j])
+		}
+		return groups[i][0] < groups[j][0]
+	})
+	return groups
+}
+
+// Function criticalStations returns the stations without which others in their group could no longer exchange
+// messages with the rest of it, with the stations each would cut off: the articulation points of the directed
+// graph. What's cut off is everything outside the largest piece the group falls into.
+func (g reportGraph) criticalStations() map[string][]string {
+	critical := make(map[string][]string)
+	for _, group := range g.components() {
+		if len(group) < 3 {
+			continue
+		}
+		for _, station := range group {
+			pieces := g.within(group).without(station).components()
+			if len(pieces) < 2 {
+				continue
+			}
+			for _, piece := range pieces[1:] {
+				critical[station] = append(critical[station], piece...)
+			}
+			sort.Strings(critical[station])
+		}
+	}
+	return critical
+}
+
+// Function relaySet returns a small set of relay stations that between them are in two-way contact with every
+// station that's in two-way contact with anyone, chosen greedily (so it isn't always the smallest possible),
+// and the stations in two-way contact with no one
+func (g reportGraph) relaySet() (relays, unreachable []string) {
+	neighbors := make(map[string][]string)
+	for _, station := range g.stations() {
+		for _, other := range sortedCalls(g[station]) {
+			if g[other][station] {
+				neighbors[station] = append(neighbors[station], other)
+			}
+		}
+		if len(neighbors[station]) == 0 {
+			unreachable = append(unreachable, station)
+		}
+	}
+
+	covered := make(map[string]bool)
+	for len(covered) < len(neighbors) {
+		best, bestCount := "", 0
+		for _, station := range g.stations() {
+			count := 0
+			for _, s := range append([]string{station}, neighbors[station]...) {
+				if !covered[s] && len(neighbors[s]) > 0 {
+					count++
+				}
+			}
+			if count > bestCount {
+				best, bestCount = station, count
+			}
+		}
+		relays = append(relays, best)
+		covered[best] = true
+		for _, s := range neighbors[best] {
+			covered[s] = true
+		}
+	}
+	sort.Strings(relays)
+	return relays, unreachable
+}
+
+// Function stations returns the stations in the graph, in call sign order
+func (g reportGraph) stations() []string {
+	stations := make([]string, 0, len(g))
+	for station := range g {
+		stations = append(stations, station)
+	}
+	sort.Strings(stations)
+	return stations
+}
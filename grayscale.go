@@ -0,0 +1,181 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// A shape is one or more polygons, in coordinates where the icon spans -1 to 1 in each direction (y increasing
+// downward). The shape covers any point inside one of its polygons.
+type shape [][]shapePoint
+
+// A point in shape coordinates
+type shapePoint struct{ x, y float64 }
+
+// Shapes used for reports in grayscale mode, from best reception to worst. Reports beyond the last shape all
+// get the last one.
+var reportShapes = []shape{
+	regularPolygon(48, 0.8, 0),                             // Circle
+	translate(regularPolygon(3, 1.0, -math.Pi/2), 0, 0.25), // Triangle, point up
+	regularPolygon(4, 0.88, -math.Pi/4),                    // Square
+	{xStroke(math.Pi / 4), xStroke(-math.Pi / 4)},          // X
+}
+
+// Shape used for the transmitter in grayscale mode: a five-pointed star
+var transmitterShape = starShape(5, 0.9, 0.4)
+
+// How much of the way to white the base map is lightened in grayscale mode, so the icons and labels stand out
+const grayscaleLighten = 0.45
+
+// Function grayscaleMap returns a lightened grayscale copy of a base map, for maps that will be printed or
+// photocopied in black and white.
+func grayscaleMap(baseMap image.Image) image.Image {
+	bounds := baseMap.Bounds()
+	grayPtr := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			lum := float64(color.GrayModel.Convert(baseMap.At(x, y)).(color.Gray).Y)
+			grayPtr.SetGray(x, y, color.Gray{uint8(lum + (255-lum)*grayscaleLighten + 0.5)})
+		}
+	}
+	return grayPtr
+}
+
+// Function adjustBrightness returns a copy of a base map lightened or dimmed so the icons stand out from it:
+// brightness blends it part of the way to white if it's positive, or to black if it's negative
+func adjustBrightness(baseMap image.Image, brightness float64) image.Image {
+	brightness = math.Max(-1, math.Min(1, brightness))
+	target := 0.0
+	if brightness > 0 {
+		target = 0xffff
+	}
+	amount := math.Abs(brightness)
+
+	bounds := baseMap.Bounds()
+	mapPtr := image.NewRGBA64(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := baseMap.At(x, y).RGBA()
+			adjust := func(v uint32) uint16 {
+				return uint16(float64(v) + (target*float64(a)/0xffff-float64(v))*amount + 0.5)
+			}
+			mapPtr.SetRGBA64(x, y, color.RGBA64{adjust(r), adjust(g), adjust(b), uint16(a)})
+		}
+	}
+	return mapPtr
+}
+
+// Function shapeIcons returns icons for grayscale mode: each report's colored icon is replaced by a black shape,
+// which stays distinct in black and white where the colors wouldn't. Shapes are assigned in order of reception
+// quality, so the best report gets a circle, then a triangle, a square, and an X for anything worse.
+func shapeIcons(icons map[string]image.Image) map[string]image.Image {
+	var reports []string
+	for name := range icons {
+		if name != cfg.TransIcon {
+			reports = append(reports, name)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool { return worseReport(reports[j], reports[i]) })
+
+	shaped := make(map[string]image.Image)
+	for i, report := range reports {
+		if i >= len(reportShapes) {
+			i = len(reportShapes) - 1
+		}
+		shaped[report] = drawShape(reportShapes[i], int(cfg.IconSize))
+	}
+	if _, present := icons[cfg.TransIcon]; present {
+		shaped[cfg.TransIcon] = drawShape(transmitterShape, int(cfg.IconSize))
+	}
+	return shaped
+}
+
+// Function drawShape draws a shape in black, with a thin white outline to separate it from the map, on a
+// transparent square image of the given size
+func drawShape(s shape, size int) image.Image {
+	return fillShape(s, size, color.Black)
+}
+
+// Function fillShape draws a shape filled with a color, with a thin white outline to separate it from the map, on
+// a transparent square image of the given size
+func fillShape(s shape, size int, fill color.Color) image.Image {
+	const outline = 0.12 // Width of the white outline, in shape coordinates
+
+	iconPtr := image.NewRGBA(image.Rect(0, 0, size, size))
+	scale := float64(size) / 2
+	var polygons [][]pixelPoint
+	for _, poly := range s {
+		var polygon []pixelPoint
+		for _, p := range poly {
+			polygon = append(polygon, pixelPoint{(p.x + 1) * scale, (p.y + 1) * scale})
+		}
+		strokeRing(iconPtr, polygon, 2*outline*scale, color.White)
+		polygons = append(polygons, polygon)
+	}
+	fillPolygons(iconPtr, polygons, fill)
+	return iconPtr
+}
+
+// Function regularPolygon returns a regular polygon with n sides, its vertices on a circle of the given radius,
+// the first at the given angle
+func regularPolygon(n int, radius, angle float64) shape {
+	poly := make([]shapePoint, n)
+	for i := range poly {
+		a := angle + 2*math.Pi*float64(i)/float64(n)
+		poly[i] = shapePoint{radius * math.Cos(a), radius * math.Sin(a)}
+	}
+	return shape{poly}
+}
+
+// Function translate returns a shape moved by dx, dy. A triangle's center is lower than the middle of its bounding
+// box, so it needs moving down to be centered on the icon.
+func translate(s shape, dx, dy float64) shape {
+	moved := make(shape, len(s))
+	for i, poly := range s {
+		for _, p := range poly {
+			moved[i] = append(moved[i], shapePoint{p.x + dx, p.y + dy})
+		}
+	}
+	return moved
+}
+
+// Function starShape returns a star with the given number of points, its tips at the outer radius and the
+// notches between them at the inner radius
+func starShape(points int, outer, inner float64) shape {
+	poly := make([]shapePoint, 2*points)
+	for i := range poly {
+		radius := outer
+		if i%2 == 1 {
+			radius = inner
+		}
+		a := -math.Pi/2 + math.Pi*float64(i)/float64(points)
+		poly[i] = shapePoint{radius * math.Cos(a), radius * math.Sin(a)}
+	}
+	return shape{poly}
+}
+
+// Function xStroke returns one stroke of an X: a long thin rectangle through the center at the given angle
+func xStroke(angle float64) []shapePoint {
+	const length, width = 0.8, 0.18 // Half-length and half-width
+	c, s := math.Cos(angle), math.Sin(angle)
+	corner := func(along, across float64) shapePoint {
+		return shapePoint{along*c - across*s, along*s + across*c}
+	}
+	return []shapePoint{corner(length, width), corner(-length, width), corner(-length, -width), corner(length, -width)}
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Color of the halo around text
+var textHalo = color.RGBA{0xff, 0xff, 0xff, 0xff}
+
+// Function addTextHalo puts a halo cfg.TextHalo pixels wide around everything drawn on a text layer, so labels
+// stay readable over parks, water and other dark parts of the base map
+func addTextHalo(textMapPtr *image.RGBA) {
+	width := cfg.TextHalo
+	if width <= 0 {
+		return
+	}
+
+	// How much of the halo's color each pixel within reach of a text pixel gets, smoothed at the edge
+	reach := int(math.Ceil(width))
+	type offset struct {
+		dx, dy   int
+		coverage float64
+	}
+	var kernel []offset
+	for dy := -reach; dy <= reach; dy++ {
+		for dx := -reach; dx <= reach; dx++ {
+			if c := math.Min(1, width+0.5-math.Hypot(float64(dx), float64(dy))); c > 0 {
+				kernel = append(kernel, offset{dx, dy, c})
+			}
+		}
+	}
+
+	bounds := textMapPtr.Bounds()
+	alpha := make([]uint8, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			a := textMapPtr.RGBAAt(x, y).A
+			if a == 0 {
+				continue
+			}
+			for _, k := range kernel {
+				hx, hy := x+k.dx, y+k.dy
+				if !(image.Point{hx, hy}.In(bounds)) {
+					continue
+				}
+				i := (hy-bounds.Min.Y)*bounds.Dx() + (hx - bounds.Min.X)
+				if v := uint8(float64(a)*k.coverage + 0.5); v > alpha[i] {
+					alpha[i] = v
+				}
+			}
+		}
+	}
+
+	haloPtr := image.NewRGBA(bounds)
+	r, g, b, _ := textHalo.RGBA()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if a := uint32(alpha[(y-bounds.Min.Y)*bounds.Dx()+(x-bounds.Min.X)]); a > 0 {
+				haloPtr.SetRGBA(x, y, color.RGBA{uint8(r * a / 0xffff), uint8(g * a / 0xffff), uint8(b * a / 0xffff), uint8(a)})
+			}
+		}
+	}
+	draw.Draw(haloPtr, bounds, textMapPtr, bounds.Min, draw.Over)
+	draw.Draw(textMapPtr, bounds, haloPtr, bounds.Min, draw.Src)
+}
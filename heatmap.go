@@ -0,0 +1,163 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+
+	"github.com/nfnt/resize"
+)
+
+// Spacing in pixels of the grid reception is estimated on; the heatmap is smoothly enlarged to the map's size
+const heatmapStep = 4
+
+// Reception estimated across a map from the receivers' reports, on a grid heatmapStep pixels apart
+type qualitySurface struct {
+	bounds      image.Rectangle        // The map the grid covers
+	cols, rows  int                    // Size of the grid
+	values      []float64              // Estimated report at each grid point, or NaN too far from any receiver
+	strength    []float64              // How far the estimate has faded, from 1 (not at all) to 0 (gone)
+	levels      []float64              // The reports receivers gave, by their scores, worst first
+	reports     map[float64]string     // The report each level was read from
+	levelColors map[float64]color.RGBA // Color of the icon for each level
+}
+
+// Function newQualitySurface estimates reception around the receivers by inverse distance weighting of their
+// reports. The estimate fades out HeatmapRadius meters from the nearest receiver, beyond which there's nothing to
+// base it on. Reports that aren't numbers can't be interpolated, so receivers with them are left out; if that's
+// all of them, there's no surface and it returns nil.
+func newQualitySurface(markers []marker, bounds image.Rectangle, metersPerPixel float64) *qualitySurface {
+	type sample struct {
+		x, y, value float64
+	}
+	var samples []sample
+	s := &qualitySurface{bounds: bounds, reports: make(map[float64]string), levelColors: make(map[float64]color.RGBA)}
+	for _, m := range markers {
+		value, ok := reportScore(m.report)
+		if !ok || m.operator.callsign == "" || m.cluster {
+			continue
+		}
+		if _, known := s.reports[value]; !known {
+			s.reports[value] = m.report
+			s.levels = append(s.levels, value)
+			if c := iconColor(m.icon); c != nil {
+				s.levelColors[value] = color.RGBAModel.Convert(c).(color.RGBA)
+			}
+		}
+		samples = append(samples, sample{float64(m.operator.pixel.X), float64(m.operator.pixel.Y), value})
+	}
+	if len(samples) == 0 || metersPerPixel <= 0 {
+		return nil
+	}
+	sort.Float64s(s.levels)
+
+	radius := cfg.HeatmapRadius / metersPerPixel
+	s.cols, s.rows = bounds.Dx()/heatmapStep+1, bounds.Dy()/heatmapStep+1
+	s.values, s.strength = make([]float64, s.cols*s.rows), make([]float64, s.cols*s.rows)
+	for gy := 0; gy < s.rows; gy++ {
+		for gx := 0; gx < s.cols; gx++ {
+			x, y := float64(bounds.Min.X+gx*heatmapStep), float64(bounds.Min.Y+gy*heatmapStep)
+			var weights, total float64
+			nearest := math.Inf(1)
+			exact := math.NaN()
+			for _, sm := range samples {
+				d2 := (sm.x-x)*(sm.x-x) + (sm.y-y)*(sm.y-y)
+				nearest = math.Min(nearest, d2)
+				if d2 == 0 {
+					exact = sm.value
+					break
+				}
+				weights += 1 / d2
+				total += sm.value / d2
+			}
+
+			i := gy*s.cols + gx
+			s.values[i] = math.NaN()
+			fade := 1 - math.Sqrt(nearest)/radius
+			if fade <= 0 {
+				continue
+			}
+			s.values[i], s.strength[i] = total/weights, math.Min(1, fade*2) // Full strength out to half the radius
+			if !math.IsNaN(exact) {
+				s.values[i] = exact
+			}
+		}
+	}
+	return s
+}
+
+// Function value returns the estimate at a grid point, or NaN if there isn't one
+func (s *qualitySurface) value(gx, gy int) float64 {
+	if gx < 0 || gy < 0 || gx >= s.cols || gy >= s.rows {
+		return math.NaN()
+	}
+	return s.values[gy*s.cols+gx]
+}
+
+// Function pixel returns where on the map a point on the grid is
+func (s *qualitySurface) pixel(gx, gy float64) (x, y float64) {
+	return float64(s.bounds.Min.X) + gx*heatmapStep, float64(s.bounds.Min.Y) + gy*heatmapStep
+}
+
+// Function colorAt returns the color for an estimated report, part way between the colors of the reports on
+// either side of it
+func (s *qualitySurface) colorAt(value float64) color.RGBA {
+	levelColor := func(level float64) color.RGBA {
+		if c, present := s.levelColors[level]; present {
+			return c
+		}
+		return color.RGBA{0x80, 0x80, 0x80, 0xff}
+	}
+	i := sort.SearchFloat64s(s.levels, value)
+	switch {
+	case i == 0:
+		return levelColor(s.levels[0])
+	case i == len(s.levels):
+		return levelColor(s.levels[len(s.levels)-1])
+	}
+	lo, hi := levelColor(s.levels[i-1]), levelColor(s.levels[i])
+	t := (value - s.levels[i-1]) / (s.levels[i] - s.levels[i-1])
+	mix := func(a, b uint8) uint8 { return uint8(float64(a) + (float64(b)-float64(a))*t + 0.5) }
+	return color.RGBA{mix(lo.R, hi.R), mix(lo.G, hi.G), mix(lo.B, hi.B), 0xff}
+}
+
+// Function drawHeatmap shades a map with the estimated reception, in the colors of the reports' icons, so it
+// shows a coverage footprint rather than isolated dots. It goes under the icons.
+func drawHeatmap(dstPtr *image.RGBA, s *qualitySurface) {
+	if s == nil {
+		return
+	}
+	opacity := math.Max(0, math.Min(1, cfg.HeatmapOpacity))
+	grid := image.NewRGBA(image.Rect(0, 0, s.cols, s.rows))
+	for gy := 0; gy < s.rows; gy++ {
+		for gx := 0; gx < s.cols; gx++ {
+			v := s.value(gx, gy)
+			if math.IsNaN(v) {
+				continue
+			}
+			c := s.colorAt(v)
+			a := opacity * s.strength[gy*s.cols+gx]
+			grid.SetRGBA(gx, gy, color.RGBA{uint8(float64(c.R)*a + 0.5), uint8(float64(c.G)*a + 0.5),
+				uint8(float64(c.B)*a + 0.5), uint8(0xff*a + 0.5)})
+		}
+	}
+
+	layer := resize.Resize(uint(s.cols*heatmapStep), uint(s.rows*heatmapStep), grid, resize.Bilinear)
+	draw.Draw(dstPtr, s.bounds, layer, image.Point{}, draw.Over)
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+
+	"github.com/nfnt/resize"
+)
+
+// Function addHillshade returns a copy of a base map with a hillshade layer blended into it, so gaps in
+// reception can be matched up with the hills causing them. The layer is hillshadeFile, which must cover the same
+// area as the base map, or failing that is built from tiles from HillshadeURL. It's blended in at HillshadeOpacity;
+// layers that are themselves partly transparent, as most hillshade tiles are, are blended in by their own
+// transparency too. Cfg needs to be set for the base map.
+func addHillshade(baseMap image.Image, hillshadeFile string) image.Image {
+	bounds := baseMap.Bounds()
+	var layer image.Image
+	switch {
+	case hillshadeFile != "":
+		f, err := os.Open(hillshadeFile)
+		if err != nil {
+			fatalln("can't open hillshade file", hillshadeFile, err)
+		}
+		defer f.Close()
+		hillshade, _, err := image.Decode(f)
+		if err != nil {
+			fatalln("can't decode hillshade file", hillshadeFile, err)
+		}
+		layer = resize.Resize(uint(bounds.Dx()), uint(bounds.Dy()), hillshade, resize.Bilinear)
+	case cfg.HillshadeURL != "":
+		layer = hillshadeFromTiles(baseMap)
+	default:
+		warnf("skipping hillshade for %v: no HillshadeFile or HillshadeURL", cfg.MapFile)
+		return baseMap
+	}
+
+	mapPtr := image.NewRGBA(bounds)
+	draw.Draw(mapPtr, bounds, baseMap, bounds.Min, draw.Src)
+	opacity := &image.Uniform{color.Alpha{uint8(math.Max(0, math.Min(1, cfg.HillshadeOpacity))*0xff + 0.5)}}
+	draw.DrawMask(mapPtr, bounds, layer, layer.Bounds().Min, opacity, image.Point{}, draw.Over)
+	return mapPtr
+}
+
+// Function hillshadeFromTiles builds a hillshade layer the size of a base map from tiles from HillshadeURL. Tiles
+// are in Web Mercator, which most base maps aren't, so each pixel of the layer is looked up by its location.
+func hillshadeFromTiles(baseMap image.Image) image.Image {
+	bounds := baseMap.Bounds()
+	ref := newGeoref(baseMap)
+	locate := func(x, y int) gpsCoord {
+		gps, err := modelToGPS(ref.originX+float64(x)*ref.pixelWidth, ref.originY+float64(y)*ref.pixelHeight, ref.epsg)
+		if err != nil {
+			fatalln("can't place hillshade tiles on", cfg.MapFile, err)
+		}
+		return gps
+	}
+
+	// Unless it's set, use the zoom level whose tiles are closest to the base map's scale
+	zoom := cfg.HillshadeZoom
+	if zoom == 0 {
+		middle := locate(bounds.Dx()/2, bounds.Dy()/2)
+		worldMeters := 2 * math.Pi * mercatorRadius * math.Cos(middle.lat*math.Pi/180)
+		zoom = int(math.Log2(worldMeters/(mercatorTileSize*ref.metersPerPixel())) + 0.5)
+	}
+
+	// The base map's edges may be curved in Web Mercator, so find the tiles covering the middle of each edge as
+	// well as the corners
+	var area image.Rectangle
+	for _, fx := range []int{0, 1, 2} {
+		for _, fy := range []int{0, 1, 2} {
+			p := mercatorPixel(locate(bounds.Dx()*fx/2, bounds.Dy()*fy/2), zoom)
+			at := image.Point{int(math.Floor(p[0])), int(math.Floor(p[1]))}
+			area = area.Union(image.Rectangle{at, at.Add(image.Point{1, 1})})
+		}
+	}
+	area = area.Inset(-2)
+	tiles := stitchTiles(cfg.HillshadeURL, zoom, area, "hillshade", "HillshadeZoom")
+
+	layerPtr := image.NewRGBA(bounds)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			p := mercatorPixel(locate(x, y), zoom)
+			layerPtr.SetRGBA(bounds.Min.X+x, bounds.Min.Y+y, bilinear(tiles, p[0]-float64(area.Min.X)-0.5, p[1]-float64(area.Min.Y)-0.5))
+		}
+	}
+	return layerPtr
+}
+
+// Function bilinear returns the color of an image at a point between pixel centers, blended from the four
+// pixels around it
+func bilinear(img *image.RGBA, x, y float64) color.RGBA {
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	fx, fy := x-float64(x0), y-float64(y0)
+	var sum [4]float64
+	for _, corner := range []struct {
+		dx, dy int
+		weight float64
+	}{{0, 0, (1 - fx) * (1 - fy)}, {1, 0, fx * (1 - fy)}, {0, 1, (1 - fx) * fy}, {1, 1, fx * fy}} {
+		c := img.RGBAAt(x0+corner.dx, y0+corner.dy)
+		sum[0] += float64(c.R) * corner.weight
+		sum[1] += float64(c.G) * corner.weight
+		sum[2] += float64(c.B) * corner.weight
+		sum[3] += float64(c.A) * corner.weight
+	}
+	return color.RGBA{uint8(sum[0] + 0.5), uint8(sum[1] + 0.5), uint8(sum[2] + 0.5), uint8(sum[3] + 0.5)}
+}
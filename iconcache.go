@@ -0,0 +1,90 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/nfnt/resize"
+)
+
+// Directory within MapCacheDirectory that resized icons are kept in
+const iconCacheDir = "icons"
+
+// Icons resized so far, by the hash of the icon file and the size, so each is only decoded and resized once
+// however often the icons are loaded: for each output profile, and for each upload in server mode
+var (
+	iconCache     = make(map[string]image.Image)
+	iconCacheLock sync.Mutex
+)
+
+// Function cachedIcon returns the icon in a PNG file, from the icon files given, resized to size pixels wide.
+// Resized icons are kept in memory, and as PNG files in MapCacheDirectory so later runs can skip decoding and
+// resizing the original.
+func cachedIcon(files fs.FS, file string, size uint) (image.Image, error) {
+	content, err := fs.ReadFile(files, file)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%s-%d", sha256Hex(content)[:16], size)
+
+	iconCacheLock.Lock()
+	defer iconCacheLock.Unlock()
+	if icon, present := iconCache[key]; present {
+		return icon, nil
+	}
+
+	cacheFile := ""
+	if cfg.MapCacheDirectory != "" {
+		cacheFile = filepath.Join(cfg.MapCacheDirectory, iconCacheDir, key+".png")
+		if cached, err := ioutil.ReadFile(cacheFile); err == nil {
+			if icon, err := png.Decode(bytes.NewReader(cached)); err == nil {
+				iconCache[key] = icon
+				return icon, nil
+			}
+		}
+	}
+
+	original, err := png.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	// The resized icon is used as it comes back from PNG, since storing some kinds of image as PNG rounds the
+	// colors of partly transparent pixels, and an icon should be drawn the same whether it was cached or not
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, resize.Resize(size, 0, original, resize.Bilinear)); err != nil {
+		return nil, err
+	}
+	icon, err := png.Decode(bytes.NewReader(encoded.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	iconCache[key] = icon
+
+	// The disk cache only saves time, so if it can't be written, the icon is just resized again next run
+	if cacheFile != "" && os.MkdirAll(filepath.Dir(cacheFile), 0755) == nil {
+		writeFileAtomic(cacheFile, func(w io.Writer) error { _, err := w.Write(encoded.Bytes()); return err })
+	}
+	return icon, nil
+}
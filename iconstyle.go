@@ -0,0 +1,119 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sync"
+)
+
+// Darkness of drop shadows, from 0 (none) to 1 (black)
+const shadowOpacity = 0.5
+
+// Drop shadows made so far, by icon, since the same few icons are plotted over and over
+var (
+	shadows     = make(map[image.Image]*image.Alpha)
+	shadowsLock sync.Mutex
+)
+
+// Function iconOpacity returns how opaque to draw the icon for a report, from 0 (invisible) to 1 (solid), from
+// IconTransparencies or failing that IconTransparency
+func iconOpacity(report string) float64 {
+	transparency, present := cfg.IconTransparencies[report]
+	if !present {
+		transparency = cfg.IconTransparency
+	}
+	return 1 - math.Max(0, math.Min(1, transparency))
+}
+
+// Function dropShadow returns a soft shadow the shape of an icon: its alpha, blurred. The shadow is bigger than
+// the icon by the blur radius on every side, and has the same center.
+func dropShadow(icon image.Image) *image.Alpha {
+	shadowsLock.Lock()
+	defer shadowsLock.Unlock()
+	if shadow, present := shadows[icon]; present {
+		return shadow
+	}
+
+	bounds := icon.Bounds()
+	radius := bounds.Dx()/16 + 1
+	shadowPtr := image.NewAlpha(image.Rect(0, 0, bounds.Dx()+2*radius, bounds.Dy()+2*radius))
+	draw.Draw(shadowPtr, bounds.Sub(bounds.Min).Add(image.Point{radius, radius}), icon, bounds.Min, draw.Src)
+
+	// Two box blurs in each direction are close enough to a Gaussian blur
+	w, h := shadowPtr.Bounds().Dx(), shadowPtr.Bounds().Dy()
+	for pass := 0; pass < 4; pass++ {
+		dx, dy := 1, 0
+		if pass%2 == 1 {
+			dx, dy = 0, 1
+		}
+		blurred := make([]uint8, len(shadowPtr.Pix))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				sum, n := 0, 0
+				for d := -radius; d <= radius; d++ {
+					sx, sy := x+d*dx, y+d*dy
+					if sx >= 0 && sx < w && sy >= 0 && sy < h {
+						sum += int(shadowPtr.Pix[sy*shadowPtr.Stride+sx])
+					}
+					n++
+				}
+				blurred[y*shadowPtr.Stride+x] = uint8(sum / n)
+			}
+		}
+		shadowPtr.Pix = blurred
+	}
+	shadows[icon] = shadowPtr
+	return shadowPtr
+}
+
+// Function drawIcon draws an icon centered on a point, at the opacity for its report, over a drop shadow if
+// IconShadowFlag is set
+func drawIcon(mapPtr *image.RGBA, icon image.Image, center image.Point, report string) {
+	opacity := iconOpacity(report)
+	if opacity == 0 {
+		return
+	}
+
+	if cfg.IconShadowFlag {
+		shadow := dropShadow(icon)
+		offset := icon.Bounds().Dx()/12 + 1 // Down and to the right, as if lit from the upper left
+		at := shadow.Bounds().Sub(shadow.Bounds().Size().Div(2)).Add(center).Add(image.Point{offset, offset})
+		mask := &scaledMask{shadow, shadowOpacity * opacity}
+		draw.DrawMask(mapPtr, at, image.Black, image.Point{}, mask, image.Point{}, draw.Over)
+	}
+
+	at := icon.Bounds().Sub(icon.Bounds().Min).Sub(icon.Bounds().Size().Div(2)).Add(center)
+	if opacity == 1 {
+		draw.Draw(mapPtr, at, icon, icon.Bounds().Min, draw.Over)
+		return
+	}
+	mask := &image.Uniform{color.Alpha{uint8(opacity*0xff + 0.5)}}
+	draw.DrawMask(mapPtr, at, icon, icon.Bounds().Min, mask, image.Point{}, draw.Over)
+}
+
+// An alpha mask made fainter by a factor, so a shadow can be drawn at any strength without copying it
+type scaledMask struct {
+	*image.Alpha
+	scale float64
+}
+
+// Function At returns the mask's alpha at a point, scaled
+func (m *scaledMask) At(x, y int) color.Color {
+	return color.Alpha{uint8(float64(m.AlphaAt(x, y).A)*m.scale + 0.5)}
+}
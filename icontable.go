@@ -0,0 +1,68 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"io/fs"
+)
+
+// The icon for a report, from an [[Icons]] table in reception.cfg. Without any tables, each icon file is the icon for
+// the report with its name, so "1.png" is the icon for report 1.
+type iconEntry struct {
+	Report string // Report (or TransIcon, NoReportIcon or operator icon name) the icon is for, e.g. "1" or "Trans"
+	File   string // Icon file, in IconDirectory or among the built-in icons, e.g. "green-circle.png"
+	Order  int    // Place in the legend key and heatmap levels, 1 = best reception; 0 = not a level of reception
+	Name   string // What the report means, for the legend key, overriding ReportNames
+}
+
+// Function loadIconTable loads the icon files named in the [[Icons]] tables, returning each under its report
+func loadIconTable(files fs.FS) map[string]image.Image {
+	icons := make(map[string]image.Image)
+	for _, e := range cfg.Icons {
+		if e.Report == "" || e.File == "" {
+			fatalf("an [[Icons]] table in reception.cfg needs both a Report and a File")
+		}
+		if _, present := icons[e.Report]; present {
+			fatalf("report %q has more than one [[Icons]] table in reception.cfg", e.Report)
+		}
+		icon, err := cachedIcon(files, e.File, cfg.IconSize)
+		if err != nil {
+			fatalln("can't load icon "+e.File+" for report "+e.Report, err)
+		}
+		icons[e.Report] = icon
+	}
+	return icons
+}
+
+// Function iconOrder returns a report's Order from the [[Icons]] tables, if it has one
+func iconOrder(report string) (order int, ok bool) {
+	for _, e := range cfg.Icons {
+		if e.Report == report && e.Order > 0 {
+			return e.Order, true
+		}
+	}
+	return 0, false
+}
+
+// Function reportName returns what a report means, from its [[Icons]] table or else ReportNames, or "" if neither says
+func reportName(report string) string {
+	for _, e := range cfg.Icons {
+		if e.Report == report && e.Name != "" {
+			return e.Name
+		}
+	}
+	return cfg.ReportNames[report]
+}
@@ -0,0 +1,410 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mailbox report emails are fetched from when IMAPMailbox isn't set
+const defaultIMAPMailbox = "INBOX"
+
+// Patterns for the lines of a report email
+var (
+	reporterLine   = regexp.MustCompile(`(?i)^reporter\s*[:=]\s*(\S+)\s*$`)
+	reportLine     = regexp.MustCompile(`^(\S+?)[\s,:=]+(\S+)$`)
+	callSignFormat = regexp.MustCompile(`^[A-Z0-9/]*([0-9][A-Z0-9/]*[A-Z]|[A-Z][A-Z0-9/]*[0-9])[A-Z0-9/]*(-[0-9]+)?$`)
+)
+
+// Function fetchCommand polls the IMAP mailbox in reception.cfg for unread report emails, adds the reports in
+// them to ReportFile, and marks them read. With -regenerate it draws the maps again whenever new reports come
+// in, with the same settings this command was given, so the maps keep up with the reports through an overnight
+// exercise without anyone at the keyboard. With -once it checks the mailbox once and stops.
+func fetchCommand(args []string) {
+	flags := flag.NewFlagSet("fetch", flag.ExitOnError)
+	every := flags.Duration("every", 5*time.Minute, "How often to check the mailbox")
+	once := flags.Bool("once", false, "Check the mailbox once, then stop")
+	regenerate := flags.Bool("regenerate", false, "Draw the maps again whenever new reports come in")
+	flags.Parse(args)
+
+	if cfg.IMAPServer == "" {
+		fatalln("reception.cfg has no IMAPServer to fetch reports from")
+	}
+	fatalStatus = exitDataError
+	for {
+		added, err := fetchReports()
+		switch {
+		case err != nil && *once:
+			fatalln("can't fetch reports:", err)
+		case err != nil:
+			warnf("can't fetch reports, trying again in %s: %s", *every, err)
+		case added > 0 && *regenerate:
+			regenerateMaps()
+		}
+		if *once {
+			return
+		}
+		time.Sleep(*every)
+	}
+}
+
+// Function fetchReports adds the reports in the unread report emails in the mailbox to ReportFile, marks the
+// emails read, and returns how many reports it added. An email whose reports can't be read is left unread, with a
+// warning each time the mailbox is checked, so its reports aren't lost: correct them by hand, then mark it read.
+func fetchReports() (int, error) {
+	c, err := dialIMAP()
+	if err != nil {
+		return 0, err
+	}
+	defer c.logout()
+
+	query := "UNSEEN"
+	if cfg.IMAPSubject != "" {
+		query += " SUBJECT " + imapQuote(cfg.IMAPSubject)
+	}
+	responses, err := c.command("UID SEARCH " + query)
+	if err != nil {
+		return 0, err
+	}
+	var uids []string
+	for _, r := range responses {
+		if strings.HasPrefix(r.text, "* SEARCH") {
+			uids = append(uids, strings.Fields(strings.TrimPrefix(r.text, "* SEARCH"))...)
+		}
+	}
+
+	added, messages := 0, 0
+	for _, uid := range uids {
+		responses, err := c.command("UID FETCH " + uid + " BODY.PEEK[]")
+		if err != nil {
+			return added, err
+		}
+		unreadable := false
+		for _, r := range responses {
+			if r.literal == nil || !strings.Contains(r.text, "FETCH") {
+				continue
+			}
+			rows, err := parseReportEmail(r.literal)
+			if err != nil {
+				warnf("leaving email %s unread: %s", uid, err)
+				unreadable = true
+				break
+			}
+			if err := appendReports(rows); err != nil {
+				return added, err
+			}
+			added += len(rows)
+			messages++
+		}
+		if unreadable {
+			continue
+		}
+		if _, err := c.command("UID STORE " + uid + ` +FLAGS.SILENT (\Seen)`); err != nil {
+			return added, err
+		}
+	}
+
+	fmt.Printf("%s: added %d reports from %d emails to %s\n", time.Now().Format("15:04"), added, messages, cfg.ReportFile)
+	return added, nil
+}
+
+// Function parseReportEmail returns the reports in an email, as rows of the report file: the station that sent
+// them, the station it heard, and the report. A report email is plain text naming the station that sent the
+// reports, then one line for each station it heard with that station's report. Other lines, such as greetings,
+// signatures and Winlink's headers, are ignored, so the reports can be typed into any message. Without a Reporter
+// line the reports are from the sender, if the sender's address is a call sign, as Winlink addresses
+// (K7ABC@winlink.org) are.
+//
+//	Reporter: K7ABC
+//	W6OWI 1
+//	N6YXJ 2
+func parseReportEmail(raw []byte) ([][]string, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	text, err := plainText(textproto.MIMEHeader(msg.Header), msg.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	reporter := ""
+	if from, err := mail.ParseAddress(msg.Header.Get("From")); err == nil {
+		if local := strings.ToUpper(strings.SplitN(from.Address, "@", 2)[0]); callSignFormat.MatchString(local) {
+			reporter = local
+		}
+	}
+	var heard [][2]string
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if m := reporterLine.FindStringSubmatch(line); m != nil {
+			reporter = strings.ToUpper(m[1])
+		} else if m := reportLine.FindStringSubmatch(line); m != nil && callSignFormat.MatchString(strings.ToUpper(m[1])) {
+			heard = append(heard, [2]string{strings.ToUpper(m[1]), m[2]})
+		}
+	}
+
+	switch {
+	case len(heard) == 0:
+		return nil, fmt.Errorf("no reports in it")
+	case reporter == "" || !callSignFormat.MatchString(reporter):
+		return nil, fmt.Errorf("it doesn't say who the reports are from; add a line such as \"Reporter: K7ABC\"")
+	}
+	var rows [][]string
+	for _, h := range heard {
+		rows = append(rows, []string{reporter, h[0], h[1]})
+	}
+	return rows, nil
+}
+
+// Function plainText returns the plain text of an email body, or of its first plain text part if it has
+// several, as an email with an HTML version or an attachment does, decoded from quoted-printable or base64
+func plainText(header textproto.MIMEHeader, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain" // The default, for an email without a Content-Type
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return "", fmt.Errorf("no plain text in it")
+			}
+			if err != nil {
+				return "", err
+			}
+			if text, err := plainText(part.Header, part); err == nil {
+				return text, nil
+			}
+		}
+	}
+	if mediaType != "text/plain" {
+		return "", fmt.Errorf("it's %s, not plain text", mediaType)
+	}
+
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, newlineRemover{body})
+	}
+	text, err := ioutil.ReadAll(body)
+	return string(text), err
+}
+
+// An io.Reader that leaves out line breaks, which base64 in email has but the decoder doesn't accept
+type newlineRemover struct {
+	r io.Reader
+}
+
+func (n newlineRemover) Read(p []byte) (int, error) {
+	count, err := n.r.Read(p)
+	kept := 0
+	for _, b := range p[:count] {
+		if b != '\r' && b != '\n' {
+			p[kept] = b
+			kept++
+		}
+	}
+	return kept, err
+}
+
+// Function appendReports adds rows to the end of ReportFile, starting a new line first if the file doesn't end
+// with one
+func appendReports(rows [][]string) error {
+	f, err := os.OpenFile(cfg.ReportFile, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			buf.WriteString("\n")
+		}
+	}
+	w := csv.NewWriter(&buf)
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	return err
+}
+
+// Function regenerateMaps draws the maps again, by running this program with the settings this command was
+// given. A run that fails is only warned about, so the next reports can still be fetched.
+func regenerateMaps() {
+	exe, err := os.Executable()
+	if err != nil {
+		fatalln("can't find this program to draw the maps", err)
+	}
+	fmt.Println("Drawing the maps again...")
+	cmd := exec.Command(exe, os.Args[1:len(os.Args)-len(flag.Args())]...) // The settings given before "fetch"
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == exitWarnings {
+		return // The maps were drawn, and the run has already listed its warnings
+	}
+	if err != nil {
+		warnf("couldn't draw the maps again: %s", err)
+	}
+}
+
+// A connection to the IMAP server, over TLS, for the few commands needed to fetch report emails
+type imapClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// One untagged response from the IMAP server, e.g. "* SEARCH 4 7", with the literal it carries, if it has one
+// (such as an email's text)
+type imapResponse struct {
+	text    string
+	literal []byte
+}
+
+// Function dialIMAP connects to IMAPServer, logs in and opens IMAPMailbox. The password comes from
+// cfg.IMAPPassword, or if that's empty, from the IMAP_PASSWORD environment variable.
+func dialIMAP() (*imapClient, error) {
+	host, _, err := net.SplitHostPort(cfg.IMAPServer)
+	if err != nil {
+		return nil, fmt.Errorf("IMAPServer in reception.cfg should be host:port: %s", err)
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Minute}, "tcp", cfg.IMAPServer, &tls.Config{ServerName: host})
+	if err != nil {
+		return nil, err
+	}
+	c := &imapClient{conn: conn, r: bufio.NewReader(conn)}
+	if greeting, _, err := c.readLine(); err != nil {
+		conn.Close()
+		return nil, err
+	} else if !strings.HasPrefix(greeting, "* OK") {
+		conn.Close()
+		return nil, fmt.Errorf("IMAP server said %q", greeting)
+	}
+
+	password := cfg.IMAPPassword
+	if password == "" {
+		password = os.Getenv("IMAP_PASSWORD")
+	}
+	mailbox := cfg.IMAPMailbox
+	if mailbox == "" {
+		mailbox = defaultIMAPMailbox
+	}
+	for _, cmd := range []string{"LOGIN " + imapQuote(cfg.IMAPUser) + " " + imapQuote(password), "SELECT " + imapQuote(mailbox)} {
+		if _, err := c.command(cmd); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Function command sends a command to the IMAP server and returns the untagged responses to it, or an error if
+// the server didn't say OK
+func (c *imapClient) command(cmd string) ([]imapResponse, error) {
+	c.tag++
+	tag := "a" + strconv.Itoa(c.tag)
+	c.conn.SetDeadline(time.Now().Add(5 * time.Minute))
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, cmd); err != nil {
+		return nil, err
+	}
+
+	var responses []imapResponse
+	for {
+		line, literal, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(line, tag+" ") {
+			if status := strings.TrimPrefix(line, tag+" "); !strings.HasPrefix(status, "OK") {
+				verb := strings.Fields(cmd)[0]
+				if verb == "UID" {
+					verb += " " + strings.Fields(cmd)[1]
+				}
+				return nil, fmt.Errorf("IMAP %s failed: %s", verb, status)
+			}
+			return responses, nil
+		}
+		responses = append(responses, imapResponse{line, literal})
+	}
+}
+
+// Function readLine reads a line from the IMAP server. A line ending in {n} is followed by a literal of n bytes
+// and then the rest of the line, which are returned as the literal and the line without it. A literal larger than an
+// upload to the server may be is refused rather than read.
+func (c *imapClient) readLine() (line string, literal []byte, err error) {
+	for {
+		part, err := c.r.ReadString('\n')
+		if err != nil {
+			return "", nil, err
+		}
+		part = strings.TrimRight(part, "\r\n")
+		open := strings.LastIndex(part, "{")
+		if open < 0 || !strings.HasSuffix(part, "}") {
+			return line + part, literal, nil
+		}
+		size, err := strconv.Atoi(part[open+1 : len(part)-1])
+		if err != nil {
+			return line + part, literal, nil
+		}
+		if size < 0 || size > maxUpload {
+			return "", nil, fmt.Errorf("IMAP server sent a literal of %d bytes", size)
+		}
+		literal = make([]byte, size)
+		if _, err := io.ReadFull(c.r, literal); err != nil {
+			return "", nil, err
+		}
+		line += part[:open]
+	}
+}
+
+// Function logout logs out of the IMAP server and closes the connection
+func (c *imapClient) logout() {
+	c.command("LOGOUT")
+	c.conn.Close()
+}
+
+// Function imapQuote returns a string as an IMAP quoted string
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
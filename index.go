@@ -0,0 +1,198 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Map types, as used in output file names and the index page
+const (
+	xmitMapType = "xmit"
+	rcvrMapType = "rcvr"
+)
+
+// One map we've generated
+type mapResult struct {
+	Transmitter string // Call sign the map was generated for
+	MapType     string // xmitMapType or rcvrMapType
+	File        string // Name of the map file, relative to the output directory
+	Thumbnail   string // Name of the thumbnail file, relative to the output directory
+
+	Clusters []mapCluster `json:",omitempty"` // Groups of receivers drawn as one badge on the map
+}
+
+// A group of receivers drawn as one badge on a map, which the index page lists so it can be expanded
+type mapCluster struct {
+	Label    string   // Label next to the badge on the map
+	Stations []string // Call sign and report of each receiver in the group, e.g. "K7ABC (2)"
+}
+
+// Names of files the index page is built from, within the output directory
+const (
+	indexFile     = "index.html"
+	manifestFile  = "maps.json" // Every map generated into the directory so far, across runs
+	thumbnailsDir = "thumbs"
+)
+
+// Function writeThumbnail saves a map's thumbnail for the index page, with the same metadata as the map, and
+// returns its file name relative to the output directory.
+func writeThumbnail(thumb image.Image, mapFile string, meta []pngText) string {
+	thumbFile := thumbnailsDir + "/" + mapFile
+	if err := os.MkdirAll(filepath.Dir(filepath.Join(cfg.OutputDirectory, thumbFile)), 0755); err != nil {
+		fatalln("can't create thumbnail directory", err)
+	}
+
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, thumbFile), func(w io.Writer) error { return encodePNG(w, thumb, meta) })
+	if err != nil {
+		fatalf("Failed to write thumbnail file: %s", err)
+	}
+	return thumbFile
+}
+
+// Function updateManifest adds this run's maps to the manifest of all maps in the output directory, and returns
+// the updated manifest. Keeping the manifest lets a transmit run and a receive run into the same directory
+// produce a single index covering both, and lets later commands (such as mail) find every station's maps.
+func updateManifest(results []mapResult) []mapResult {
+	manifestPath := filepath.Join(cfg.OutputDirectory, manifestFile)
+
+	// Merge this run's maps into what's already there; a map regenerated this run replaces its old entry
+	entries := make(map[string]mapResult)
+	for _, result := range loadManifest() {
+		if _, err := os.Stat(filepath.Join(cfg.OutputDirectory, result.File)); err == nil {
+			entries[result.File] = result // Forget about maps someone has since deleted
+		}
+	}
+	for _, result := range results {
+		entries[result.File] = result
+	}
+
+	merged := make([]mapResult, 0, len(entries))
+	for _, result := range entries {
+		merged = append(merged, result)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].Transmitter != merged[j].Transmitter {
+			return merged[i].Transmitter < merged[j].Transmitter
+		}
+		return merged[i].MapType > merged[j].MapType // Transmit maps before receive maps
+	})
+
+	manifest, err := json.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		fatalln("can't encode map manifest", err)
+	}
+	if err := writeFileAtomic(manifestPath, func(w io.Writer) error { _, err := w.Write(manifest); return err }); err != nil {
+		fatalln("can't write", manifestPath, err)
+	}
+	return merged
+}
+
+// Function loadManifest returns the manifest of maps in the output directory, or nothing if there isn't one yet
+func loadManifest() []mapResult {
+	manifestPath := filepath.Join(cfg.OutputDirectory, manifestFile)
+
+	manifest, err := ioutil.ReadFile(manifestPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		fatalln("can't read", manifestPath, err)
+	}
+
+	var maps []mapResult
+	if err := json.Unmarshal(manifest, &maps); err != nil {
+		fatalln("can't parse", manifestPath, err)
+	}
+	return maps
+}
+
+// Function writeIndex writes the index page, with a thumbnail and link for each map in the manifest
+func writeIndex(manifest []mapResult) {
+	// Group the maps by transmitter for the page
+	type station struct {
+		Call string
+		Maps []mapResult
+	}
+	var stations []station
+	for _, result := range manifest {
+		if len(stations) == 0 || stations[len(stations)-1].Call != result.Transmitter {
+			stations = append(stations, station{Call: result.Transmitter})
+		}
+		last := &stations[len(stations)-1]
+		last.Maps = append(last.Maps, result)
+	}
+
+	data := struct {
+		Frequency string
+		Stations  []station
+	}{cfg.Frequency, stations}
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, indexFile), func(w io.Writer) error { return indexTemplate.Execute(w, data) })
+	if err != nil {
+		fatalf("Failed to write index file: %s", err)
+	}
+}
+
+// Function mapTypeName returns the human-readable name of a map type, in cfg.Language
+func mapTypeName(mapType string) string {
+	if mapType == rcvrMapType {
+		return localText("Receive Map (who can I hear)")
+	}
+	return localText("Transmission Map (who can hear me)")
+}
+
+// Template for the index page
+var indexTemplate = template.Must(template.New(indexFile).Funcs(template.FuncMap{"mapTypeName": mapTypeName, "join": strings.Join}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Reception Maps - {{.Frequency}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+.station { margin-bottom: 2em; }
+.station figure { display: inline-block; margin: 0 1em 0 0; }
+.station img { border: 1px solid #ccc; }
+.station details { max-width: 20em; font-size: small; }
+</style>
+</head>
+<body>
+<h1>Reception Maps</h1>
+<p>Frequency: {{.Frequency}}</p>
+{{range .Stations}}
+<div class="station" id="{{.Call}}">
+<h2>{{.Call}}</h2>
+{{range .Maps}}<figure>
+<a href="{{.File}}"><img src="{{.Thumbnail}}" alt="{{mapTypeName .MapType}} for {{.Transmitter}}"></a>
+<figcaption>{{mapTypeName .MapType}}</figcaption>
+{{with .Clusters}}<details>
+<summary>{{len .}} cluster{{if gt (len .) 1}}s{{end}} of nearby stations</summary>
+<ul>
+{{range .}}<li>{{.Label}}: {{join .Stations ", "}}</li>
+{{end}}</ul>
+</details>
+{{end}}</figure>
+{{end}}</div>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,67 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// Function cancelOnInterrupt returns a context that Ctrl-C (or SIGTERM) cancels, so map generation can stop
+// cleanly between maps, and a function that puts Ctrl-C back to stopping the program at once. While the context
+// is in use, a second Ctrl-C stops the program at once, after removing any file it was partway through writing.
+func cancelOnInterrupt() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-signals; !ok {
+			return
+		}
+		fmt.Println("\nStopping once the maps being drawn now are written; press Ctrl-C again to stop at once")
+		cancel()
+		if _, ok := <-signals; !ok {
+			return
+		}
+		removeTempFiles()
+		fmt.Println("Stopped")
+		os.Exit(exitInterrupted)
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		close(signals)
+		cancel()
+	}
+}
+
+// Function exitInterruptedRun reports which maps were made before a run was interrupted, and exits. What wasn't
+// made yet, and what's made from all the maps (the index page, the zip file, uploads and alerts), is left undone,
+// so nothing is published from half a run.
+func exitInterruptedRun(made []mapResult, total int) {
+	var calls []string
+	for _, result := range made {
+		calls = append(calls, result.Transmitter)
+	}
+	fmt.Printf("Interrupted after making %d of %d maps", len(made), total)
+	if len(calls) > 0 {
+		fmt.Printf(": %s", strings.Join(calls, ", "))
+	}
+	fmt.Println()
+	os.Exit(exitInterrupted)
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Where call signs go relative to their icons (LabelPosition)
+const (
+	labelRight = "right"
+	labelLeft  = "left"
+	labelAbove = "above"
+	labelBelow = "below"
+	labelAuto  = "auto" // Right, unless that would run off the map
+)
+
+// Function labelPoint returns where to start a label's baseline so the label sits beside an icon centered at
+// center, on the side cfg.LabelPosition says. In auto mode, labels that would run off the right edge of the map
+// go on the left instead.
+func labelPoint(label string, center image.Point, iconSize image.Point, bounds image.Rectangle) fixed.Point26_6 {
+	ttf, size := labelFont()
+	face := truetype.NewFace(ttf, &truetype.Options{Size: size, DPI: cfg.FontDPI})
+	width := font.MeasureString(face, label)
+	height := fixed.Int26_6(size * cfg.FontDPI / 72.0 * 64)
+	gap := fixed.I(int(size)) / 2
+
+	x, y := fixed.I(center.X), fixed.I(center.Y)
+	right := fixed.Point26_6{X: x + fixed.I(iconSize.X)/2 + gap, Y: y + height/2}
+	left := fixed.Point26_6{X: x - fixed.I(iconSize.X)/2 - gap - width, Y: y + height/2}
+	switch cfg.LabelPosition {
+	case labelRight, "":
+		return right
+	case labelLeft:
+		return left
+	case labelAbove:
+		return fixed.Point26_6{X: x - width/2, Y: y - fixed.I(iconSize.Y)/2 - gap}
+	case labelBelow:
+		return fixed.Point26_6{X: x - width/2, Y: y + fixed.I(iconSize.Y)/2 + gap + height}
+	case labelAuto:
+		if right.X+width > fixed.I(bounds.Max.X) && left.X >= fixed.I(bounds.Min.X) {
+			return left
+		}
+		return right
+	default:
+		fatalf("unknown LabelPosition %q in reception.cfg; use %q, %q, %q, %q or %q", cfg.LabelPosition,
+			labelRight, labelLeft, labelAbove, labelBelow, labelAuto)
+		return right
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"image/draw"
+	"math"
+	"sync"
+)
+
+// Pixel buffers of map layers that are no longer drawn on, for new layers to reuse. Cropped maps each get layers
+// the size of their area, and allocating and clearing two images for every one of them adds up in a large batch.
+var pixelPool sync.Pool
+
+// Function newPooledRGBA returns an image with the given bounds, using a buffer from pixelPool if there's one big
+// enough. Its pixels are whatever the buffer held last, so it has to be drawn over completely before it's used.
+func newPooledRGBA(r image.Rectangle) *image.RGBA {
+	n := 4 * r.Dx() * r.Dy()
+	if bufPtr, ok := pixelPool.Get().(*[]uint8); ok && cap(*bufPtr) >= n {
+		return &image.RGBA{Pix: (*bufPtr)[:n], Stride: 4 * r.Dx(), Rect: r}
+	}
+	return image.NewRGBA(r) // A buffer too small is dropped; the maps that used it are done
+}
+
+// Function releaseRGBA returns an image's pixel buffer to pixelPool. The image mustn't be used afterward.
+func releaseRGBA(img *image.RGBA) {
+	buf := img.Pix[:0]
+	pixelPool.Put(&buf)
+}
+
+// Function release returns the layers' pixel buffers to pixelPool. The layers mustn't be used afterward.
+func (l *mapLayers) release() {
+	releaseRGBA(l.outputMapPtr)
+	releaseRGBA(l.textMapPtr)
+	l.outputMapPtr, l.textMapPtr = nil, nil
+}
+
+// Function reset readies the layers for a map on the given base map, restoring the part of the output layer the
+// last map changed and clearing the text on the text layer. The text gets a new context too: a context caches each
+// glyph as first drawn and reuses it at nearby fractions of a pixel, so one kept from the last map would draw this
+// map's text a little differently depending on which maps the worker drew before it.
+func (l *mapLayers) reset(baseMap image.Image) {
+	draw.Draw(l.outputMapPtr, l.dirty, baseMap, l.dirty.Min, draw.Src)
+	draw.Draw(l.textMapPtr, l.textDirty, image.Transparent, image.Point{}, draw.Src)
+	l.dirty, l.textDirty = image.Rectangle{}, image.Rectangle{}
+	l.textCtxPtr = newContext(l.textMapPtr)
+}
+
+// Function markIcon records that plotIcon drew an icon centered at a point on the output layer. Its shadow is
+// blurred and offset by much less than the icon's size, and a beam reaches just under that size from the center.
+func (l *mapLayers) markIcon(icon image.Image, center image.Point) {
+	reach := icon.Bounds().Dx()
+	if icon.Bounds().Dy() > reach {
+		reach = icon.Bounds().Dy()
+	}
+	reach += 2
+	l.markDrawn(image.Rect(center.X-reach, center.Y-reach, center.X+reach+1, center.Y+reach+1))
+}
+
+// Function markDrawn records that something was drawn on the output layer within a rectangle
+func (l *mapLayers) markDrawn(r image.Rectangle) {
+	l.dirty = l.dirty.Union(r.Intersect(l.outputMapPtr.Bounds()))
+}
+
+// Function markText finds the part of the text layer that has text on it, and widens it by the halo addTextHalo
+// will put around the text. It returns that part of the text layer, for the halo and merge to be limited to.
+func (l *mapLayers) markText() *image.RGBA {
+	bounds := l.textMapPtr.Bounds()
+	minX, minY, maxX, maxY := bounds.Max.X, bounds.Max.Y, bounds.Min.X, bounds.Min.Y
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := l.textMapPtr.Pix[(y-bounds.Min.Y)*l.textMapPtr.Stride:]
+		for x := 0; x < bounds.Dx(); x++ {
+			if row[4*x+3] == 0 {
+				continue
+			}
+			if x+bounds.Min.X < minX {
+				minX = x + bounds.Min.X
+			}
+			if x+bounds.Min.X >= maxX {
+				maxX = x + bounds.Min.X + 1
+			}
+			if y < minY {
+				minY = y
+			}
+			maxY = y + 1
+		}
+	}
+	if minX >= maxX {
+		return l.textMapPtr.SubImage(image.Rectangle{}).(*image.RGBA)
+	}
+
+	halo := int(math.Ceil(cfg.TextHalo))
+	if halo < 0 {
+		halo = 0
+	}
+	l.textDirty = image.Rect(minX, minY, maxX, maxY).Inset(-halo).Intersect(bounds)
+	l.markDrawn(l.textDirty)
+	return l.textMapPtr.SubImage(l.textDirty).(*image.RGBA)
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"image/draw"
+	"sort"
+
+	"github.com/golang/freetype"
+	"github.com/nfnt/resize"
+)
+
+// Function drawLegendKey draws a key just above the legend, with each report's icon next to what it means (from
+// the [[Icons]] tables or cfg.ReportNames), best reception first and the transmitter last. Reports without a name
+// or an icon are left out.
+func drawLegendKey(textMapPtr *image.RGBA, contextPtr *freetype.Context, icons map[string]image.Image) {
+	var reports []string
+	for report := range icons {
+		if reportName(report) != "" && report != cfg.TransIcon {
+			reports = append(reports, report)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool { return worseReport(reports[j], reports[i]) })
+	if _, present := icons[cfg.TransIcon]; present && reportName(cfg.TransIcon) != "" {
+		reports = append(reports, cfg.TransIcon)
+	}
+
+	// The legend's first line is 8 lines up from the bottom (see newDrawLegend); the key goes above it, with an
+	// extra half line between them
+	_, size := legendFont()
+	lineHeight := size * cfg.FontLineSpacing * cfg.FontDPI / 72.0
+	textHeight := size * cfg.FontDPI / 72.0
+	left := int(cfg.FontSize*5 + 0.5)
+	bottom := float64(textMapPtr.Bounds().Max.Y) - lineHeight*9.5
+
+	contextPtr.SetSrc(&image.Uniform{legendColor()})
+	setFont(contextPtr, legendFont)
+	defer contextPtr.SetSrc(&image.Uniform{textColor()})
+	defer setFont(contextPtr, labelFont)
+	for i, report := range reports {
+		baseline := bottom - lineHeight*float64(len(reports)-i-1)
+
+		// Center the icon on the middle of the capital letters, about 0.35 of the font size above the baseline
+		icon := resize.Resize(0, uint(textHeight*1.3+0.5), icons[report], resize.Bilinear)
+		center := image.Point{left + icon.Bounds().Dx()/2, int(baseline - textHeight*0.35 + 0.5)}
+		offset := center.Sub(image.Point{icon.Bounds().Dx() / 2, icon.Bounds().Dy() / 2})
+		draw.Draw(textMapPtr, icon.Bounds().Add(offset), icon, icon.Bounds().Min, draw.Over)
+
+		pt := freetype.Pt(left+icon.Bounds().Dx()+int(size), int(baseline+0.5))
+		if _, err := contextPtr.DrawString(localText(reportName(report)), pt); err != nil {
+			fatalln("can't plot legend key", err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// Values assumed for an operator whose antenna gain or height isn't known (-100 in the operator file): a
+// handheld's rubber duck at head height
+const (
+	defaultAntGain   = 0.0 // dBi
+	defaultAntHeight = 5.0 // Feet
+)
+
+// The first number in Frequency, taken to be in MHz, e.g. 146.535 in "146.535 MHz Simplex"
+var frequencyPattern = regexp.MustCompile(`\d+(\.\d+)?`)
+
+// A rough estimate of how strong a station's signal should be at another, from their equipment and the distance
+// between them, ignoring terrain and buildings
+type linkBudget struct {
+	loss     float64 // Path loss in dB: free space up close, then two-ray (flat ground reflection) beyond the breakpoint
+	signal   float64 // Expected received signal, in dBm
+	expected string  // Report expected for that signal, from ReportSignalLevels
+}
+
+// Function frequencyMHz returns the frequency of the net in MHz, read from Frequency, and whether there is one
+func frequencyMHz() (float64, bool) {
+	mhz, err := strconv.ParseFloat(frequencyPattern.FindString(cfg.Frequency), 64)
+	return mhz, err == nil && mhz > 0
+}
+
+// Function estimateLink returns the link budget for a signal from tx to rx, meters apart. It returns false if
+// the transmitter's power or the frequency isn't known.
+func estimateLink(tx, rx operatorData, meters float64) (linkBudget, bool) {
+	mhz, ok := frequencyMHz()
+	if !ok || tx.xmitPwr <= 0 || meters <= 0 {
+		return linkBudget{}, false
+	}
+	var b linkBudget
+	b.loss = pathLoss(mhz, meters, antennaMeters(tx), antennaMeters(rx))
+	b.signal = 10*math.Log10(tx.xmitPwr*1000) + knownValue(tx.antGain, defaultAntGain) + knownValue(rx.antGain, defaultAntGain) - b.loss
+	b.expected = strconv.Itoa(int(math.Ceil(signalLevel(b.signal))))
+	return b, true
+}
+
+// Function pathLoss returns the loss in dB between antennas the given heights in meters above flat ground:
+// free space up close, then two-ray beyond the breakpoint, where the wave reflected off the ground starts to
+// cancel the direct one and the loss goes up with the fourth power of distance instead of the square
+func pathLoss(mhz, meters, txHeight, rxHeight float64) float64 {
+	loss := 20*math.Log10(meters/1000) + 20*math.Log10(mhz) + 32.44
+	wavelength := 299.792458 / mhz
+	if meters > 4*math.Pi*txHeight*rxHeight/wavelength {
+		loss = math.Max(loss, 40*math.Log10(meters)-20*math.Log10(txHeight)-20*math.Log10(rxHeight))
+	}
+	return loss
+}
+
+// Function signalLevel returns the report expected for a signal in dBm, from ReportSignalLevels, as a number that
+// runs smoothly between reports: 1 at or above the first level, 1.5 halfway between the first and second, and so
+// on down to one more than the number of levels
+func signalLevel(signal float64) float64 {
+	levels := cfg.ReportSignalLevels
+	for i, level := range levels {
+		if signal >= level {
+			if i == 0 {
+				return 1
+			}
+			return float64(i) + (levels[i-1]-signal)/(levels[i-1]-level)
+		}
+	}
+	return float64(len(levels) + 1)
+}
+
+// Function knownValue returns a value from the operator file, or a default if it's -100 (no value)
+func knownValue(value, def float64) float64 {
+	if value == -100 {
+		return def
+	}
+	return value
+}
+
+// Function antennaMeters returns the height of an operator's antenna above the ground in meters, assuming the
+// usual handheld if it isn't known
+func antennaMeters(op operatorData) float64 {
+	const feetToMeters = 0.3048
+	return math.Max(knownValue(op.antHeight, defaultAntHeight), 1) * feetToMeters
+}
+
+// Function reportShortfall returns how many levels worse a report is than the link budget suggests, e.g. 2 for a
+// report of 3 where 1 was expected, or "" if the report isn't a number. A station that keeps falling short of its
+// hardware may have a bad cable, a mis-set radio or a blocked antenna.
+func reportShortfall(report string, b linkBudget) string {
+	reported, err := strconv.Atoi(report)
+	if err != nil {
+		return ""
+	}
+	expected, _ := strconv.Atoi(b.expected)
+	return strconv.Itoa(reported - expected)
+}
@@ -0,0 +1,175 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+)
+
+// Size of path profile charts in pixels, and the number of points along the path they're drawn from
+const (
+	profileChartWidth  = 1600
+	profileChartHeight = 700
+	profileChartPoints = 400
+)
+
+// Colors of the ground, the first Fresnel zone, and the ground that intrudes into it on path profile charts
+var (
+	profileGroundColor  = color.RGBA{0xc8, 0xb4, 0x8c, 0xff}
+	profileFresnelColor = color.NRGBA{0x40, 0x80, 0xe0, 0x50}
+	profileBlockedColor = color.RGBA{0xd0, 0x20, 0x20, 0xff}
+)
+
+// Function writePathProfile draws a chart of the terrain between two stations from the SRTM elevation data, with
+// the line of sight between their antennas and the first Fresnel zone around it, so it's clear whether a hill is
+// in the way. Ground is raised by the curve of the earth, as usual for such charts, and ground in the Fresnel zone
+// is shown in red. It returns the file's name relative to the output directory and a description of the worst
+// obstruction.
+func writePathProfile(a, b operatorData) (string, string) {
+	mhz, ok := frequencyMHz()
+	if !ok {
+		fatalln("can't draw a path profile without the frequency; set Frequency in reception.cfg")
+	}
+	meters := distanceMeters(a.gps, b.gps)
+	if meters < 1 {
+		fatalln("can't draw a path profile between", a.callsign, "and", b.callsign, "as they're in the same place")
+	}
+	wavelength := 299.792458 / mhz
+	land := loadTerrain([]gpsCoord{a.gps, b.gps})
+	aTop, bTop := land.elevation(a.gps)+antennaMeters(a), land.elevation(b.gps)+antennaMeters(b)
+
+	// Sample the path, keeping track of the range of heights to chart and the ground that intrudes furthest into
+	// the Fresnel zone, as a fraction of its radius
+	ground, sight, radius := make([]float64, profileChartPoints+1), make([]float64, profileChartPoints+1), make([]float64, profileChartPoints+1)
+	low, high := math.Inf(1), math.Inf(-1)
+	worst, worstAt := math.Inf(-1), 0
+	for i := range ground {
+		f := float64(i) / profileChartPoints
+		ground[i] = profileGround(land, a.gps, b.gps, meters, f)
+		sight[i] = aTop*(1-f) + bTop*f
+		radius[i] = fresnelRadius(wavelength, meters*f, meters*(1-f))
+		low = math.Min(low, math.Min(ground[i], sight[i]-radius[i]))
+		high = math.Max(high, math.Max(ground[i], sight[i]+radius[i]))
+		if i > 0 && i < profileChartPoints {
+			if e := (ground[i] - sight[i]) / radius[i]; e > worst {
+				worst, worstAt = e, i
+			}
+		}
+	}
+	span := math.Max(high-low, 10)
+	low, high = low-span*0.1, high+span*0.1
+
+	var finding string
+	from := meters * float64(worstAt) / profileChartPoints / 1000
+	loss := knifeEdgeLoss(math.Sqrt2 * worst)
+	switch {
+	case worst > 0:
+		finding = fmt.Sprintf("blocked: the ground is %.0f m above the line of sight %.1f km from %s (about %.0f dB lost)",
+			worst*radius[worstAt], from, a.callsign, loss)
+	case worst > -1:
+		finding = fmt.Sprintf("partly obstructed: the ground fills %.0f%% of the first Fresnel zone %.1f km from %s (about %.0f dB lost)",
+			(1+worst)*50, from, a.callsign, loss)
+	default:
+		finding = "clear: the ground stays out of the first Fresnel zone"
+	}
+
+	mapPtr := image.NewRGBA(image.Rect(0, 0, profileChartWidth, profileChartHeight))
+	draw.Draw(mapPtr, mapPtr.Bounds(), image.White, image.Point{}, draw.Src)
+	ctxPtr := newContext(mapPtr)
+	legendTTF, size := legendFont()
+	face := truetype.NewFace(legendTTF, &truetype.Options{Size: size, DPI: cfg.FontDPI})
+	setFont(ctxPtr, legendFont)
+	ascent := int(size*cfg.FontDPI/72.0 + 0.5)
+	lineHeight := int(float64(ascent)*cfg.FontLineSpacing + 0.5)
+	legend := []string{"Path profile from " + a.callsign + " to " + b.callsign, "Frequency: " + cfg.Frequency, "Terrain: " + finding}
+
+	plot := image.Rect(ascent*5, ascent*3+len(legend)*lineHeight, profileChartWidth-ascent*2, profileChartHeight-ascent*3)
+	toChart := func(i int, height float64) pixelPoint {
+		return pixelPoint{float64(plot.Min.X) + float64(plot.Dx())*float64(i)/profileChartPoints,
+			float64(plot.Min.Y) + float64(plot.Dy())*(high-height)/(high-low)}
+	}
+
+	// The Fresnel zone, with the ground drawn over it, then in red where it reaches into the zone, a run at a time
+	var zone, earth []pixelPoint
+	for i := range ground {
+		zone = append(zone, toChart(i, sight[i]+radius[i]))
+		earth = append(earth, toChart(i, ground[i]))
+	}
+	for i := len(ground) - 1; i >= 0; i-- {
+		zone = append(zone, toChart(i, sight[i]-radius[i]))
+	}
+	earth = append(earth, toChart(profileChartPoints, low), toChart(0, low))
+	fillPolygons(mapPtr, [][]pixelPoint{zone}, profileFresnelColor)
+	fillPolygons(mapPtr, [][]pixelPoint{earth}, profileGroundColor)
+	var blocked [][]pixelPoint
+	for i := 0; i < len(ground); i++ {
+		var run []pixelPoint
+		start := i
+		for ; i < len(ground) && ground[i] > sight[i]-radius[i]; i++ {
+			run = append(run, toChart(i, ground[i]))
+		}
+		for j := i - 1; j >= start; j-- {
+			run = append(run, toChart(j, sight[j]-radius[j]))
+		}
+		if len(run) > 0 {
+			blocked = append(blocked, run)
+		}
+	}
+	fillPolygons(mapPtr, blocked, profileBlockedColor)
+
+	// The antennas and the line of sight between them
+	width := float64(cfg.IconSize) / 12
+	strokeLine(mapPtr, toChart(0, ground[0]), toChart(0, aTop), width*2, textColor(), false)
+	strokeLine(mapPtr, toChart(profileChartPoints, ground[profileChartPoints]), toChart(profileChartPoints, bTop), width*2, textColor(), false)
+	strokeLine(mapPtr, toChart(0, aTop), toChart(profileChartPoints, bTop), width, textColor(), true)
+	strokeRing(mapPtr, []pixelPoint{toChart(0, high), toChart(profileChartPoints, high), toChart(profileChartPoints, low), toChart(0, low)},
+		1, color.Gray{0x80})
+
+	write := func(text string, x, y int) {
+		if _, err := ctxPtr.DrawString(text, freetype.Pt(x, y)); err != nil {
+			fatalln("can't write on path profile", err)
+		}
+	}
+	for i, line := range legend {
+		write(line, ascent, ascent*2+i*lineHeight)
+	}
+	write(fmt.Sprintf("%.0f m", high), ascent/2, plot.Min.Y+ascent)
+	write(fmt.Sprintf("%.0f m", low), ascent/2, plot.Max.Y)
+	write(a.callsign, plot.Min.X, plot.Max.Y+ascent*2)
+	distance := fmt.Sprintf("%s  %.1f km (%.1f mi)", b.callsign, meters/1000, meters/1609.344)
+	write(distance, plot.Max.X-font.MeasureString(face, distance).Ceil(), plot.Max.Y+ascent*2)
+
+	file := "profile-" + fileNameSafe(a.callsign) + "-" + fileNameSafe(b.callsign) + ".png"
+	if err := os.MkdirAll(cfg.OutputDirectory, 0755); err != nil {
+		fatalf("Failed to create output directory: %s", err)
+	}
+	meta := outputMetadata(legend[0], pngText{"Stations", a.callsign + " " + b.callsign})
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, file), func(w io.Writer) error { return encodePNG(w, mapPtr, meta) })
+	if err != nil {
+		fatalf("Failed to write path profile: %s", err)
+	}
+	return file, finding
+}
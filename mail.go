@@ -0,0 +1,212 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Message body used when cfg.MailTemplate isn't set
+const defaultMailTemplate = `Hello {{.Call}},
+
+Attached are your reception maps from the {{.Frequency}} net on {{.Date}}:
+{{range .Maps}}  - {{.}}
+{{end}}
+The transmission map shows which stations could hear you; the receive map shows which stations you could hear.
+
+73,
+Net Control
+`
+
+// Values available to the subject and body templates
+type mailData struct {
+	Call      string   // Operator's call sign
+	Frequency string   // Frequency the net was on
+	Date      string   // Date of the net
+	Maps      []string // Description of each attached map
+}
+
+// Function mailCommand emails each operator who has an address in the operator file the maps generated for
+// them (both transmit and receive, if both have been generated into the output directory). With -dry-run it
+// just lists what it would send.
+func mailCommand(args []string) {
+	flags := flag.NewFlagSet("mail", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "List the messages that would be sent, without sending them")
+	calls := flags.String("calls", "all", "Comma-separated call signs to email, or 'all' for all")
+	flags.Parse(args)
+
+	// The templates are settings, so they're checked before the data is
+	subjectTemplate, err := template.New("subject").Parse(cfg.MailSubject)
+	if err != nil {
+		fatalf("can't use MailSubject %q in reception.cfg: %s", cfg.MailSubject, err)
+	}
+	bodyTemplate := template.Must(template.New("body").Parse(defaultMailTemplate))
+	if cfg.MailTemplate != "" {
+		if bodyTemplate, err = template.ParseFiles(cfg.MailTemplate); err != nil {
+			fatalf("can't use MailTemplate in reception.cfg: %s", err)
+		}
+	}
+
+	gpsToPixel = newGpsToPixel(loadBaseMap(cfg.MapFile))
+	fatalStatus = exitDataError
+	operators := loadOperators(cfg.OperatorFile)
+
+	only := make(map[string]bool)
+	if strings.ToUpper(*calls) != "ALL" {
+		for _, call := range strings.Split(strings.ReplaceAll(strings.ToUpper(*calls), " ", ""), ",") {
+			only[call] = true
+		}
+	}
+
+	// Collect each station's maps from the manifest
+	maps := make(map[string][]mapResult)
+	for _, result := range loadManifest() {
+		maps[result.Transmitter] = append(maps[result.Transmitter], result)
+	}
+	if len(maps) == 0 {
+		fatalln("no maps to send; generate some into", cfg.OutputDirectory, "first")
+	}
+
+	sent := 0
+	for _, call := range sortedOperatorCalls(operators) {
+		op := operators[call]
+		if op.email == "" || len(maps[call]) == 0 || (len(only) > 0 && !only[call]) {
+			continue
+		}
+
+		data := mailData{Call: call, Frequency: cfg.Frequency, Date: netDate()}
+		var attachments []string
+		for _, result := range maps[call] {
+			data.Maps = append(data.Maps, mapTypeName(result.MapType))
+			attachments = append(attachments, filepath.Join(cfg.OutputDirectory, result.File))
+		}
+
+		var subject, body bytes.Buffer
+		if err := subjectTemplate.Execute(&subject, data); err != nil {
+			fatalStatus = exitConfigError
+			fatalln("can't fill in MailSubject", err)
+		}
+		if err := bodyTemplate.Execute(&body, data); err != nil {
+			fatalStatus = exitConfigError
+			fatalln("can't fill in mail template", err)
+		}
+
+		if *dryRun {
+			fmt.Printf("Would send %q to %s with %d maps\n", subject.String(), op.email, len(attachments))
+			continue
+		}
+		if err := sendMail([]string{op.email}, subject.String(), body.String(), attachments); err != nil {
+			warnf("skipping %v: can't send mail: %s", call, err)
+			continue
+		}
+		sent++
+	}
+
+	if !*dryRun {
+		fmt.Printf("Sent maps to %d operators\n", sent)
+	}
+}
+
+// Function sortedOperatorCalls returns the call signs in the operator file in alphabetical order
+func sortedOperatorCalls(operators map[string]operatorData) []string {
+	calls := make(map[string]bool)
+	for call := range operators {
+		calls[call] = true
+	}
+	return sortedCalls(calls)
+}
+
+// Function sendMail sends a plain text message, with files attached, through the SMTP server in reception.cfg.
+// The password comes from cfg.SMTPPassword, or if that's empty, from the SMTP_PASSWORD environment variable.
+func sendMail(to []string, subject, body string, attachments []string) error {
+	from, err := mail.ParseAddress(cfg.MailFrom)
+	if err != nil {
+		return fmt.Errorf("MailFrom in reception.cfg isn't a valid address: %s", err)
+	}
+
+	var msg bytes.Buffer
+	mw := multipart.NewWriter(&msg)
+	fmt.Fprintf(&msg, "From: %s\r\n", from.String())
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", startTime.Format("Mon, 02 Jan 2006 15:04:05 -0700"))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+	part, _ := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	qp := quotedprintable.NewWriter(part)
+	qp.Write([]byte(body))
+	qp.Close()
+
+	for _, attachment := range attachments {
+		content, err := ioutil.ReadFile(attachment)
+		if err != nil {
+			return err
+		}
+		name := filepath.Base(attachment)
+		mediaType := mime.TypeByExtension(path.Ext(name))
+		if mediaType == "" {
+			mediaType = "application/octet-stream"
+		}
+		part, _ := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(mediaType, map[string]string{"name": name})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+
+		// Base64 in email has to be broken into lines of at most 76 characters
+		encoded := base64.StdEncoding.EncodeToString(content)
+		for len(encoded) > 76 {
+			part.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		part.Write([]byte(encoded + "\r\n"))
+	}
+	mw.Close()
+
+	var auth smtp.Auth
+	if cfg.SMTPUser != "" {
+		password := cfg.SMTPPassword
+		if password == "" {
+			password = os.Getenv("SMTP_PASSWORD")
+		}
+		host, _, err := net.SplitHostPort(cfg.SMTPServer)
+		if err != nil {
+			return fmt.Errorf("SMTPServer in reception.cfg should be host:port: %s", err)
+		}
+		auth = smtp.PlainAuth("", cfg.SMTPUser, password, host)
+	}
+
+	return smtp.SendMail(cfg.SMTPServer, auth, from.Address, to, msg.Bytes())
+}
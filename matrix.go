@@ -0,0 +1,247 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+)
+
+// Colors used when drawing the who-hears-whom matrix
+var (
+	matrixBackground = color.RGBA{0xff, 0xff, 0xff, 0xff} // Background, and cells with no report
+	matrixGridLine   = color.RGBA{0xc0, 0xc0, 0xc0, 0xff} // Lines between cells
+	matrixDiagonal   = color.RGBA{0x60, 0x60, 0x60, 0xff} // Cells where transmitter and receiver are the same station
+	matrixUnknown    = color.RGBA{0xe0, 0xe0, 0xe0, 0xff} // Cells with a report that has no icon
+)
+
+// Function writeMatrix draws the who-hears-whom matrix for a set of reports and saves it as a png file in the
+// output directory. Transmitters are on the rows and receivers are on the columns; each cell is colored with
+// the dominant color of the icon for its report, so the matrix uses the same color scheme as the maps. It returns
+// the file's name relative to the output directory.
+func writeMatrix(reports map[string]map[string]string, receivers, transmitters map[string]bool, icons map[string]image.Image) string {
+	const matrixFile = "matrix.png"
+	outputFile := filepath.Join(cfg.OutputDirectory, matrixFile)
+
+	matrix := drawMatrix(reports, receivers, transmitters, icons)
+	if err := writeFileAtomic(outputFile, func(w io.Writer) error { return encodePNG(w, matrix, outputMetadata("Who-hears-whom matrix")) }); err != nil {
+		fatalf("Failed to write matrix file: %s", err)
+	}
+	return matrixFile
+}
+
+// Function writeMatrixCSV writes the who-hears-whom matrix as a CSV file named for the date of the net, so a
+// season of nets can be loaded into R or pandas side by side. As in the image, transmitters are on the rows and
+// receivers on the columns (the other way round on receive maps); each cell is the report, or empty if there
+// wasn't one. It returns the file's name relative to the output directory.
+func writeMatrixCSV(reports map[string]map[string]string, receivers, transmitters map[string]bool) string {
+	matrixFile := "matrix-" + netDate() + ".csv"
+	rows := sortedCalls(transmitters)
+	cols := sortedCalls(receivers)
+
+	corner := "Transmitter"
+	if cfg.RcvMapFlag {
+		corner = "Receiver"
+	}
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, matrixFile), func(f io.Writer) error {
+		w := csv.NewWriter(f)
+		w.Write(append([]string{corner}, cols...))
+		for _, transmitter := range rows {
+			record := []string{transmitter}
+			for _, receiver := range cols {
+				record = append(record, reports[transmitter][receiver])
+			}
+			w.Write(record)
+		}
+		w.Flush()
+		return w.Error()
+	})
+	if err != nil {
+		fatalf("Failed to write matrix CSV file: %s", err)
+	}
+	return matrixFile
+}
+
+// Function drawMatrix returns an image of the who-hears-whom matrix. Row labels run down the left side, and
+// column labels are drawn rotated so they read bottom-to-top across the top of the grid.
+func drawMatrix(reports map[string]map[string]string, receivers, transmitters map[string]bool, icons map[string]image.Image) *image.RGBA {
+	rows := sortedCalls(transmitters)
+	cols := sortedCalls(receivers)
+
+	// Cell colors come from the icons, so look each one up just once
+	iconColors := make(map[string]color.Color)
+	for name, icon := range icons {
+		iconColors[name] = iconColor(icon)
+	}
+
+	// Size everything from the icon and font sizes so the matrix is legible at the same scale as the maps
+	face := truetype.NewFace(loadFont(), &truetype.Options{Size: cfg.FontSize, DPI: cfg.FontDPI})
+	cell := int(cfg.IconSize)
+	margin := int(cfg.FontSize + 0.5)
+	ascent := face.Metrics().Ascent.Ceil()
+
+	rowLabelWidth := maxLabelWidth(face, rows) + 2*margin
+	colLabelHeight := maxLabelWidth(face, cols) + 2*margin
+	gridOrigin := image.Point{rowLabelWidth, colLabelHeight}
+
+	matrixPtr := image.NewRGBA(image.Rect(0, 0, rowLabelWidth+len(cols)*cell+margin, colLabelHeight+len(rows)*cell+margin))
+	draw.Draw(matrixPtr, matrixPtr.Bounds(), &image.Uniform{matrixBackground}, image.Point{}, draw.Src)
+
+	// Fill in the cells
+	for r, transmitter := range rows {
+		for c, receiver := range cols {
+			cellRect := image.Rect(0, 0, cell, cell).Add(gridOrigin).Add(image.Point{c * cell, r * cell})
+
+			var fill color.Color
+			report := reports[transmitter][receiver]
+			switch {
+			case transmitter == receiver:
+				fill = matrixDiagonal
+			case report == "":
+				fill = matrixBackground
+			case iconColors[report] != nil:
+				fill = iconColors[report]
+			default:
+				fill = matrixUnknown
+			}
+			draw.Draw(matrixPtr, cellRect, &image.Uniform{fill}, image.Point{}, draw.Src)
+		}
+	}
+
+	// Draw the grid lines over the cells
+	for r := 0; r <= len(rows); r++ {
+		y := gridOrigin.Y + r*cell
+		draw.Draw(matrixPtr, image.Rect(gridOrigin.X, y, gridOrigin.X+len(cols)*cell+1, y+1), &image.Uniform{matrixGridLine}, image.Point{}, draw.Src)
+	}
+	for c := 0; c <= len(cols); c++ {
+		x := gridOrigin.X + c*cell
+		draw.Draw(matrixPtr, image.Rect(x, gridOrigin.Y, x+1, gridOrigin.Y+len(rows)*cell+1), &image.Uniform{matrixGridLine}, image.Point{}, draw.Src)
+	}
+
+	// Label the rows, vertically centered on each row of cells
+	ctxPtr := newContext(matrixPtr)
+	for r, transmitter := range rows {
+		pt := freetype.Pt(margin, gridOrigin.Y+r*cell+(cell+ascent)/2)
+		if _, err := ctxPtr.DrawString(transmitter, pt); err != nil {
+			fatalln("can't plot matrix row label", err)
+		}
+	}
+
+	// Label the columns. Freetype can't draw rotated text, so we draw the labels horizontally onto a scratch
+	// image, one per line, and then copy that image onto the matrix rotated 90 degrees counter-clockwise.
+	labelsPtr := image.NewRGBA(image.Rect(0, 0, colLabelHeight, len(cols)*cell))
+	labelCtxPtr := newContext(labelsPtr)
+	for c, receiver := range cols {
+		pt := freetype.Pt(margin, c*cell+(cell+ascent)/2)
+		if _, err := labelCtxPtr.DrawString(receiver, pt); err != nil {
+			fatalln("can't plot matrix column label", err)
+		}
+	}
+	w := labelsPtr.Bounds().Dx()
+	for y := 0; y < labelsPtr.Bounds().Dy(); y++ {
+		for x := 0; x < w; x++ {
+			if _, _, _, a := labelsPtr.At(x, y).RGBA(); a == 0 {
+				continue
+			}
+			dx, dy := gridOrigin.X+y, w-1-x
+			matrixPtr.Set(dx, dy, blend(matrixPtr.RGBAAt(dx, dy), labelsPtr.RGBAAt(x, y)))
+		}
+	}
+
+	return matrixPtr
+}
+
+// Function sortedCalls returns the call signs in a set in alphabetical order
+func sortedCalls(calls map[string]bool) []string {
+	sorted := make([]string, 0, len(calls))
+	for call := range calls {
+		sorted = append(sorted, call)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// Function sortedReceivers returns the call signs of the stations with a report for a transmitter, sorted
+func sortedReceivers(heard map[string]string) []string {
+	sorted := make([]string, 0, len(heard))
+	for call := range heard {
+		sorted = append(sorted, call)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// Function maxLabelWidth returns the width in pixels of the widest of a set of labels
+func maxLabelWidth(face font.Face, labels []string) int {
+	width := 0
+	for _, label := range labels {
+		if w := font.MeasureString(face, label).Ceil(); w > width {
+			width = w
+		}
+	}
+	return width
+}
+
+// Function iconColor returns the most common fully opaque color in an icon, which for our icons is the color of
+// the marker behind the symbol drawn on it. It returns nil if the icon has no opaque pixels.
+func iconColor(icon image.Image) color.Color {
+	counts := make(map[color.RGBA]int)
+	bounds := icon.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.RGBAModel.Convert(icon.At(x, y)).(color.RGBA)
+			if c.A == 0xff {
+				counts[c]++
+			}
+		}
+	}
+
+	var dominant color.RGBA
+	best := 0
+	for c, n := range counts {
+		// Break ties on the color value itself, so the result doesn't depend on map ordering
+		if n > best || (n == best && packRGBA(c) < packRGBA(dominant)) {
+			dominant, best = c, n
+		}
+	}
+	if best == 0 {
+		return nil
+	}
+	return dominant
+}
+
+// Function packRGBA packs a color into a single integer, for ordering colors
+func packRGBA(c color.RGBA) uint32 {
+	return uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A)
+}
+
+// Function blend composites a premultiplied source color over a destination color
+func blend(dst, src color.RGBA) color.RGBA {
+	a := 0xff - uint32(src.A)
+	return color.RGBA{
+		uint8(uint32(src.R) + uint32(dst.R)*a/0xff),
+		uint8(uint32(src.G) + uint32(dst.G)*a/0xff),
+		uint8(uint32(src.B) + uint32(dst.B)*a/0xff),
+		uint8(uint32(src.A) + uint32(dst.A)*a/0xff)}
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Translations of the legend and other text drawn on maps, by language and then by the English text, which for
+// text with numbers or names in it is a fmt format. Text a language has no translation for is drawn in English.
+var messageCatalog = map[string]map[string]string{
+	"es": {
+		"Transmission Map (who can hear me)":       "Mapa de transmisión (quién me escucha)",
+		"Receive Map (who can I hear)":             "Mapa de recepción (a quién escucho)",
+		"%s for %s":                                "%s de %s",
+		"Frequency: %s":                            "Frecuencia: %s",
+		"Transmitter Power: %.0f Watts":            "Potencia del transmisor: %.0f vatios",
+		"Antenna Type: %s":                         "Tipo de antena: %s",
+		"Antenna Height: %.0f feet":                "Altura de la antena: %.0f pies",
+		"Antenna Est. Gain: %.1f dBi":              "Ganancia est. de la antena: %.1f dBi",
+		"Shading: coverage predicted from terrain": "Sombreado: cobertura prevista según el terreno",
+		"Good":        "Buena",
+		"Fair":        "Regular",
+		"Poor":        "Mala",
+		"Transmitter": "Transmisor",
+	},
+}
+
+// Function language returns the language of the text on the maps, from cfg.Language: a code such as "es",
+// lowercased and without a region, so "es-MX" and "ES" are both "es"
+func language() string {
+	lang := strings.ToLower(cfg.Language)
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		lang = lang[:i]
+	}
+	return lang
+}
+
+// Function checkLanguage warns if the maps are to be in a language there are no translations for, since they'll
+// be in English
+func checkLanguage() {
+	if lang := language(); lang != "" && lang != "en" && messageCatalog[lang] == nil && len(cfg.Messages) == 0 {
+		warnf("no translations for Language %q, so the maps will be in English; translate their text with Messages", cfg.Language)
+	}
+}
+
+// Function localText returns text to draw on a map in cfg.Language: its translation from cfg.Messages, or else
+// from the catalog, or else the English. With args, the text is a format they're filled into, as by fmt.Sprintf.
+func localText(text string, args ...interface{}) string {
+	if t, found := cfg.Messages[text]; found {
+		text = t
+	} else if t, found := messageCatalog[language()][text]; found {
+		text = t
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"image/draw"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+// Name of the montage image within the output directory
+const montageFile = "montage.png"
+
+// Function montageTile returns a map shrunk to the montage's tile width, or the map itself if tiles are full size.
+// Unlike a thumbnail, the whole map is shrunk, labels and legend included, since the montage is printed large.
+func montageTile(mapImage image.Image) image.Image {
+	if cfg.MontageTileWidth == 0 || int(cfg.MontageTileWidth) >= mapImage.Bounds().Dx() {
+		tile := image.NewRGBA(mapImage.Bounds())
+		draw.Draw(tile, tile.Bounds(), mapImage, mapImage.Bounds().Min, draw.Src)
+		return tile
+	}
+	return resize.Resize(cfg.MontageTileWidth, 0, mapImage, resize.Lanczos3)
+}
+
+// Function writeMontage tiles the maps made this run into one large image, in order of call sign and
+// cfg.MontageColumns across, with a white border around each. It returns the montage's file name relative to
+// the output directory.
+func writeMontage(tiles map[string]image.Image) string {
+	calls := make([]string, 0, len(tiles))
+	for call := range tiles {
+		calls = append(calls, call)
+	}
+	sort.Strings(calls)
+
+	columns := cfg.MontageColumns
+	if columns <= 0 {
+		columns = 4
+	}
+	if columns > len(calls) {
+		columns = len(calls)
+	}
+	rows := (len(calls) + columns - 1) / columns
+
+	// Maps drawn on different base maps can be different sizes, so each gets a space as big as the largest
+	var tileSize image.Point
+	for _, tile := range tiles {
+		if size := tile.Bounds().Size(); size.X > tileSize.X {
+			tileSize.X = size.X
+		}
+		if size := tile.Bounds().Size(); size.Y > tileSize.Y {
+			tileSize.Y = size.Y
+		}
+	}
+	gap := tileSize.X / 50
+	montagePtr := image.NewRGBA(image.Rect(0, 0, columns*(tileSize.X+gap)+gap, rows*(tileSize.Y+gap)+gap))
+	draw.Draw(montagePtr, montagePtr.Bounds(), image.White, image.Point{}, draw.Src)
+
+	for i, call := range calls {
+		tile := tiles[call]
+		at := image.Point{gap + (i%columns)*(tileSize.X+gap), gap + (i/columns)*(tileSize.Y+gap)}
+		draw.Draw(montagePtr, tile.Bounds().Sub(tile.Bounds().Min).Add(at), tile, tile.Bounds().Min, draw.Src)
+	}
+
+	if err := os.MkdirAll(cfg.OutputDirectory, 0755); err != nil {
+		fatalf("Failed to create output directory: %s", err)
+	}
+	title := mapTypeName(currentMapType()) + " montage"
+	meta := outputMetadata(title, pngText{"Transmitters", strings.Join(calls, " ")})
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, montageFile), func(w io.Writer) error { return encodePNG(w, montagePtr, meta) })
+	if err != nil {
+		fatalf("Failed to write montage: %s", err)
+	}
+	return montageFile
+}
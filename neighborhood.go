@@ -0,0 +1,168 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/freetype"
+)
+
+// One CERT neighborhood, from a [[Neighborhoods]] table in reception.cfg. Its area is either a polygon or, if
+// there's no polygon, the box between two corners.
+type neighborhood struct {
+	Name     string       // Name of the neighborhood, used in the map legend and the name of its directory
+	NWCorner [2]float64   // GPS coordinates of the northwest corner of the neighborhood's bounding box
+	SECorner [2]float64   // GPS coordinates of the southeast corner of the neighborhood's bounding box
+	Polygon  [][2]float64 // GPS coordinates of each corner of the neighborhood's boundary, in order
+}
+
+// Directory within the output directory that neighborhood maps go in, one subdirectory per neighborhood
+const neighborhoodsDir = "neighborhoods"
+
+// Color of the neighborhood boundary drawn on its maps
+var neighborhoodBoundary = color.RGBA{0x30, 0x30, 0x90, 0xff}
+
+// Function vertices returns the corners of the neighborhood's area, going round its boundary
+func (n neighborhood) vertices() []gpsCoord {
+	if len(n.Polygon) == 0 {
+		return []gpsCoord{
+			{n.NWCorner[0], n.NWCorner[1]},
+			{n.NWCorner[0], n.SECorner[1]},
+			{n.SECorner[0], n.SECorner[1]},
+			{n.SECorner[0], n.NWCorner[1]},
+		}
+	}
+
+	vertices := make([]gpsCoord, len(n.Polygon))
+	for i, v := range n.Polygon {
+		vertices[i] = gpsCoord{v[0], v[1]}
+	}
+	return vertices
+}
+
+// Function contains reports whether a location is inside the neighborhood. Neighborhoods are small enough that
+// treating latitude and longitude as flat coordinates makes no practical difference.
+func (n neighborhood) contains(gps gpsCoord) bool {
+	poly := n.vertices()
+	inside := false
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		a, b := poly[i], poly[j]
+		if (a.lat > gps.lat) != (b.lat > gps.lat) && gps.long < (b.long-a.long)*(gps.lat-a.lat)/(b.lat-a.lat)+a.long {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Function checkNeighborhoods makes sure every neighborhood in reception.cfg has a name and an area
+func checkNeighborhoods() {
+	for i, n := range cfg.Neighborhoods {
+		if n.Name == "" {
+			fatalf("neighborhood %d in reception.cfg has no Name", i+1)
+		}
+		if len(n.Polygon) == 0 && n.NWCorner == n.SECorner {
+			fatalf("neighborhood %q in reception.cfg needs either a Polygon or NWCorner and SECorner", n.Name)
+		}
+		if len(n.Polygon) > 0 && len(n.Polygon) < 3 {
+			fatalf("neighborhood %q in reception.cfg has a Polygon with fewer than 3 corners", n.Name)
+		}
+	}
+}
+
+// Function writeNeighborhoodMaps crops a transmitter's map to each neighborhood, showing just the stations in
+// that neighborhood, so each neighborhood's team lead gets a map of their own area. Each neighborhood's maps go
+// in its own directory. It returns the names of the files written, relative to the output directory.
+func writeNeighborhoodMaps(baseMap image.Image, markers []marker, transmitter marker, mapFile, title string) []string {
+	var files []string
+	for _, n := range cfg.Neighborhoods {
+		// Crop to the neighborhood, with room around the edge for the icons of stations near the boundary
+		var boundary []image.Point
+		var area image.Rectangle
+		for _, v := range n.vertices() {
+			p := gpsToPixel(v)
+			boundary = append(boundary, p)
+			area = area.Union(image.Rectangle{p, p.Add(image.Point{1, 1})})
+		}
+		area = area.Inset(-int(cfg.IconSize) * 2).Intersect(baseMap.Bounds())
+		if area.Empty() {
+			warnf("skipping neighborhood %v: not on the map", n.Name)
+			continue
+		}
+
+		mapPtr := image.NewRGBA(image.Rect(0, 0, area.Dx(), area.Dy()))
+		draw.Draw(mapPtr, mapPtr.Bounds(), baseMap, area.Min, draw.Src)
+		for i := range boundary {
+			from, to := boundary[i].Sub(area.Min), boundary[(i+1)%len(boundary)].Sub(area.Min)
+			drawLine(mapPtr, from, to, float64(cfg.IconSize)/8, neighborhoodBoundary, true)
+		}
+
+		// Labels go on their own layer, as on the full map, so they're on top of all the icons
+		textMapPtr, ctxPtr := newDrawing(mapPtr)
+		for _, m := range append(markers, transmitter) {
+			if m.operator.callsign == "" || !n.contains(m.operator.gps) {
+				continue
+			}
+			op := m.operator
+			op.pixel = op.pixel.Sub(area.Min)
+			plotIcon(mapPtr, m.shownIcon(), m.report, op, ctxPtr)
+		}
+
+		legend := []string{title, n.Name + " neighborhood", "Frequency: " + cfg.Frequency}
+		_, size := legendFont()
+		setFont(ctxPtr, legendFont)
+		ascent := int(size*cfg.FontDPI/72.0 + 0.5)
+		for i, line := range legend {
+			pt := freetype.Pt(ascent, ascent*2+i*int(float64(ascent)*cfg.FontLineSpacing+0.5))
+			if _, err := ctxPtr.DrawString(line, pt); err != nil {
+				fatalln("can't plot neighborhood map legend", err)
+			}
+		}
+		if cfg.ScaleBarFlag {
+			drawScaleBar(textMapPtr, newGeoref(baseMap).metersPerPixel())
+		}
+		if cfg.NorthArrowFlag {
+			drawNorthArrow(textMapPtr)
+		}
+		addTextHalo(textMapPtr)
+		draw.Draw(mapPtr, mapPtr.Bounds(), textMapPtr, image.Point{}, draw.Over)
+		drawAttribution(mapPtr)
+		drawWatermark(mapPtr)
+
+		file := neighborhoodsDir + "/" + fileNameSafe(n.Name) + "/" + mapFile
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(cfg.OutputDirectory, file)), 0755); err != nil {
+			fatalf("Failed to create neighborhood directory: %s", err)
+		}
+		meta := outputMetadata(title+", "+n.Name, pngText{"Transmitter", transmitter.operator.callsign}, pngText{"Neighborhood", n.Name})
+		err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, file), func(w io.Writer) error { return encodePNG(w, mapPtr, meta) })
+		if err != nil {
+			fatalf("Failed to write neighborhood map: %s", err)
+		}
+		files = append(files, file)
+
+		if cfg.WorldFileFlag {
+			files = append(files, writeWorldFiles(file, newGeoref(baseMap).crop(area.Min))...)
+		}
+		if cfg.GeoTIFFFlag {
+			files = append(files, writeGeoTIFF(mapPtr, file, newGeoref(baseMap).crop(area.Min), title+", "+n.Name))
+		}
+	}
+	return files
+}
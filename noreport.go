@@ -0,0 +1,85 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+// NoReportIcon value for drawing a hollow circle instead of one of the icons
+const noReportHollow = "hollow"
+
+// How strongly a NoReportIcon shows, from 0 (not at all) to 1 (fully)
+const noReportOpacity = 0.4
+
+// Color of the hollow no-report circle
+var noReportColor = color.RGBA{0x60, 0x60, 0x60, 0xff}
+
+// Function noReportIcon returns the icon for operators who gave no report: cfg.NoReportIcon from the icons,
+// faded so it can't be mistaken for a report, or a hollow circle. It returns nil if NoReportIcon is "", when
+// operators without reports are left off the maps.
+func noReportIcon(icons map[string]image.Image) image.Image {
+	if cfg.NoReportIcon == "" {
+		return nil
+	}
+	icon, present := icons[cfg.NoReportIcon]
+	if !present {
+		if cfg.NoReportIcon != noReportHollow {
+			warnf("no icon %q for NoReportIcon; using a hollow circle", cfg.NoReportIcon)
+		}
+		size := float64(cfg.IconSize)
+		hollowPtr := image.NewRGBA(image.Rect(0, 0, int(cfg.IconSize), int(cfg.IconSize)))
+		ring := circle(pixelPoint{size / 2, size / 2}, size/2-size/10)
+		strokeRing(hollowPtr, ring, size/8, color.White)
+		strokeRing(hollowPtr, ring, size/16, noReportColor)
+		return hollowPtr
+	}
+
+	fadedPtr := image.NewRGBA(icon.Bounds())
+	opacity := &image.Uniform{color.Alpha{uint8(noReportOpacity * 0xff)}}
+	draw.DrawMask(fadedPtr, fadedPtr.Bounds(), icon, icon.Bounds().Min, opacity, image.Point{}, draw.Over)
+	return fadedPtr
+}
+
+// Function noReportMarkers returns markers for the operators in the operator file who gave no report for a
+// transmitter, so "didn't check in" can be told apart from "not on the roster". They're in call sign order.
+func noReportMarkers(operators map[string]operatorData, reports map[string]map[string]string, transmitter string, icon image.Image) []marker {
+	var markers []marker
+	if icon == nil {
+		return markers
+	}
+	for call, op := range operators {
+		if call != transmitter && reports[transmitter][call] == "" {
+			markers = append(markers, marker{operator: op, icon: icon, absent: true})
+		}
+	}
+	sort.Slice(markers, func(i, j int) bool { return markers[i].operator.callsign < markers[j].operator.callsign })
+	return markers
+}
+
+// Function splitAbsent separates the markers for operators who gave no report from the rest
+func splitAbsent(markers []marker) (present, absent []marker) {
+	for _, m := range markers {
+		if m.absent {
+			absent = append(absent, m)
+		} else {
+			present = append(present, m)
+		}
+	}
+	return present, absent
+}
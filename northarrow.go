@@ -0,0 +1,49 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Function drawNorthArrow draws a north arrow with an "N" over it in the upper right corner of a map: the
+// traditional split arrowhead, dark on the left half and white on the right. Base maps are always north-up, so it
+// always points straight up.
+func drawNorthArrow(dstPtr *image.RGBA) {
+	face := truetype.NewFace(loadFont(), &truetype.Options{Size: cfg.FontSize, DPI: cfg.FontDPI})
+	ascent := face.Metrics().Ascent.Ceil()
+	height := float64(cfg.IconSize) * 1.5
+	halfWidth := height / 3
+
+	bounds := dstPtr.Bounds()
+	cx := float64(bounds.Max.X) - float64(ascent)*2 - halfWidth
+	top := float64(bounds.Min.Y) + float64(ascent)*3
+	bottom := top + height
+	notch := bottom - height/4
+
+	tip, left, right, middle := pixelPoint{cx, top}, pixelPoint{cx - halfWidth, bottom}, pixelPoint{cx + halfWidth, bottom}, pixelPoint{cx, notch}
+	fillPolygons(dstPtr, [][]pixelPoint{{tip, left, middle}}, scaleBarColor)
+	fillPolygons(dstPtr, [][]pixelPoint{{tip, middle, right}}, scaleBarOutline)
+	strokeRing(dstPtr, []pixelPoint{tip, left, middle, right}, cfg.FontSize/6, scaleBarColor)
+
+	d := font.Drawer{Dst: dstPtr, Src: &image.Uniform{scaleBarColor}, Face: face}
+	width := d.MeasureString("N")
+	d.Dot = fixed.Point26_6{X: fixed.Int26_6(cx*64) - width/2, Y: fixed.I(int(top) - ascent/3)}
+	d.DrawString("N")
+}
@@ -0,0 +1,246 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Ways of resolving conflicts when merging operator files
+const (
+	preferA   = "a"           // Take the value from the first file
+	preferB   = "b"           // Take the value from the second file
+	preferAsk = "interactive" // Ask, for each conflict
+)
+
+// Names of the operator file's values, by column, for reporting conflicts
+var operatorColumns = []string{"call sign", "latitude", "longitude", "transmitter power", "antenna type", "antenna gain",
+	"antenna height", "email", "icon", "beam heading"}
+
+// Function operatorsCommand runs the operator file tools: "reception operators merge fileA fileB"
+func operatorsCommand(args []string) {
+	if len(args) == 0 || args[0] != "merge" {
+		fmt.Fprintln(os.Stderr, "Usage: reception operators merge [-prefer a|b|interactive] [-tolerance meters] [-o file] fileA fileB")
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("operators merge", flag.ExitOnError)
+	prefer := flags.String("prefer", preferAsk, "How to resolve conflicts: a, b, or interactive")
+	tolerance := flags.Float64("tolerance", 25, "Locations closer together than this many meters aren't a conflict")
+	output := flags.String("o", "operators-merged.csv", "File to write the merged operators to")
+	flags.Parse(args[1:])
+	if flags.NArg() != 2 {
+		fatalln("operators merge needs exactly two operator files")
+	}
+	if *prefer != preferA && *prefer != preferB && *prefer != preferAsk {
+		fatalf("unknown -prefer %q; use %q, %q or %q", *prefer, preferA, preferB, preferAsk)
+	}
+
+	fileA, fileB := flags.Arg(0), flags.Arg(1)
+	fatalStatus = exitDataError
+	merged, conflicts := mergeOperators(readOperatorRecords(fileA), readOperatorRecords(fileB), *tolerance, conflictResolver(*prefer, fileA, fileB))
+
+	f, err := os.Create(*output)
+	if err != nil {
+		fatalln("can't create", *output, err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.WriteAll(merged)
+	if err := w.Error(); err != nil {
+		fatalln("can't write", *output, err)
+	}
+
+	fmt.Printf("Wrote %d operators to %s (%d conflicts resolved)\n", len(merged), *output, conflicts)
+}
+
+// Function readOperatorRecords reads an operator file without interpreting it, so everything in it (including
+// optional columns) survives a merge. Records are keyed by normalized call sign.
+func readOperatorRecords(csvFile string) map[string][]string {
+	f, err := os.Open(csvFile)
+	if err != nil {
+		fatalln("Couldn't open the operator csv file:", err)
+	}
+	defer f.Close()
+
+	records := make(map[string][]string)
+	r := csv.NewReader(bufio.NewReader(f))
+	r.FieldsPerRecord = -1
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("error reading operator file", csvFile, err)
+		}
+		if len(record) < 7 {
+			fatalf("operator file %s has a record with only %d values: %v", csvFile, len(record), record)
+		}
+
+		record[0] = strings.ReplaceAll(strings.ToUpper(record[0]), " ", "")
+		records[record[0]] = record
+	}
+	return records
+}
+
+// A function that picks between two conflicting values for one of an operator's fields, returning true to
+// take the first file's value
+type resolver func(call, field, a, b string) bool
+
+// Function conflictResolver returns the resolver for a -prefer option
+func conflictResolver(prefer, fileA, fileB string) resolver {
+	switch prefer {
+	case preferA:
+		return func(call, field, a, b string) bool { return true }
+	case preferB:
+		return func(call, field, a, b string) bool { return false }
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	return func(call, field, a, b string) bool {
+		for {
+			fmt.Printf("%s %s differs:\n  [a] %-30s (%s)\n  [b] %-30s (%s)\nKeep which? ", call, field, a, fileA, b, fileB)
+			answer, err := in.ReadString('\n')
+			if err != nil {
+				fatalln("no answer for conflict; use -prefer to resolve conflicts without asking")
+			}
+			switch strings.ToLower(strings.TrimSpace(answer)) {
+			case "a":
+				return true
+			case "b":
+				return false
+			}
+		}
+	}
+}
+
+// Function mergeOperators merges two sets of operator records, returning the merged records sorted by call
+// sign and the number of conflicts that had to be resolved. A value that's unknown in one file (empty, or -100)
+// is filled in from the other without counting as a conflict, and locations closer together than tolerance
+// meters are treated as the same, keeping the first file's.
+func mergeOperators(a, b map[string][]string, tolerance float64, resolve resolver) ([][]string, int) {
+	calls := make(map[string]bool)
+	for call := range a {
+		calls[call] = true
+	}
+	for call := range b {
+		calls[call] = true
+	}
+
+	var merged [][]string
+	conflicts := 0
+	for _, call := range sortedCalls(calls) {
+		recA, inA := a[call]
+		recB, inB := b[call]
+		switch {
+		case !inB:
+			merged = append(merged, recA)
+			continue
+		case !inA:
+			merged = append(merged, recB)
+			continue
+		}
+
+		// Make the records the same length, so optional columns in just one file are still merged
+		for len(recA) < len(recB) {
+			recA = append(recA, "")
+		}
+		for len(recB) < len(recA) {
+			recB = append(recB, "")
+		}
+		rec := append([]string(nil), recA...)
+
+		// Location: latitude and longitude go together
+		if !unknownValue(recB[1]) && !unknownValue(recB[2]) {
+			if unknownValue(recA[1]) || unknownValue(recA[2]) {
+				rec[1], rec[2] = recB[1], recB[2]
+			} else if d := recordDistance(recA, recB); d > tolerance {
+				conflicts++
+				where := fmt.Sprintf("location (%.0f m apart)", d)
+				if !resolve(call, where, recA[1]+", "+recA[2], recB[1]+", "+recB[2]) {
+					rec[1], rec[2] = recB[1], recB[2]
+				}
+			}
+		}
+
+		// Everything else is compared field by field
+		for i := 3; i < len(rec); i++ {
+			switch {
+			case unknownValue(recB[i]) || sameValue(recA[i], recB[i]):
+				// Nothing to add from the second file
+			case unknownValue(recA[i]):
+				rec[i] = recB[i]
+			default:
+				conflicts++
+				field := "column " + strconv.Itoa(i+1)
+				if i < len(operatorColumns) {
+					field = operatorColumns[i]
+				}
+				if !resolve(call, field, recA[i], recB[i]) {
+					rec[i] = recB[i]
+				}
+			}
+		}
+		merged = append(merged, rec)
+	}
+
+	return merged, conflicts
+}
+
+// Function unknownValue reports whether an operator file value means "not known"
+func unknownValue(value string) bool {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return true
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	return err == nil && f == -100.0
+}
+
+// Function sameValue reports whether two operator file values are the same, comparing numbers numerically and
+// text without regard to case or surrounding space
+func sameValue(a, b string) bool {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	aNum, aErr := strconv.ParseFloat(a, 64)
+	bNum, bErr := strconv.ParseFloat(b, 64)
+	if aErr == nil && bErr == nil {
+		return aNum == bNum
+	}
+	return strings.EqualFold(a, b)
+}
+
+// Function recordDistance returns the distance in meters between the locations in two operator records, or
+// infinity if either can't be parsed
+func recordDistance(a, b []string) float64 {
+	var coords [4]float64
+	for i, value := range []string{a[1], a[2], b[1], b[2]} {
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return math.Inf(1)
+		}
+		coords[i] = f
+	}
+	return distanceMeters(gpsCoord{coords[0], coords[1]}, gpsCoord{coords[2], coords[3]})
+}
@@ -0,0 +1,143 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Color of the city, county and other boundaries from BoundaryFile
+var jurisdictionBoundary = color.RGBA{0x90, 0x30, 0x90, 0xff}
+
+// Function loadNeighborhoodFile reads neighborhood boundaries from a GeoJSON, KML or shapefile. A neighborhood
+// made of several separate areas is given the largest of them, since a neighborhood in reception.cfg has only one.
+func loadNeighborhoodFile(file string) []neighborhood {
+	var neighborhoods []neighborhood
+	for i, f := range readAreaFile(file) {
+		if f.name == "" {
+			fatalf("area %d in neighborhood file %s has no name", i+1, file)
+		}
+		var outlines [][][2]float64
+		for _, rings := range f.polygons {
+			if len(rings) > 0 {
+				outlines = append(outlines, rings[0]) // The rest are holes
+			}
+		}
+		neighborhoods = append(neighborhoods, neighborhood{Name: f.name, Polygon: largestPolygon(outlines)})
+	}
+	return neighborhoods
+}
+
+// Function largestPolygon returns the polygon with the largest area
+func largestPolygon(polygons [][][2]float64) [][2]float64 {
+	var largest [][2]float64
+	largestArea := -1.0
+	for _, p := range polygons {
+		if area := math.Abs(polygonArea(p)); area > largestArea {
+			largest, largestArea = p, area
+		}
+	}
+	return largest
+}
+
+// Function polygonArea returns the signed area of a polygon, by the shoelace formula
+func polygonArea(polygon [][2]float64) float64 {
+	area := 0.0
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		area += polygon[j][0]*polygon[i][1] - polygon[i][0]*polygon[j][1]
+	}
+	return area / 2
+}
+
+// Function polygonCenter returns the middle of a polygon's area (its centroid), to center its name on, which for an
+// odd shape isn't the middle of its corners. A polygon with no area gets the middle of its bounding box.
+func polygonCenter(polygon [][2]float64, bounds image.Rectangle) (x, y float64) {
+	a := polygonArea(polygon)
+	if a == 0 {
+		center := bounds.Min.Add(bounds.Max).Div(2)
+		return float64(center.X), float64(center.Y)
+	}
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		cross := polygon[j][0]*polygon[i][1] - polygon[i][0]*polygon[j][1]
+		x += (polygon[j][0] + polygon[i][0]) * cross
+		y += (polygon[j][1] + polygon[i][1]) * cross
+	}
+	return x / (6 * a), y / (6 * a)
+}
+
+// Function overlayNeighborhoods returns a copy of a base map with the outline of each neighborhood in
+// reception.cfg drawn on it, and its name in the middle. Drawing them on the base map puts them beneath
+// everything else on every map made from it, cropped and resized maps included.
+func overlayNeighborhoods(baseMap image.Image, toPixel func(gpsCoord) image.Point) image.Image {
+	mapPtr := image.NewRGBA(baseMap.Bounds())
+	draw.Draw(mapPtr, mapPtr.Bounds(), baseMap, baseMap.Bounds().Min, draw.Src)
+	face := truetype.NewFace(loadFont(), &truetype.Options{Size: cfg.FontSize, DPI: cfg.FontDPI})
+
+	for _, n := range cfg.Neighborhoods {
+		var outline []pixelPoint
+		var pixels [][2]float64
+		var area image.Rectangle
+		for _, v := range n.vertices() {
+			p := toPixel(v)
+			outline = append(outline, toPixelPoint(p))
+			pixels = append(pixels, [2]float64{float64(p.X), float64(p.Y)})
+			area = area.Union(image.Rectangle{p, p.Add(image.Point{1, 1})})
+		}
+		if !area.Overlaps(mapPtr.Bounds()) {
+			continue
+		}
+		strokeRing(mapPtr, outline, float64(cfg.IconSize)/12, neighborhoodBoundary)
+
+		cx, cy := polygonCenter(pixels, area)
+		d := font.Drawer{Dst: mapPtr, Src: &image.Uniform{neighborhoodBoundary}, Face: face}
+		width := d.MeasureString(n.Name)
+		d.Dot = fixed.Point26_6{X: fixed.Int26_6(cx*64) - width/2, Y: fixed.Int26_6(cy*64) + face.Metrics().Ascent/2}
+		d.DrawString(n.Name)
+	}
+	return mapPtr
+}
+
+// Function overlayBoundaries returns a copy of a base map with the boundaries of areas such as cities and counties
+// drawn on it, beneath everything else on every map made from it
+func overlayBoundaries(baseMap image.Image, toPixel func(gpsCoord) image.Point, areas []mapFeature) image.Image {
+	mapPtr := image.NewRGBA(baseMap.Bounds())
+	draw.Draw(mapPtr, mapPtr.Bounds(), baseMap, baseMap.Bounds().Min, draw.Src)
+
+	for _, a := range areas {
+		for _, polygon := range a.polygons {
+			for _, ring := range polygon {
+				var outline []pixelPoint
+				var area image.Rectangle
+				for _, v := range ring {
+					p := toPixel(gpsCoord{v[0], v[1]})
+					outline = append(outline, toPixelPoint(p))
+					area = area.Union(image.Rectangle{p, p.Add(image.Point{1, 1})})
+				}
+				if !area.Overlaps(mapPtr.Bounds()) {
+					continue
+				}
+				strokeRing(mapPtr, outline, float64(cfg.IconSize)/10, jurisdictionBoundary)
+			}
+		}
+	}
+	return mapPtr
+}
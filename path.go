@@ -0,0 +1,315 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/freetype"
+)
+
+// Color of path lines for which there's no report
+var pathNoReport = color.RGBA{0x80, 0x80, 0x80, 0xff}
+
+// Function pathCommand answers "can these two stations talk?": it prints what the reports say about how well
+// each hears the other, the best station to relay between them, and the distance, bearing and radio horizon
+// between them. With several report files (e.g. one per net), the direct reports from each are listed, and the
+// relay is chosen from all of them, later files taking precedence. With -map it also draws a map of just the two
+// stations and the relay, and with -profile a chart of the terrain between them.
+func pathCommand(args []string) {
+	flags := flag.NewFlagSet("path", flag.ExitOnError)
+	reportFiles := flags.String("reports", cfg.ReportFile, "Comma-separated report files to look in, oldest first")
+	drawMap := flags.Bool("map", false, "Also draw a map of the two stations and the best relay")
+	drawProfile := flags.Bool("profile", false, "Also chart the terrain between the two stations")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		fatalln("Usage: reception path [-reports file,file...] [-map] [-profile] CALL1 CALL2")
+	}
+	a, b := strings.ToUpper(flags.Arg(0)), strings.ToUpper(flags.Arg(1))
+
+	icons := loadIcons(cfg.IconDirectory)
+	baseMap := loadBaseMap(cfg.MapFile)
+	gpsToPixel = newGpsToPixel(baseMap)
+	fatalStatus = exitDataError
+	operators := loadOperators(cfg.OperatorFile)
+	sourceFiles = []string{cfg.OperatorFile, cfg.MapFile}
+
+	// Reports are always wanted as transmitter -> receiver here, whatever reception.cfg says
+	cfg.RcvMapFlag = false
+	var files []string
+	var history []map[string]map[string]string
+	latest := make(map[string]map[string]string)
+	for _, file := range strings.Split(*reportFiles, ",") {
+		reports, _, _ := loadReports(strings.TrimSpace(file))
+		files = append(files, strings.TrimSpace(file))
+		sourceFiles = append(sourceFiles, strings.TrimSpace(file))
+		history = append(history, reports)
+		for transmitter, heard := range reports {
+			if latest[transmitter] == nil {
+				latest[transmitter] = make(map[string]string)
+			}
+			for receiver, report := range heard {
+				latest[transmitter][receiver] = report
+			}
+		}
+	}
+
+	opA, opB := lookupOperator(operators, a), lookupOperator(operators, b)
+	fmt.Printf("%s to %s\n", a, b)
+	if opA.callsign != "" && opB.callsign != "" {
+		d := distanceMeters(opA.gps, opB.gps)
+		fmt.Printf("  Distance:      %.1f km (%.1f mi); %s bears %.0f° from %s, %s bears %.0f° from %s\n",
+			d/1000, d/1609.344, b, bearing(opA.gps, opB.gps), a, a, bearing(opB.gps, opA.gps), b)
+		if opA.antHeight != -100 && opB.antHeight != -100 {
+			horizon := radioHorizon(opA.antHeight, opB.antHeight)
+			within := "within"
+			if d > horizon {
+				within = "beyond"
+			}
+			fmt.Printf("  Line of sight: %s the %.1f km radio horizon for their antenna heights (terrain not considered)\n", within, horizon/1000)
+		}
+	} else {
+		for _, op := range []struct{ call, found string }{{a, opA.callsign}, {b, opB.callsign}} {
+			if op.found == "" {
+				fmt.Printf("  %s isn't in the operator file, so there's no distance or line of sight\n", op.call)
+			}
+		}
+	}
+
+	fmt.Printf("  %s heard by %s: %s\n", a, b, reportHistory(history, files, a, b))
+	fmt.Printf("  %s heard by %s: %s\n", b, a, reportHistory(history, files, b, a))
+
+	relay, links, relayReport := bestRelay(latest, icons, a, b)
+	if relay == "" {
+		fmt.Println("  Relay:         no station both hears and is heard by both")
+	} else {
+		fmt.Printf("  Relay:         %s (%s->%s %s, %s->%s %s, %s->%s %s, %s->%s %s)\n", relay,
+			a, relay, links[0], relay, b, links[1], b, relay, links[2], relay, a, links[3])
+	}
+
+	if *drawMap {
+		if opA.callsign == "" || opB.callsign == "" {
+			fatalln("can't draw a map of stations that aren't in the operator file")
+		}
+		file := writePathMap(baseMap, icons, latest, opA, opB, lookupOperator(operators, relay), relayReport)
+		fmt.Println("Wrote", filepath.Join(cfg.OutputDirectory, file))
+	}
+	if *drawProfile {
+		if opA.callsign == "" || opB.callsign == "" {
+			fatalln("can't chart the terrain between stations that aren't in the operator file")
+		}
+		file, finding := writePathProfile(opA, opB)
+		fmt.Printf("  Terrain:       %s\n", finding)
+		fmt.Println("Wrote", filepath.Join(cfg.OutputDirectory, file))
+	}
+}
+
+// Function reportHistory describes how well receiver heard transmitter in each of a series of report files
+func reportHistory(history []map[string]map[string]string, files []string, transmitter, receiver string) string {
+	var found []string
+	for i, reports := range history {
+		report := reports[transmitter][receiver]
+		if report == "" {
+			report = "no report"
+		}
+		if len(history) > 1 {
+			report += " (" + files[i] + ")"
+		}
+		found = append(found, report)
+	}
+	return strings.Join(found, ", ")
+}
+
+// Function bestRelay returns the station best able to relay in both directions between a and b: one that hears
+// each of them and is heard by each of them, and whose worst link of the four is the best. It also returns the
+// four reports (a->relay, relay->b, b->relay, relay->a) and the worst of them. If there's no such station it
+// returns "".
+func bestRelay(reports map[string]map[string]string, icons map[string]image.Image, a, b string) (string, [4]string, string) {
+	heard := func(transmitter, receiver string) (string, bool) {
+		report := reports[transmitter][receiver]
+		_, present := icons[report]
+		return report, report != "" && present
+	}
+
+	var best, bestWorst string
+	var bestLinks [4]string
+	for _, relay := range sortedCalls(stationsIn(reports)) {
+		if relay == a || relay == b {
+			continue
+		}
+
+		var links [4]string
+		viable := true
+		for i, pair := range [4][2]string{{a, relay}, {relay, b}, {b, relay}, {relay, a}} {
+			report, ok := heard(pair[0], pair[1])
+			viable = viable && ok
+			links[i] = report
+		}
+		if !viable {
+			continue
+		}
+
+		worst := links[0]
+		for _, report := range links[1:] {
+			if worseReport(report, worst) {
+				worst = report
+			}
+		}
+		if best == "" || worseReport(bestWorst, worst) {
+			best, bestWorst, bestLinks = relay, worst, links
+		}
+	}
+	return best, bestLinks, bestWorst
+}
+
+// Function stationsIn returns every station that appears in a set of reports, as transmitter or receiver
+func stationsIn(reports map[string]map[string]string) map[string]bool {
+	stations := make(map[string]bool)
+	for transmitter, heard := range reports {
+		stations[transmitter] = true
+		for receiver := range heard {
+			stations[receiver] = true
+		}
+	}
+	return stations
+}
+
+// Function writePathMap draws a map of two stations, with lines showing how well they hear each other and the
+// relay between them (if any), cropped to the area around them. Lines are colored by the worse of the two
+// directions, and the relay's icon by its worst link. It returns the file's name relative to the output directory.
+func writePathMap(baseMap image.Image, icons map[string]image.Image, reports map[string]map[string]string, a, b, relay operatorData, relayReport string) string {
+	// Crop to the stations, with a generous margin so there's some context and room for the legend
+	var area image.Rectangle
+	for _, op := range []operatorData{a, b, relay} {
+		if op.callsign != "" {
+			area = area.Union(image.Rectangle{op.pixel, op.pixel.Add(image.Point{1, 1})})
+		}
+	}
+	margin := int(cfg.IconSize) * 6
+	area = area.Inset(-margin).Intersect(baseMap.Bounds())
+
+	mapPtr := image.NewRGBA(image.Rect(0, 0, area.Dx(), area.Dy()))
+	draw.Draw(mapPtr, mapPtr.Bounds(), baseMap, area.Min, draw.Src)
+	for _, op := range []*operatorData{&a, &b, &relay} {
+		op.pixel = op.pixel.Sub(area.Min)
+	}
+
+	link := func(from, to operatorData) {
+		c, dashed := color.Color(pathNoReport), true
+		worst := reports[from.callsign][to.callsign]
+		if other := reports[to.callsign][from.callsign]; worst == "" || (other != "" && worseReport(other, worst)) {
+			worst = other
+		}
+		if icon, present := icons[worst]; present && worst != "" {
+			if ic := iconColor(icon); ic != nil {
+				c = ic
+			}
+			dashed = false
+		}
+		drawLine(mapPtr, from.pixel, to.pixel, float64(cfg.IconSize)/6, c, dashed)
+	}
+	link(a, b)
+	if relay.callsign != "" {
+		link(a, relay)
+		link(relay, b)
+	}
+
+	ctxPtr := newContext(mapPtr)
+	plot := func(op operatorData, report string) {
+		m := marker{operator: op, report: report, icon: icons[report], ownIcon: icons[op.icon]}
+		plotIcon(mapPtr, m.shownIcon(), report, op, ctxPtr)
+	}
+	if relay.callsign != "" {
+		plot(relay, relayReport)
+	}
+	plot(a, cfg.TransIcon)
+	plot(b, cfg.TransIcon)
+
+	legend := []string{"Path between " + a.callsign + " and " + b.callsign, "Frequency: " + cfg.Frequency}
+	_, size := legendFont()
+	setFont(ctxPtr, legendFont)
+	ascent := int(size*cfg.FontDPI/72.0 + 0.5)
+	for i, line := range legend {
+		pt := freetype.Pt(ascent, ascent*2+i*int(float64(ascent)*cfg.FontLineSpacing+0.5))
+		if _, err := ctxPtr.DrawString(line, pt); err != nil {
+			fatalln("can't plot path map legend", err)
+		}
+	}
+
+	if cfg.ScaleBarFlag {
+		drawScaleBar(mapPtr, newGeoref(baseMap).metersPerPixel())
+	}
+	if cfg.NorthArrowFlag {
+		drawNorthArrow(mapPtr)
+	}
+	drawAttribution(mapPtr)
+	drawWatermark(mapPtr)
+
+	file := "path-" + fileNameSafe(a.callsign) + "-" + fileNameSafe(b.callsign) + ".png"
+	if err := os.MkdirAll(cfg.OutputDirectory, 0755); err != nil {
+		fatalf("Failed to create output directory: %s", err)
+	}
+	meta := outputMetadata(legend[0], pngText{"Stations", a.callsign + " " + b.callsign})
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, file), func(w io.Writer) error { return encodePNG(w, mapPtr, meta) })
+	if err != nil {
+		fatalf("Failed to write path map: %s", err)
+	}
+	if cfg.WorldFileFlag {
+		writeWorldFiles(file, newGeoref(baseMap).crop(area.Min))
+	}
+	if cfg.GeoTIFFFlag {
+		writeGeoTIFF(mapPtr, file, newGeoref(baseMap).crop(area.Min), legend[0])
+	}
+	return file
+}
+
+// Function drawReportLines draws a line from the transmitter to each receiver on a map, in the color of the
+// receiver's icon, so patterns of good and poor paths stand out. Lines for the best report on the map are solid
+// and the rest dashed, which keeps them apart in grayscale mode too. They're drawn beneath the icons, which go on top.
+func drawReportLines(mapPtr *image.RGBA, markers []marker, transmitter marker) {
+	if transmitter.operator.callsign == "" {
+		return
+	}
+	best := ""
+	for _, m := range markers {
+		if best == "" || worseReport(best, m.report) {
+			best = m.report
+		}
+	}
+
+	for _, m := range markers {
+		if m.operator.callsign == "" {
+			continue
+		}
+		c := iconColor(m.icon)
+		if c == nil {
+			c = color.Gray{0x60}
+		}
+		drawLine(mapPtr, transmitter.operator.pixel, m.operator.pixel, float64(cfg.IconSize)/12, c, m.report != best)
+	}
+}
+
+// Function drawLine draws a smooth line of the given width between two points, solid or dashed
+func drawLine(dstPtr *image.RGBA, from, to image.Point, width float64, c color.Color, dashed bool) {
+	strokeLine(dstPtr, toPixelPoint(from), toPixelPoint(to), width, c, dashed)
+}
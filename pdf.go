@@ -0,0 +1,168 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+)
+
+// A minimal PDF writer for simple tabular reports. It only knows the built-in Helvetica fonts, so it needs no
+// font files, and it lays text out on US Letter landscape pages.
+type pdfDoc struct {
+	pages []*bytes.Buffer // Content stream for each page
+}
+
+// Page geometry, in points
+const (
+	pdfPageWidth  = 792.0
+	pdfPageHeight = 612.0
+	pdfMargin     = 36.0
+)
+
+// Function newPage starts a new page, and returns it for drawing onto
+func (doc *pdfDoc) newPage() *bytes.Buffer {
+	page := new(bytes.Buffer)
+	doc.pages = append(doc.pages, page)
+	return page
+}
+
+// Function pdfText draws a line of text onto a page at x, y (measured up from the bottom left corner).
+// Bold selects Helvetica-Bold instead of Helvetica.
+func pdfText(page *bytes.Buffer, x, y, size float64, bold bool, text string) {
+	fontName := "/F1"
+	if bold {
+		fontName = "/F2"
+	}
+	fmt.Fprintf(page, "BT %s %.1f Tf %.1f %.1f Td (%s) Tj ET\n", fontName, size, x, y, pdfEscape(text))
+}
+
+// Function pdfLine draws a thin line on a page
+func pdfLine(page *bytes.Buffer, x1, y1, x2, y2 float64) {
+	fmt.Fprintf(page, "0.5 w %.1f %.1f m %.1f %.1f l S\n", x1, y1, x2, y2)
+}
+
+// Function pdfEscape escapes the characters that are special inside a PDF string, and replaces anything outside
+// printable ASCII, which the built-in fonts can't be relied on to have.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r < ' ' || r > '~':
+			b.WriteRune('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Function write writes the document out as a PDF file
+func (doc *pdfDoc) write(w io.Writer) error {
+	// Objects are numbered from 1: the catalog, the page tree, the two fonts, then a page and its contents for
+	// each page
+	var f pdfFile
+	f.object("<< /Type /Catalog /Pages 2 0 R >>")
+
+	var kids []string
+	for i := range doc.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 5+2*i))
+	}
+	f.object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(doc.pages)))
+	f.object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	f.object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, page := range doc.pages {
+		f.object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 6+2*i))
+		f.stream("", page.Bytes())
+	}
+	return f.finish(w, "")
+}
+
+// Function writeImagePDF writes a PDF file of one page showing an image, a point to a pixel, so a map can be
+// printed or mailed as a PDF
+func writeImagePDF(w io.Writer, img image.Image, title string) error {
+	// The image is stored as RGB bytes, compressed with zlib, which PDF readers understand as FlateDecode
+	b := img.Bounds()
+	var pixels bytes.Buffer
+	zw := zlib.NewWriter(&pixels)
+	row := make([]byte, 0, b.Dx()*3)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row = row[:0]
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			row = append(row, byte(r>>8), byte(g>>8), byte(bl>>8))
+		}
+		zw.Write(row)
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	// Objects are the catalog, the page tree, the page, its contents, the image and the document information
+	var f pdfFile
+	f.object("<< /Type /Catalog /Pages 2 0 R >>")
+	f.object("<< /Type /Pages /Kids [3 0 R] /Count 1 >>")
+	f.object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /XObject << /Map 5 0 R >> >> /Contents 4 0 R >>",
+		b.Dx(), b.Dy()))
+	f.stream("", []byte(fmt.Sprintf("q %d 0 0 %d 0 0 cm /Map Do Q\n", b.Dx(), b.Dy())))
+	f.stream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode",
+		b.Dx(), b.Dy()), pixels.Bytes())
+	f.object(fmt.Sprintf("<< /Title (%s) /Producer (reception %s) >>", pdfEscape(title), pdfEscape(version)))
+	return f.finish(w, "/Info 6 0 R")
+}
+
+// The objects of a PDF file as they're written, and where each starts, for the cross-reference table at the end
+type pdfFile struct {
+	out     bytes.Buffer
+	offsets []int
+}
+
+// Function object adds the next object to the file; the first is the catalog
+func (f *pdfFile) object(body string) {
+	if f.out.Len() == 0 {
+		f.out.WriteString("%PDF-1.4\n")
+	}
+	f.offsets = append(f.offsets, f.out.Len())
+	fmt.Fprintf(&f.out, "%d 0 obj\n%s\nendobj\n", len(f.offsets), body)
+}
+
+// Function stream adds the next object to the file as a stream, with any entries for its dictionary besides its
+// length
+func (f *pdfFile) stream(entries string, data []byte) {
+	f.object(fmt.Sprintf("<< /Length %d %s>>\nstream\n%s\nendstream", len(data), entries, data))
+}
+
+// Function finish writes the file out with its cross-reference table and trailer, which can add entries such
+// as the document information
+func (f *pdfFile) finish(w io.Writer, trailer string) error {
+	xref := f.out.Len()
+	fmt.Fprintf(&f.out, "xref\n0 %d\n0000000000 65535 f \n", len(f.offsets)+1)
+	for _, offset := range f.offsets {
+		fmt.Fprintf(&f.out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&f.out, "trailer\n<< /Size %d /Root 1 0 R %s>>\nstartxref\n%d\n%%%%EOF\n", len(f.offsets)+1, trailer, xref)
+
+	_, err := w.Write(f.out.Bytes())
+	return err
+}
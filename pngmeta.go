@@ -0,0 +1,217 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// One text entry to embed in a PNG file. Keywords are short, capitalized and may contain spaces; PNG defines a
+// few (Title, Software, Creation Time...) and we add our own.
+type pngText struct {
+	keyword, text string
+}
+
+// Files the current run's output was made from; their hashes go into every image, so an archived map can be
+// traced back to exactly the data it was made from, and the newest of their modification times is the images'
+// creation time
+var (
+	sourceFiles      []string
+	sourceDigestOnce sync.Once
+	sourceDigest     string
+	sourceTime       time.Time
+)
+
+// Function outputMetadata returns the text to embed in an output image: its title, what made it and when, the
+// net's frequency and the hashes of the source files, followed by anything else about this particular image.
+func outputMetadata(title string, extra ...pngText) []pngText {
+	meta := []pngText{
+		{"Title", title},
+		{"Software", "reception " + version},
+		{"Creation Time", creationTime().Format(time.RFC1123Z)},
+		{"Frequency", cfg.Frequency},
+	}
+	meta = append(meta, extra...)
+	if digest := sourceFileDigest(); digest != "" {
+		meta = append(meta, pngText{"Source Files", digest})
+	}
+	return meta
+}
+
+// Function setSourceFiles sets the source files whose hashes go in output images, and forgets the hashes and
+// times of any set before, as the server needs when it loads changed files
+func setSourceFiles(files ...string) {
+	sourceFiles = files
+	sourceDigestOnce, sourceDigest, sourceTime = sync.Once{}, "", time.Time{}
+}
+
+// Function sourceFileDigest returns the SHA-256 hashes of the source files, one per line in the same format as
+// sha256sum, so the files can be checked with "sha256sum -c". Files that can't be read are left out.
+func sourceFileDigest() string {
+	sourceDigestOnce.Do(func() {
+		var lines []string
+		for _, file := range sourceFiles {
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			if info, err := os.Stat(file); err == nil && info.ModTime().After(sourceTime) {
+				sourceTime = info.ModTime()
+			}
+			lines = append(lines, sha256Hex(content)+"  "+file)
+		}
+		sourceDigest = strings.Join(lines, "\n")
+	})
+	return sourceDigest
+}
+
+// Function runStartTime returns when this run started, for dating its output: now, or the time in the
+// SOURCE_DATE_EPOCH environment variable (seconds since 1970) if it's set, so the same inputs can be drawn
+// into the same maps on any day, as the golden-image test does
+func runStartTime() time.Time {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		seconds, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			fatalf("SOURCE_DATE_EPOCH %q isn't a number of seconds", epoch)
+		}
+		return time.Unix(seconds, 0).UTC()
+	}
+	return time.Now()
+}
+
+// Function creationTime returns the creation time to embed in output images: when the newest of the source files
+// was last changed, rather than when the run started, so drawing the same files again makes the same images, byte
+// for byte. With SOURCE_DATE_EPOCH set, or no source files, it's the start of the run.
+func creationTime() time.Time {
+	sourceFileDigest()
+	if os.Getenv("SOURCE_DATE_EPOCH") != "" || sourceTime.IsZero() {
+		return startTime
+	}
+	return sourceTime.UTC()
+}
+
+// Encoder for the PNGs we write, which reuses its compression buffers from one map to the next instead of
+// allocating them afresh for each
+var pngEncoder = png.Encoder{BufferPool: &pngBuffers{}}
+
+// Compression buffers for pngEncoder, shared by the workers drawing maps
+type pngBuffers struct {
+	pool sync.Pool
+}
+
+// Function Get returns a buffer from the pool, or nil for the encoder to make one
+func (p *pngBuffers) Get() *png.EncoderBuffer {
+	b, _ := p.pool.Get().(*png.EncoderBuffer)
+	return b
+}
+
+// Function Put returns a buffer to the pool
+func (p *pngBuffers) Put(b *png.EncoderBuffer) {
+	p.pool.Put(b)
+}
+
+// Buffers for encoding PNGs to memory before their text chunks are spliced in
+var encodedPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// Function encodePNG writes an image as a PNG file with text chunks embedded in it
+func encodePNG(w io.Writer, img image.Image, meta []pngText) error {
+	return encodePNGAt(w, img, meta, 0)
+}
+
+// Function encodePNGAt writes an image as a PNG file with text chunks embedded in it, and its resolution in dots
+// per inch if dpi isn't 0. The standard library can't write either, so we encode the image to memory and splice
+// the extra chunks in after the header chunk. Text that fits in Latin-1 goes in a tEXt chunk, which every PNG
+// reader understands; anything else goes in an iTXt chunk as UTF-8.
+func encodePNGAt(w io.Writer, img image.Image, meta []pngText, dpi float64) error {
+	encoded := encodedPool.Get().(*bytes.Buffer)
+	encoded.Reset()
+	defer encodedPool.Put(encoded)
+	if err := pngEncoder.Encode(encoded, img); err != nil {
+		return err
+	}
+
+	// The file starts with an 8 byte signature and then the IHDR chunk: 4 bytes of length, 4 of type, 13 of
+	// data and 4 of CRC
+	const headerEnd = 8 + 4 + 4 + 13 + 4
+	data := encoded.Bytes()
+	if _, err := w.Write(data[:headerEnd]); err != nil {
+		return err
+	}
+
+	// PNG records resolution in pixels per meter, the same horizontally and vertically, with unit 1 = meters
+	if dpi != 0 {
+		chunk := []byte("pHYs\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+		ppm := uint32(dpi/0.0254 + 0.5)
+		binary.BigEndian.PutUint32(chunk[4:], ppm)
+		binary.BigEndian.PutUint32(chunk[8:], ppm)
+		if err := writePNGChunk(w, chunk); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range meta {
+		var chunk []byte
+		latin1, ok := toLatin1(t.text)
+		if ok {
+			chunk = append(append(append([]byte("tEXt"), t.keyword...), 0), latin1...)
+		} else {
+			// Keyword, then no compression, no language tag and no translated keyword
+			chunk = append(append(append([]byte("iTXt"), t.keyword...), 0, 0, 0, 0, 0), t.text...)
+		}
+		if err := writePNGChunk(w, chunk); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(data[headerEnd:])
+	return err
+}
+
+// Function writePNGChunk writes a PNG chunk, given its type followed by its data, adding the length and CRC
+func writePNGChunk(w io.Writer, chunk []byte) error {
+	var length, crc [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(chunk)-4))
+	binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(chunk))
+	for _, b := range [][]byte{length[:], chunk, crc[:]} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function toLatin1 converts text to Latin-1 for a tEXt chunk, reporting whether it could. Latin-1 is the first
+// 256 code points of Unicode, so each rune below 256 becomes a single byte.
+func toLatin1(text string) ([]byte, bool) {
+	latin1 := make([]byte, 0, len(text))
+	for _, r := range text {
+		if r > 0xff {
+			return nil, false
+		}
+		latin1 = append(latin1, byte(r))
+	}
+	return latin1, true
+}
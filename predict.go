@@ -0,0 +1,122 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+)
+
+// Number of points along each path the terrain is sampled at when predicting coverage
+const profileSamples = 64
+
+// Effective earth radius factor: the atmosphere bends VHF and UHF signals a little way over the horizon, as if the
+// earth were a third bigger and flatter
+const kFactor = 4.0 / 3
+
+// Function newPredictedSurface predicts how well a station would be heard across a map, from its equipment and
+// the terrain between it and each point, for a receiver with the usual handheld. The loss is the link budget's
+// flat ground loss plus the diffraction loss over the worst obstruction on the way (a single knife edge), and the
+// result is graded into reports by ReportSignalLevels, so it can be shaded like the heatmap and compared with the
+// reports actually received. On receive maps, the stations heard are taken to have the same power as the station
+// mapped. It returns nil if the station's power or the frequency isn't known.
+func newPredictedSurface(station operatorData, baseMap image.Image, icons map[string]image.Image) *qualitySurface {
+	mhz, ok := frequencyMHz()
+	if !ok || station.xmitPwr <= 0 || len(cfg.ReportSignalLevels) == 0 {
+		return nil
+	}
+	bounds := baseMap.Bounds()
+	s := &qualitySurface{bounds: bounds, reports: make(map[float64]string), levelColors: make(map[float64]color.RGBA)}
+	for level := 1; level <= len(cfg.ReportSignalLevels)+1; level++ {
+		value := float64(level)
+		s.levels = append(s.levels, value)
+		s.reports[value] = strconv.Itoa(level)
+		if icon := icons[s.reports[value]]; icon != nil {
+			if c := iconColor(icon); c != nil {
+				s.levelColors[value] = color.RGBAModel.Convert(c).(color.RGBA)
+			}
+		}
+	}
+
+	ref := newGeoref(baseMap)
+	s.cols, s.rows = bounds.Dx()/heatmapStep+1, bounds.Dy()/heatmapStep+1
+	points := make([]gpsCoord, s.cols*s.rows)
+	for gy := 0; gy < s.rows; gy++ {
+		for gx := 0; gx < s.cols; gx++ {
+			gps, err := modelToGPS(ref.originX+float64(gx*heatmapStep)*ref.pixelWidth,
+				ref.originY+float64(gy*heatmapStep)*ref.pixelHeight, ref.epsg)
+			if err != nil {
+				fatalln("can't place the coverage prediction on", cfg.MapFile, err)
+			}
+			points[gy*s.cols+gx] = gps
+		}
+	}
+	land := loadTerrain(append([]gpsCoord{station.gps}, points...))
+
+	receiver := operatorData{antGain: -100, antHeight: -100}
+	txHeight, rxHeight := antennaMeters(station), antennaMeters(receiver)
+	txTop := land.elevation(station.gps) + txHeight
+	power := 10*math.Log10(station.xmitPwr*1000) + knownValue(station.antGain, defaultAntGain) + knownValue(receiver.antGain, defaultAntGain)
+	s.values, s.strength = make([]float64, len(points)), make([]float64, len(points))
+	for i, p := range points {
+		s.values[i], s.strength[i] = 1, 1
+		meters := distanceMeters(station.gps, p)
+		if meters < 1 {
+			continue
+		}
+		rxTop := land.elevation(p) + rxHeight
+		loss := pathLoss(mhz, meters, txHeight, rxHeight) + diffractionLoss(land, station.gps, p, meters, txTop, rxTop, mhz)
+		s.values[i] = signalLevel(power - loss)
+	}
+	return s
+}
+
+// Function diffractionLoss returns the loss in dB over the worst obstruction on the path between two antennas
+// meters apart, whose tops are txTop and rxTop meters above sea level, treating it as a knife edge. Paths whose
+// first Fresnel zone is mostly clear lose nothing.
+func diffractionLoss(land terrain, from, to gpsCoord, meters, txTop, rxTop, mhz float64) float64 {
+	wavelength := 299.792458 / mhz
+	worst := math.Inf(-1)
+	for i := 1; i < profileSamples; i++ {
+		f := float64(i) / profileSamples
+		excess := profileGround(land, from, to, meters, f) - (txTop*(1-f) + rxTop*f)
+		worst = math.Max(worst, math.Sqrt2*excess/fresnelRadius(wavelength, meters*f, meters*(1-f)))
+	}
+	return knifeEdgeLoss(worst)
+}
+
+// Function profileGround returns the height of the ground f of the way along a path meters long, raised by the
+// curve of the earth, so it can be compared with the straight line between the antennas at either end
+func profileGround(land terrain, from, to gpsCoord, meters, f float64) float64 {
+	p := gpsCoord{from.lat + (to.lat-from.lat)*f, from.long + (to.long-from.long)*f}
+	return land.elevation(p) + meters*f*meters*(1-f)/(2*kFactor*earthRadius)
+}
+
+// Function fresnelRadius returns the radius in meters of the first Fresnel zone, the part of the space around the
+// line of sight that carries most of the signal, d1 and d2 meters from either end of a path
+func fresnelRadius(wavelength, d1, d2 float64) float64 {
+	return math.Sqrt(wavelength * d1 * d2 / (d1 + d2))
+}
+
+// Function knifeEdgeLoss returns the loss in dB over a knife edge, from how far it sticks up into the path
+// (the Fresnel-Kirchhoff parameter v, which is negative for edges below the line of sight)
+func knifeEdgeLoss(v float64) float64 {
+	if v <= -0.78 {
+		return 0
+	}
+	return 6.9 + 20*math.Log10(math.Sqrt((v-0.1)*(v-0.1)+1)+v-0.1)
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+// An extra size to draw every map at, from a [[Profiles]] table in reception.cfg; e.g. a small version for the
+// web and a large one for printing
+type outputProfile struct {
+	Name  string  // Added to the names of the profile's map files; "print" gives K7ABC-xmit-map-print.png
+	Width uint    // Width of the profile's maps in pixels; icons and text are scaled to match
+	DPI   float64 // Resolution recorded in the profile's maps, so they print at the right size; 0 = none
+}
+
+// A profile ready to draw maps with: its own copies of the settings, base map and icons scaled to its size,
+// and layers to draw on
+type profileRenderer struct {
+	outputProfile
+	settings       config // cfg, with the icon and font sizes scaled to the profile
+	scaleX, scaleY float64
+	baseMap        image.Image
+	icons          map[string]image.Image
+	noReportIcon   image.Image
+	layers         *mapLayers
+}
+
+// Function newProfileRenderers readies each profile in reception.cfg for drawing maps. Icons are loaded afresh
+// at each profile's size, rather than scaled from the ones already loaded, so they stay sharp in large maps.
+func newProfileRenderers(baseMap image.Image) []*profileRenderer {
+	var renderers []*profileRenderer
+	for _, p := range cfg.Profiles {
+		if p.Name == "" || p.Width == 0 {
+			fatalln("output profiles in reception.cfg need a Name and a Width")
+		}
+
+		r := &profileRenderer{outputProfile: p, baseMap: resize.Resize(p.Width, 0, baseMap, resize.Lanczos3)}
+		r.scaleX = float64(r.baseMap.Bounds().Dx()) / float64(baseMap.Bounds().Dx())
+		r.scaleY = float64(r.baseMap.Bounds().Dy()) / float64(baseMap.Bounds().Dy())
+
+		r.settings = scaledSettings(cfg, r.scaleX)
+		r.withSettings(func() {
+			r.icons = loadIcons(cfg.IconDirectory)
+			if cfg.GrayscaleFlag {
+				r.icons = shapeIcons(r.icons)
+			}
+			r.noReportIcon = noReportIcon(r.icons)
+			r.layers = newMapLayers(r.baseMap)
+		})
+		renderers = append(renderers, r)
+	}
+	return renderers
+}
+
+// Function scaledSettings returns a copy of the settings with everything measured in pixels or points scaled by a
+// factor: icons, text, and the line widths, margins and legend offsets worked out from their sizes
+func scaledSettings(c config, factor float64) config {
+	c.IconSize = uint(float64(c.IconSize)*factor + 0.5)
+	c.FontSize *= factor
+	c.TitleFontSize *= factor
+	c.LegendFontSize *= factor
+	c.LabelFontSize *= factor
+	c.TextHalo *= factor
+	c.ClusterRadius = int(float64(c.ClusterRadius)*factor + 0.5)
+	c.CropMargin = int(float64(c.CropMargin)*factor + 0.5)
+	return c
+}
+
+// Function withSettings runs a function with cfg set to the profile's settings, so everything that reads the
+// icon and font sizes from cfg draws at the profile's size
+func (r *profileRenderer) withSettings(f func()) {
+	saved := cfg
+	cfg = r.settings
+	defer func() { cfg = saved }()
+	f()
+}
+
+// Function writeMap draws a transmitter's map at the profile's size and saves it next to the full-size map,
+// along with its world files and GeoTIFF if those are wanted. Area is the part of the full-size base map the map
+// shows, which is all of it unless maps are cropped. It returns the names of the files written, relative to the
+// output directory.
+func (r *profileRenderer) writeMap(transmitter string, markers []marker, transmitterMarker marker, area image.Rectangle, mapFile, title string, meta []pngText) []string {
+	scaled := func(m marker) marker {
+		m.operator.pixel = image.Point{int(float64(m.operator.pixel.X)*r.scaleX + 0.5), int(float64(m.operator.pixel.Y)*r.scaleY + 0.5)}
+		m.icon, m.ownIcon = r.icons[m.report], r.icons[m.operator.icon]
+		if m.absent {
+			m.icon = r.noReportIcon
+		}
+		return m
+	}
+	scaledMarkers := make([]marker, len(markers))
+	for i, m := range markers {
+		scaledMarkers[i] = scaled(m)
+	}
+	scaledTransmitter := scaled(transmitterMarker)
+
+	baseMap, layers := r.baseMap, r.layers
+	ref := newGeoref(r.baseMap)
+	area = image.Rect(int(float64(area.Min.X)*r.scaleX), int(float64(area.Min.Y)*r.scaleY),
+		int(math.Ceil(float64(area.Max.X)*r.scaleX)), int(math.Ceil(float64(area.Max.Y)*r.scaleY))).Intersect(r.baseMap.Bounds())
+	if area != r.baseMap.Bounds() {
+		baseMap, scaledMarkers, scaledTransmitter = cropMap(r.baseMap, area, scaledMarkers, scaledTransmitter)
+		r.withSettings(func() { layers = newMapLayers(baseMap) })
+		defer layers.release()
+		ref = ref.crop(area.Min)
+	}
+	r.withSettings(func() {
+		drawMap(layers, baseMap, ref.metersPerPixel(), r.icons, transmitter, scaledMarkers, scaledTransmitter)
+	})
+
+	outputMapPtr := layers.outputMapPtr
+	file := strings.TrimSuffix(mapFile, ".png") + "-" + fileNameSafe(r.Name) + ".png"
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, file), func(w io.Writer) error {
+		return encodePNGAt(w, outputMapPtr, meta, r.DPI)
+	})
+	if err != nil {
+		fatalf("Failed to write %s profile map: %s", r.Name, err)
+	}
+
+	files := []string{file}
+	if cfg.WorldFileFlag {
+		files = append(files, writeWorldFiles(file, ref)...)
+	}
+	if cfg.GeoTIFFFlag {
+		files = append(files, writeGeoTIFF(outputMapPtr, file, ref, title))
+	}
+	return files
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/schollz/progressbar"
+)
+
+// Ways of showing how map generation is getting on, for the Progress setting
+const (
+	progressBar   = "bar"   // A bar redrawn in place, for a terminal
+	progressLines = "lines" // A line per map, for logs and for programs that run this one and show their own
+	progressNone  = "none"  // Nothing
+)
+
+// Something told as each map is finished, to show how far along map generation is. Its methods are called by one
+// worker at a time.
+type progressReporter interface {
+	mapDone(transmitter string) // A transmitter's map and everything that goes with it has been written
+	finish()                    // All the maps are done
+}
+
+// Function newProgress returns the reporter for cfg.Progress, for a run that will make total maps. With no
+// setting, there's a bar on a terminal and nothing otherwise, since the bar's control characters make a mess of
+// the log of a run from cron.
+func newProgress(total int) progressReporter {
+	mode := cfg.Progress
+	if mode == "" {
+		mode = progressNone
+		if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			mode = progressBar
+		}
+	}
+	switch mode {
+	case progressBar:
+		return &barProgress{progressbar.New(total)}
+	case progressLines:
+		return &lineProgress{total: total}
+	case progressNone:
+		return noProgress{}
+	}
+	fatalStatus = exitConfigError // It's the setting that's wrong, not the data
+	fatalf("unknown Progress %q in reception.cfg; use %q, %q or %q", cfg.Progress, progressBar, progressLines, progressNone)
+	return nil
+}
+
+// Progress shown as a bar
+type barProgress struct {
+	bar *progressbar.ProgressBar
+}
+
+func (p *barProgress) mapDone(transmitter string) { p.bar.Add(1) }
+func (p *barProgress) finish()                    { fmt.Println() } // The bar doesn't end its line
+
+// Progress shown as a line for each map, e.g. "Map 3 of 12 done: K6XYZ", which a program running this one can read
+// to show progress its own way
+type lineProgress struct {
+	done, total int
+}
+
+func (p *lineProgress) mapDone(transmitter string) {
+	p.done++
+	fmt.Printf("Map %d of %d done: %s\n", p.done, p.total, transmitter)
+}
+func (p *lineProgress) finish() {}
+
+// Progress not shown at all
+type noProgress struct{}
+
+func (noProgress) mapDone(string) {}
+func (noProgress) finish()        {}
@@ -0,0 +1,222 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/im7mortal/UTM"
+)
+
+// Projections a base map can be drawn in
+const (
+	projectionUTM      = "utm"      // UTM; maps made from survey and GIS data usually are. "utm10n", "utm55s" etc. name the zone.
+	projectionMercator = "mercator" // Web Mercator, which Google Maps, OpenStreetMap and other web maps use
+	projectionLatLong  = "latlong"  // Latitude and longitude scaled directly, as in an EPSG:4326 GeoTIFF
+)
+
+// A map projection: flattens GPS coordinates onto a plane in which the base map is a linear scaling, with x
+// increasing eastward and y northward
+type projection interface {
+	project(gps gpsCoord) (x, y float64)
+	epsg() int                          // EPSG code of the projected coordinate system, for world files and GeoTIFFs
+	metersPerUnit(gps gpsCoord) float64 // Meters on the ground per unit of x near a location, for scale bars
+}
+
+// UTM, in the zone of a reference point
+type utmProjection struct {
+	zone  int
+	south bool
+}
+
+// Web Mercator, on a sphere the size of the WGS 84 equator
+type mercatorProjection struct{}
+
+// Latitude and longitude, unchanged
+type latLongProjection struct{}
+
+// Radius of the sphere Web Mercator projects from, in meters
+const mercatorRadius = 6378137.0
+
+// The WGS 84 ellipsoid's equatorial radius and flattening, and UTM's scale factor at the central meridian
+const (
+	wgs84Radius     = 6378137.0
+	wgs84Flattening = 1 / 298.257223563
+	utmScale        = 0.9996
+)
+
+// Zones we've already warned are too far from the map's UTM zone, so each is only warned about once. Maps drawn
+// at the same time can both find the same zone, so it's locked.
+var (
+	warnedZones     = make(map[int]bool)
+	warnedZonesLock sync.Mutex
+)
+
+// Function mapProjection returns the projection for the current base map, given its northwest corner. UTM is in
+// the zone MapProjection names, or else the zone of the northwest corner.
+func mapProjection(nw gpsCoord) projection {
+	switch cfg.MapProjection {
+	case "", projectionUTM:
+		_, _, zone, _, err := UTM.FromLatLon(nw.lat, nw.long, false)
+		if err != nil {
+			fatalln("MapNWCorner can't be converted to UTM", err)
+		}
+		return utmProjection{zone: zone, south: nw.lat < 0}
+	case projectionMercator:
+		return mercatorProjection{}
+	case projectionLatLong:
+		return latLongProjection{}
+	default:
+		if p, ok := utmZoneProjection(cfg.MapProjection); ok {
+			return p
+		}
+		fatalf("unknown MapProjection %q in reception.cfg; use %q (or e.g. \"utm10n\" for a zone), %q or %q",
+			cfg.MapProjection, projectionUTM, projectionMercator, projectionLatLong)
+		return nil
+	}
+}
+
+// Function utmZoneProjection returns the UTM projection a name such as "utm10n" or "utm55s" gives the zone and
+// hemisphere of, and whether the name is one
+func utmZoneProjection(name string) (utmProjection, bool) {
+	var zone int
+	var hemisphere string
+	if n, _ := fmt.Sscanf(strings.ToLower(name), projectionUTM+"%d%s", &zone, &hemisphere); n != 2 || zone < 1 || zone > 60 {
+		return utmProjection{}, false
+	}
+	switch hemisphere {
+	case "n":
+		return utmProjection{zone: zone}, true
+	case "s":
+		return utmProjection{zone: zone, south: true}, true
+	default:
+		return utmProjection{}, false
+	}
+}
+
+// Function projectionForEPSG returns the name of the projection a coordinate system with the given EPSG code uses.
+// WGS 84 UTM codes keep their zone, since a map can reach past the edge of the zone it's drawn in.
+func projectionForEPSG(epsg int) string {
+	switch {
+	case epsg == 3857:
+		return projectionMercator
+	case epsg == 4326:
+		return projectionLatLong
+	case epsg > 32600 && epsg <= 32660:
+		return fmt.Sprintf("%s%dn", projectionUTM, epsg-32600)
+	case epsg > 32700 && epsg <= 32760:
+		return fmt.Sprintf("%s%ds", projectionUTM, epsg-32700)
+	default:
+		return projectionUTM
+	}
+}
+
+// Function project converts GPS coordinates to UTM, always in the projection's zone. Converting each location
+// in its own zone, as UTM normally does, would put locations on either side of a zone boundary (e.g. 120°W) in
+// different coordinate systems, skewing everything on the far side. The projection stays accurate for a zone or
+// so either side; we warn about locations farther away than that.
+func (p utmProjection) project(gps gpsCoord) (x, y float64) {
+	if err := UTM.ValidateLatLone(gps.lat, gps.long); err != nil {
+		fatalln("can't convert GPS coordinate to UTM", err)
+	}
+	warnedZonesLock.Lock()
+	if zone := utmZone(gps.long); zoneDistance(zone, p.zone) > 1 && !warnedZones[zone] {
+		warnf("some stations are in UTM zone %d, too far from the map's zone %d to be placed accurately; "+
+			"MapProjection = %q would place them better", zone, p.zone, projectionMercator)
+		warnedZones[zone] = true
+	}
+	warnedZonesLock.Unlock()
+	return transverseMercator(gps, 6*float64(p.zone)-183, p.south)
+}
+
+// Function utmZone returns the number of the UTM zone a longitude is in. Norway and Svalbard have some odd zones
+// that this doesn't account for, but no map can be far enough off by ignoring them to matter.
+func utmZone(long float64) int {
+	return int(math.Floor((long+180)/6))%60 + 1
+}
+
+// Function zoneDistance returns how many zones apart two UTM zones are, going the shorter way round the world
+func zoneDistance(a, b int) int {
+	d := a - b
+	if d < 0 {
+		d = -d
+	}
+	if d > 30 {
+		d = 60 - d
+	}
+	return d
+}
+
+// Function transverseMercator returns the UTM easting and northing of a location, in the zone with the given
+// central meridian, using the series from Snyder's "Map Projections: A Working Manual"
+func transverseMercator(gps gpsCoord, centralMeridian float64, south bool) (easting, northing float64) {
+	e2 := wgs84Flattening * (2 - wgs84Flattening)
+	e4, e6 := e2*e2, e2*e2*e2
+	ep2 := e2 / (1 - e2)
+
+	lat := gps.lat * math.Pi / 180
+	sinLat, cosLat, tanLat := math.Sin(lat), math.Cos(lat), math.Tan(lat)
+	n := wgs84Radius / math.Sqrt(1-e2*sinLat*sinLat)
+	t := tanLat * tanLat
+	c := ep2 * cosLat * cosLat
+	long := math.Remainder(gps.long-centralMeridian, 360)
+	a := cosLat * long * math.Pi / 180
+
+	m := wgs84Radius * ((1-e2/4-3*e4/64-5*e6/256)*lat -
+		(3*e2/8+3*e4/32+45*e6/1024)*math.Sin(2*lat) +
+		(15*e4/256+45*e6/1024)*math.Sin(4*lat) -
+		(35*e6/3072)*math.Sin(6*lat))
+
+	easting = utmScale*n*(a+(1-t+c)*math.Pow(a, 3)/6+(5-18*t+t*t+72*c-58*ep2)*math.Pow(a, 5)/120) + 500000
+	northing = utmScale * (m + n*tanLat*(a*a/2+(5-t+9*c+4*c*c)*math.Pow(a, 4)/24+
+		(61-58*t+t*t+600*c-330*ep2)*math.Pow(a, 6)/720))
+	if south {
+		northing += 10000000
+	}
+	return easting, northing
+}
+
+func (p utmProjection) epsg() int {
+	if p.south {
+		return 32700 + p.zone // WGS 84 / UTM south
+	}
+	return 32600 + p.zone // WGS 84 / UTM north
+}
+
+// UTM coordinates are already meters, distorted by no more than a few parts in ten thousand within the zone
+func (utmProjection) metersPerUnit(gps gpsCoord) float64 { return 1 }
+
+func (mercatorProjection) project(gps gpsCoord) (x, y float64) {
+	lat := gps.lat * math.Pi / 180
+	return mercatorRadius * gps.long * math.Pi / 180, mercatorRadius * math.Log(math.Tan(math.Pi/4+lat/2))
+}
+
+func (mercatorProjection) epsg() int { return 3857 }
+
+// Web Mercator stretches east-west distances by the same factor as north-south ones, 1/cos(latitude)
+func (mercatorProjection) metersPerUnit(gps gpsCoord) float64 {
+	return math.Cos(gps.lat * math.Pi / 180)
+}
+
+func (latLongProjection) project(gps gpsCoord) (x, y float64) { return gps.long, gps.lat }
+
+func (latLongProjection) epsg() int { return 4326 }
+
+func (latLongProjection) metersPerUnit(gps gpsCoord) float64 {
+	return wgs84Radius * math.Pi / 180 * math.Cos(gps.lat*math.Pi/180)
+}
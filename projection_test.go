@@ -0,0 +1,51 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestProjectionForEPSG(t *testing.T) {
+	for epsg, want := range map[int]string{3857: projectionMercator, 4326: projectionLatLong, 32611: "utm11n", 32755: "utm55s", 2227: projectionUTM} {
+		if got := projectionForEPSG(epsg); got != want {
+			t.Errorf("projectionForEPSG(%d) = %q, want %q", epsg, got, want)
+		}
+	}
+}
+
+// A GeoTIFF in UTM zone 11 whose west edge reaches into zone 10 has to be projected in zone 11, the zone its
+// pixels are linear in, not the zone of its northwest corner
+func TestMapProjectionKeepsZone(t *testing.T) {
+	defer func(saved config) { cfg = saved }(cfg)
+	cfg = config{MapProjection: projectionForEPSG(32611)}
+
+	nw := gpsCoord{36.5, -120.2} // Zone 10, just west of the 120°W boundary
+	p := mapProjection(nw)
+	if p.epsg() != 32611 {
+		t.Fatalf("projection is EPSG:%d, want EPSG:32611", p.epsg())
+	}
+	// 117°W is zone 11's central meridian, where its eastings are 500 km
+	if x, _ := p.project(gpsCoord{36.5, -117}); math.Abs(x-500000) > 0.01 {
+		t.Errorf("easting on zone 11's central meridian = %.2f, want 500000", x)
+	}
+
+	for _, name := range []string{"utm", "utm61n", "utm10x", "utm10"} {
+		if _, ok := utmZoneProjection(name); ok {
+			t.Errorf("utmZoneProjection(%q) is a zone", name)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"golang.org/x/image/vector"
+)
+
+// A point on a map or other image, in pixels. Pixel x, y covers the square from x, y to x+1, y+1.
+type pixelPoint struct{ x, y float64 }
+
+// Function fillPolygons fills polygons with a color. The rasterizer works out exactly how much of each pixel
+// along an edge the polygons cover, so edges are smooth at any size. Parts of the polygons that overlap are only
+// filled once, as long as the polygons go round the same way (all clockwise, or all counterclockwise).
+func fillPolygons(dstPtr *image.RGBA, polygons [][]pixelPoint, c color.Color) {
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, polygon := range polygons {
+		for _, p := range polygon {
+			minX, maxX = math.Min(minX, p.x), math.Max(maxX, p.x)
+			minY, maxY = math.Min(minY, p.y), math.Max(maxY, p.y)
+		}
+	}
+	area := image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX)), int(math.Ceil(maxY)))
+	area = area.Intersect(dstPtr.Bounds())
+	if area.Empty() {
+		return
+	}
+
+	// The rasterizer only needs to cover the polygons, which for a short line is a small part of the image
+	z := vector.NewRasterizer(area.Dx(), area.Dy())
+	z.DrawOp = draw.Over
+	for _, polygon := range polygons {
+		if len(polygon) < 3 {
+			continue
+		}
+		z.MoveTo(float32(polygon[0].x-float64(area.Min.X)), float32(polygon[0].y-float64(area.Min.Y)))
+		for _, p := range polygon[1:] {
+			z.LineTo(float32(p.x-float64(area.Min.X)), float32(p.y-float64(area.Min.Y)))
+		}
+		z.ClosePath()
+	}
+	z.Draw(dstPtr, area, &image.Uniform{c}, image.Point{})
+}
+
+// Function circle returns a polygon close enough to a circle that the difference can't be seen
+func circle(center pixelPoint, radius float64) []pixelPoint {
+	n := int(math.Max(16, math.Ceil(radius*2)))
+	points := make([]pixelPoint, n)
+	for i := range points {
+		a := 2 * math.Pi * float64(i) / float64(n)
+		points[i] = pixelPoint{center.x + radius*math.Cos(a), center.y + radius*math.Sin(a)}
+	}
+	return points
+}
+
+// Function segment returns the rectangle covered by a line of the given width between two points, going round
+// the same way as circle's polygons so the two can be filled together
+func segment(from, to pixelPoint, width float64) []pixelPoint {
+	length := math.Hypot(to.x-from.x, to.y-from.y)
+	if length == 0 {
+		return nil
+	}
+	nx, ny := -(to.y-from.y)/length*width/2, (to.x-from.x)/length*width/2
+	return []pixelPoint{{from.x - nx, from.y - ny}, {to.x - nx, to.y - ny}, {to.x + nx, to.y + ny}, {from.x + nx, from.y + ny}}
+}
+
+// Function strokeLine draws a line of the given width between two points, with rounded ends, solid or dashed
+func strokeLine(dstPtr *image.RGBA, from, to pixelPoint, width float64, c color.Color, dashed bool) {
+	const dash, gap = 12.0, 8.0 // Lengths in pixels
+
+	length := math.Hypot(to.x-from.x, to.y-from.y)
+	polygons := [][]pixelPoint{circle(from, width/2)}
+	if !dashed || math.Mod(length, dash+gap) < dash {
+		polygons = append(polygons, circle(to, width/2)) // Unless the line ends in a gap
+	}
+	if !dashed {
+		polygons = append(polygons, segment(from, to, width))
+	}
+	for start := 0.0; dashed && start < length; start += dash + gap {
+		end := math.Min(length, start+dash)
+		at := func(d float64) pixelPoint {
+			return pixelPoint{from.x + (to.x-from.x)*d/length, from.y + (to.y-from.y)*d/length}
+		}
+		polygons = append(polygons, segment(at(start), at(end), width))
+	}
+	fillPolygons(dstPtr, polygons, c)
+}
+
+// Function strokeRing draws the outline of a closed ring of points, with rounded corners. The whole outline
+// is drawn at once, so a translucent color doesn't come out darker where the sides meet.
+func strokeRing(dstPtr *image.RGBA, ring []pixelPoint, width float64, c color.Color) {
+	var polygons [][]pixelPoint
+	for i, p := range ring {
+		polygons = append(polygons, circle(p, width/2), segment(p, ring[(i+1)%len(ring)], width))
+	}
+	fillPolygons(dstPtr, polygons, c)
+}
+
+// Function toPixelPoint returns the point at the corner of a pixel
+func toPixelPoint(p image.Point) pixelPoint {
+	return pixelPoint{float64(p.X), float64(p.Y)}
+}
@@ -21,22 +21,22 @@ import (
 	"flag"
 	"fmt"
 	"image"
-	"image/color"
 	"image/draw"
-	"image/png"
 	"io"
-	"io/ioutil"
-	"log"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
-	"github.com/BurntSushi/toml"
 	"github.com/golang/freetype"
-	"github.com/im7mortal/UTM"
-	"github.com/nfnt/resize"
-	"github.com/schollz/progressbar"
+	"github.com/golang/freetype/truetype"
 	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
 )
 
 // Latitude-Longitude coordinates
@@ -53,174 +53,1013 @@ type operatorData struct {
 	antType   string      // Operator's antenna type
 	antGain   float64     // Estimated gain of operator's antenna, in dBi
 	antHeight float64     // Height of operator's antenna, in feet
+	email     string      // Operator's email address, or "" if we don't have it
+	icon      string      // Name of an icon to always show for the operator, e.g. a star for net control; "" = by report
+	beam      float64     // Heading of a directional antenna in degrees clockwise from true north, or noBeam
 }
 
 // Configuration parameters, loaded from reception.cfg file
 type config struct {
-	OperatorFile    string // Name of file containing data on all operators
-	ReportFile      string // Name of file containing reception reports
-	OutputDirectory string // Directory we'll write reception maps into
-	CallSigns       string // Comma-separate call signs to create a map of, or "all" for all in report file
-	Frequency       string // Frequency the radio reception was tested at
-	RcvMapFlag      bool   // False = create transmit maps; true = create receive maps
-
-	IconDirectory string // Directory containing icon image files
+	OperatorFile       string // Name of file containing data on all operators
+	ReportFile         string // Name of file containing reception reports
+	OutputDirectory    string // Directory we'll write reception maps into
+	OutputNameTemplate string // Name of each map file, with {call}, {type}, {freq} and {date} placeholders; ".png" is added
+	Formats            string // Comma-separated formats each map is written in: png, svg, pdf, kml and html; "" = png
+	Jobs               int    // Number of maps drawn at the same time; 0 = one per CPU core
+	Progress           string // How map generation shows progress: "bar", "lines" (one per map) or "none"; "" = bar on a terminal
+	CallSigns          string // Comma-separate call signs to create a map of, or "all" for all in report file
+	Frequency          string // Frequency the radio reception was tested at
+	NetName            string // Name of the net, shown with its date as a title across the top of each map; "" = no title
+	NetDate            string // Date of the net, as YYYY-MM-DD, for titles, file names, mail, stats and history; "" = today
+	RcvMapFlag         bool   // False = create transmit maps; true = create receive maps
+	SuffixMode         string // How suffixed receivers (K7ABC-7) are mapped: "separate", "ignore" or "merge"
+	MatrixFlag         bool   // True = also create a who-hears-whom matrix image
+	MatrixCSVFlag      bool   // True = also write the who-hears-whom matrix as a CSV file named for the date of the net
+	DotFlag            bool   // True = also write the who-hears-whom graph as reception.dot, for Graphviz
+	CapabilityFlag     bool   // True = also create a station capability matrix (CSV and PDF) from the operator file
+	ZipFlag            bool   // True = also pack the maps and index page into a timestamped zip file
+	GrayscaleFlag      bool   // True = print-friendly maps: lightened grayscale base map, and shapes instead of colored icons
+	WorldFileFlag      bool   // True = also write a world file (.pgw) with each map, so GIS programs can place it
+	GeoTIFFFlag        bool   // True = also write each map as a GeoTIFF, with its coordinate system embedded
+	StatsFlag          bool   // True = also record each transmitter's statistics in stats.csv, and each path in paths.csv
+	SummaryFlag        bool   // True = also write a summary of the net, as summary.txt and summary.html
+	RelayFlag          bool   // True = also write relays.csv, the best relay to each station that didn't hear each transmitter
+	GapsFlag           bool   // True = also list the pairs of stations that didn't hear each other either way in gaps.csv
+	AsymmetryFlag      bool   // True = also list pairs of stations that don't hear each other equally well in asymmetry.csv
+	AsymmetryThreshold int    // Difference in report scores at which a pair that hear each other counts as asymmetric; 0 = only one-way
+	AsymmetryMapFlag   bool   // True = also draw those pairs on a map, asymmetry-map.png
+	ResultsFlag        bool   // True = also describe the maps generated, and who's on them, in results.json
+	CropFlag           bool   // True = trim each map to the area around its stations, instead of the whole base map
+	CropMargin         int    // Pixels of map kept around the outermost stations of a cropped map
+	ScaleBarFlag       bool   // True = draw scale bars in kilometers and miles on each map
+	NorthArrowFlag     bool   // True = draw a north arrow in the upper right corner of each map
+	ReportLinesFlag    bool   // True = draw a line from the transmitter to each receiver, in the color of its report
+	WatermarkFlag      bool   // True = stamp each map with when it was generated, and by which version of the program
+	DistanceLabelFlag  bool   // True = add each receiver's distance from the transmitter to its label
+	DistanceUnits      string // Units distances are given in: "km" or "mi"
+	BearingLabelFlag   bool   // True = add the compass direction of each receiver from the transmitter to its label
+
+	ReportSignalLevels []float64          // Signal in dBm at or above which reports 1, 2 and so on are expected, for paths.csv
+	ReportScores       map[string]float64 // Score for each report, higher = better, for averages, heatmaps and scoring
+
+	Scale float64 // Scales icons, text, line widths and margins together, for print-resolution base maps; 0 = 1
+
+	IconDirectory string // Directory containing icon image files; "" = the default icons built into the program
 	IconSize      uint   // icons will be resized to this dimension before plotting
 	TransIcon     string // Icon to use for transmitter
+	NoReportIcon  string // Icon, shown faded, for operators who gave no report; "hollow" = a circle; "" = leave them off
+
+	Icons []iconEntry // Icon file, legend key order and meaning of each report; none = icon files named by report
+
+	VectorIconFlag bool         // True = draw built-in icons instead of loading the ones in IconDirectory
+	VectorIcons    []vectorIcon // Shape and color of the built-in icon for each report; none = the palette's
+	Palette        string       // "default" or "colorblind", which also implies VectorIconFlag
+
+	IconTransparency   float64            // How see-through icons are, from 0 (solid) to 1 (invisible, leaving the call sign)
+	IconTransparencies map[string]float64 // Transparency of the icons for particular reports, overriding IconTransparency
+	IconShadowFlag     bool               // True = draw a soft shadow under each icon, to lift it off busy base maps
 
 	MapFile     string    // File containing image of base map
 	MapNWCorner []float64 // GPS lat-long coordinates of upper left corner of base map
 	MapSECorner []float64 // GPS lat-long coordinates of lower right corner of base map
 
+	MapProjection     string // "utm" (or e.g. "utm11n"), "mercator" or "latlong"; downloaded and GeoTIFF maps set their own
+	MapSource         string // "file" = use MapFile; "static" = download from a static map service; "tiles" = stitch tiles
+	StaticMapURL      string // URL of the static map service, with {lat}, {long}, {zoom}, {width} and {height} filled in
+	StaticMapSize     int    // Largest width or height of a downloaded base map, in pixels
+	TileURL           string // URL of the tile server, with {z}, {x} and {y} filled in
+	TileZoom          int    // Zoom level of the tiles; 0 = the highest at which the map fits in StaticMapSize
+	MapCacheDirectory string // Directory downloaded base maps and tiles, and resized icons, are kept in
+	MapAttribution    string // Credit for the base map, shown on every map; downloaded maps default to OpenStreetMap's
+	MapCredit         string // Credit line shown on every map before the base map's, e.g. the club's name
+
+	HillshadeFlag    bool    // True = blend hill shading into the base map, to show how terrain affects reception
+	HillshadeFile    string  // Hillshade image covering the same area as MapFile; "" = build it from HillshadeURL's tiles
+	HillshadeURL     string  // Tile server with hillshade tiles, e.g. "https://tile.example.org/hillshade/{z}/{x}/{y}.png"
+	HillshadeZoom    int     // Zoom level of the hillshade tiles; 0 = the one closest to the base map's scale
+	HillshadeOpacity float64 // How strongly hill shading shows, from 0 (not at all) to 1 (fully)
+	MapBrightness    float64 // Lightens (up to 1, white) or dims (down to -1, black) the base map; 0 = as is
+
+	HeatmapFlag    bool    // True = shade the map around the receivers with an estimate of reception, under the icons
+	HeatmapRadius  float64 // Meters from the nearest receiver the estimate reaches before fading out
+	HeatmapOpacity float64 // How strongly the estimate shows, from 0 (not at all) to 1 (fully)
+	ContourFlag    bool    // True = draw lines between the areas where the estimate is good, fair, poor and so on
+	ContourGeoJSON bool    // True = also write those areas as GeoJSON polygons next to each map, for GIS programs
+
+	PredictionFlag     bool   // True = shade the map with the coverage predicted from the terrain, under the icons
+	ElevationDirectory string // Directory SRTM elevation tiles (e.g. N37W123.hgt) are kept in
+	ElevationURL       string // Where to download missing tiles from, with {dir} (e.g. N37) and {tile} (e.g. N37W123)
+
 	FontDPI         float64 // Screen resolution in dots per inch
-	FontFile        string  // Name of file containing the TTF font we'll use on the map
+	FontFile        string  // Name of file containing the TTF font we'll use on the map; if it's "" or missing, a built-in one
 	FontHinting     string  // "none" or "full" ("none" seems to look better)
 	FontSize        float64 // Font size in points
 	FontLineSpacing float64 // Spacing between lines of text - NOT USED
+	TextHalo        float64 // Width in pixels of the white halo around text, so it's readable over dark areas; 0 = none
+
+	TitleFontFile  string  // Font for the title, as FontFile; "" = FontFile
+	TitleFontSize  float64 // Size of the title in points; 0 = twice FontSize
+	LegendFontFile string  // Font for the legend; "" = FontFile
+	LegendFontSize float64 // Size of the legend in points; 0 = FontSize
+	LabelFontFile  string  // Font for the call signs next to the icons; "" = FontFile
+	LabelFontSize  float64 // Size of the call signs in points; 0 = FontSize
+	LabelPosition  string  // Side of the icons the call signs go on: "right", "left", "above", "below" or "auto"
+
+	TextColor   string            // Color of call signs and other text, as "#rrggbb"; "" = dark gray
+	LegendColor string            // Color of the legend; "" = TextColor
+	LabelColors map[string]string // Color of the call signs next to each kind of icon (report), overriding TextColor
+
+	LegendKeyFlag bool              // True = show each icon in the legend, next to what its report means
+	ReportNames   map[string]string // What each report (icon name) means, for the legend key, e.g. "1" = "Good"
+
+	Language string            // Language of the legend and other text drawn on the maps, e.g. "es"; "" = English
+	Messages map[string]string // Translations of that text, by the English, overriding or adding to the built-in ones
+
+	IndexFlag     bool // True = write an index.html gallery of all maps in the output directory
+	ThumbnailSize uint // Width in pixels of map thumbnails on the index page
+
+	ThumbnailIconSize uint   // Icons on thumbnails are resized to this dimension
+	ThinBelowWidth    int    // Maps narrower than this many pixels have overlapping icons thinned out, as thumbnails do
+	ThinningMode      string // "worst" = keep the worst-reception icon of an overlapping group; "cluster" = one averaged marker
+	ClusterRadius     int    // Receivers closer together than this many pixels are drawn as one badge with a count; 0 = never
+	ClusterColor      string // "worst" or "best": which of its receivers' reports a cluster badge is colored by
+
+	DriveFlag         bool   // True = upload the results to Google Drive
+	DriveFolderID     string // ID of the Drive folder each run's results go into (as a new subfolder)
+	GoogleCredentials string // Google service account key file (JSON) used to access Google APIs
+
+	SheetFlag bool   // True = add each transmitter's statistics to a tab of a Google Sheet after each run
+	SheetID   string // ID of the Google Sheet (from its URL), usually the one the reports are collected in
+	SheetTab  string // Tab of the Google Sheet the statistics go on; "" = "Results"
+
+	S3Flag      bool   // True = upload the results to an S3-compatible bucket
+	S3Endpoint  string // Base URL of the S3 service, e.g. https://s3.us-west-2.amazonaws.com
+	S3Region    string // Region of the bucket, e.g. us-west-2 (many non-AWS services accept anything)
+	S3Bucket    string // Bucket to upload into
+	S3Prefix    string // Folder within the bucket the results go into
+	S3AccessKey string // Access key; if empty, the AWS_ACCESS_KEY_ID environment variable is used
+	S3SecretKey string // Secret key; if empty, the AWS_SECRET_ACCESS_KEY environment variable is used
+
+	SMTPServer   string // Mail server, as host:port, for the mail command and alert emails
+	SMTPUser     string // User name for the mail server, or "" if it doesn't need one
+	SMTPPassword string // Password for the mail server; if empty, the SMTP_PASSWORD environment variable is used
+	MailFrom     string // Address mail is sent from
+	MailSubject  string // Subject of map emails; a Go template that can use {{.Call}}, {{.Frequency}} and {{.Date}}
+	MailTemplate string // File containing a Go template for the body of map emails, or "" for the built-in message
+	AlertEmail   string // Comma-separated addresses alerts are emailed to, or "" to not email alerts
+
+	IMAPServer   string // Mail server, as host:port, that the fetch command reads report emails from over TLS
+	IMAPUser     string // User name for the IMAP server
+	IMAPPassword string // Password for the IMAP server; if empty, the IMAP_PASSWORD environment variable is used
+	IMAPMailbox  string // Mailbox the report emails arrive in; "" = INBOX
+	IMAPSubject  string // Only unread emails with this in their subject are read as reports; "" = all unread emails
+
+	DiscordFlag    bool   // True = post the results to a Discord channel
+	DiscordWebhook string // Webhook URL of the channel; if empty, the DISCORD_WEBHOOK environment variable is used
+	DiscordPost    string // "maps" = post every map; "summary" = post just the who-hears-whom matrix
+	DiscordAlerts  bool   // True = also post alerts to the Discord channel
+
+	BadgeFlag  bool // True = also create a small net coverage badge image for a website
+	BadgeWidth int  // Width of the badge in pixels
+
+	HistoryFile string      // CSV file recording each station's heard-percentage per session, for alerts, relative to OutputDirectory; "" = none
+	Alerts      []alertRule // Alert rules checked after each run
+
+	Profiles []outputProfile // Extra sizes to draw every map at, e.g. for the web and for printing
+
+	BaseMaps []baseMapConfig // More base maps; each map is drawn on the smallest one all its stations are on
+
+	MontageFlag      bool // True = also tile all the maps made in a run into one large image, for printing as a poster
+	MontageColumns   int  // Number of maps across the montage
+	MontageTileWidth uint // Width in pixels each map is shrunk to in the montage; 0 = full size
+
+	NeighborhoodFlag    bool           // True = also crop each map to each CERT neighborhood, showing just its stations
+	NeighborhoodOverlay bool           // True = outline and name every neighborhood on every map, beneath the icons
+	NeighborhoodFile    string         // GeoJSON, KML or shapefile of more neighborhoods; "" = just the ones below
+	BoundaryFile        string         // GeoJSON, KML or shapefile of city, county or other boundaries to draw; "" = none
+	EOCCoverageFlag     bool           // True = also map the share of each neighborhood's stations the EOC heard
+	EOCCallSign         string         // Call sign of the station at the EOC, for EOCCoverageFlag
+	Neighborhoods       []neighborhood // CERT neighborhoods, for NeighborhoodFlag, NeighborhoodOverlay and EOCCoverageFlag
+
+	UpdateCheck bool   // True = "reception version" checks GitHub for a newer release
+	AssetsURL   string // Where -download-assets fetches the default icon/font bundle from
 }
 
 // Globals for the package
 var (
 	cfg        config
+	startTime  = runStartTime() // When this run started, for dating its output
 	gpsToPixel func(gpsCoord) image.Point
-	drawLegend func([]string)
 )
 
 func main() {
-	// Load configuration information. reception.cfg must be in the same directory as the program itself.
-	if _, err := toml.DecodeFile("reception.cfg", &cfg); err != nil {
-		log.Fatalln("can't open reception.cfg", err)
+	// Load configuration information. We don't give up yet if it's missing, since a new user may be about to
+	// download it with -download-assets. If reception.cfg is found in the user's configuration directory or next
+	// to the program, we run in that directory, so the files it names are found however the program was started
+	// (e.g. by cron or a file manager).
+	loadDefaults(&cfg)
+	cfg.AssetsURL = defaultAssetsURL
+	cfgFile, elsewhere := configFile(os.Args[1:])
+	cfgMeta, cfgErr := loadConfig(cfgFile, &cfg)
+	if cfgErr == nil && elsewhere {
+		if err := os.Chdir(filepath.Dir(cfgFile)); err != nil {
+			fatalln("can't change to the directory of", cfgFile, err)
+		}
 	}
 
 	// Parse command line options
+	flag.String("config", cfgFile, "Configuration file; by default reception.cfg in the current directory, ~/.config/reception or the program's directory")
 	flag.StringVar(&cfg.OperatorFile, "operators", cfg.OperatorFile, "Name of file containing operator information")
 	flag.StringVar(&cfg.ReportFile, "reports", cfg.ReportFile, "Name of file containing reception reports to be mapped")
 	flag.StringVar(&cfg.CallSigns, "calls", cfg.CallSigns, "Call signs for whom to generate maps, or 'all' for all")
 	flag.StringVar(&cfg.Frequency, "freq", cfg.Frequency, "Frequency the radio reception was tested at")
+	flag.StringVar(&cfg.OutputDirectory, "output", cfg.OutputDirectory, "Directory to write the maps into")
+	flag.StringVar(&cfg.OutputNameTemplate, "output-name", cfg.OutputNameTemplate, "Map file name template, using {call}, {type}, {freq} and {date}")
+	flag.StringVar(&cfg.Formats, "format", cfg.Formats, "Comma-separated formats to write each map in: png, svg, pdf, kml, html")
+	flag.IntVar(&cfg.Jobs, "jobs", cfg.Jobs, "Number of maps to draw at the same time; 0 = one per CPU core")
+	flag.StringVar(&cfg.Progress, "progress", cfg.Progress, "How to show progress: bar, lines (one per map, for other programs) or none")
+	noProgressFlag := flag.Bool("no-progress", false, "Don't show progress, as for -progress none")
+	flag.StringVar(&cfg.NetName, "net", cfg.NetName, "Name of the net, for the title at the top of each map")
+	flag.StringVar(&cfg.NetDate, "date", cfg.NetDate, "Date of the net (YYYY-MM-DD), if it wasn't today")
 	flag.BoolVar(&cfg.RcvMapFlag, "receive", cfg.RcvMapFlag, "Generate receive maps, instead of transmit maps")
+	flag.StringVar(&cfg.SuffixMode, "suffix", cfg.SuffixMode, "How suffixed receivers (K7ABC-7) are mapped: separate, ignore or merge")
+	flag.BoolVar(&cfg.MatrixFlag, "matrix", cfg.MatrixFlag, "Also generate a who-hears-whom matrix image")
+	flag.BoolVar(&cfg.MatrixCSVFlag, "matrix-csv", cfg.MatrixCSVFlag, "Also write the who-hears-whom matrix as a CSV file, for R or pandas")
+	flag.BoolVar(&cfg.DotFlag, "dot", cfg.DotFlag, "Also write the who-hears-whom graph as a Graphviz DOT file")
+	flag.BoolVar(&cfg.CapabilityFlag, "capabilities", cfg.CapabilityFlag, "Also generate a station capability matrix (CSV and PDF)")
+	flag.BoolVar(&cfg.ZipFlag, "zip", cfg.ZipFlag, "Also pack the maps and index page into a timestamped zip file")
+	flag.BoolVar(&cfg.DriveFlag, "drive", cfg.DriveFlag, "Upload the results to a new subfolder of the configured Google Drive folder")
+	flag.BoolVar(&cfg.SheetFlag, "sheet", cfg.SheetFlag, "Add each transmitter's statistics to the configured Google Sheet")
+	flag.BoolVar(&cfg.S3Flag, "s3", cfg.S3Flag, "Upload the results to the configured S3-compatible bucket")
+	flag.BoolVar(&cfg.GrayscaleFlag, "grayscale", cfg.GrayscaleFlag, "Generate print-friendly maps: grayscale base map, shapes instead of colored icons")
+	flag.BoolVar(&cfg.WorldFileFlag, "worldfile", cfg.WorldFileFlag, "Also write world files (.pgw) so GIS programs can place the maps")
+	flag.BoolVar(&cfg.GeoTIFFFlag, "geotiff", cfg.GeoTIFFFlag, "Also write each map as a GeoTIFF file")
+	flag.BoolVar(&cfg.MontageFlag, "montage", cfg.MontageFlag, "Also tile all the maps into one large montage image")
+	flag.BoolVar(&cfg.NeighborhoodFlag, "neighborhoods", cfg.NeighborhoodFlag, "Also make a map of each CERT neighborhood in reception.cfg")
+	flag.BoolVar(&cfg.NeighborhoodOverlay, "neighborhood-overlay", cfg.NeighborhoodOverlay, "Outline and name every CERT neighborhood on every map")
+	flag.BoolVar(&cfg.EOCCoverageFlag, "eoc-coverage", cfg.EOCCoverageFlag, "Also map how many of each neighborhood's stations the EOC heard")
+	flag.BoolVar(&cfg.SummaryFlag, "summary", cfg.SummaryFlag, "Also write a summary of the net in summary.txt and summary.html")
+	flag.BoolVar(&cfg.RelayFlag, "relays", cfg.RelayFlag, "Also suggest a relay to each station that didn't hear each transmitter, in relays.csv")
+	flag.BoolVar(&cfg.GapsFlag, "gaps", cfg.GapsFlag, "Also list pairs of stations that didn't hear each other either way, by neighborhood, in gaps.csv")
+	flag.BoolVar(&cfg.AsymmetryFlag, "asymmetry", cfg.AsymmetryFlag, "Also list one-way and lopsided paths in asymmetry.csv")
+	flag.BoolVar(&cfg.AsymmetryMapFlag, "asymmetry-map", cfg.AsymmetryMapFlag, "Also draw one-way and lopsided paths on a map")
+	flag.BoolVar(&cfg.ResultsFlag, "results", cfg.ResultsFlag, "Also describe the maps generated in results.json")
+	flag.BoolVar(&cfg.StatsFlag, "stats", cfg.StatsFlag, "Also record each transmitter's statistics in stats.csv")
+	flag.BoolVar(&cfg.CropFlag, "crop", cfg.CropFlag, "Trim each map to the area around its stations")
+	flag.BoolVar(&cfg.HillshadeFlag, "hillshade", cfg.HillshadeFlag, "Blend hill shading into the base map")
+	flag.BoolVar(&cfg.ScaleBarFlag, "scalebar", cfg.ScaleBarFlag, "Draw scale bars in kilometers and miles on each map")
+	flag.BoolVar(&cfg.NorthArrowFlag, "northarrow", cfg.NorthArrowFlag, "Draw a north arrow on each map")
+	flag.BoolVar(&cfg.ReportLinesFlag, "lines", cfg.ReportLinesFlag, "Draw a line from the transmitter to each receiver, colored by reception")
+	flag.BoolVar(&cfg.VectorIconFlag, "vector-icons", cfg.VectorIconFlag, "Draw built-in icons instead of the icon files")
+	flag.StringVar(&cfg.Palette, "palette", cfg.Palette, "Icon colors: default, or colorblind for a palette safe for red-green color blindness")
+	flag.BoolVar(&cfg.LegendKeyFlag, "legend-key", cfg.LegendKeyFlag, "Show each icon in the legend, next to what it means")
+	flag.BoolVar(&cfg.WatermarkFlag, "watermark", cfg.WatermarkFlag, "Stamp each map with when it was generated and the program version")
+	flag.BoolVar(&cfg.DistanceLabelFlag, "distances", cfg.DistanceLabelFlag, "Add each receiver's distance from the transmitter to its label")
+	flag.BoolVar(&cfg.BearingLabelFlag, "bearings", cfg.BearingLabelFlag, "Add each receiver's direction from the transmitter to its label")
+	flag.BoolVar(&cfg.PredictionFlag, "predict", cfg.PredictionFlag, "Shade the map with the coverage predicted from the terrain")
+	flag.BoolVar(&cfg.HeatmapFlag, "heatmap", cfg.HeatmapFlag, "Shade the map with an estimate of reception interpolated from the reports")
+	flag.BoolVar(&cfg.ContourFlag, "contours", cfg.ContourFlag, "Draw lines between areas of estimated good, fair and poor reception")
+	flag.Float64Var(&cfg.Scale, "scale", cfg.Scale, "Scale icons, text and lines together, e.g. 3 for a print-resolution base map")
+	flag.BoolVar(&cfg.BadgeFlag, "badge", cfg.BadgeFlag, "Also generate a net coverage badge image for a website")
+	flag.BoolVar(&cfg.DiscordFlag, "discord", cfg.DiscordFlag, "Post the results to the configured Discord webhook")
+	flag.BoolVar(&cfg.IndexFlag, "index", cfg.IndexFlag, "Write an index.html gallery of the maps in the output directory")
+	downloadFlag := flag.Bool("download-assets", false, "Download the default icon and font bundle, then exit")
 	flag.Parse()
+	localPaths(reflect.ValueOf(&cfg))
+	if *noProgressFlag {
+		cfg.Progress = progressNone
+	}
+
+	if *downloadFlag {
+		downloadAssets(cfg.AssetsURL)
+		return
+	}
+	if cfg.Scale > 0 && cfg.Scale != 1 {
+		cfg = scaledSettings(cfg, cfg.Scale)
+	}
+
+	// check-config reports unknown settings itself, along with everything else
+	if cfgErr == nil && flag.Arg(0) != "check-config" {
+		warnUnknownSettings(cfgFile, cfgMeta)
+	}
+
+	// Run a command instead of generating maps, if one was given
+	if flag.NArg() > 0 {
+		cmd := lookupCommand(flag.Arg(0))
+		if cmd.needsConfig && cfgErr != nil {
+			fatalln("can't open", cfgFile, cfgErr)
+		}
+		cmd.run(flag.Args()[1:])
+		exitWithSummary()
+	}
+
+	if cfgErr != nil {
+		fatalln("can't open", cfgFile, cfgErr)
+	}
+	generateMaps()
+	exitWithSummary()
+}
+
+// Function generateMaps draws the maps reception.cfg asks for, writes the other files it asks for along with them,
+// and publishes them all where it says to
+func generateMaps() {
+	if cfg.NeighborhoodFile != "" {
+		cfg.Neighborhoods = append(cfg.Neighborhoods, loadNeighborhoodFile(cfg.NeighborhoodFile)...)
+	}
+	if cfg.NeighborhoodFlag || cfg.NeighborhoodOverlay || cfg.EOCCoverageFlag || cfg.GapsFlag {
+		checkNeighborhoods()
+	}
+
+	checkLanguage()
+	checkOutputName()
 
 	// Load the assets we need to construct the maps
+	reloadFonts()
 	icons := loadIcons(cfg.IconDirectory)
 	baseMap := loadBaseMap(cfg.MapFile)
 	gpsToPixel = newGpsToPixel(baseMap)
+	baseMaps := loadBaseMaps(baseMap)
+	finishBaseMaps(baseMaps) // Reads the hill shading and boundary files, which are part of the setup too
 
-	// Load operator and report data
+	// Load operator and report data. From here on, anything wrong is wrong with the data rather than the setup.
+	fatalStatus = exitDataError
 	operators := loadOperators(cfg.OperatorFile)
 	reports, receivers, transmitters := loadReports(cfg.ReportFile)
+	setSourceFiles(cfg.OperatorFile, cfg.ReportFile)
+	for _, m := range baseMaps {
+		sourceFiles = append(sourceFiles, m.file)
+		if cfg.HillshadeFlag && m.hillshadeFile != "" {
+			sourceFiles = append(sourceFiles, m.hillshadeFile)
+		}
+	}
+	if cfg.NeighborhoodFile != "" {
+		sourceFiles = append(sourceFiles, cfg.NeighborhoodFile)
+	}
+	if cfg.BoundaryFile != "" {
+		sourceFiles = append(sourceFiles, cfg.BoundaryFile)
+	}
 
-	// If the user said they only want a subset of receivers, update the transmitter map to match them
-	if cfg.CallSigns != "ALL" {
+	// If the user said they only want a subset of receivers, update the transmitter map to match them. Alerts
+	// are about the whole net, though, so we hang on to the full set for them.
+	allTransmitters := transmitters
+	if !strings.EqualFold(cfg.CallSigns, "all") {
 		newTransmitters := make(map[string]bool)
 		calls := strings.Split(strings.ReplaceAll(strings.ToUpper(cfg.CallSigns), " ", ""), ",")
 		for _, call := range calls {
 			if transmitters[call] {
 				newTransmitters[call] = true // We ignore any asked-for call signs there aren't any reports for
 			} else {
-				fmt.Printf("Skipping %v: no reports\n", call)
+				warnf("skipping %v: no reports", call)
 			}
 		}
 		transmitters = newTransmitters
 	}
 
+	var extraFiles []string // Files other than maps generated this run, relative to the output directory
+
+	if cfg.CapabilityFlag {
+		fmt.Println("Generating station capability matrix...")
+		extraFiles = append(extraFiles, writeCapabilities(operators)...)
+	}
+
+	// Posting a summary to Discord needs the matrix even if it wasn't asked for
+	var matrixFile string
+	if cfg.MatrixFlag || (cfg.DiscordFlag && cfg.DiscordPost == discordSummary) {
+		fmt.Println("Generating who-hears-whom matrix...")
+		matrixFile = writeMatrix(reports, receivers, transmitters, icons)
+		if cfg.MatrixFlag {
+			extraFiles = append(extraFiles, matrixFile)
+		}
+	}
+	if cfg.MatrixCSVFlag {
+		extraFiles = append(extraFiles, writeMatrixCSV(reports, receivers, transmitters))
+	}
+	if cfg.DotFlag {
+		fmt.Println("Writing who-hears-whom graph...")
+		extraFiles = append(extraFiles, writeDot(reports, receivers, transmitters, icons))
+	}
+
+	if cfg.StatsFlag {
+		fmt.Println("Recording transmitter statistics...")
+		extraFiles = append(extraFiles, writeStats(transmitters, reports, operators, icons))
+		extraFiles = append(extraFiles, writePaths(transmitters, reports, operators))
+	}
+	if cfg.RelayFlag {
+		fmt.Println("Suggesting relays...")
+		extraFiles = append(extraFiles, writeRelays(transmitters, reports, icons))
+	}
+	if cfg.GapsFlag {
+		fmt.Println("Looking for gaps...")
+		extraFiles = append(extraFiles, writeGaps(reports, operators, icons))
+	}
+
+	// The badge and summary are about the whole net, not just the maps asked for
+	if cfg.SummaryFlag {
+		fmt.Println("Writing net summary...")
+		extraFiles = append(extraFiles, writeSummary(allTransmitters, receivers, reports, operators, icons)...)
+	}
+	if cfg.BadgeFlag {
+		fmt.Println("Generating coverage badge...")
+		extraFiles = append(extraFiles, writeBadge(netCoverage(allTransmitters, receivers, reports, operators, icons)))
+	}
+
+	// Grayscale only changes the maps; the matrix and badge above keep their colors
+	if cfg.GrayscaleFlag {
+		icons = shapeIcons(icons)
+	}
+	for _, op := range operators {
+		if _, present := icons[op.icon]; op.icon != "" && !present {
+			warnf("no icon %q for %s in the operator file; using the icons for its reports", op.icon, op.callsign)
+		}
+	}
+
+	if cfg.AsymmetryFlag || cfg.AsymmetryMapFlag {
+		fmt.Println("Looking for asymmetric paths...")
+		paths := findAsymmetricPaths(reports, icons)
+		extraFiles = append(extraFiles, writeAsymmetry(paths))
+		if cfg.AsymmetryMapFlag {
+			extraFiles = append(extraFiles, writeAsymmetryMap(baseMaps[0].image, icons, operators, paths))
+		}
+	}
+	if cfg.EOCCoverageFlag {
+		fmt.Println("Working out neighborhood coverage...")
+		coverage := findEOCCoverage(reports, icons, operators)
+		extraFiles = append(extraFiles, writeEOCCoverage(coverage), writeEOCCoverageMap(baseMaps[0].image, icons, operators, coverage))
+	}
+
 	// Create maps for each transmitter
 	fmt.Println("Beginning map generation...")
-	bar := progressbar.New(len(transmitters))
-	baseBounds := baseMap.Bounds()
-	outputMapPtr := image.NewRGBA(baseBounds)
-	textMapPtr, textCtxPtr := newDrawing(baseMap) // Separate layer for labels so they're always on top of icons
-
-	for transmitter := range transmitters {
-		// Reset the main and text maps to their base images
-		draw.Draw(outputMapPtr, baseBounds, baseMap, image.Point{}, draw.Src)
-		draw.Draw(textMapPtr, textMapPtr.Bounds(), image.Transparent, image.Point{}, draw.Src)
-		drawLegend = newDrawLegend(textMapPtr, textCtxPtr)
-
-		// Add icons and call signs for each receiver
-		for receiver := range receivers {
-			if transmitter == receiver {
-				continue
+	progress := newProgress(len(transmitters))
+	var results []mapResult
+	var details []mapDetail
+	tiles := make(map[string]image.Image) // Maps for the montage, by transmitter
+	mapFiles := make(map[string][]string) // Files other than the maps that go with each map, by transmitter
+	var thumbIcons map[string]image.Image
+	if cfg.IndexFlag {
+		thumbIcons = scaleIcons(icons, cfg.ThumbnailIconSize)
+	}
+	absentIcon := noReportIcon(icons)
+	formats := outputRenderers()
+
+	// Work out what goes on each map first, and which base map it goes on
+	type mapJob struct {
+		transmitter       string
+		markers           []marker
+		transmitterMarker marker
+	}
+	jobs := make(map[*baseMapChoice][]mapJob)
+	for _, transmitter := range sortedCalls(transmitters) {
+		markers, transmitterMarker := transmitterMarkers(transmitter, receivers, reports, operators, icons, absentIcon)
+		choice, markers, transmitterMarker := placeMarkers(baseMaps, markers, transmitterMarker)
+		jobs[choice] = append(jobs[choice], mapJob{transmitter, markers, transmitterMarker})
+	}
+
+	// A drawn map on its way to being written: a copy of it, so the worker that drew it can go on to the next map,
+	// and what writes it and the files that go with it
+	type mapFrame struct {
+		image *image.RGBA
+		write func(outputMapPtr *image.RGBA)
+	}
+
+	// Each map is drawn on the layers of the worker drawing it, along with anything else drawn from its markers,
+	// and then handed on to be encoded and written while the worker draws the next one. The writers share the
+	// results, so they're added under a lock.
+	var resultsLock sync.Mutex
+	drawTransmitterMap := func(job mapJob, choice *baseMapChoice, layers *mapLayers, handOn func(mapFrame)) {
+		transmitter, markers, transmitterMarker := job.transmitter, job.markers, job.transmitterMarker
+		baseMap := choice.image
+
+		// Cropped maps get their own layers, the size of the area they show
+		mapImage, mapMarkers, mapTransmitter, ref := baseMap, markers, transmitterMarker, newGeoref(baseMap)
+		area := baseMap.Bounds()
+		if cfg.CropFlag {
+			area = cropArea(area, markers, transmitterMarker)
+			mapImage, mapMarkers, mapTransmitter = cropMap(baseMap, area, markers, transmitterMarker)
+			layers = newMapLayers(mapImage)
+			defer layers.release()
+			ref = ref.crop(area.Min)
+		}
+
+		plotted := drawMap(layers, mapImage, ref.metersPerPixel(), icons, transmitter, mapMarkers, mapTransmitter)
+		frame := newPooledRGBA(layers.outputMapPtr.Bounds())
+		copy(frame.Pix, layers.outputMapPtr.Pix)
+
+		// The files that go with the map are named for the PNG
+		mapType := currentMapType()
+		mapFile := outputName(transmitter, mapType)
+		outputFile := filepath.Join(cfg.OutputDirectory, mapFile)
+		if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+			fatalf("Failed to create output directory: %s", err)
+		}
+		title := localText("%s for %s", mapTypeName(mapType), transmitter)
+		meta := outputMetadata(title, pngText{"Transmitter", transmitter}, pngText{"Map Type", mapType})
+
+		// Profiles and neighborhood maps draw on layers of their own, so they're drawn here rather than by a writer
+		var drawnFiles []string
+		for _, profile := range choice.profiles {
+			drawnFiles = append(drawnFiles, profile.writeMap(transmitter, markers, transmitterMarker, area, mapFile, title, meta)...)
+		}
+		if cfg.NeighborhoodFlag {
+			drawnFiles = append(drawnFiles, writeNeighborhoodMaps(baseMap, markers, transmitterMarker, mapFile, title)...)
+		}
+
+		handOn(mapFrame{image: frame, write: func(outputMapPtr *image.RGBA) {
+			// Save the map in each format asked for
+			rendered := renderedMap{image: outputMapPtr, title: title, meta: meta, transmitter: mapTransmitter, markers: plotted,
+				imageHref: filepath.Base(mapFile)}
+			rendered.nw, rendered.se = mapCorners(area, baseMap.Bounds())
+			var files []string
+			for _, r := range formats {
+				file := strings.TrimSuffix(mapFile, ".png") + r.extension()
+				err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, file), func(w io.Writer) error { return r.render(w, rendered) })
+				if err != nil {
+					fatalf("Failed to write output file: %s", err)
+				}
+				files = append(files, file)
 			}
 
-			report := reports[transmitter][receiver]
-			icon, present := icons[report]
+			result := mapResult{Transmitter: transmitter, MapType: mapType, File: files[0], Clusters: markerClusters(plotted)}
+			files = files[1:]
+			if cfg.IndexFlag {
+				result.Thumbnail = writeThumbnail(drawThumbnail(mapImage, mapMarkers, mapTransmitter, thumbIcons), mapFile, meta)
+			}
+			var detail mapDetail
+			if cfg.ResultsFlag {
+				detail = receiverDetails(result, receivers, reports, operators, icons, plotted)
+			}
+			if cfg.WorldFileFlag {
+				files = append(files, writeWorldFiles(mapFile, ref)...)
+			}
+			if cfg.GeoTIFFFlag {
+				files = append(files, writeGeoTIFF(outputMapPtr, mapFile, ref, title))
+			}
+			if cfg.ContourGeoJSON {
+				surface := newQualitySurface(mapMarkers, mapImage.Bounds(), ref.metersPerPixel())
+				if file := writeContours(surface, ref, mapFile, transmitter); file != "" {
+					files = append(files, file)
+				}
+			}
+			files = append(files, drawnFiles...)
+			var tile image.Image
+			if cfg.MontageFlag {
+				tile = montageTile(outputMapPtr)
+			}
 
-			// Ignore if there's no report for this xmit/rcvr pair, or if there's no icon for the report
-			if report == "" || !present {
-				continue
+			resultsLock.Lock()
+			defer resultsLock.Unlock()
+			results = append(results, result)
+			if cfg.ResultsFlag {
+				details = append(details, detail)
+			}
+			if tile != nil {
+				tiles[transmitter] = tile
 			}
+			mapFiles[transmitter] = files
+			progress.mapDone(transmitter)
+		}})
+	}
 
-			plotIcon(outputMapPtr, icon, operators[receiver], textCtxPtr)
+	// Draw the maps, Jobs at a time, with as many writers encoding and writing them as they're drawn. Choosing a
+	// base map sets cfg and gpsToPixel for everything drawn on it, and writing a map uses them too, so the maps
+	// on each base map are drawn and written before the next base map is chosen. Output profiles change cfg
+	// while they draw, so with them the maps are drawn one at a time, and each is written before the next is drawn.
+	workers := cfg.Jobs
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > 1 && len(cfg.Profiles) > 0 {
+		warnf("maps with output profiles can't be drawn at the same time; drawing them one at a time")
+		workers = 1
+	}
+	ctx, stopInterrupts := cancelOnInterrupt()
+	for _, choice := range baseMaps {
+		queue := jobs[choice]
+		if len(queue) == 0 || ctx.Err() != nil {
+			continue
+		}
+		choice.use()
+		choice.profiles = newProfileRenderers(choice.image)
+
+		// Writers take frames as they come; a few waiting keep them busy without holding many maps in memory
+		frames := make(chan mapFrame, workers)
+		write := func(f mapFrame) {
+			f.write(f.image)
+			releaseRGBA(f.image)
+		}
+		handOn := func(f mapFrame) { frames <- f }
+		if len(choice.profiles) > 0 {
+			handOn = write
+		}
+		var writers sync.WaitGroup
+		for w := 0; w < workers && w < len(queue); w++ {
+			writers.Add(1)
+			go func() {
+				defer writers.Done()
+				for f := range frames {
+					write(f)
+				}
+			}()
 		}
 
-		// Plot the transmitter; we do it last so it isn't potentially covered by one of the receivers
-		plotIcon(outputMapPtr, icons[cfg.TransIcon], operators[transmitter], textCtxPtr)
+		work := make(chan mapJob)
+		var wg sync.WaitGroup
+		for w := 0; w < workers && w < len(queue); w++ {
+			wg.Add(1)
+			go func(choice *baseMapChoice) {
+				defer wg.Done()
+				layers := newMapLayers(choice.image) // Each worker draws on its own layers
+				defer layers.release()
+				for job := range work {
+					drawTransmitterMap(job, choice, layers, handOn)
+				}
+			}(choice)
+		}
+	queueing:
+		for _, job := range queue {
+			select {
+			case work <- job:
+			case <-ctx.Done():
+				break queueing // The maps being drawn are finished, but no more are started
+			}
+		}
+		close(work)
+		wg.Wait()
+		close(frames)
+		writers.Wait()
+		for _, profile := range choice.profiles {
+			profile.layers.release() // For the next base map's layers
+		}
+	}
 
-		plotLegend(transmitter, operators[transmitter])
+	interrupted := ctx.Err() != nil
+	stopInterrupts()
+	progress.finish()
 
-		// Merge the text layer onto the main map
-		draw.Draw(outputMapPtr, textMapPtr.Bounds(), textMapPtr, image.Point{}, draw.Over)
+	// The workers finish in no particular order, so put what they made in call sign order, so each run over the
+	// same reports writes the same files
+	sort.Slice(results, func(i, j int) bool { return results[i].Transmitter < results[j].Transmitter })
+	if interrupted {
+		exitInterruptedRun(results, len(transmitters))
+	}
+	fmt.Println("Map generation completed!")
+	for _, result := range results {
+		extraFiles = append(extraFiles, mapFiles[result.Transmitter]...)
+	}
+	if cfg.ResultsFlag {
+		extraFiles = append(extraFiles, writeResults(details))
+	}
+	if cfg.MontageFlag && len(tiles) > 0 {
+		fmt.Println("Generating montage...")
+		extraFiles = append(extraFiles, writeMontage(tiles))
+	}
 
-		// Finish up: save the map into a png file
-		var outputFile string
-		if cfg.RcvMapFlag {
-			outputFile = cfg.OutputDirectory + "/" + transmitter + "-rcvr-map" + ".png"
-		} else {
-			outputFile = cfg.OutputDirectory + "/" + transmitter + "-xmit-map" + ".png"
+	// The index page covers maps from earlier runs too, so if there is one, those belong in the zip file as well
+	manifest := updateManifest(results)
+	indexed := results
+	if cfg.IndexFlag {
+		writeIndex(manifest)
+		indexed = manifest
+		extraFiles = append(extraFiles, indexFile)
+	}
+
+	// Everything that gets published, relative to the output directory
+	var files []string
+	for _, result := range indexed {
+		files = append(files, result.File)
+		if result.Thumbnail != "" {
+			files = append(files, result.Thumbnail)
 		}
+	}
+	files = append(files, extraFiles...)
 
-		f, err := os.Create(outputFile)
-		if err != nil {
-			log.Fatalf("Failed to create output file: %s", err)
+	if cfg.ZipFlag {
+		zipFile := writeZip(files)
+		fmt.Println("Wrote", zipFile)
+		files = append(files, filepath.Base(zipFile))
+	}
+
+	if cfg.DriveFlag {
+		fmt.Println("Uploading to Google Drive...")
+		uploadToDrive(files)
+	}
+
+	if cfg.SheetFlag {
+		fmt.Println("Adding statistics to Google Sheet...")
+		appendSheetStats(transmitters, reports, operators, icons)
+	}
+
+	if cfg.S3Flag {
+		fmt.Println("Uploading to S3...")
+		uploadToS3(files)
+	}
+
+	if cfg.DiscordFlag {
+		fmt.Println("Posting to Discord...")
+		postToDiscord(results, discordSummaryText(transmitters, reports, operators, icons), matrixFile)
+	}
+
+	checkAlerts(reports, receivers, allTransmitters, operators, icons)
+}
+
+// Function finishBaseMaps blends hill shading into the base maps, adjusts their brightness, grays them and draws
+// the boundaries and neighborhoods on them, as reception.cfg says
+func finishBaseMaps(baseMaps []*baseMapChoice) {
+	// Hill shading is blended in before the grayscale conversion, so it's grayed along with the rest of the map
+	if cfg.HillshadeFlag {
+		for _, m := range baseMaps {
+			m.use()
+			m.image = addHillshade(m.image, m.hillshadeFile)
 		}
+		baseMaps[0].use()
+	}
+
+	if cfg.MapBrightness != 0 {
+		for _, m := range baseMaps {
+			m.image = adjustBrightness(m.image, cfg.MapBrightness)
+		}
+	}
 
-		png.Encode(f, outputMapPtr)
-		f.Close()
-		bar.Add(1)
+	if cfg.GrayscaleFlag {
+		for _, m := range baseMaps {
+			m.image = grayscaleMap(m.image)
+		}
 	}
 
-	fmt.Println("\nMap generation completed!")
+	// Boundaries and neighborhoods are drawn after the grayscale conversion, so their outlines stand out
+	if cfg.BoundaryFile != "" {
+		boundaries := readAreaFile(cfg.BoundaryFile)
+		for _, m := range baseMaps {
+			m.image = overlayBoundaries(m.image, m.toPixel, boundaries)
+		}
+	}
+	if cfg.NeighborhoodOverlay {
+		for _, m := range baseMaps {
+			m.image = overlayNeighborhoods(m.image, m.toPixel)
+		}
+	}
+}
+
+// Function transmitterMarkers returns the markers for a transmitter's map: one for each receiver with a report
+// that has an icon, in call sign order so they're drawn in the same order every time, then the operators who gave
+// no report, and the transmitter's own
+func transmitterMarkers(transmitter string, receivers map[string]bool, reports map[string]map[string]string, operators map[string]operatorData, icons map[string]image.Image, absentIcon image.Image) ([]marker, marker) {
+	var markers []marker
+	for _, receiver := range sortedCalls(receivers) {
+		if transmitter == receiver {
+			continue
+		}
+
+		report := reports[transmitter][receiver]
+		icon, present := icons[report]
+
+		// Ignore if there's no report for this xmit/rcvr pair, or if there's no icon for the report
+		if report == "" || !present {
+			continue
+		}
+
+		op := lookupOperator(operators, receiver)
+		markers = append(markers, marker{operator: op, report: report, icon: icon, ownIcon: icons[op.icon]})
+	}
+	markers = append(markers, noReportMarkers(operators, reports, transmitter, absentIcon)...)
+	xmitOp := lookupOperator(operators, transmitter)
+	return markers, marker{operator: xmitOp, report: cfg.TransIcon, icon: icons[cfg.TransIcon], ownIcon: icons[xmitOp.icon]}
+}
+
+// Function placeMarkers picks the smallest base map that has every station on a map, if there's more than one,
+// and returns it with the markers positioned on it
+func placeMarkers(baseMaps []*baseMapChoice, markers []marker, transmitterMarker marker) (*baseMapChoice, []marker, marker) {
+	if len(baseMaps) == 1 {
+		return baseMaps[0], markers, transmitterMarker
+	}
+	var locations []gpsCoord
+	for _, m := range append(markers, transmitterMarker) {
+		if m.operator.callsign != "" && !m.absent {
+			locations = append(locations, m.operator.gps)
+		}
+	}
+	choice := chooseBaseMap(baseMaps, locations)
+	for i := range markers {
+		markers[i] = choice.place(markers[i])
+	}
+	return choice, markers, choice.place(transmitterMarker)
+}
+
+// Function currentMapType returns the type of map we're generating, based on the configuration
+func currentMapType() string {
+	if cfg.RcvMapFlag {
+		return rcvrMapType
+	}
+	return xmitMapType
+}
+
+// Function drawMap draws a transmitter's map onto the output layer: the base map, any predicted coverage, an icon and
+// call sign for each receiver (over those of operators who gave no report) and then the transmitter, the legend with
+// its key to the icons, and the scale bar for a map with pixels metersPerPixel across. Labels are drawn on the text
+// layer first, so they're always on top of icons. It returns the receiver markers actually plotted, after any
+// thinning.
+func drawMap(layers *mapLayers, baseMap image.Image, metersPerPixel float64, icons map[string]image.Image, transmitter string, markers []marker, transmitterMarker marker) []marker {
+	// Reset the main and text maps to their base images. Only what the last map drawn on them changed needs
+	// resetting, which for a map with a handful of stations is a small part of it.
+	layers.reset(baseMap)
+	outputMapPtr, textMapPtr, textCtxPtr := layers.outputMapPtr, layers.textMapPtr, layers.textCtxPtr
+	baseBounds := baseMap.Bounds()
+	drawLegend := newDrawLegend(textMapPtr, textCtxPtr)
+	markers, absent := splitAbsent(markers)
+
+	// Add icons and call signs for each receiver. On small maps, the icons would pile up on each other, so
+	// we thin them out first.
+	plotted := markers
+	if baseBounds.Dx() < cfg.ThinBelowWidth {
+		plotted = thinMarkers(markers, 1.0, int(cfg.IconSize))
+	}
+	if cfg.ClusterRadius > 0 {
+		plotted = clusterDense(plotted)
+	}
+	predicted := cfg.PredictionFlag && !cfg.GrayscaleFlag && transmitterMarker.operator.callsign != ""
+	if predicted {
+		prediction := newPredictedSurface(transmitterMarker.operator, baseMap, icons)
+		drawHeatmap(outputMapPtr, prediction)
+		predicted = prediction != nil
+	}
+	if cfg.HeatmapFlag || cfg.ContourFlag {
+		surface := newQualitySurface(markers, baseBounds, metersPerPixel)
+		if cfg.HeatmapFlag && !cfg.GrayscaleFlag {
+			drawHeatmap(outputMapPtr, surface)
+		}
+		if cfg.ContourFlag {
+			drawContours(outputMapPtr, surface)
+		}
+	}
+	if cfg.ReportLinesFlag {
+		drawReportLines(outputMapPtr, plotted, transmitterMarker)
+	}
+	if predicted || cfg.HeatmapFlag || cfg.ContourFlag || cfg.ReportLinesFlag {
+		layers.markDrawn(baseBounds) // These cover the map, or could reach anywhere on it
+	}
+	plot := func(icon image.Image, report string, operator operatorData) {
+		plotIcon(outputMapPtr, icon, report, operator, textCtxPtr)
+		if operator.callsign != "" {
+			layers.markIcon(icon, operator.pixel)
+		}
+	}
+	for _, m := range absent {
+		plot(m.icon, m.report, m.operator) // Beneath the stations that did report
+	}
+	for _, m := range plotted {
+		op := m.operator
+		if !m.cluster && op.callsign != "" && transmitterMarker.operator.callsign != "" {
+			op.callsign += pathLabel(transmitterMarker.operator.gps, op.gps)
+		}
+		plot(m.shownIcon(), m.report, op)
+	}
+
+	// Plot the transmitter; we do it last so it isn't potentially covered by one of the receivers
+	plot(transmitterMarker.shownIcon(), transmitterMarker.report, transmitterMarker.operator)
+
+	plotLegend(drawLegend, transmitter, transmitterMarker.operator)
+	if predicted {
+		drawLegend([]string{localText("Shading: coverage predicted from terrain")})
+	}
+	if cfg.LegendKeyFlag {
+		drawLegendKey(textMapPtr, textCtxPtr, icons)
+	}
+	if cfg.NetName != "" {
+		drawTitle(textMapPtr)
+	}
+	if cfg.ScaleBarFlag {
+		drawScaleBar(textMapPtr, metersPerPixel)
+	}
+	if cfg.NorthArrowFlag {
+		drawNorthArrow(textMapPtr)
+	}
+
+	// Merge the text layer onto the main map, skipping the parts with no text
+	text := layers.markText()
+	addTextHalo(text)
+	draw.Draw(outputMapPtr, text.Bounds(), text, text.Bounds().Min, draw.Over)
+	layers.markDrawn(drawAttribution(outputMapPtr))
+	layers.markDrawn(drawWatermark(outputMapPtr))
+	return plotted
+}
+
+// Function outputName returns the name of the map file for a transmitter, relative to the output directory,
+// by filling in the placeholders in cfg.OutputNameTemplate. An empty template gives the traditional
+// CALL-xmit-map.png names. The template may include "/" (or "\") to sort maps into subdirectories; either way the
+// name uses "/", since it's also the map's address on the index page.
+func outputName(transmitter, mapType string) string {
+	checkOutputName()
+	template := strings.ReplaceAll(cfg.OutputNameTemplate, `\`, "/")
+	if template == "" {
+		template = "{call}-{type}-map"
+	}
+
+	r := strings.NewReplacer(
+		"{call}", fileNameSafe(transmitter),
+		"{type}", mapType,
+		"{freq}", fileNameSafe(cfg.Frequency),
+		"{date}", netDate())
+	return r.Replace(template) + ".png"
+}
+
+// Function checkOutputName stops with a configuration error if cfg.OutputNameTemplate leaves out {call}, since
+// every map would then get the same name, each one silently replacing the last
+func checkOutputName() {
+	if cfg.OutputNameTemplate != "" && !strings.Contains(cfg.OutputNameTemplate, "{call}") {
+		fatalStatus = exitConfigError
+		fatalf("OutputNameTemplate %q in reception.cfg has no {call}, so every map would get the same name", cfg.OutputNameTemplate)
+	}
+}
+
+// Function fileNameSafe replaces characters that are awkward or illegal in file names (on any platform) with
+// dashes, so values like "146.535 MHz Simplex" can be used in output file names.
+func fileNameSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		default:
+			return '-'
+		}
+	}, s)
 }
 
-// Function loadIcons loads and resizes icons
+// Function loadIcons loads and resizes the icons in a directory, or the default icon files built into the program if
+// dir is "". With [[Icons]] tables it loads just the files they name, for their reports. If VectorIconFlag is set it
+// draws the built-in vector icons instead; the icon files' colors can't be changed, so the colorblind palette always
+// uses those. The icons' reports also set the scale reports are scored on (see setScoreScale).
 func loadIcons(dir string) map[string]image.Image {
-	fileInfos, err := ioutil.ReadDir(dir)
+	if cfg.VectorIconFlag || cfg.Palette == paletteColorblind {
+		icons := vectorIcons()
+		setScoreScale(icons)
+		return icons
+	}
+
+	files := iconFiles(dir)
+	if len(cfg.Icons) > 0 {
+		icons := loadIconTable(files)
+		setScoreScale(icons)
+		return icons
+	}
+	entries, err := fs.ReadDir(files, ".")
 	if err != nil {
-		log.Fatal("can't read directory", dir, err)
+		fatal("can't read directory", dir, err)
 	}
 
 	icons := make(map[string]image.Image)
 
-	for _, fileInfo := range fileInfos {
-		r, err := os.Open(dir + "/" + fileInfo.Name())
+	for _, entry := range entries {
+		icon, err := cachedIcon(files, entry.Name(), cfg.IconSize)
 		if err != nil {
-			log.Fatal("can't open "+fileInfo.Name(), err)
+			fatal("can't load icon "+entry.Name(), err)
 		}
-		defer r.Close()
 
-		icon, err := png.Decode(r)
-		if err != nil {
-			log.Fatal("can't decode "+fileInfo.Name(), err)
-		}
-
-		iconName := strings.TrimSuffix(fileInfo.Name(), ".png")
-		icons[iconName] = resize.Resize(cfg.IconSize, 0, icon, resize.Bilinear)
+		iconName := strings.TrimSuffix(entry.Name(), ".png")
+		icons[iconName] = icon
 	}
 
+	setScoreScale(icons)
 	return icons
 }
 
-// Read the static base map file and return its image data
+// Read the static base map file and return its image data. If the base map comes from a map service, it's
+// downloaded first, and MapFile, the corners and the projection are updated to match. A GeoTIFF base map says
+// where its corners are and what projection it's in itself, as does any base map with a world file next to it, so
+// they're taken from that rather than from reception.cfg.
 func loadBaseMap(imageFile string) image.Image {
+	switch cfg.MapSource {
+	case "", mapSourceFile:
+	case mapSourceStatic:
+		imageFile = fetchStaticMap()
+		cfg.MapFile, cfg.MapProjection = imageFile, projectionMercator
+	case mapSourceTiles:
+		imageFile = fetchTileMap()
+		cfg.MapFile, cfg.MapProjection = imageFile, projectionMercator
+	default:
+		fatalf("unknown MapSource %q in reception.cfg; use %q, %q or %q", cfg.MapSource, mapSourceFile, mapSourceStatic, mapSourceTiles)
+	}
+
+	if ext := strings.ToLower(filepath.Ext(imageFile)); ext == ".tif" || ext == ".tiff" {
+		mapImage, nw, se, epsg := readGeoTIFF(imageFile)
+		cfg.MapNWCorner = []float64{nw.lat, nw.long}
+		cfg.MapSECorner = []float64{se.lat, se.long}
+		cfg.MapProjection = projectionForEPSG(epsg)
+		return mapImage
+	}
+
 	f, err := os.Open(imageFile)
 	if err != nil {
-		log.Fatal("can't open", imageFile, err)
+		fatal("can't open", imageFile, err)
 	}
 	defer f.Close()
 
-	mapImage, err := png.Decode(f)
+	mapImage, _, err := image.Decode(f)
 	if err != nil {
-		log.Fatal("can't decode base map", imageFile, err)
+		fatal("can't decode base map", imageFile, err)
+	}
+	if nw, se, epsg, found := readWorldFile(imageFile, mapImage.Bounds().Size()); found {
+		cfg.MapNWCorner = []float64{nw.lat, nw.long}
+		cfg.MapSECorner = []float64{se.lat, se.long}
+		cfg.MapProjection = projectionForEPSG(epsg)
 	}
 	return mapImage
 
@@ -235,16 +1074,31 @@ func loadBaseMap(imageFile string) image.Image {
 //   - Antenna type
 //   - Antenna gain (dBi)
 //   - Antenna height (ft)
+// Records may have these optional values after them; leave a value empty if the operator doesn't have one:
+//   - Email address (for the mail command)
+//   - Icon (shown for the operator whatever their reports, looked up like a report's icon)
+//   - Antenna heading (degrees clockwise from true north, for a directional antenna)
 func loadOperators(csvFile string) map[string]operatorData {
+	operators, err := readOperators(csvFile)
+	if err != nil {
+		fatalln(err)
+	}
+	return operators
+}
+
+// Function readOperators reads an operator file as loadOperators does, but returns an error instead of stopping
+// the program if the file can't be used, for the server, which has to keep going
+func readOperators(csvFile string) (map[string]operatorData, error) {
 	f, err := os.Open(csvFile)
 	if err != nil {
-		log.Fatalln("Couldn't open the operator csv file:", err)
+		return nil, fmt.Errorf("couldn't open the operator csv file: %s", err)
 	}
 	defer f.Close()
 
 	operators := make(map[string]operatorData)
 
 	r := csv.NewReader(bufio.NewReader(f))
+	r.FieldsPerRecord = -1 // Trailing values are optional
 
 	for {
 		record, err := r.Read()
@@ -252,49 +1106,48 @@ func loadOperators(csvFile string) map[string]operatorData {
 			break
 		}
 		if err != nil {
-			log.Fatal("error reading operator file", csvFile, err)
+			return nil, fmt.Errorf("error reading operator file %s: %s", csvFile, err)
 		}
-
-		callsign := strings.ReplaceAll(strings.ToUpper(record[0]), " ", "")
-
-		lat, err := strconv.ParseFloat(record[1], 64)
-		if err != nil {
-			log.Fatalln("can't parse latitude in operator CSV", err)
+		line, _ := r.FieldPos(0)
+		if len(record) < 7 {
+			return nil, fmt.Errorf("operator file %s, line %d: only %d values: %v", csvFile, line, len(record), record)
 		}
-		long, err := strconv.ParseFloat(record[2], 64)
-		if err != nil {
-			log.Fatalln("can't parse longitude in operator CSV", err)
-		}
-		gps := gpsCoord{lat, long}
-
-		xmitPwr, err := strconv.ParseFloat(record[3], 64)
-		if err != nil {
-			log.Fatalln("can't parse transmitter power in operator CSV", err)
+		for len(record) < 10 {
+			record = append(record, "")
 		}
 
-		antType := record[4]
+		callsign := strings.ReplaceAll(strings.ToUpper(record[0]), " ", "")
 
-		antGain, err := strconv.ParseFloat(record[5], 64)
-		if err != nil {
-			log.Fatalln("can't parse antenna gain in operator CSV", err)
+		// The numbers, in the order of their columns
+		var numbers [5]float64
+		for i, column := range []struct {
+			index int
+			name  string
+		}{{1, "latitude"}, {2, "longitude"}, {3, "transmitter power"}, {5, "antenna gain"}, {6, "antenna height"}} {
+			if numbers[i], err = strconv.ParseFloat(record[column.index], 64); err != nil {
+				return nil, fmt.Errorf("operator file %s, line %d: can't parse %s: %s", csvFile, line, column.name, err)
+			}
 		}
-
-		antHeight, err := strconv.ParseFloat(record[6], 64)
+		gps := gpsCoord{numbers[0], numbers[1]}
+		beam, err := parseBeam(record[9])
 		if err != nil {
-			log.Fatalln("can't parse antenna height in operator CSV", err)
+			return nil, fmt.Errorf("operator file %s, line %d: can't parse beam heading: %s", csvFile, line, err)
 		}
 
 		operators[callsign] = operatorData{
 			callsign:  callsign,
 			gps:       gps,
 			pixel:     gpsToPixel(gps),
-			xmitPwr:   xmitPwr,
-			antType:   antType,
-			antGain:   antGain,
-			antHeight: antHeight}
+			xmitPwr:   numbers[2],
+			antType:   record[4],
+			antGain:   numbers[3],
+			antHeight: numbers[4],
+			email:     strings.TrimSpace(record[7]),
+			icon:      strings.TrimSpace(record[8]),
+			beam:      beam}
 	}
 
-	return operators
+	return operators, nil
 }
 
 // FunctionloadReports loads reception reports from a CSV. Each record of the file contains 3 items:
@@ -309,17 +1162,29 @@ func loadOperators(csvFile string) map[string]operatorData {
 // Normally these reports are for tranmission maps, showing reception quality for all receivers that hear one
 // transmitter. However, if cfg.RcvMapFlag is true, the user asked for a reception map instead--reception quality
 // the transmitter had for all receivers. If we're doing a receive map, we just swap transmitters and receivers as
-// we load the reception reports.
+// we load the reception reports. Receivers with suffixed call signs (K7ABC-7) are handled according to
+// cfg.SuffixMode; see addReport.
 func loadReports(csvFile string) (reports map[string]map[string]string, receivers map[string]bool, transmitters map[string]bool) {
+	reports, receivers, transmitters, err := readReports(csvFile)
+	if err != nil {
+		fatalln(err)
+	}
+	return reports, receivers, transmitters
+}
+
+// Function readReports reads a report file as loadReports does, but returns an error instead of stopping the
+// program if the file can't be used, for the server, which has to keep going
+func readReports(csvFile string) (reports map[string]map[string]string, receivers map[string]bool, transmitters map[string]bool, err error) {
 	f, err := os.Open(csvFile)
 	if err != nil {
-		log.Fatalln("couldn't open the report csv file:", err)
+		return nil, nil, nil, fmt.Errorf("couldn't open the report csv file: %s", err)
 	}
 	defer f.Close()
 
 	reports = make(map[string]map[string]string)
 	receivers = make(map[string]bool)
 	transmitters = make(map[string]bool)
+	fromSuffix := make(map[string]map[string]bool) // Which reports came from suffixed receivers; see addReport
 
 	r := csv.NewReader(bufio.NewReader(f))
 
@@ -329,7 +1194,11 @@ func loadReports(csvFile string) (reports map[string]map[string]string, receiver
 			break
 		}
 		if err != nil {
-			log.Fatal(err)
+			return nil, nil, nil, fmt.Errorf("error reading report file %s: %s", csvFile, err)
+		}
+		if len(record) < 3 {
+			line, _ := r.FieldPos(0)
+			return nil, nil, nil, fmt.Errorf("report file %s, line %d: only %d values: %v", csvFile, line, len(record), record)
 		}
 
 		var transmitter, receiver string
@@ -344,44 +1213,41 @@ func loadReports(csvFile string) (reports map[string]map[string]string, receiver
 
 		if reports[transmitter] == nil {
 			reports[transmitter] = make(map[string]string)
+			fromSuffix[transmitter] = make(map[string]bool)
 		}
 
-		reports[transmitter][receiver] = report
+		receiver = addReport(reports, fromSuffix, transmitter, receiver, report)
 		receivers[receiver] = true
 		transmitters[transmitter] = true
 	}
 
-	return
+	return reports, receivers, transmitters, nil
 }
 
 // Function plotLegend plots the legend onto the map image
-func plotLegend(transmitter string, opData operatorData) {
-	if cfg.RcvMapFlag {
-		drawLegend([]string{"Receive Map (who can I hear) for " + transmitter})
-	} else {
-		drawLegend([]string{"Transmission Map (who can hear me) for " + transmitter})
-	}
+func plotLegend(drawLegend func([]string), transmitter string, opData operatorData) {
+	drawLegend([]string{localText("%s for %s", mapTypeName(currentMapType()), transmitter)})
 
-	drawLegend([]string{"Frequency: " + cfg.Frequency})
+	drawLegend([]string{localText("Frequency: %s", cfg.Frequency)})
 
 	pwr := opData.xmitPwr
 	if pwr != -100.0 {
-		drawLegend([]string{fmt.Sprintf("Transmitter Power: %.0f Watts", pwr)})
+		drawLegend([]string{localText("Transmitter Power: %.0f Watts", pwr)})
 	}
 
 	ant := opData.antType
 	if ant != "" {
-		drawLegend([]string{"Antenna Type: " + ant})
+		drawLegend([]string{localText("Antenna Type: %s", ant)})
 	}
 
 	height := opData.antHeight
 	if height != -100.0 {
-		drawLegend([]string{fmt.Sprintf("Antenna Height: %.0f feet", height)})
+		drawLegend([]string{localText("Antenna Height: %.0f feet", height)})
 	}
 
 	gain := opData.antGain
 	if gain != -100 {
-		drawLegend([]string{fmt.Sprintf("Antenna Est. Gain: %.1f dBi", gain)})
+		drawLegend([]string{localText("Antenna Est. Gain: %.1f dBi", gain)})
 	}
 
 	return
@@ -389,112 +1255,107 @@ func plotLegend(transmitter string, opData operatorData) {
 
 // Function newGpsToPixel returns a function closure that converts GPS coordinates into an X/Y pixel position on a map image
 func newGpsToPixel(mapImage image.Image) func(gpsCoord) image.Point {
-	// We use UTM coordinates as an intermediate step between polar GPS goodinates and pixel
-	// coordinates; UTM provide a flat, linear mapping of spherical lat/long that is easy
-	// to scale to the image pixel coordinates we need.
+	// We project GPS coordinates onto a plane in which the base map is a linear scaling (see projection.go), and
+	// then scale them to the image's pixel coordinates.
 	//
-	// We throw away the zone number and zone letter components when we convert to UTM;
-	// they won't matter if the locations are within a few hundred miles of each other
-	// TODO: Test that the zone numbers are +/- 1 from each other, just in case someone does something crazy
+	// For UTM every location is converted in the zone of the map's northwest corner, even if it's in the next
+	// zone over, so the whole map is in one coordinate system.
 
-	eastingNW, northingNW, _, _, err := UTM.FromLatLon(cfg.MapNWCorner[0], cfg.MapNWCorner[1], false)
-	if err != nil {
-		log.Fatalln("MapNWCorner can't be converted to UTM", err)
-	}
-	eastingSE, northingSE, _, _, err := UTM.FromLatLon(cfg.MapSECorner[0], cfg.MapSECorner[1], false)
-	if err != nil {
-		log.Fatalln("MapSECorner can't be converted to UTM", err)
-	}
-	xMetersPerPixel := (eastingSE - eastingNW) / float64(mapImage.Bounds().Dx())
-	yMetersPerPixel := (northingNW - northingSE) / float64(mapImage.Bounds().Dy())
+	ref := newGeoref(mapImage)
 
 	return func(gps gpsCoord) image.Point {
-		easting, northing, _, _, err := UTM.FromLatLon(gps.lat, gps.long, false)
-		if err != nil {
-			log.Fatalln("can't convert GPS coordinate to UTM", err)
-		}
-
+		x, y := ref.proj.project(gps)
 		return image.Point{
-			int(((easting - eastingNW) / xMetersPerPixel) + 0.5),
-			int(((northingNW - northing) / yMetersPerPixel) + 0.5)}
+			int(((x - ref.originX) / ref.pixelWidth) + 0.5),
+			int(((y - ref.originY) / ref.pixelHeight) + 0.5)}
 	}
 }
 
 // Function newDrawing returns a blank image for drawing text onto, and a Freetype context for doing the
 // drawing that's been initialized with our chosen font info.
 func newDrawing(baseMap image.Image) (*image.RGBA, *freetype.Context) {
-	// Read and parse the font we'll use
-	fontBytes, err := ioutil.ReadFile(cfg.FontFile)
-	if err != nil {
-		log.Fatalln("can't open font file", cfg.FontFile, err)
-	}
-	f, err := freetype.ParseFont(fontBytes)
-	if err != nil {
-		log.Fatalln("can't parse font file", cfg.FontFile, err)
-	}
-
 	// Initialize a blank image for plotting text (icon labels and the legend) onto. After we're done plotting
 	// everything for one reception map, we overlay the text image onto the main map image.
 	textMapPtr := image.NewRGBA(baseMap.Bounds())
 	draw.Draw(textMapPtr, textMapPtr.Bounds(), image.Transparent, image.Point{}, draw.Src)
 
+	return textMapPtr, newContext(textMapPtr)
+}
+
+// Function newContext returns a Freetype context that draws onto the given image, initialized with our chosen
+// font info. It's set up for labels; anything else drawn with it switches fonts and back.
+func newContext(dstPtr *image.RGBA) *freetype.Context {
 	ctxPtr := freetype.NewContext()
 	ctxPtr.SetDPI(cfg.FontDPI)
-	ctxPtr.SetFont(f)
-	ctxPtr.SetFontSize(cfg.FontSize)
-	ctxPtr.SetClip(textMapPtr.Bounds())
-	ctxPtr.SetDst(textMapPtr)
-	ctxPtr.SetSrc(&image.Uniform{color.RGBA{0x10, 0x10, 0x10, 0xff}}) // Color of text
+	setFont(ctxPtr, labelFont)
+	ctxPtr.SetClip(dstPtr.Bounds())
+	ctxPtr.SetDst(dstPtr)
+	ctxPtr.SetSrc(&image.Uniform{textColor()})
 	switch cfg.FontHinting {
 	default:
 		ctxPtr.SetHinting(font.HintingNone)
 	case "full":
 		ctxPtr.SetHinting(font.HintingFull)
 	}
-	return textMapPtr, ctxPtr
+	return ctxPtr
 }
 
 // Function newDrawLegends returns a function closure that takes an slice of strings and plots them onto an image,
 // one element per line. Cursor location is is wrapped in the closure, so the function can be called repeatedly
-// to plot additional slices of strings onto the image.
+// to plot additional slices of strings onto the image. Lines too long to fit across the image are wrapped, with
+// the rest indented.
 func newDrawLegend(textImagePtr *image.RGBA, contextPtr *freetype.Context) func([]string) {
 
 	// TODO: Make margins, line spacing, and positioning configurable
+	ttf, size := legendFont()
 	cursorX := int(cfg.FontSize*5 + 0.5)
-	cursorY := textImagePtr.Bounds().Max.Y - int(cfg.FontSize*cfg.FontLineSpacing*cfg.FontDPI/72.0*8+0.5)
+	cursorY := textImagePtr.Bounds().Max.Y - int(size*cfg.FontLineSpacing*cfg.FontDPI/72.0*8+0.5)
+	face := truetype.NewFace(ttf, &truetype.Options{Size: size, DPI: cfg.FontDPI})
+	indent := int(size*2 + 0.5)
+	width := fixed.I(textImagePtr.Bounds().Max.X - cursorX*2 - indent) // Same margin on the right as the left
 
 	return func(legendItems []string) {
+		contextPtr.SetSrc(&image.Uniform{legendColor()})
+		setFont(contextPtr, legendFont)
+		defer contextPtr.SetSrc(&image.Uniform{textColor()})
+		defer setFont(contextPtr, labelFont)
 		for _, legend := range legendItems {
-			cursor := freetype.Pt(cursorX, cursorY)
-			_, err := contextPtr.DrawString(legend, cursor)
-			if err != nil {
-				log.Fatalln("Can't plot legend string", err)
+			for i, line := range wrapText(face, legend, width) {
+				cursor := freetype.Pt(cursorX, cursorY)
+				if i > 0 {
+					cursor = freetype.Pt(cursorX+indent, cursorY)
+				}
+				_, err := contextPtr.DrawString(line, cursor)
+				if err != nil {
+					fatalln("Can't plot legend string", err)
+				}
+				cursorY += int(size*cfg.FontLineSpacing*cfg.FontDPI/72.0 + 0.5)
 			}
-			cursorY += int(cfg.FontSize*cfg.FontLineSpacing*cfg.FontDPI/72.0 + 0.5)
 		}
 
 		return
 	}
 }
 
-// Function plotIcons plots an icon on the map image
-func plotIcon(mapPtr *image.RGBA, icon image.Image, operator operatorData, contextPtr *freetype.Context) {
+// Function plotIcons plots an icon on the map image, labeled in the color for its report on the side
+// LabelPosition says
+func plotIcon(mapPtr *image.RGBA, icon image.Image, report string, operator operatorData, contextPtr *freetype.Context) {
 	if operator.callsign == "" {
-		fmt.Println("Skipping icon for missing operator")
+		warnf("skipping icon for missing operator")
 		return
 	}
 
-	offset := image.Point{
-		operator.pixel.X - int(icon.Bounds().Max.X/2),
-		operator.pixel.Y - int(icon.Bounds().Max.Y/2)}
-
-	draw.Draw(mapPtr, icon.Bounds().Add(offset), icon, image.Point{}, draw.Over)
+	drawIcon(mapPtr, icon, operator.pixel, report)
+	if operator.beam != noBeam {
+		drawBeam(mapPtr, operator.pixel, icon.Bounds().Dx(), operator.beam)
+	}
 
-	pt := freetype.Pt(operator.pixel.X+int((icon.Bounds().Max.X+int(cfg.FontSize))/2),
-		operator.pixel.Y+int(cfg.FontSize*cfg.FontDPI/72.0/2.0+0.5))
+	pt := labelPoint(operator.callsign, operator.pixel, icon.Bounds().Size(), mapPtr.Bounds())
+	contextPtr.SetSrc(&image.Uniform{labelColor(report)})
+	defer contextPtr.SetSrc(&image.Uniform{textColor()})
 	_, err := contextPtr.DrawString(operator.callsign, pt)
 	if err != nil {
-		log.Fatalln("can't plot icon label", err)
+		fatalln("can't plot icon label", err)
 		return
 	}
 }
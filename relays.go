@@ -0,0 +1,61 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"image"
+	"io"
+	"path/filepath"
+)
+
+// Relay table in the output directory, and its columns
+const relaysFile = "relays.csv"
+
+var relaysHeadings = []string{"Call Sign", "Station", "Relay", "Call Sign to Relay", "Relay to Station", "Station to Relay", "Relay to Call Sign", "Worst Relay Link"}
+
+// Function writeRelays writes a relay table for each transmitter mapped this run to relays.csv in the output
+// directory: for every station in the reports that didn't hear the transmitter (or, on receive maps, that the
+// station mapped didn't hear), the best station to relay between them, chosen as by "reception path". Stations
+// with no relay are listed with none. The table is what's worked out on the whiteboard after a simplex drill, and
+// is rewritten each run. It returns the file's name relative to the output directory.
+func writeRelays(transmitters map[string]bool, reports map[string]map[string]string, icons map[string]image.Image) string {
+	var rows [][]string
+	stations := sortedCalls(stationsIn(reports))
+	for _, transmitter := range sortedCalls(transmitters) {
+		for _, station := range stations {
+			if _, heard := icons[reports[transmitter][station]]; heard || baseCall(station) == baseCall(transmitter) {
+				continue
+			}
+			relay, links, worst := bestRelay(reports, icons, transmitter, station)
+			if relay == "" {
+				rows = append(rows, []string{transmitter, station, "none", "", "", "", "", ""})
+				continue
+			}
+			rows = append(rows, []string{transmitter, station, relay, links[0], links[1], links[2], links[3], worst})
+		}
+	}
+
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, relaysFile), func(f io.Writer) error {
+		w := csv.NewWriter(f)
+		w.Write(relaysHeadings)
+		w.WriteAll(rows)
+		return w.Error()
+	})
+	if err != nil {
+		fatalln("couldn't write the relay table:", err)
+	}
+	return relaysFile
+}
@@ -0,0 +1,244 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/golang/freetype"
+)
+
+// Reliability scorecard files in the output directory, and the CSV file's columns
+const (
+	reliabilityFile    = "reliability.csv"
+	reliabilityMapFile = "reliability-map.png"
+)
+
+var reliabilityHeadings = []string{"Call Sign", "Sessions", "Sessions Heard", "Heard (%)", "Average Stations Hearing", "Average Report", "Score"}
+
+// How reliably one operator was heard across a series of sessions
+type operatorReliability struct {
+	callsign string
+	sessions int     // Sessions the operator transmitted in
+	heard    int     // Sessions in which at least one station heard them
+	hearing  float64 // Average number of stations that heard them per session
+	scored   int     // Reports they were given that have a score
+	quality  float64 // Average score of those reports, or 0 if there were none
+	score    float64 // From 0 (never heard) to 100 (heard by every other station, at the best report, every time)
+}
+
+// Function reliabilityCommand scores each operator on how reliably they're heard, from a directory of report
+// files from past sessions, one per session. A station's score for a session is the share of the session's other
+// stations that heard it, with each weighted down for worse reports, and its overall score is the average over
+// the sessions it transmitted in. The scores go in reliability.csv, best first, and on a map of the whole roster
+// with each station's icon showing its score.
+func reliabilityCommand(args []string) {
+	flags := flag.NewFlagSet("reliability", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fatalln("Usage: reception reliability DIRECTORY")
+	}
+	files := reportFilesIn(flags.Arg(0))
+
+	icons := loadIcons(cfg.IconDirectory)
+	baseMap := loadBaseMap(cfg.MapFile)
+	gpsToPixel = newGpsToPixel(baseMap)
+	fatalStatus = exitDataError
+	operators := loadOperators(cfg.OperatorFile)
+	sourceFiles = append([]string{cfg.OperatorFile, cfg.MapFile}, files...)
+	cfg.RcvMapFlag = false // Reports are always wanted as transmitter -> receiver here, whatever reception.cfg says
+
+	// Reports are weighted from 1 for the best report an icon is given for, down in equal steps to a
+	// fraction for the worst, or in proportion to their ReportScores if the club has set them
+	levels := reportLevels(icons)
+	weight := make(map[string]float64)
+	for i, level := range levels {
+		weight[level] = float64(len(levels)-i) / float64(len(levels))
+		if best, _ := reportScore(levels[0]); len(cfg.ReportScores) > 0 && best > 0 {
+			score, _ := reportScore(level)
+			weight[level] = math.Max(0, score/best)
+		}
+	}
+
+	scores := make(map[string]*operatorReliability)
+	for _, file := range files {
+		reports, _, transmitters := loadReports(file)
+		session := stationsIn(reports)
+		for station := range transmitters {
+			r := scores[station]
+			if r == nil {
+				r = &operatorReliability{callsign: station}
+				scores[station] = r
+			}
+			r.sessions++
+
+			hearing, score := 0, 0.0
+			for receiver, report := range reports[station] {
+				if _, present := icons[report]; !present || receiver == station {
+					continue
+				}
+				hearing++
+				score += weight[report]
+				if quality, ok := reportScore(report); ok {
+					r.quality += quality
+					r.scored++
+				}
+			}
+			if hearing > 0 {
+				r.heard++
+			}
+			r.hearing += float64(hearing)
+			if len(session) > 1 {
+				r.score += 100 * score / float64(len(session)-1)
+			}
+		}
+	}
+
+	var ranked []*operatorReliability
+	for _, r := range scores {
+		r.hearing /= float64(r.sessions)
+		r.score /= float64(r.sessions)
+		if r.scored > 0 {
+			r.quality /= float64(r.scored)
+		}
+		ranked = append(ranked, r)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		return ranked[i].callsign < ranked[j].callsign
+	})
+
+	if err := os.MkdirAll(cfg.OutputDirectory, 0755); err != nil {
+		fatalf("Failed to create output directory: %s", err)
+	}
+	writeReliability(ranked)
+	fmt.Println("Wrote", filepath.Join(cfg.OutputDirectory, reliabilityFile))
+	writeReliabilityMap(baseMap, icons, operators, ranked, levels, len(files))
+	fmt.Println("Wrote", filepath.Join(cfg.OutputDirectory, reliabilityMapFile))
+}
+
+// Function writeReliability writes the operators' reliability scores to reliability.csv, in the order given
+func writeReliability(ranked []*operatorReliability) {
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, reliabilityFile), func(f io.Writer) error {
+		w := csv.NewWriter(f)
+		w.Write(reliabilityHeadings)
+		for _, r := range ranked {
+			row := []string{r.callsign, strconv.Itoa(r.sessions), strconv.Itoa(r.heard),
+				strconv.FormatFloat(100*float64(r.heard)/float64(r.sessions), 'f', 1, 64),
+				strconv.FormatFloat(r.hearing, 'f', 1, 64), "", strconv.FormatFloat(r.score, 'f', 1, 64)}
+			if r.scored > 0 {
+				row[5] = strconv.FormatFloat(r.quality, 'f', 2, 64)
+			}
+			w.Write(row)
+		}
+		w.Flush()
+		return w.Error()
+	})
+	if err != nil {
+		fatalln("couldn't write the reliability file:", err)
+	}
+}
+
+// Function writeReliabilityMap draws every operator on the roster on the base map, labeled with their score and
+// shown with the icon for the report their score is closest to: the best report's icon for the top scores, down
+// to the worst's for the bottom. Operators who never transmitted are left off, or shown with NoReportIcon if it's set.
+func writeReliabilityMap(baseMap image.Image, icons map[string]image.Image, operators map[string]operatorData, ranked []*operatorReliability, levels []string, sessions int) {
+	mapPtr := image.NewRGBA(baseMap.Bounds())
+	draw.Draw(mapPtr, mapPtr.Bounds(), baseMap, baseMap.Bounds().Min, draw.Src)
+	ctxPtr := newContext(mapPtr)
+
+	scored := make(map[string]bool)
+	absent := noReportIcon(icons)
+	for _, r := range ranked {
+		scored[r.callsign] = true
+	}
+	for _, call := range sortedCalls(operatorCalls(operators)) {
+		if op := operators[call]; !scored[call] && absent != nil {
+			plotIcon(mapPtr, absent, cfg.NoReportIcon, op, ctxPtr)
+		}
+	}
+	for i := len(ranked) - 1; i >= 0; i-- { // Best last, so they're on top
+		r := ranked[i]
+		op := lookupOperator(operators, r.callsign)
+		if op.callsign == "" || len(levels) == 0 {
+			continue
+		}
+		level := levels[len(levels)-1]
+		if step := int((100 - r.score) / 100 * float64(len(levels))); step < len(levels) {
+			level = levels[step]
+		}
+		op.callsign += fmt.Sprintf(" %.0f", r.score)
+		plotIcon(mapPtr, icons[level], level, op, ctxPtr)
+	}
+
+	legend := []string{fmt.Sprintf("Operator reliability over %d sessions", sessions),
+		"Score: 100 = heard by every other station, at the best report, every session"}
+	_, size := legendFont()
+	setFont(ctxPtr, legendFont)
+	ascent := int(size*cfg.FontDPI/72.0 + 0.5)
+	for i, line := range legend {
+		pt := freetype.Pt(ascent, ascent*2+i*int(float64(ascent)*cfg.FontLineSpacing+0.5))
+		if _, err := ctxPtr.DrawString(line, pt); err != nil {
+			fatalln("can't plot reliability map legend", err)
+		}
+	}
+	if cfg.ScaleBarFlag {
+		drawScaleBar(mapPtr, newGeoref(baseMap).metersPerPixel())
+	}
+	if cfg.NorthArrowFlag {
+		drawNorthArrow(mapPtr)
+	}
+	drawAttribution(mapPtr)
+	drawWatermark(mapPtr)
+
+	meta := outputMetadata(legend[0])
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, reliabilityMapFile), func(w io.Writer) error { return encodePNG(w, mapPtr, meta) })
+	if err != nil {
+		fatalf("Failed to write reliability map: %s", err)
+	}
+}
+
+// Function reportLevels returns the reports with a score that there are icons for, best first
+func reportLevels(icons map[string]image.Image) []string {
+	var levels []string
+	for name := range icons {
+		if _, ok := reportScore(name); ok {
+			levels = append(levels, name)
+		}
+	}
+	sort.Slice(levels, func(i, j int) bool { return worseReport(levels[j], levels[i]) })
+	return levels
+}
+
+// Function operatorCalls returns the call signs of the operators on the roster
+func operatorCalls(operators map[string]operatorData) map[string]bool {
+	calls := make(map[string]bool)
+	for call := range operators {
+		calls[call] = true
+	}
+	return calls
+}
@@ -0,0 +1,297 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"image"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Renderer is a file format maps can be written in. Each map is drawn once, then written in each of the formats
+// chosen with -format (cfg.Formats) by its renderer.
+type Renderer interface {
+	extension() string   // Ending of the map's file name, e.g. ".png"
+	contentType() string // MIME type, for serving the map over HTTP
+	render(w io.Writer, m renderedMap) error
+}
+
+// A map as drawn, and what the formats that do more than show the picture need to know about it
+type renderedMap struct {
+	image       image.Image // The map, with everything drawn on it
+	title       string
+	meta        []pngText // Text to embed in formats that can carry it
+	nw, se      gpsCoord  // Corners of the map; places in between are interpolated, which is close enough to show them
+	transmitter marker
+	markers     []marker // The receivers shown on it, positioned in the pixels of image
+	imageHref   string   // Where formats that refer to the PNG rather than including it (KML) find it
+}
+
+// Renderers by the names used with -format
+var renderers = map[string]Renderer{
+	"png":  pngRenderer{},
+	"svg":  svgRenderer{},
+	"pdf":  pdfRenderer{},
+	"kml":  kmlRenderer{},
+	"html": htmlRenderer{},
+}
+
+// Function outputRenderers returns the renderers for the comma-separated formats in cfg.Formats, PNG if there
+// are none, or exits if one isn't known. KML only places the PNG on the globe, so the PNG is always written with it.
+func outputRenderers() []Renderer {
+	var chosen []Renderer
+	names := make(map[string]bool)
+	for _, name := range strings.Split(strings.ToLower(strings.ReplaceAll(cfg.Formats, " ", "")), ",") {
+		if name == "" || names[name] {
+			continue
+		}
+		r, present := renderers[name]
+		if !present {
+			fatalf("unknown format %q; use %s", name, strings.Join(rendererNames(), ", "))
+		}
+		chosen = append(chosen, r)
+		names[name] = true
+	}
+	if len(chosen) == 0 || (names["kml"] && !names["png"]) {
+		chosen = append(chosen, pngRenderer{})
+	}
+	return chosen
+}
+
+// Function rendererNames returns the names of the formats, in alphabetical order
+func rendererNames() []string {
+	var names []string
+	for name := range renderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Function mapCorners returns the GPS coordinates of the corners of part of the base map, interpolating between
+// the base map's corners
+func mapCorners(area, bounds image.Rectangle) (nw, se gpsCoord) {
+	at := func(p image.Point) gpsCoord {
+		fx := float64(p.X-bounds.Min.X) / float64(bounds.Dx())
+		fy := float64(p.Y-bounds.Min.Y) / float64(bounds.Dy())
+		return gpsCoord{cfg.MapNWCorner[0] + fy*(cfg.MapSECorner[0]-cfg.MapNWCorner[0]),
+			cfg.MapNWCorner[1] + fx*(cfg.MapSECorner[1]-cfg.MapNWCorner[1])}
+	}
+	return at(area.Min), at(area.Max)
+}
+
+// Function reportDescription returns a report with what it means, if the [[Icons]] tables or ReportNames say,
+// e.g. "1 (Good)"
+func reportDescription(report string) string {
+	if name := reportName(report); name != "" {
+		return report + " (" + name + ")"
+	}
+	return report
+}
+
+// Function markerStations returns the stations a marker stands for: those of a cluster, or else itself
+func markerStations(m marker) []marker {
+	if m.cluster {
+		return m.members
+	}
+	return []marker{m}
+}
+
+// Function markerTooltip describes the stations a marker stands for, a line each, e.g. "K7ABC: 1 (Good)"
+func markerTooltip(m marker) string {
+	var lines []string
+	for _, s := range markerStations(m) {
+		lines = append(lines, s.operator.callsign+": "+reportDescription(s.report))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Function pngBase64 returns a map's image as a PNG, base64-encoded for a data: URL
+func pngBase64(m renderedMap) (string, error) {
+	var buf bytes.Buffer
+	if err := encodePNG(&buf, m.image, m.meta); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// Function xmlEscape escapes text for XML
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// The map as a PNG image, with its metadata, as maps have always been written
+type pngRenderer struct{}
+
+func (pngRenderer) extension() string   { return ".png" }
+func (pngRenderer) contentType() string { return "image/png" }
+
+func (pngRenderer) render(w io.Writer, m renderedMap) error {
+	return encodePNG(w, m.image, m.meta)
+}
+
+// The map as an SVG file: the picture, with an invisible circle over each station that shows its call sign and
+// report when pointed at, in a browser
+type svgRenderer struct{}
+
+func (svgRenderer) extension() string   { return ".svg" }
+func (svgRenderer) contentType() string { return "image/svg+xml" }
+
+func (svgRenderer) render(w io.Writer, m renderedMap) error {
+	data, err := pngBase64(m)
+	if err != nil {
+		return err
+	}
+	b := m.image.Bounds()
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" xmlns:xlink=\"http://www.w3.org/1999/xlink\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+		b.Dx(), b.Dy(), b.Dx(), b.Dy())
+	fmt.Fprintf(w, "<title>%s</title>\n", xmlEscape(m.title))
+	fmt.Fprintf(w, "<image width=\"%d\" height=\"%d\" xlink:href=\"data:image/png;base64,%s\"/>\n", b.Dx(), b.Dy(), data)
+	fmt.Fprintf(w, "<g fill=\"transparent\">\n")
+	radius := float64(cfg.IconSize) / 2
+	for _, s := range append(m.markers, m.transmitter) {
+		if s.operator.callsign == "" {
+			continue
+		}
+		p := s.operator.pixel.Sub(b.Min)
+		fmt.Fprintf(w, "<circle cx=\"%d\" cy=\"%d\" r=\"%.1f\"><title>%s</title></circle>\n", p.X, p.Y, radius, xmlEscape(markerTooltip(s)))
+	}
+	_, err = fmt.Fprintf(w, "</g>\n</svg>\n")
+	return err
+}
+
+// The map as a one-page PDF, for printing or mailing
+type pdfRenderer struct{}
+
+func (pdfRenderer) extension() string   { return ".pdf" }
+func (pdfRenderer) contentType() string { return "application/pdf" }
+
+func (pdfRenderer) render(w io.Writer, m renderedMap) error {
+	return writeImagePDF(w, m.image, m.title)
+}
+
+// The map as KML for Google Earth: the PNG laid over the ground where it belongs, and a placemark for each
+// station
+type kmlRenderer struct{}
+
+func (kmlRenderer) extension() string   { return ".kml" }
+func (kmlRenderer) contentType() string { return "application/vnd.google-earth.kml+xml" }
+
+func (kmlRenderer) render(w io.Writer, m renderedMap) error {
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<kml xmlns=\"http://www.opengis.net/kml/2.2\">\n<Document>\n")
+	fmt.Fprintf(w, "<name>%s</name>\n", xmlEscape(m.title))
+	fmt.Fprintf(w, "<GroundOverlay>\n<name>%s</name>\n<Icon><href>%s</href></Icon>\n", xmlEscape(m.title), xmlEscape(m.imageHref))
+	fmt.Fprintf(w, "<LatLonBox><north>%f</north><south>%f</south><east>%f</east><west>%f</west></LatLonBox>\n</GroundOverlay>\n",
+		m.nw.lat, m.se.lat, m.se.long, m.nw.long)
+
+	fmt.Fprintf(w, "<Folder>\n<name>Stations</name>\n")
+	stations := []marker{m.transmitter}
+	for _, marker := range m.markers {
+		stations = append(stations, markerStations(marker)...)
+	}
+	for i, s := range stations {
+		if s.operator.callsign == "" {
+			continue
+		}
+		description := "Report " + reportDescription(s.report)
+		if i == 0 {
+			description = "Transmitter"
+		}
+		fmt.Fprintf(w, "<Placemark><name>%s</name><description>%s</description><Point><coordinates>%f,%f</coordinates></Point></Placemark>\n",
+			xmlEscape(s.operator.callsign), xmlEscape(description), s.operator.gps.long, s.operator.gps.lat)
+	}
+	_, err := fmt.Fprintf(w, "</Folder>\n</Document>\n</kml>\n")
+	return err
+}
+
+// The map as a web page with the picture built in, so it's a single file, and a table of who's on it. Pointing
+// at a station on the map shows its call sign and report.
+type htmlRenderer struct{}
+
+func (htmlRenderer) extension() string   { return ".html" }
+func (htmlRenderer) contentType() string { return "text/html; charset=utf-8" }
+
+// A row of the table on a map's web page
+type htmlStation struct {
+	Call, Report string
+}
+
+// A place on a map's web page that shows a station when pointed at
+type htmlArea struct {
+	X, Y, R int
+	Tooltip string
+}
+
+func (htmlRenderer) render(w io.Writer, m renderedMap) error {
+	data, err := pngBase64(m)
+	if err != nil {
+		return err
+	}
+	page := struct {
+		Title     string
+		Image     template.URL
+		Frequency string
+		Areas     []htmlArea
+		Stations  []htmlStation
+	}{Title: m.title, Image: template.URL("data:image/png;base64," + data), Frequency: cfg.Frequency}
+
+	b := m.image.Bounds()
+	for _, s := range m.markers {
+		p := s.operator.pixel.Sub(b.Min)
+		page.Areas = append(page.Areas, htmlArea{p.X, p.Y, int(cfg.IconSize) / 2, markerTooltip(s)})
+		for _, station := range markerStations(s) {
+			page.Stations = append(page.Stations, htmlStation{station.operator.callsign, reportDescription(station.report)})
+		}
+	}
+	sort.Slice(page.Stations, func(i, j int) bool { return page.Stations[i].Call < page.Stations[j].Call })
+	return mapPageTemplate.Execute(w, page)
+}
+
+// Template for a map's web page
+var mapPageTemplate = template.Must(template.New("map").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+img { border: 1px solid #ccc; }
+table { border-collapse: collapse; margin-top: 1em; }
+td, th { padding: 0.2em 1em; text-align: left; border-bottom: 1px solid #ddd; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Frequency: {{.Frequency}}</p>
+<img src="{{.Image}}" usemap="#stations" alt="{{.Title}}">
+<map name="stations">
+{{range .Areas}}<area shape="circle" coords="{{.X}},{{.Y}},{{.R}}" title="{{.Tooltip}}" alt="{{.Tooltip}}">
+{{end}}</map>
+<table>
+<tr><th>Station</th><th>Report</th></tr>
+{{range .Stations}}<tr><td>{{.Call}}</td><td>{{.Report}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
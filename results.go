@@ -0,0 +1,115 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Name of the results file within the output directory
+const resultsFile = "results.json"
+
+// What happened in a run, for programs (such as a web site generator) that need to know what was generated
+// without scraping our output
+type runResults struct {
+	Generated string // When the run started, in RFC 3339 format
+	Frequency string
+	Maps      []mapDetail
+}
+
+// One map generated in a run, with which receivers made it onto the map and which didn't
+type mapDetail struct {
+	mapResult
+	Plotted []string          // Call signs of the receivers plotted on the map
+	Skipped []skippedReceiver // Receivers left off the map
+}
+
+// A receiver left off a map, and why
+type skippedReceiver struct {
+	Callsign string
+	Reason   string
+}
+
+// Function receiverDetails sorts a map's receivers into those plotted on it and those that weren't, along with the
+// reason each was left off. Plotted is the list of markers actually drawn, after any thinning.
+func receiverDetails(result mapResult, receivers map[string]bool, reports map[string]map[string]string,
+	operators map[string]operatorData, icons map[string]image.Image, plotted []marker) mapDetail {
+
+	// A cluster marker is labeled with one station's call sign, followed by how many others it stands for
+	drawn, clustered := make(map[string]bool), make(map[string]bool)
+	for _, m := range plotted {
+		if fields := strings.Fields(m.operator.callsign); len(fields) > 0 {
+			drawn[fields[0]] = true
+		}
+		for _, member := range m.members {
+			clustered[member.operator.callsign] = true
+		}
+	}
+
+	detail := mapDetail{mapResult: result, Plotted: []string{}, Skipped: []skippedReceiver{}}
+	for _, receiver := range sortedCalls(receivers) {
+		if receiver == result.Transmitter {
+			continue
+		}
+
+		var reason string
+		report := reports[result.Transmitter][receiver]
+		switch _, present := icons[report]; {
+		case report == "":
+			reason = "no report"
+		case !present:
+			reason = fmt.Sprintf("no icon for report %q", report)
+		case lookupOperator(operators, receiver).callsign == "":
+			reason = "not in operator file"
+		case !drawn[receiver] && clustered[receiver]:
+			reason = "merged into a cluster of overlapping stations"
+		case !drawn[receiver]:
+			reason = "overlaps a station with worse reception"
+		default:
+			detail.Plotted = append(detail.Plotted, receiver)
+			continue
+		}
+		detail.Skipped = append(detail.Skipped, skippedReceiver{receiver, reason})
+	}
+	return detail
+}
+
+// Function writeResults writes the results file for this run, with the maps in order of transmitter call sign,
+// and returns its name relative to the output directory
+func writeResults(maps []mapDetail) string {
+	sort.Slice(maps, func(i, j int) bool { return maps[i].Transmitter < maps[j].Transmitter })
+	results := runResults{
+		Generated: startTime.Format(time.RFC3339),
+		Frequency: cfg.Frequency,
+		Maps:      maps,
+	}
+
+	encoded, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		fatalln("can't encode results", err)
+	}
+	resultsPath := filepath.Join(cfg.OutputDirectory, resultsFile)
+	if err := writeFileAtomic(resultsPath, func(w io.Writer) error { _, err := w.Write(encoded); return err }); err != nil {
+		fatalln("can't write", resultsPath, err)
+	}
+	return resultsFile
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Function uploadToS3 uploads files from the output directory to an S3-compatible bucket (AWS, MinIO,
+// DigitalOcean Spaces, Backblaze B2 and so on), under cfg.S3Prefix. File names are relative to the output
+// directory and keep their relative paths in the bucket, so the index page's links still work.
+//
+// Credentials come from cfg.S3AccessKey and cfg.S3SecretKey, or if those are empty, from the usual
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables, which keeps secrets out of reception.cfg.
+func uploadToS3(files []string) {
+	accessKey, secretKey := cfg.S3AccessKey, cfg.S3SecretKey
+	if accessKey == "" {
+		accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if secretKey == "" {
+		secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if accessKey == "" || secretKey == "" {
+		fatalln("no S3 credentials; set S3AccessKey and S3SecretKey, or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+
+	endpoint, err := url.Parse(cfg.S3Endpoint)
+	if err != nil || endpoint.Host == "" {
+		fatalf("S3Endpoint %q in reception.cfg isn't a valid URL", cfg.S3Endpoint)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(filepath.Join(cfg.OutputDirectory, file))
+		if err != nil {
+			fatalln("can't open", file, "for upload", err)
+		}
+
+		// Path-style addressing (endpoint/bucket/key) works with every S3-compatible service
+		key := strings.Trim(cfg.S3Prefix, "/") + "/" + file
+		objectURL := *endpoint
+		objectURL.Path = "/" + cfg.S3Bucket + "/" + strings.TrimPrefix(key, "/")
+
+		req, err := http.NewRequest(http.MethodPut, objectURL.String(), bytes.NewReader(content))
+		if err != nil {
+			fatalln("can't upload", file, "to S3", err)
+		}
+		if mediaType := mime.TypeByExtension(filepath.Ext(file)); mediaType != "" {
+			req.Header.Set("Content-Type", mediaType)
+		}
+		signS3Request(req, content, accessKey, secretKey, cfg.S3Region, time.Now())
+
+		resp, err := client.Do(req)
+		if err != nil {
+			fatalln("can't upload", file, "to S3", err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			fatalf("can't upload %s to S3: %s %s", file, resp.Status, body)
+		}
+	}
+
+	fmt.Printf("Uploaded %d files to s3://%s/%s\n", len(files), cfg.S3Bucket, strings.Trim(cfg.S3Prefix, "/"))
+}
+
+// Function signS3Request signs a request with AWS Signature Version 4, adding the x-amz-date,
+// x-amz-content-sha256 and Authorization headers. Every header already on the request is signed, along with
+// the host.
+func signS3Request(req *http.Request, payload []byte, accessKey, secretKey, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Canonical headers are lowercased, sorted, and include the host, which Go keeps out of req.Header
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(), // Encode sorts by key, as the canonical form requires
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+// Function sha256Hex returns the hex-encoded SHA-256 hash of some data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Function hmacSHA256 returns the HMAC-SHA256 of a message
+func hmacSHA256(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Colors of the scale bar and north arrow, and of the outline that keeps them readable over dark parts of the map
+var (
+	scaleBarColor   = color.RGBA{0x10, 0x10, 0x10, 0xff}
+	scaleBarOutline = color.RGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+// Meters in a mile
+const metersPerMile = 1609.344
+
+// Function drawScaleBar draws two scale bars in the lower right corner of a map, above the attribution: one a
+// round number of kilometers long, and one a round number of miles. Each is at most a fifth of the map's width.
+func drawScaleBar(dstPtr *image.RGBA, metersPerPixel float64) {
+	if metersPerPixel <= 0 {
+		return
+	}
+
+	face := truetype.NewFace(loadFont(), &truetype.Options{Size: cfg.FontSize * 0.75, DPI: cfg.FontDPI})
+	ascent := face.Metrics().Ascent.Ceil()
+	lineHeight := int(float64(ascent) * cfg.FontLineSpacing * 1.5)
+	width := cfg.FontSize / 4
+
+	maxLength := float64(dstPtr.Bounds().Dx()) / 5
+	km := roundDistance(maxLength * metersPerPixel / 1000)
+	kmLabel := fmt.Sprintf("%g km", km)
+	if km < 1 {
+		kmLabel = fmt.Sprintf("%.0f m", km*1000)
+	}
+	miles := roundDistance(maxLength * metersPerPixel / metersPerMile)
+	bars := []struct {
+		label  string
+		length int
+	}{
+		{kmLabel, int(km*1000/metersPerPixel + 0.5)},
+		{fmt.Sprintf("%g mi", miles), int(miles*metersPerMile/metersPerPixel + 0.5)},
+	}
+
+	// Line the bars up on the left, with their labels after them, far enough from the right edge for the
+	// longer bar and label
+	right := 0
+	for _, bar := range bars {
+		if r := bar.length + ascent/2 + font.MeasureString(face, bar.label).Ceil(); r > right {
+			right = r
+		}
+	}
+	bounds := dstPtr.Bounds()
+	left := bounds.Max.X - ascent*2 - right
+	y := bounds.Max.Y - ascent*3 - lineHeight*len(bars)
+
+	for _, bar := range bars {
+		y += lineHeight
+		from, to := image.Point{left, y}, image.Point{left + bar.length, y}
+		tick := image.Point{0, ascent / 2}
+		for _, c := range []struct {
+			color color.Color
+			width float64
+		}{{scaleBarOutline, width + 3}, {scaleBarColor, width}} {
+			drawLine(dstPtr, from, to, c.width, c.color, false)
+			drawLine(dstPtr, from.Sub(tick), from, c.width, c.color, false)
+			drawLine(dstPtr, to.Sub(tick), to, c.width, c.color, false)
+		}
+
+		d := font.Drawer{
+			Dst:  dstPtr,
+			Src:  &image.Uniform{scaleBarColor},
+			Face: face,
+			Dot:  fixed.P(to.X+ascent/2, y),
+		}
+		d.DrawString(bar.label)
+	}
+}
+
+// Function roundDistance returns the largest 1, 2 or 5 times a power of ten that's no more than a distance, so
+// scale bars are a length that's easy to read
+func roundDistance(max float64) float64 {
+	power := math.Pow(10, math.Floor(math.Log10(max)))
+	for _, m := range []float64{5, 2, 1} {
+		if m*power <= max {
+			return m * power
+		}
+	}
+	return power
+}
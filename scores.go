@@ -0,0 +1,78 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"strconv"
+)
+
+// The worst quality level among the report icons, or the highest Order in the [[Icons]] tables: the level that
+// scores 1 when ReportScores isn't set. It's set when the icons are loaded.
+var worstLevel int
+
+// Function setScoreScale sets the scale reports are scored on without ReportScores from the report icons, so the
+// worst report with an icon scores 1 and each better one a point more
+func setScoreScale(icons map[string]image.Image) {
+	worstLevel = 0
+	for _, e := range cfg.Icons {
+		if e.Order > worstLevel {
+			worstLevel = e.Order
+		}
+	}
+	if len(cfg.Icons) > 0 {
+		return
+	}
+	for report := range icons {
+		if level, err := strconv.Atoi(report); err == nil && level > worstLevel {
+			worstLevel = level
+		}
+	}
+}
+
+// Function reportScore returns the number a report counts as in averages, heatmaps and scores, which is always
+// higher for better reception: its score from ReportScores if the club has set them, otherwise its place on the
+// scale of quality levels (the report itself if it's a number, or its Order in the [[Icons]] tables, where 1 is
+// the best), counting up from 1 for the worst level. ok is false for a report that has no score.
+func reportScore(report string) (score float64, ok bool) {
+	if len(cfg.ReportScores) > 0 {
+		score, ok = cfg.ReportScores[report]
+		return score, ok
+	}
+	level, ok := iconOrder(report)
+	if len(cfg.Icons) == 0 {
+		var err error
+		level, err = strconv.Atoi(report)
+		ok = err == nil
+	}
+	return float64(worstLevel + 1 - level), ok
+}
+
+// Function worseReport reports whether report a indicates worse reception than report b, by their scores.
+// Anything without a score counts as worse than any report with one.
+func worseReport(a, b string) bool {
+	aScore, aOK := reportScore(a)
+	bScore, bOK := reportScore(b)
+	switch {
+	case aOK && bOK:
+		return aScore < bScore
+	case aOK:
+		return false
+	case bOK:
+		return true
+	default:
+		return a > b
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The reports as one type of map needs them: receive maps have transmitters and receivers swapped
+type mapReports struct {
+	reports                 map[string]map[string]string
+	receivers, transmitters map[string]bool
+}
+
+// A server drawing maps when they're asked for. Maps are drawn one at a time, since drawing sets cfg, and kept
+// until the operator or report file changes.
+type mapServer struct {
+	lock       sync.Mutex
+	icons      map[string]image.Image
+	absentIcon image.Image
+	baseMaps   []*baseMapChoice
+	layers     map[*baseMapChoice]*mapLayers
+	operators  map[string]operatorData
+	loaded     map[string]*mapReports // By map type
+	modTime    time.Time              // When the operator or report file last changed, as of loading them
+	tried      time.Time              // The same, as of last trying to load them, whether or not that worked
+	cache      map[string][]byte      // PNG of each map drawn since, by map type and call sign
+}
+
+// Function serveCommand serves maps over HTTP, drawing each the first time it's asked for, so a website can link
+// to maps that are always current: /map/K7ABC is K7ABC's transmit map, and /map/K7ABC?type=rcvr its receive map.
+// The operator and report files are read again whenever they change. The home page lets anyone make maps from
+// report and operator files of their own, without the command line.
+func serveCommand(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "Address to listen on, as host:port")
+	flags.Parse(args)
+
+	if cfg.NeighborhoodFile != "" {
+		cfg.Neighborhoods = append(cfg.Neighborhoods, loadNeighborhoodFile(cfg.NeighborhoodFile)...)
+	}
+	if cfg.NeighborhoodOverlay {
+		checkNeighborhoods()
+	}
+	s := &mapServer{icons: loadIcons(cfg.IconDirectory), layers: make(map[*baseMapChoice]*mapLayers)}
+	baseMap := loadBaseMap(cfg.MapFile)
+	gpsToPixel = newGpsToPixel(baseMap)
+	s.baseMaps = loadBaseMaps(baseMap)
+	finishBaseMaps(s.baseMaps)
+	if cfg.GrayscaleFlag {
+		s.icons = shapeIcons(s.icons)
+	}
+	s.absentIcon = noReportIcon(s.icons)
+	fatalStatus = exitDataError // The files have to load when the server starts
+	s.refresh()
+	fatalStatus = exitConfigError // Once they have, it's only the address that can be wrong
+
+	http.Handle("/map/", s)
+	http.HandleFunc("/", s.uploadPage)
+	http.HandleFunc("/upload", s.upload)
+	fmt.Printf("Serving maps at http://%s/map/CALL, and a page for making maps from uploaded files at http://%s/\n", *addr, *addr)
+	fatal(http.ListenAndServe(*addr, nil))
+}
+
+// Function ServeHTTP answers a request for a map: /map/CALL, with type=xmit (the default) or type=rcvr, and
+// format=png (the default) or any other format -format takes
+func (s *mapServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	call := strings.ToUpper(strings.TrimPrefix(r.URL.Path, "/map/"))
+	mapType := r.URL.Query().Get("type")
+	if mapType == "" {
+		mapType = xmitMapType
+	}
+	if mapType != xmitMapType && mapType != rcvrMapType {
+		http.Error(w, fmt.Sprintf("unknown map type %q; use %q or %q", mapType, xmitMapType, rcvrMapType), http.StatusBadRequest)
+		return
+	}
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = "png"
+	}
+	renderer, present := renderers[format]
+	if !present {
+		http.Error(w, fmt.Sprintf("unknown format %q; use %s", format, strings.Join(rendererNames(), ", ")), http.StatusBadRequest)
+		return
+	}
+
+	// KML refers to the PNG it lays over the ground, which is served too
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	imageHref := fmt.Sprintf("%s://%s/map/%s?type=%s&format=png", scheme, r.Host, url.PathEscape(call), mapType)
+
+	data, modTime, found := s.mapFile(call, mapType, format, renderer, imageHref)
+	if !found {
+		http.Error(w, "no reports for "+call, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", renderer.contentType())
+	http.ServeContent(w, r, call+renderer.extension(), modTime, bytes.NewReader(data))
+}
+
+// Function mapFile returns a map in a format, drawing it if it hasn't been drawn since the operator and report
+// files last changed, and when they changed. Found is false if there are no reports for the call sign.
+func (s *mapServer) mapFile(call, mapType, format string, renderer Renderer, imageHref string) (data []byte, modTime time.Time, found bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.refresh()
+
+	key := mapType + "/" + call + "." + format
+	if data, present := s.cache[key]; present {
+		return data, s.modTime, true
+	}
+	if !s.loaded[mapType].transmitters[call] {
+		return nil, s.modTime, false
+	}
+	data = s.draw(call, mapType, s.loaded[mapType], s.operators, renderer, imageHref)
+	s.cache[key] = data
+	return data, s.modTime, true
+}
+
+// Function refresh loads the operator and report files if they've changed since they were last loaded, which throws
+// away the maps drawn from them and looks the fonts up again. Files that can't be loaded, such as a report file
+// caught halfway through being edited, are logged and the ones loaded before are kept, until the files change
+// again; only when the server starts do they have to load.
+func (s *mapServer) refresh() {
+	var modTime time.Time
+	for _, file := range []string{cfg.OperatorFile, cfg.ReportFile} {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if s.loaded != nil && !modTime.After(s.tried) {
+		return
+	}
+	s.tried = modTime
+
+	s.baseMaps[0].use() // Operators are placed on the main base map
+	operators, err := readOperators(cfg.OperatorFile)
+	loaded := make(map[string]*mapReports)
+	for _, mapType := range []string{xmitMapType, rcvrMapType} {
+		if err != nil {
+			break
+		}
+		cfg.RcvMapFlag = mapType == rcvrMapType
+		r := &mapReports{}
+		r.reports, r.receivers, r.transmitters, err = readReports(cfg.ReportFile)
+		loaded[mapType] = r
+	}
+	switch {
+	case err != nil && s.loaded == nil:
+		fatalln(err)
+	case err != nil:
+		log.Println("keeping the maps from the files as they were:", err)
+		return
+	}
+
+	s.operators, s.loaded = operators, loaded
+	setSourceFiles(cfg.OperatorFile, cfg.ReportFile, cfg.MapFile)
+	reloadFonts()
+	s.modTime, s.cache = modTime, make(map[string][]byte)
+	fmt.Printf("Loaded %s and %s\n", cfg.OperatorFile, cfg.ReportFile)
+}
+
+// Function draw draws a transmitter's map from the given reports and operators, as reception.cfg says to, and
+// returns it in a renderer's format. ImageHref is where KML finds the PNG.
+func (s *mapServer) draw(call, mapType string, loaded *mapReports, operators map[string]operatorData, renderer Renderer, imageHref string) []byte {
+	cfg.RcvMapFlag = mapType == rcvrMapType
+	markers, transmitterMarker := transmitterMarkers(call, loaded.receivers, loaded.reports, operators, s.icons, s.absentIcon)
+	choice, markers, transmitterMarker := placeMarkers(s.baseMaps, markers, transmitterMarker)
+	choice.use()
+	if s.layers[choice] == nil {
+		s.layers[choice] = newMapLayers(choice.image)
+	}
+
+	layers := s.layers[choice]
+	mapImage, ref, area := choice.image, newGeoref(choice.image), choice.image.Bounds()
+	if cfg.CropFlag {
+		area = cropArea(mapImage.Bounds(), markers, transmitterMarker)
+		mapImage, markers, transmitterMarker = cropMap(mapImage, area, markers, transmitterMarker)
+		layers = newMapLayers(mapImage)
+		defer layers.release()
+		ref = ref.crop(area.Min)
+	}
+	plotted := drawMap(layers, mapImage, ref.metersPerPixel(), s.icons, call, markers, transmitterMarker)
+
+	title := localText("%s for %s", mapTypeName(mapType), call)
+	rendered := renderedMap{image: layers.outputMapPtr, title: title, transmitter: transmitterMarker, markers: plotted, imageHref: imageHref,
+		meta: outputMetadata(title, pngText{"Transmitter", call}, pngText{"Map Type", mapType})}
+	rendered.nw, rendered.se = mapCorners(area, choice.image.Bounds())
+	var buf bytes.Buffer
+	if err := renderer.render(&buf, rendered); err != nil {
+		fatalln("can't write map for", call, err)
+	}
+	return buf.Bytes()
+}
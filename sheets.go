@@ -0,0 +1,140 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Google Sheets API endpoint and scope, and the tab the statistics go on when SheetTab isn't set
+const (
+	sheetsScope      = "https://www.googleapis.com/auth/spreadsheets"
+	sheetsURL        = "https://sheets.googleapis.com/v4/spreadsheets/"
+	defaultSheetTab  = "Results"
+	sheetsTimeFormat = "2006-01-02 15:04"
+)
+
+// Function appendSheetStats adds a row of statistics for each transmitter mapped this run to the SheetTab tab of
+// the Google Sheet cfg.SheetID, usually the one the net's reports are collected in, so the net manager sees how
+// each station did next to what was reported. The columns are those of stats.csv, with the time of the run in
+// place of its date. A tab that isn't there yet is added, and a new or empty one gets a heading row first.
+func appendSheetStats(transmitters map[string]bool, reports map[string]map[string]string, operators map[string]operatorData, icons map[string]image.Image) {
+	if cfg.SheetID == "" {
+		fatalStatus = exitConfigError
+		fatalln("SheetFlag is set, but reception.cfg has no SheetID to write the statistics to")
+	}
+	tab := cfg.SheetTab
+	if tab == "" {
+		tab = defaultSheetTab
+	}
+	client := newGoogleClient(sheetsScope)
+
+	var rows [][]string
+	if !hasSheetTab(client, tab) {
+		addSheetTab(client, tab)
+		rows = append(rows, statsHeadings)
+	} else if sheetTabEmpty(client, tab) {
+		rows = append(rows, statsHeadings)
+	}
+	when, mapType := startTime.Format(sheetsTimeFormat), currentMapType()
+	for _, transmitter := range sortedCalls(transmitters) {
+		rows = append(rows, statsRow(when, mapType, computeStats(transmitter, reports, operators, icons)))
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"values": rows})
+	resp, err := client.Post(sheetsURL+url.PathEscape(cfg.SheetID)+"/values/"+url.PathEscape(sheetRange(tab, "A1"))+
+		":append?valueInputOption=USER_ENTERED&insertDataOption=INSERT_ROWS", "application/json", bytes.NewReader(body))
+	if err != nil {
+		fatalln("can't add statistics to Google Sheet", err)
+	}
+	sheetsResponse(resp, nil)
+
+	fmt.Printf("Added %d rows to the %s tab of the Google Sheet\n", len(transmitters), tab)
+}
+
+// Function hasSheetTab reports whether the Google Sheet has a tab with the given name
+func hasSheetTab(client *http.Client, tab string) bool {
+	resp, err := client.Get(sheetsURL + url.PathEscape(cfg.SheetID) + "?fields=sheets.properties.title")
+	if err != nil {
+		fatalln("can't read Google Sheet", err)
+	}
+	var spreadsheet struct {
+		Sheets []struct {
+			Properties struct {
+				Title string `json:"title"`
+			} `json:"properties"`
+		} `json:"sheets"`
+	}
+	sheetsResponse(resp, &spreadsheet)
+	for _, s := range spreadsheet.Sheets {
+		if s.Properties.Title == tab {
+			return true
+		}
+	}
+	return false
+}
+
+// Function addSheetTab adds a tab with the given name to the Google Sheet
+func addSheetTab(client *http.Client, tab string) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"requests": []interface{}{map[string]interface{}{"addSheet": map[string]interface{}{
+			"properties": map[string]string{"title": tab},
+		}}},
+	})
+	resp, err := client.Post(sheetsURL+url.PathEscape(cfg.SheetID)+":batchUpdate", "application/json", bytes.NewReader(body))
+	if err != nil {
+		fatalln("can't add tab", tab, "to Google Sheet", err)
+	}
+	sheetsResponse(resp, nil)
+}
+
+// Function sheetTabEmpty reports whether a tab of the Google Sheet has nothing in its first cell, as a tab the
+// statistics haven't been written to yet doesn't
+func sheetTabEmpty(client *http.Client, tab string) bool {
+	resp, err := client.Get(sheetsURL + url.PathEscape(cfg.SheetID) + "/values/" + url.PathEscape(sheetRange(tab, "A1")))
+	if err != nil {
+		fatalln("can't read Google Sheet", err)
+	}
+	var values struct {
+		Values [][]interface{} `json:"values"`
+	}
+	sheetsResponse(resp, &values)
+	return len(values.Values) == 0
+}
+
+// Function sheetRange returns a range of cells on a tab in the Sheets API's A1 notation, e.g. 'Results'!A1. The
+// tab's name is quoted, so names with spaces work.
+func sheetRange(tab, cells string) string {
+	return "'" + strings.ReplaceAll(tab, "'", "''") + "'!" + cells
+}
+
+// Function sheetsResponse checks that a Sheets API call worked, and decodes its response into v unless v is nil
+func sheetsResponse(resp *http.Response, v interface{}) {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fatalln("can't update Google Sheet:", googleAPIError(resp))
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			fatalln("can't parse Google Sheets response", err)
+		}
+	}
+}
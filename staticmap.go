@@ -0,0 +1,177 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg" // Some map services send JPEG images
+	"image/png"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where the base map comes from
+const (
+	mapSourceFile   = "file"   // MapFile, an image the user made
+	mapSourceStatic = "static" // Downloaded from a static map service
+	mapSourceTiles  = "tiles"  // Stitched together from tiles downloaded from a tile server
+)
+
+// Default static map service: OpenStreetMap's, which takes the center, zoom level and size of the map
+const defaultStaticMapURL = "https://staticmap.openstreetmap.de/staticmap.php?center={lat},{long}&zoom={zoom}&size={width}x{height}&maptype=mapnik"
+
+// Size of a Web Mercator tile in pixels; a map at zoom level z is 256 * 2^z pixels around the world
+const mercatorTileSize = 256
+
+// Fraction of the operators' extent added on each side when the map's corners are worked out automatically, so
+// stations at the edges aren't cut off
+const autoMapMargin = 0.1
+
+// Function fetchStaticMap downloads a base map covering the area between MapNWCorner and MapSECorner, or around
+// all the operators if the corners aren't set, from a static map service. Maps are cached in MapCacheDirectory,
+// so the service is only asked once for each area. The service draws maps centered on a point at a fixed set of
+// zoom levels, so the map is the largest that fits in StaticMapSize pixels, and the corners are updated to match
+// it exactly. It returns the name of the cached file.
+func fetchStaticMap() string {
+	nw, se := staticMapArea()
+	zoom := fitZoom(nw, se)
+	nwPixel, sePixel := mercatorPixel(nw, zoom), mercatorPixel(se, zoom)
+	width, height := int(math.Ceil(sePixel[0]-nwPixel[0])), int(math.Ceil(sePixel[1]-nwPixel[1]))
+	centerX, centerY := (nwPixel[0]+sePixel[0])/2, (nwPixel[1]+sePixel[1])/2
+	center := mercatorCoord(centerX, centerY, zoom)
+
+	// The map the service sends is exactly width by height pixels around the center, so those are its corners
+	nw = mercatorCoord(centerX-float64(width)/2, centerY-float64(height)/2, zoom)
+	se = mercatorCoord(centerX+float64(width)/2, centerY+float64(height)/2, zoom)
+	cfg.MapNWCorner = []float64{nw.lat, nw.long}
+	cfg.MapSECorner = []float64{se.lat, se.long}
+
+	template := cfg.StaticMapURL
+	if template == "" {
+		template = defaultStaticMapURL
+	}
+	url := strings.NewReplacer(
+		"{lat}", strconv.FormatFloat(center.lat, 'f', 6, 64),
+		"{long}", strconv.FormatFloat(center.long, 'f', 6, 64),
+		"{zoom}", strconv.Itoa(zoom),
+		"{width}", strconv.Itoa(width),
+		"{height}", strconv.Itoa(height)).Replace(template)
+
+	cacheFile := filepath.Join(cfg.MapCacheDirectory, "static-"+sha256Hex([]byte(url))[:16]+".png")
+	if _, err := os.Stat(cacheFile); err == nil {
+		fmt.Println("Using cached base map", cacheFile)
+		return cacheFile
+	}
+
+	fmt.Println("Downloading base map from", url)
+	mapImage := downloadImage(url)
+	if err := os.MkdirAll(cfg.MapCacheDirectory, 0755); err != nil {
+		fatalln("can't create map cache directory", err)
+	}
+	if err := writeFileAtomic(cacheFile, func(w io.Writer) error { return png.Encode(w, mapImage) }); err != nil {
+		fatalln("can't save base map", cacheFile, err)
+	}
+	return cacheFile
+}
+
+// Function staticMapArea returns the corners of the area the base map should cover: MapNWCorner and MapSECorner
+// if they're set, and otherwise the extent of the operators' locations with a margin around it
+func staticMapArea() (nw, se gpsCoord) {
+	if len(cfg.MapNWCorner) == 2 && len(cfg.MapSECorner) == 2 && (cfg.MapNWCorner[0] != cfg.MapSECorner[0] || cfg.MapNWCorner[1] != cfg.MapSECorner[1]) {
+		return gpsCoord{cfg.MapNWCorner[0], cfg.MapNWCorner[1]}, gpsCoord{cfg.MapSECorner[0], cfg.MapSECorner[1]}
+	}
+
+	nw, se = gpsCoord{-90, 180}, gpsCoord{90, -180}
+	for call, record := range readOperatorRecords(cfg.OperatorFile) {
+		lat, errLat := strconv.ParseFloat(record[1], 64)
+		long, errLong := strconv.ParseFloat(record[2], 64)
+		if errLat != nil || errLong != nil {
+			fatalln("can't parse location of", call, "in operator CSV")
+		}
+		nw.lat, nw.long = math.Max(nw.lat, lat), math.Min(nw.long, long)
+		se.lat, se.long = math.Min(se.lat, lat), math.Max(se.long, long)
+	}
+	if nw.lat < se.lat {
+		fatalln("can't work out the base map's corners: there are no operators in", cfg.OperatorFile)
+	}
+
+	latMargin, longMargin := (nw.lat-se.lat)*autoMapMargin, (se.long-nw.long)*autoMapMargin
+	return gpsCoord{nw.lat + latMargin, nw.long - longMargin}, gpsCoord{se.lat - latMargin, se.long + longMargin}
+}
+
+// Function fitZoom returns the highest zoom level at which the area between two corners fits in StaticMapSize
+// pixels
+func fitZoom(nw, se gpsCoord) int {
+	zoom := 19
+	for ; zoom > 0; zoom-- {
+		nwPixel, sePixel := mercatorPixel(nw, zoom), mercatorPixel(se, zoom)
+		if sePixel[0]-nwPixel[0] <= float64(cfg.StaticMapSize) && sePixel[1]-nwPixel[1] <= float64(cfg.StaticMapSize) {
+			break
+		}
+	}
+	return zoom
+}
+
+// Function mercatorPixel returns the Web Mercator pixel coordinates of a location at a zoom level, measured from
+// the northwest corner of the world
+func mercatorPixel(gps gpsCoord, zoom int) [2]float64 {
+	worldSize := mercatorTileSize * math.Exp2(float64(zoom))
+	lat := gps.lat * math.Pi / 180
+	x := (gps.long + 180) / 360 * worldSize
+	y := (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * worldSize
+	return [2]float64{x, y}
+}
+
+// Function mercatorCoord returns the location at Web Mercator pixel coordinates at a zoom level; it's the
+// inverse of mercatorPixel
+func mercatorCoord(x, y float64, zoom int) gpsCoord {
+	worldSize := mercatorTileSize * math.Exp2(float64(zoom))
+	long := x/worldSize*360 - 180
+	lat := math.Atan(math.Sinh(math.Pi*(1-2*y/worldSize))) * 180 / math.Pi
+	return gpsCoord{lat, long}
+}
+
+// Function downloadImage fetches an image from a map service. OpenStreetMap's usage policy asks that programs
+// identify themselves, so we send our name and version.
+func downloadImage(url string) image.Image {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		fatalln("bad map service URL", err)
+	}
+	req.Header.Set("User-Agent", "reception/"+version+" (+https://github.com/fthiess/reception)")
+
+	client := &http.Client{Timeout: 2 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		fatalln("can't download base map", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fatalln("can't download base map:", resp.Status)
+	}
+
+	mapImage, _, err := image.Decode(resp.Body)
+	if err != nil {
+		fatalln("map service didn't send an image", err)
+	}
+	return mapImage
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"image"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// Statistics file in the output directory, and its columns
+const statsFile = "stats.csv"
+
+var statsHeadings = []string{"Date", "Frequency", "Map Type", "Call Sign", "Stations Heard", "Roster Heard (%)", "Average Quality", "Max Distance (km)"}
+
+// Reception statistics for one transmitter
+type transmitterStats struct {
+	callsign    string  // Transmitter call sign
+	heard       int     // Number of stations with a plottable report for the transmitter
+	heardPct    float64 // Percentage of the roster (not counting the transmitter itself) that heard it
+	scored      int     // Number of those stations whose report has a score
+	avgQuality  float64 // Average score of the reports from the stations that heard it, or 0 if none have a score
+	maxDistance float64 // Distance in meters to the farthest station that heard it, or 0 if none have a location
+}
+
+// Function computeStats returns the reception statistics for one transmitter. A station counts as having heard
+// the transmitter if its report has an icon, i.e. if it would be plotted on the transmitter's map.
+func computeStats(transmitter string, reports map[string]map[string]string, operators map[string]operatorData, icons map[string]image.Image) transmitterStats {
+	stats := transmitterStats{callsign: transmitter}
+
+	from := lookupOperator(operators, transmitter)
+	qualityTotal := 0.0
+	for _, receiver := range sortedReceivers(reports[transmitter]) { // In order, so the sums come out the same every run
+		report := reports[transmitter][receiver]
+		if _, present := icons[report]; !present || receiver == transmitter {
+			continue
+		}
+		stats.heard++
+
+		if quality, ok := reportScore(report); ok {
+			qualityTotal += quality
+			stats.scored++
+		}
+		if to := lookupOperator(operators, receiver); from.callsign != "" && to.callsign != "" {
+			stats.maxDistance = math.Max(stats.maxDistance, distanceMeters(from.gps, to.gps))
+		}
+	}
+	if stats.scored > 0 {
+		stats.avgQuality = qualityTotal / float64(stats.scored)
+	}
+
+	roster := len(operators)
+	if _, present := operators[transmitter]; present {
+		roster--
+	}
+	if roster > 0 {
+		stats.heardPct = 100.0 * float64(stats.heard) / float64(roster)
+	}
+
+	return stats
+}
+
+// Function netCoverage returns a single score for how well the net's stations covered each other: the average,
+// over all transmitters, of the percentage of the roster that heard them. It also returns the number of
+// stations that checked in, i.e. appear in the reports at all.
+func netCoverage(transmitters, receivers map[string]bool, reports map[string]map[string]string, operators map[string]operatorData, icons map[string]image.Image) (coverage float64, checkins int) {
+	stations := make(map[string]bool)
+	for call := range receivers {
+		stations[call] = true
+	}
+	for _, transmitter := range sortedCalls(transmitters) {
+		stations[transmitter] = true
+		coverage += computeStats(transmitter, reports, operators, icons).heardPct
+	}
+	if len(transmitters) > 0 {
+		coverage /= float64(len(transmitters))
+	}
+	return coverage, len(stations)
+}
+
+// Function writeStats records a row of statistics for each transmitter mapped this run in stats.csv in the output
+// directory, so coverage trends can be charted in a spreadsheet. Rows from earlier runs are kept, except that a map
+// made again on the same day, on the same frequency, replaces its earlier row. It returns the file's name relative
+// to the output directory.
+func writeStats(transmitters map[string]bool, reports map[string]map[string]string, operators map[string]operatorData, icons map[string]image.Image) string {
+	statsPath := filepath.Join(cfg.OutputDirectory, statsFile)
+	date, mapType := netDate(), currentMapType()
+
+	var rows [][]string
+	for _, row := range loadStats(statsPath) {
+		if len(row) == len(statsHeadings) && !(row[0] == date && row[1] == cfg.Frequency && row[2] == mapType && transmitters[row[3]]) {
+			rows = append(rows, row)
+		}
+	}
+
+	for _, transmitter := range sortedCalls(transmitters) {
+		rows = append(rows, statsRow(date, mapType, computeStats(transmitter, reports, operators, icons)))
+	}
+
+	// Dates sort chronologically, so this keeps the file in date order
+	sort.SliceStable(rows, func(i, j int) bool {
+		for c := 0; c < 4; c++ {
+			if rows[i][c] != rows[j][c] {
+				return rows[i][c] < rows[j][c]
+			}
+		}
+		return false
+	})
+
+	err := writeFileAtomic(statsPath, func(f io.Writer) error {
+		w := csv.NewWriter(f)
+		w.Write(statsHeadings)
+		w.WriteAll(rows)
+		return w.Error()
+	})
+	if err != nil {
+		fatalln("couldn't write the statistics file:", err)
+	}
+	return statsFile
+}
+
+// Function statsRow returns a transmitter's statistics as a row of stats.csv, with the given date and map type.
+// Averages and distances that couldn't be worked out are left empty.
+func statsRow(date, mapType string, stats transmitterStats) []string {
+	row := []string{date, cfg.Frequency, mapType, stats.callsign, strconv.Itoa(stats.heard),
+		strconv.FormatFloat(stats.heardPct, 'f', 1, 64), "", ""}
+	if stats.scored > 0 {
+		row[6] = strconv.FormatFloat(stats.avgQuality, 'f', 2, 64)
+	}
+	if stats.maxDistance > 0 {
+		row[7] = strconv.FormatFloat(stats.maxDistance/1000, 'f', 1, 64)
+	}
+	return row
+}
+
+// Function loadStats returns the rows of an existing statistics file, without its heading row, or nothing if
+// there isn't one yet
+func loadStats(csvFile string) [][]string {
+	f, err := os.Open(csvFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		fatalln("couldn't open the statistics file:", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(bufio.NewReader(f))
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		fatal("error reading statistics file", csvFile, err)
+	}
+	if len(rows) > 0 {
+		rows = rows[1:]
+	}
+	return rows
+}
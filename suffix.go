@@ -0,0 +1,87 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+)
+
+// How receivers with a suffixed call sign, such as K7ABC-7, are mapped. The suffix usually marks a second radio
+// or a portable setup belonging to the same operator. These apply to the receivers plotted on a map in both map
+// modes; suffixed transmitters always get their own maps.
+const (
+	// K7ABC-7 is a separate station from K7ABC, with its own icon and label. If it isn't in the operator file,
+	// it's plotted at K7ABC's location.
+	suffixSeparate = "separate"
+
+	// The suffix is ignored, so K7ABC-7 is plotted as K7ABC. If both K7ABC and K7ABC-7 report on the same
+	// transmitter, K7ABC's own report is used.
+	suffixIgnore = "ignore"
+
+	// K7ABC-7 is merged with K7ABC and plotted as K7ABC. If both report on the same transmitter, the better
+	// report is used, since it shows what the operator's station can do at its best.
+	suffixMerge = "merge"
+)
+
+// Function baseCall returns a call sign without any suffix, e.g. K7ABC for K7ABC-7
+func baseCall(call string) string {
+	if dash := strings.Index(call, "-"); dash >= 0 {
+		return call[:dash]
+	}
+	return call
+}
+
+// Function addReport records a report for a transmitter/receiver pair, applying cfg.SuffixMode to suffixed
+// receivers, and returns the call sign the receiver is plotted as. fromSuffix remembers which stored reports
+// came from a suffixed call, so that for suffixIgnore a base call's own report takes precedence no matter which
+// order they appear in the report file.
+func addReport(reports map[string]map[string]string, fromSuffix map[string]map[string]bool, transmitter, receiver, report string) string {
+	if cfg.SuffixMode == suffixSeparate || cfg.SuffixMode == "" {
+		reports[transmitter][receiver] = report
+		return receiver
+	}
+
+	base := baseCall(receiver)
+	suffixed := base != receiver
+	existing := reports[transmitter][base]
+
+	switch cfg.SuffixMode {
+	case suffixIgnore:
+		if !suffixed || existing == "" || fromSuffix[transmitter][base] {
+			reports[transmitter][base] = report
+			fromSuffix[transmitter][base] = suffixed
+		}
+	case suffixMerge:
+		if existing == "" || worseReport(existing, report) {
+			reports[transmitter][base] = report
+		}
+	default:
+		fatalf("unknown SuffixMode %q in reception.cfg; use %q, %q or %q", cfg.SuffixMode, suffixSeparate, suffixIgnore, suffixMerge)
+	}
+	return base
+}
+
+// Function lookupOperator returns the operator data for a call sign. A suffixed call sign that isn't in the
+// operator file falls back to the data for its base call, relabeled with the suffixed call.
+func lookupOperator(operators map[string]operatorData, call string) operatorData {
+	if op, present := operators[call]; present {
+		return op
+	}
+	if op, present := operators[baseCall(call)]; present {
+		op.callsign = call
+		return op
+	}
+	return operatorData{}
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Reports from K7ABC and its second radio K7ABC-7, in the report file's order: the station reporting, the station
+// it heard, and the report. K7ABC-7's report on W6OWI comes first, so SuffixMode "ignore" has to prefer K7ABC's own
+// report even though it comes later.
+const suffixReports = `K7ABC-7,W6OWI,1
+K7ABC,W6OWI,3
+N6YXJ,K7ABC,2
+N6YXJ,K7ABC-7,1
+`
+
+func TestSuffixModes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "reports.csv")
+	if err := os.WriteFile(file, []byte(suffixReports), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved config) { cfg = saved }(cfg)
+	cfg = config{}
+
+	type reports = map[string]map[string]string
+	type calls = map[string]bool
+	tests := []struct {
+		mode         string
+		rcvMap       bool
+		reports      reports
+		receivers    calls
+		transmitters calls
+	}{
+		{suffixSeparate, false,
+			reports{"W6OWI": {"K7ABC-7": "1", "K7ABC": "3"}, "K7ABC": {"N6YXJ": "2"}, "K7ABC-7": {"N6YXJ": "1"}},
+			calls{"K7ABC-7": true, "K7ABC": true, "N6YXJ": true}, calls{"W6OWI": true, "K7ABC": true, "K7ABC-7": true}},
+		{suffixIgnore, false,
+			reports{"W6OWI": {"K7ABC": "3"}, "K7ABC": {"N6YXJ": "2"}, "K7ABC-7": {"N6YXJ": "1"}},
+			calls{"K7ABC": true, "N6YXJ": true}, calls{"W6OWI": true, "K7ABC": true, "K7ABC-7": true}},
+		{suffixMerge, false,
+			reports{"W6OWI": {"K7ABC": "1"}, "K7ABC": {"N6YXJ": "2"}, "K7ABC-7": {"N6YXJ": "1"}},
+			calls{"K7ABC": true, "N6YXJ": true}, calls{"W6OWI": true, "K7ABC": true, "K7ABC-7": true}},
+		{suffixSeparate, true,
+			reports{"K7ABC-7": {"W6OWI": "1"}, "K7ABC": {"W6OWI": "3"}, "N6YXJ": {"K7ABC": "2", "K7ABC-7": "1"}},
+			calls{"W6OWI": true, "K7ABC": true, "K7ABC-7": true}, calls{"K7ABC-7": true, "K7ABC": true, "N6YXJ": true}},
+		{suffixIgnore, true,
+			reports{"K7ABC-7": {"W6OWI": "1"}, "K7ABC": {"W6OWI": "3"}, "N6YXJ": {"K7ABC": "2"}},
+			calls{"W6OWI": true, "K7ABC": true}, calls{"K7ABC-7": true, "K7ABC": true, "N6YXJ": true}},
+		{suffixMerge, true,
+			reports{"K7ABC-7": {"W6OWI": "1"}, "K7ABC": {"W6OWI": "3"}, "N6YXJ": {"K7ABC": "1"}},
+			calls{"W6OWI": true, "K7ABC": true}, calls{"K7ABC-7": true, "K7ABC": true, "N6YXJ": true}},
+	}
+	for _, test := range tests {
+		cfg.SuffixMode, cfg.RcvMapFlag = test.mode, test.rcvMap
+		reports, receivers, transmitters, err := readReports(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(reports, test.reports) {
+			t.Errorf("SuffixMode %q, RcvMapFlag %v: reports are %v, want %v", test.mode, test.rcvMap, reports, test.reports)
+		}
+		if !reflect.DeepEqual(receivers, test.receivers) {
+			t.Errorf("SuffixMode %q, RcvMapFlag %v: receivers are %v, want %v", test.mode, test.rcvMap, receivers, test.receivers)
+		}
+		if !reflect.DeepEqual(transmitters, test.transmitters) {
+			t.Errorf("SuffixMode %q, RcvMapFlag %v: transmitters are %v, want %v", test.mode, test.rcvMap, transmitters, test.transmitters)
+		}
+	}
+}
+
+func TestLookupOperator(t *testing.T) {
+	operators := map[string]operatorData{
+		"K7ABC":   {callsign: "K7ABC", gps: gpsCoord{37.40, -122.10}},
+		"K7ABC-9": {callsign: "K7ABC-9", gps: gpsCoord{37.38, -122.08}},
+	}
+	tests := []struct {
+		call, callsign string
+		gps            gpsCoord
+	}{
+		{"K7ABC", "K7ABC", gpsCoord{37.40, -122.10}},
+		{"K7ABC-7", "K7ABC-7", gpsCoord{37.40, -122.10}}, // Not in the operator file, so at K7ABC's location
+		{"K7ABC-9", "K7ABC-9", gpsCoord{37.38, -122.08}}, // In the operator file at a location of its own
+		{"N6YXJ-7", "", gpsCoord{}},
+	}
+	for _, test := range tests {
+		if op := lookupOperator(operators, test.call); op.callsign != test.callsign || op.gps != test.gps {
+			t.Errorf("lookupOperator(%q) = %q at %v, want %q at %v", test.call, op.callsign, op.gps, test.callsign, test.gps)
+		}
+	}
+}
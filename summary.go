@@ -0,0 +1,170 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	htmlTemplate "html/template"
+	"image"
+	"io"
+	"path/filepath"
+	"strconv"
+	"text/template"
+)
+
+// Summary report files in the output directory
+const (
+	summaryTextFile = "summary.txt"
+	summaryHTMLFile = "summary.html"
+)
+
+// The net at a glance, for the summary report
+type netSummary struct {
+	Title         string                // Name of the net, or "Net" if it has none
+	Date          string                // Date of the net
+	Frequency     string                // Frequency the net was on
+	MapType       string                // Type of the maps, which decides which way the reports go
+	Checkins      int                   // Stations that appear in the reports at all
+	Roster        int                   // Stations in the operator file
+	Coverage      string                // Average percentage of the roster that heard each transmitter
+	Stations      []stationSummary      // Each transmitter, in call sign order
+	Unheard       []string              // Transmitters no one heard
+	Neighborhoods []neighborhoodSummary // Each neighborhood in reception.cfg, in order
+}
+
+// One transmitter's line in the summary report
+type stationSummary struct {
+	Call      string // Call sign
+	Heard     int    // Stations that heard it
+	RosterPct string // Percentage of the roster that heard it
+	Quality   string // Average score of the reports from those that heard it, or "-" if none have a score
+}
+
+// One neighborhood's line in the summary report
+type neighborhoodSummary struct {
+	Name     string // Name of the neighborhood
+	Stations int    // Operators located in it
+	Reports  int    // Reports with a score that those operators gave
+	Quality  string // Average score of those reports, or "-" if there are none
+}
+
+// Function writeSummary writes a summary of the whole net, as plain text and as a web page: how many stations
+// checked in, how much of the roster heard each transmitter, which transmitters no one heard, and the average
+// report given by the stations in each neighborhood. It's the narrative an EC would otherwise put together by
+// hand from the maps. It returns the files' names relative to the output directory.
+func writeSummary(transmitters, receivers map[string]bool, reports map[string]map[string]string, operators map[string]operatorData, icons map[string]image.Image) []string {
+	coverage, checkins := netCoverage(transmitters, receivers, reports, operators, icons)
+	s := netSummary{Title: cfg.NetName, Date: netDate(), Frequency: cfg.Frequency, MapType: mapTypeName(currentMapType()),
+		Checkins: checkins, Roster: len(operators), Coverage: strconv.FormatFloat(coverage, 'f', 1, 64)}
+	if s.Title == "" {
+		s.Title = "Net"
+	}
+
+	average := func(total float64, count int) string {
+		if count == 0 {
+			return "-"
+		}
+		return strconv.FormatFloat(total/float64(count), 'f', 2, 64)
+	}
+	for _, transmitter := range sortedCalls(transmitters) {
+		stats := computeStats(transmitter, reports, operators, icons)
+		station := stationSummary{Call: transmitter, Heard: stats.heard, RosterPct: strconv.FormatFloat(stats.heardPct, 'f', 1, 64), Quality: "-"}
+		if stats.scored > 0 {
+			station.Quality = strconv.FormatFloat(stats.avgQuality, 'f', 2, 64)
+		}
+		s.Stations = append(s.Stations, station)
+		if stats.heard == 0 {
+			s.Unheard = append(s.Unheard, transmitter)
+		}
+	}
+
+	for _, n := range cfg.Neighborhoods {
+		inside := make(map[string]bool)
+		for call, op := range operators {
+			if n.contains(op.gps) {
+				inside[call] = true
+			}
+		}
+		total, count := 0.0, 0
+		for _, heard := range reports {
+			for receiver, report := range heard {
+				if quality, ok := reportScore(report); ok && inside[lookupOperator(operators, receiver).callsign] {
+					total += quality
+					count++
+				}
+			}
+		}
+		s.Neighborhoods = append(s.Neighborhoods, neighborhoodSummary{Name: n.Name, Stations: len(inside), Reports: count, Quality: average(total, count)})
+	}
+
+	for file, write := range map[string]func(io.Writer) error{
+		summaryTextFile: func(w io.Writer) error { return summaryTextTemplate.Execute(w, s) },
+		summaryHTMLFile: func(w io.Writer) error { return summaryHTMLTemplate.Execute(w, s) },
+	} {
+		if err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, file), write); err != nil {
+			fatalf("Failed to write summary report: %s", err)
+		}
+	}
+	return []string{summaryTextFile, summaryHTMLFile}
+}
+
+// Template for the plain text summary report
+var summaryTextTemplate = template.Must(template.New(summaryTextFile).Parse(`{{.Title}} summary, {{.Date}}
+Frequency: {{.Frequency}}
+{{.MapType}}
+
+Check-ins: {{.Checkins}} stations ({{.Roster}} on the roster)
+Average coverage: {{.Coverage}}% of the roster heard each transmitter
+
+Station     Heard by   Roster heard   Average report
+{{range .Stations}}{{printf "%-11s %8d %13s%% %16s" .Call .Heard .RosterPct .Quality}}
+{{end}}
+Heard by nobody: {{range $i, $call := .Unheard}}{{if $i}}, {{end}}{{$call}}{{else}}none{{end}}
+{{with .Neighborhoods}}
+Neighborhood               Stations   Reports   Average report
+{{range .}}{{printf "%-25s %10d %9d %16s" .Name .Stations .Reports .Quality}}
+{{end}}{{end}}`))
+
+// Template for the web page summary report
+var summaryHTMLTemplate = htmlTemplate.Must(htmlTemplate.New(summaryHTMLFile).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} Summary - {{.Date}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; }
+td.number { text-align: right; }
+</style>
+</head>
+<body>
+<h1>{{.Title}} Summary, {{.Date}}</h1>
+<p>Frequency: {{.Frequency}}<br>{{.MapType}}</p>
+<p>Check-ins: {{.Checkins}} stations ({{.Roster}} on the roster)<br>
+Average coverage: {{.Coverage}}% of the roster heard each transmitter</p>
+<h2>Stations</h2>
+<table>
+<tr><th>Station</th><th>Heard by</th><th>Roster heard</th><th>Average report</th></tr>
+{{range .Stations}}<tr><td>{{.Call}}</td><td class="number">{{.Heard}}</td><td class="number">{{.RosterPct}}%</td><td class="number">{{.Quality}}</td></tr>
+{{end}}</table>
+<p>Heard by nobody: {{range $i, $call := .Unheard}}{{if $i}}, {{end}}{{$call}}{{else}}none{{end}}</p>
+{{with .Neighborhoods}}<h2>Neighborhoods</h2>
+<table>
+<tr><th>Neighborhood</th><th>Stations</th><th>Reports</th><th>Average report</th></tr>
+{{range .}}<tr><td>{{.Name}}</td><td class="number">{{.Stations}}</td><td class="number">{{.Reports}}</td><td class="number">{{.Quality}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,176 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Height SRTM tiles give where there's no data, such as in radar shadows
+const srtmVoid = -32768
+
+// An SRTM elevation tile: a square grid of heights in meters covering one degree of latitude and longitude, from
+// its northwest corner across and then down
+type elevationTile struct {
+	size    int
+	heights []int16
+}
+
+// Tiles loaded so far, by name, including nil for ones that couldn't be found, so they're only looked for once
+var (
+	elevationTiles     = make(map[string]*elevationTile)
+	elevationTilesLock sync.Mutex
+)
+
+// The elevation tiles covering an area, by the latitude and longitude of their southwest corners
+type terrain map[[2]int]*elevationTile
+
+// Function tileName returns the SRTM name of the tile whose southwest corner is at a whole latitude and
+// longitude, e.g. N37W123
+func tileName(lat, long int) string {
+	ns, ew := "N", "E"
+	if lat < 0 {
+		ns, lat = "S", -lat
+	}
+	if long < 0 {
+		ew, long = "W", -long
+	}
+	return fmt.Sprintf("%s%02d%s%03d", ns, lat, ew, long)
+}
+
+// Function loadTerrain returns the elevation tiles covering the area around a set of points, from
+// ElevationDirectory, downloading any that are missing from ElevationURL. Where there's no tile, the ground is
+// taken to be at sea level.
+func loadTerrain(points []gpsCoord) terrain {
+	t := make(terrain)
+	if len(points) == 0 {
+		return t
+	}
+	south, north, west, east := points[0].lat, points[0].lat, points[0].long, points[0].long
+	for _, p := range points {
+		south, north = math.Min(south, p.lat), math.Max(north, p.lat)
+		west, east = math.Min(west, p.long), math.Max(east, p.long)
+	}
+	for lat := int(math.Floor(south)); lat <= int(math.Floor(north)); lat++ {
+		for long := int(math.Floor(west)); long <= int(math.Floor(east)); long++ {
+			t[[2]int{lat, long}] = loadElevationTile(lat, long)
+		}
+	}
+	return t
+}
+
+// Function loadElevationTile returns one elevation tile, or nil if it isn't in ElevationDirectory and can't be
+// downloaded
+func loadElevationTile(lat, long int) *elevationTile {
+	name := tileName(lat, long)
+	elevationTilesLock.Lock()
+	defer elevationTilesLock.Unlock()
+	if tile, loaded := elevationTiles[name]; loaded {
+		return tile
+	}
+
+	file := filepath.Join(cfg.ElevationDirectory, name+".hgt")
+	data, err := ioutil.ReadFile(file)
+	if err != nil && cfg.ElevationURL != "" {
+		if err = downloadElevationTile(name, file); err == nil {
+			data, err = ioutil.ReadFile(file)
+		}
+	}
+	var tile *elevationTile
+	size := int(math.Sqrt(float64(len(data) / 2)))
+	switch {
+	case err != nil:
+		warnf("no elevation data for %s, taking it to be at sea level: %v", name, err)
+	case size < 2 || size*size*2 != len(data):
+		warnf("%s isn't an SRTM elevation tile, taking %s to be at sea level", file, name)
+	default:
+		tile = &elevationTile{size: size, heights: make([]int16, size*size)}
+		for i := range tile.heights {
+			tile.heights[i] = int16(binary.BigEndian.Uint16(data[2*i:]))
+		}
+	}
+	elevationTiles[name] = tile
+	return tile
+}
+
+// Function downloadElevationTile downloads a tile from ElevationURL into file, unzipping it if the URL ends in .gz
+func downloadElevationTile(name, file string) error {
+	url := strings.NewReplacer("{dir}", name[:3], "{tile}", name).Replace(cfg.ElevationURL)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "reception/"+version+" (+https://github.com/fthiess/reception)")
+	client := &http.Client{Timeout: 2 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	var body io.Reader = resp.Body
+	if strings.HasSuffix(url, ".gz") {
+		unzipped, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		defer unzipped.Close()
+		body = unzipped
+	}
+	if err := os.MkdirAll(cfg.ElevationDirectory, 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(file, func(w io.Writer) error {
+		_, err := io.Copy(w, body)
+		return err
+	})
+}
+
+// Function elevation returns the height of the ground in meters at a point, blended from the four tile samples
+// around it. Points with no data are taken to be at sea level.
+func (t terrain) elevation(gps gpsCoord) float64 {
+	lat, long := math.Floor(gps.lat), math.Floor(gps.long)
+	tile := t[[2]int{int(lat), int(long)}]
+	if tile == nil {
+		return 0
+	}
+	last := tile.size - 1
+	x, y := (gps.long-long)*float64(last), (lat+1-gps.lat)*float64(last)
+	x0, y0 := int(math.Min(x, float64(last-1))), int(math.Min(y, float64(last-1)))
+	fx, fy := x-float64(x0), y-float64(y0)
+	height := func(x, y int) float64 {
+		h := tile.heights[y*tile.size+x]
+		if h == srtmVoid {
+			return 0
+		}
+		return float64(h)
+	}
+	return height(x0, y0)*(1-fx)*(1-fy) + height(x0+1, y0)*fx*(1-fy) +
+		height(x0, y0+1)*(1-fx)*fy + height(x0+1, y0+1)*fx*fy
+}
@@ -0,0 +1,265 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+	"strconv"
+
+	"github.com/golang/freetype/truetype"
+	"github.com/nfnt/resize"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Ways of thinning overlapping markers
+const (
+	thinWorst   = "worst"   // Keep only the marker with the worst reception in each overlapping group
+	thinCluster = "cluster" // Replace each overlapping group with one marker in the group's average color
+)
+
+// Which report's color a cluster badge is drawn in
+const (
+	clusterWorst = "worst"
+	clusterBest  = "best"
+)
+
+// One receiver's icon, ready to be plotted
+type marker struct {
+	operator operatorData // The receiver; its callsign is used as the marker's label
+	report   string       // The receiver's report, which is also the name of the icon
+	icon     image.Image
+	ownIcon  image.Image // The operator's own icon from the operator file, if any, shown instead of icon
+	cluster  bool        // True if this marker stands for a group of overlapping markers
+	absent   bool        // True if the operator gave no report, and is shown with NoReportIcon
+	members  []marker    // The markers a cluster stands for
+}
+
+// Function shownIcon returns the icon to plot for a marker: the operator's own icon if it has one, so stations
+// like net control stand out whatever their reports, and otherwise the icon for its report. The icon is still
+// what colors the marker's cluster, heatmap and report line.
+func (m marker) shownIcon() image.Image {
+	if m.ownIcon != nil && !m.cluster {
+		return m.ownIcon
+	}
+	return m.icon
+}
+
+// Function thinMarkers thins out markers whose icons would overlap when drawn iconSize pixels across at
+// the given scale (pixels on the drawn image per pixel on the base map), so small images stay legible
+// instead of becoming a smear of icons. How overlapping markers are combined depends on cfg.ThinningMode.
+// Markers for operators not in the operator file are passed through untouched, so they're still reported
+// as missing when plotted.
+func thinMarkers(markers []marker, scale float64, iconSize int) []marker {
+	groups, thinned := groupMarkers(markers, scale, float64(iconSize))
+	for _, group := range groups {
+		if len(group) == 1 || cfg.ThinningMode != thinCluster {
+			thinned = append(thinned, group[0])
+			continue
+		}
+		thinned = append(thinned, clusterMarker(group, iconSize))
+	}
+	return worstOnTop(thinned)
+}
+
+// Function groupMarkers sorts markers into groups whose first markers are closer together than the given distance
+// when drawn at the given scale. Each group is in order of reception, worst first. Markers for operators not in the
+// operator file aren't grouped, and are returned separately.
+func groupMarkers(markers []marker, scale, distance float64) (groups [][]marker, ungrouped []marker) {
+	sorted := append([]marker(nil), markers...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].report != sorted[j].report {
+			return worseReport(sorted[i].report, sorted[j].report)
+		}
+		return sorted[i].operator.callsign < sorted[j].operator.callsign
+	})
+
+	for _, m := range sorted {
+		if m.operator.callsign == "" {
+			ungrouped = append(ungrouped, m)
+			continue
+		}
+
+		joined := false
+		for g, group := range groups {
+			dx := float64(m.operator.pixel.X-group[0].operator.pixel.X) * scale
+			dy := float64(m.operator.pixel.Y-group[0].operator.pixel.Y) * scale
+			if dx*dx+dy*dy < distance*distance {
+				groups[g] = append(group, m)
+				joined = true
+				break
+			}
+		}
+		if !joined {
+			groups = append(groups, []marker{m})
+		}
+	}
+	return groups, ungrouped
+}
+
+// Function worstOnTop reverses markers that are in order of reception, worst first, so the worst reception is
+// plotted last and ends up on top of anything it still overlaps
+func worstOnTop(markers []marker) []marker {
+	for i, j := 0, len(markers)-1; i < j; i, j = i+1, j-1 {
+		markers[i], markers[j] = markers[j], markers[i]
+	}
+	return markers
+}
+
+// Function clusterMarker returns a single marker standing in for a group of overlapping markers: a disc in the
+// average color of the group's icons, at the position of the group's worst-reception marker, labeled with that
+// marker's call sign and how many others it stands for.
+func clusterMarker(group []marker, iconSize int) marker {
+	var r, g, b, n uint32
+	for _, m := range group {
+		if c := iconColor(m.icon); c != nil {
+			cr, cg, cb, _ := c.RGBA()
+			r, g, b, n = r+cr>>8, g+cg>>8, b+cb>>8, n+1
+		}
+	}
+	fill := color.RGBA{0x80, 0x80, 0x80, 0xff}
+	if n > 0 {
+		fill = color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), 0xff}
+	}
+
+	op := group[0].operator
+	op.callsign, op.beam = fmt.Sprintf("%s +%d", op.callsign, len(group)-1), noBeam
+	return marker{operator: op, report: group[0].report, icon: disc(iconSize, fill), cluster: true, members: group}
+}
+
+// Function clusterDense replaces each group of receivers closer together than cfg.ClusterRadius pixels with a
+// single badge showing how many there are, so apartment buildings and other dense spots don't become a pile of
+// icons. The badge is in the color of the group's worst or best report, as cfg.ClusterColor says, and is labeled
+// with that receiver's call sign and how many others it stands for.
+func clusterDense(markers []marker) []marker {
+	groups, clustered := groupMarkers(markers, 1, float64(cfg.ClusterRadius))
+	for _, group := range groups {
+		if len(group) == 1 {
+			clustered = append(clustered, group[0])
+			continue
+		}
+
+		shown := group[0]
+		switch cfg.ClusterColor {
+		case "", clusterWorst:
+		case clusterBest:
+			shown = group[len(group)-1]
+		default:
+			fatalf("unknown ClusterColor %q in reception.cfg; use %q or %q", cfg.ClusterColor, clusterWorst, clusterBest)
+		}
+		fill := iconColor(shown.icon)
+		if fill == nil {
+			fill = color.RGBA{0x80, 0x80, 0x80, 0xff}
+		}
+
+		op := shown.operator
+		op.callsign, op.beam = fmt.Sprintf("%s +%d", op.callsign, len(group)-1), noBeam
+		clustered = append(clustered, marker{operator: op, report: shown.report, icon: countBadge(int(cfg.IconSize), fill, len(group)),
+			cluster: true, members: group})
+	}
+	return worstOnTop(clustered)
+}
+
+// Function countBadge returns a disc like the one disc draws, with a count written across it in white
+func countBadge(diameter int, fill color.Color, count int) image.Image {
+	badgePtr := image.NewRGBA(image.Rect(0, 0, diameter, diameter))
+	draw.Draw(badgePtr, badgePtr.Bounds(), disc(diameter, fill), image.Point{}, draw.Src)
+
+	// Size the digits to fill about half the disc; a point is DPI/72 pixels
+	text := strconv.Itoa(count)
+	face := truetype.NewFace(loadFont(), &truetype.Options{Size: float64(diameter) / 2 * 72 / cfg.FontDPI, DPI: cfg.FontDPI})
+	d := font.Drawer{Dst: badgePtr, Src: image.White, Face: face}
+	width := d.MeasureString(text)
+	d.Dot = fixed.Point26_6{X: fixed.I(diameter)/2 - width/2, Y: fixed.I(diameter)/2 + face.Metrics().Ascent*3/8}
+	d.DrawString(text)
+	return badgePtr
+}
+
+// Function markerClusters lists the receivers in each cluster among a map's markers, for the index page
+func markerClusters(markers []marker) []mapCluster {
+	var clusters []mapCluster
+	for _, m := range markers {
+		if !m.cluster {
+			continue
+		}
+		c := mapCluster{Label: m.operator.callsign}
+		for _, member := range m.members {
+			c.Stations = append(c.Stations, fmt.Sprintf("%s (%s)", member.operator.callsign, member.report))
+		}
+		clusters = append(clusters, c)
+	}
+	return clusters
+}
+
+// Function disc returns an image of a filled circle of the given diameter, with a thin white outline
+func disc(diameter int, fill color.Color) image.Image {
+	discPtr := image.NewRGBA(image.Rect(0, 0, diameter, diameter))
+	radius := float64(diameter) / 2
+	center := pixelPoint{radius, radius}
+	fillPolygons(discPtr, [][]pixelPoint{circle(center, radius)}, color.White)
+	fillPolygons(discPtr, [][]pixelPoint{circle(center, radius-1.5)}, fill)
+	return discPtr
+}
+
+// Function scaleIcons returns copies of the icons resized to the given size
+func scaleIcons(icons map[string]image.Image, size uint) map[string]image.Image {
+	scaled := make(map[string]image.Image)
+	for name, icon := range icons {
+		scaled[name] = resize.Resize(size, 0, icon, resize.Bilinear)
+	}
+	return scaled
+}
+
+// Function drawThumbnail returns a small version of a map for the index page. Rather than shrinking the full
+// map, which turns the icons into an illegible smear, it shrinks just the base map and then plots thinned-out
+// icons (and no labels) at a size that can still be made out.
+func drawThumbnail(baseMap image.Image, markers []marker, transmitter marker, thumbIcons map[string]image.Image) image.Image {
+	thumb := resize.Resize(cfg.ThumbnailSize, 0, baseMap, resize.Bilinear)
+	thumbPtr := image.NewRGBA(thumb.Bounds())
+	draw.Draw(thumbPtr, thumbPtr.Bounds(), thumb, image.Point{}, draw.Src)
+
+	scale := float64(thumb.Bounds().Dx()) / float64(baseMap.Bounds().Dx())
+	markers, _ = splitAbsent(markers) // Too small to tell apart from the rest
+	plot := func(m marker, icon image.Image) {
+		if m.operator.callsign == "" || icon == nil {
+			return
+		}
+		center := image.Point{int(float64(m.operator.pixel.X)*scale + 0.5), int(float64(m.operator.pixel.Y)*scale + 0.5)}
+		offset := center.Sub(image.Point{icon.Bounds().Dx() / 2, icon.Bounds().Dy() / 2})
+		draw.Draw(thumbPtr, icon.Bounds().Add(offset), icon, icon.Bounds().Min, draw.Over)
+	}
+
+	for _, m := range thinMarkers(markers, scale, int(cfg.ThumbnailIconSize)) {
+		icon := thumbIcons[m.report]
+		if own, present := thumbIcons[m.operator.icon]; present {
+			icon = own
+		}
+		if m.cluster {
+			icon = m.icon // Already drawn at thumbnail size
+		}
+		plot(m, icon)
+	}
+	if own, present := thumbIcons[transmitter.operator.icon]; present {
+		plot(transmitter, own)
+	} else {
+		plot(transmitter, thumbIcons[transmitter.report])
+	}
+
+	return thumbPtr
+}
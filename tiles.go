@@ -0,0 +1,178 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Default tile server, OpenStreetMap's, and the attribution its license requires on maps made from it
+const (
+	defaultTileURL        = "https://tile.openstreetmap.org/{z}/{x}/{y}.png"
+	defaultMapAttribution = "© OpenStreetMap contributors"
+)
+
+// Most tiles we'll download for one base map; OpenStreetMap's tile usage policy forbids bulk downloading
+const maxTiles = 400
+
+// Colors of the attribution text and the translucent box behind it
+var (
+	attributionText       = color.RGBA{0x30, 0x30, 0x30, 0xff}
+	attributionBackground = color.NRGBA{0xff, 0xff, 0xff, 0xb0}
+)
+
+// Function fetchTileMap builds a base map covering the area between MapNWCorner and MapSECorner, or around all
+// the operators if the corners aren't set, by stitching together tiles from a tile server at TileZoom. Tiles and
+// the finished map are cached in MapCacheDirectory, so each tile is only downloaded once. The map is cropped to
+// whole pixels, and the corners are updated to match it exactly. It returns the name of the cached map file.
+func fetchTileMap() string {
+	nw, se := staticMapArea()
+	zoom := cfg.TileZoom
+	if zoom == 0 {
+		zoom = fitZoom(nw, se)
+	}
+	nwPixel, sePixel := mercatorPixel(nw, zoom), mercatorPixel(se, zoom)
+	area := image.Rect(int(math.Floor(nwPixel[0])), int(math.Floor(nwPixel[1])), int(math.Ceil(sePixel[0])), int(math.Ceil(sePixel[1])))
+
+	nw = mercatorCoord(float64(area.Min.X), float64(area.Min.Y), zoom)
+	se = mercatorCoord(float64(area.Max.X), float64(area.Max.Y), zoom)
+	cfg.MapNWCorner = []float64{nw.lat, nw.long}
+	cfg.MapSECorner = []float64{se.lat, se.long}
+
+	template := cfg.TileURL
+	if template == "" {
+		template = defaultTileURL
+	}
+	mapFile := filepath.Join(cfg.MapCacheDirectory, "tiles-"+sha256Hex([]byte(fmt.Sprint(template, zoom, area)))[:16]+".png")
+	if _, err := os.Stat(mapFile); err == nil {
+		fmt.Println("Using cached base map", mapFile)
+		return mapFile
+	}
+
+	mapPtr := stitchTiles(template, zoom, area, "base map", "TileZoom")
+	if err := writeFileAtomic(mapFile, func(w io.Writer) error { return png.Encode(w, mapPtr) }); err != nil {
+		fatalln("can't save base map", mapFile, err)
+	}
+	return mapFile
+}
+
+// Function stitchTiles returns an image of an area of the world, given in Web Mercator pixels at a zoom level,
+// made from the tiles that cover it. What says what the image is for, and zoomSetting is the reception.cfg
+// setting to suggest lowering if it needs too many tiles.
+func stitchTiles(template string, zoom int, area image.Rectangle, what, zoomSetting string) *image.RGBA {
+	// Tiles are numbered from the northwest corner of the world, mercatorTileSize pixels apart
+	tiles := image.Rect(area.Min.X/mercatorTileSize, area.Min.Y/mercatorTileSize,
+		(area.Max.X-1)/mercatorTileSize+1, (area.Max.Y-1)/mercatorTileSize+1)
+	if tiles.Dx()*tiles.Dy() > maxTiles {
+		fatalf("%s at zoom level %d needs %d tiles, more than the limit of %d; use a lower %s",
+			what, zoom, tiles.Dx()*tiles.Dy(), maxTiles, zoomSetting)
+	}
+
+	fmt.Printf("Building %s from %d tiles at zoom level %d\n", what, tiles.Dx()*tiles.Dy(), zoom)
+	mapPtr := image.NewRGBA(image.Rect(0, 0, area.Dx(), area.Dy()))
+	for y := tiles.Min.Y; y < tiles.Max.Y; y++ {
+		for x := tiles.Min.X; x < tiles.Max.X; x++ {
+			tile := loadTile(template, zoom, x, y)
+			at := image.Point{x*mercatorTileSize - area.Min.X, y*mercatorTileSize - area.Min.Y}
+			draw.Draw(mapPtr, tile.Bounds().Sub(tile.Bounds().Min).Add(at), tile, tile.Bounds().Min, draw.Src)
+		}
+	}
+	return mapPtr
+}
+
+// Function loadTile returns one tile, from the cache if it's there and from the tile server if not
+func loadTile(template string, zoom, x, y int) image.Image {
+	tileFile := filepath.Join(cfg.MapCacheDirectory, "tiles", sha256Hex([]byte(template))[:8], strconv.Itoa(zoom), strconv.Itoa(x), strconv.Itoa(y)+".png")
+	if f, err := os.Open(tileFile); err == nil {
+		defer f.Close()
+		if tile, err := png.Decode(f); err == nil {
+			return tile
+		}
+	}
+
+	url := strings.NewReplacer("{z}", strconv.Itoa(zoom), "{x}", strconv.Itoa(x), "{y}", strconv.Itoa(y)).Replace(template)
+	tile := downloadImage(url)
+	if err := os.MkdirAll(filepath.Dir(tileFile), 0755); err != nil {
+		fatalln("can't create map cache directory", err)
+	}
+	if err := writeFileAtomic(tileFile, func(w io.Writer) error { return png.Encode(w, tile) }); err != nil {
+		fatalln("can't save map tile", tileFile, err)
+	}
+	return tile
+}
+
+// Function mapAttribution returns the credit to show on maps for the base map: MapAttribution if it's set, and
+// otherwise OpenStreetMap's for a downloaded base map
+func mapAttribution() string {
+	if cfg.MapAttribution != "" || cfg.MapSource == "" || cfg.MapSource == mapSourceFile {
+		return cfg.MapAttribution
+	}
+	return defaultMapAttribution
+}
+
+// Function drawAttribution writes the credit line from reception.cfg and the base map's credit in small text in
+// the lower right corner of a map, on a translucent box so it's legible over anything. It returns the box.
+func drawAttribution(dstPtr *image.RGBA) image.Rectangle {
+	var credits []string
+	for _, credit := range []string{cfg.MapCredit, mapAttribution()} {
+		if credit != "" {
+			credits = append(credits, credit)
+		}
+	}
+	if len(credits) == 0 {
+		return image.Rectangle{}
+	}
+	return drawCornerText(dstPtr, strings.Join(credits, " | "), false)
+}
+
+// Function drawCornerText writes a line of small text in the lower left or right corner of a map, on a
+// translucent box so it's legible over anything. It returns the box, which the text is within.
+func drawCornerText(dstPtr *image.RGBA, text string, left bool) image.Rectangle {
+	face := truetype.NewFace(loadFont(), &truetype.Options{Size: cfg.FontSize * 0.75, DPI: cfg.FontDPI})
+	metrics := face.Metrics()
+	pad := int(cfg.FontSize*0.4 + 0.5)
+	width := font.MeasureString(face, text).Ceil()
+	height := (metrics.Ascent + metrics.Descent).Ceil()
+
+	bounds := dstPtr.Bounds()
+	box := image.Rect(bounds.Max.X-width-2*pad, bounds.Max.Y-height-2*pad, bounds.Max.X, bounds.Max.Y)
+	if left {
+		box = image.Rect(bounds.Min.X, box.Min.Y, bounds.Min.X+width+2*pad, box.Max.Y)
+	}
+	draw.Draw(dstPtr, box, &image.Uniform{attributionBackground}, image.Point{}, draw.Over)
+
+	d := font.Drawer{
+		Dst:  dstPtr,
+		Src:  &image.Uniform{attributionText},
+		Face: face,
+		Dot:  fixed.P(box.Min.X+pad, box.Min.Y+pad+metrics.Ascent.Ceil()),
+	}
+	d.DrawString(text)
+	return box
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"time"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Function netDate returns the date of the net, as YYYY-MM-DD: cfg.NetDate if it's given, otherwise today
+func netDate() string {
+	if cfg.NetDate == "" {
+		return startTime.Format("2006-01-02")
+	}
+	if _, err := time.Parse("2006-01-02", cfg.NetDate); err != nil {
+		fatalf("NetDate %q isn't a date; use YYYY-MM-DD", cfg.NetDate)
+	}
+	return cfg.NetDate
+}
+
+// Function drawTitle draws the name and date of the net across the top of a map, in the title font (by default
+// twice the size of the rest of the text), e.g. "Foo County ARES Weekly Net — 2024-03-12"
+func drawTitle(dstPtr *image.RGBA) {
+	title := cfg.NetName + " — " + netDate()
+	ttf, size := titleFont()
+	face := truetype.NewFace(ttf, &truetype.Options{Size: size, DPI: cfg.FontDPI})
+
+	// Shrink the title to fit if the net has a long name and the map is narrow
+	bounds := dstPtr.Bounds()
+	width := font.MeasureString(face, title)
+	if room := fixed.I(bounds.Dx() * 9 / 10); width > room {
+		face = truetype.NewFace(ttf, &truetype.Options{Size: size * float64(room) / float64(width), DPI: cfg.FontDPI})
+		width = font.MeasureString(face, title)
+	}
+
+	d := font.Drawer{Dst: dstPtr, Src: &image.Uniform{legendColor()}, Face: face}
+	ascent := face.Metrics().Ascent
+	d.Dot = fixed.Point26_6{X: fixed.I(bounds.Min.X+bounds.Dx()/2) - width/2, Y: fixed.I(bounds.Min.Y) + ascent*3/2}
+	d.DrawString(title)
+}
@@ -0,0 +1,261 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/golang/freetype"
+)
+
+// Trend report files in the output directory, and the CSV file's columns
+const (
+	trendFile      = "trend.csv"
+	trendChartFile = "trend.png"
+)
+
+var trendHeadings = []string{"Date", "Call Sign", "Stations Heard", "Heard By", "Roster Heard (%)"}
+
+// The date in a report file's name
+var fileDatePattern = regexp.MustCompile(`\d{4}-\d{2}-\d{2}`)
+
+// Colors of the lines on the trend chart: the share of the roster that heard each station, and the share it heard
+var (
+	heardByColor = color.RGBA{0x20, 0x60, 0xc0, 0xff}
+	hearsColor   = color.RGBA{0x20, 0xa0, 0x40, 0xff}
+)
+
+// One station's coverage in one session
+type sessionCoverage struct {
+	transmitted bool    // Whether it transmitted, so could have been heard
+	heard       int     // Stations it heard
+	heardBy     int     // Stations that heard it
+	percent     float64 // Percentage of the roster that heard it
+}
+
+// Function reportFilesIn returns the report files (*.csv) in a directory, in name order
+func reportFilesIn(dir string) []string {
+	files, err := filepath.Glob(dir + "/*.csv")
+	if err != nil || len(files) == 0 {
+		fatalln("no report files (*.csv) in", dir)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Function trendCommand charts how each station's coverage has changed from session to session, from a directory
+// of report files with the date of each session in its name (e.g. reports-2024-03-05.csv), so it's clear whether
+// the net is getting healthier. Each station's numbers for each session go in trend.csv, and trend.png charts the
+// share of the roster that heard each station and the share it heard, the whole net's first.
+func trendCommand(args []string) {
+	flags := flag.NewFlagSet("trend", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fatalln("Usage: reception trend DIRECTORY")
+	}
+	files := reportFilesIn(flags.Arg(0))
+	dates := make(map[string]string)
+	for _, file := range files {
+		if dates[file] = fileDatePattern.FindString(filepath.Base(file)); dates[file] == "" {
+			fatalf("can't tell when %s is from; put the date (YYYY-MM-DD) in its name", file)
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool { return dates[files[i]] < dates[files[j]] })
+
+	icons := loadIcons(cfg.IconDirectory)
+	gpsToPixel = newGpsToPixel(loadBaseMap(cfg.MapFile))
+	fatalStatus = exitDataError
+	operators := loadOperators(cfg.OperatorFile)
+	cfg.RcvMapFlag = false // Reports are always wanted as transmitter -> receiver here, whatever reception.cfg says
+
+	// Coverage of each station in each session
+	if err := os.MkdirAll(cfg.OutputDirectory, 0755); err != nil {
+		fatalf("Failed to create output directory: %s", err)
+	}
+	sessions := make([]map[string]sessionCoverage, len(files))
+	stations := make(map[string]bool)
+	var rows [][]string
+	for i, file := range files {
+		reports, _, transmitters := loadReports(file)
+		sessions[i] = make(map[string]sessionCoverage)
+		for transmitter := range transmitters {
+			stats := computeStats(transmitter, reports, operators, icons)
+			c := sessions[i][transmitter]
+			c.transmitted, c.heardBy, c.percent = true, stats.heard, stats.heardPct
+			sessions[i][transmitter] = c
+			for receiver, report := range reports[transmitter] {
+				if _, present := icons[report]; present && receiver != transmitter {
+					c := sessions[i][receiver]
+					c.heard++
+					sessions[i][receiver] = c
+				}
+			}
+		}
+
+		var calls []string
+		for call := range sessions[i] {
+			calls = append(calls, call)
+			stations[call] = true
+		}
+		sort.Strings(calls)
+		for _, call := range calls {
+			c := sessions[i][call]
+			rows = append(rows, []string{dates[file], call, strconv.Itoa(c.heard), strconv.Itoa(c.heardBy),
+				strconv.FormatFloat(c.percent, 'f', 1, 64)})
+		}
+	}
+
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, trendFile), func(f io.Writer) error {
+		w := csv.NewWriter(f)
+		w.Write(trendHeadings)
+		w.WriteAll(rows)
+		return w.Error()
+	})
+	if err != nil {
+		fatalln("couldn't write the trend file:", err)
+	}
+	fmt.Println("Wrote", filepath.Join(cfg.OutputDirectory, trendFile))
+
+	var sessionDates []string
+	for _, file := range files {
+		sessionDates = append(sessionDates, dates[file])
+	}
+	writeTrendChart(sessions, sessionDates, sortedCalls(stations), len(operators))
+	fmt.Println("Wrote", filepath.Join(cfg.OutputDirectory, trendChartFile))
+}
+
+// Function writeTrendChart draws trend.png: a small chart for each station, and one for the whole net before
+// them, each with a line for the share of the roster that heard the station and one for the share it heard,
+// session by session. A station missing from a session leaves a gap in its lines.
+func writeTrendChart(sessions []map[string]sessionCoverage, dates []string, stations []string, roster int) {
+	hears := func(c sessionCoverage) float64 {
+		if roster < 2 {
+			return 0
+		}
+		return math.Min(100, 100*float64(c.heard)/float64(roster-1))
+	}
+
+	// The whole net's lines are the averages over the stations in each session
+	type series struct {
+		title          string
+		heardBy, heard []float64 // NaN where the station wasn't in the session, or for heardBy didn't transmit
+	}
+	net := series{title: "Whole net"}
+	for _, session := range sessions {
+		heardBy, heard, transmitters := 0.0, 0.0, 0
+		for _, c := range session {
+			heard += hears(c)
+			if c.transmitted {
+				heardBy += c.percent
+				transmitters++
+			}
+		}
+		net.heardBy, net.heard = append(net.heardBy, heardBy/float64(transmitters)), append(net.heard, heard/float64(len(session)))
+	}
+	charts := []series{net}
+	for _, station := range stations {
+		s := series{title: station}
+		for _, session := range sessions {
+			c, present := session[station]
+			heardBy, heard := math.NaN(), math.NaN()
+			if present {
+				heard = hears(c)
+				if c.transmitted {
+					heardBy = c.percent
+				}
+			}
+			s.heardBy, s.heard = append(s.heardBy, heardBy), append(s.heard, heard)
+		}
+		charts = append(charts, s)
+	}
+
+	_, size := legendFont()
+	ascent := int(size*cfg.FontDPI/72.0 + 0.5)
+	lineHeight := int(float64(ascent)*cfg.FontLineSpacing + 0.5)
+	panel := image.Pt(ascent*14, ascent*7)
+	columns := 6
+	if len(charts) < columns {
+		columns = len(charts)
+	}
+	rowsOfCharts := (len(charts) + columns - 1) / columns
+	top := ascent + 2*lineHeight
+	width := ascent + columns*(panel.X+ascent)
+	height := top + rowsOfCharts*(panel.Y+ascent) + ascent
+
+	chartPtr := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(chartPtr, chartPtr.Bounds(), image.White, image.Point{}, draw.Src)
+	ctxPtr := newContext(chartPtr)
+	setFont(ctxPtr, legendFont)
+	write := func(text string, x, y int) {
+		if _, err := ctxPtr.DrawString(text, freetype.Pt(x, y)); err != nil {
+			fatalln("can't write on trend chart", err)
+		}
+	}
+	title := fmt.Sprintf("Coverage trend, %s to %s (%d sessions)", dates[0], dates[len(dates)-1], len(dates))
+	write(title, ascent, ascent+lineHeight/2)
+	write("Blue: share of the roster that heard the station; green: share of the roster it heard", ascent, ascent+lineHeight*3/2)
+
+	lineWidth := float64(ascent) / 6
+	for i, chart := range charts {
+		origin := image.Pt(ascent+(i%columns)*(panel.X+ascent), top+(i/columns)*(panel.Y+ascent))
+		plot := image.Rect(origin.X, origin.Y+lineHeight, origin.X+panel.X, origin.Y+panel.Y)
+		strokeRing(chartPtr, []pixelPoint{toPixelPoint(plot.Min), {float64(plot.Max.X), float64(plot.Min.Y)},
+			toPixelPoint(plot.Max), {float64(plot.Min.X), float64(plot.Max.Y)}}, 1, color.Gray{0xa0})
+		middle := float64(plot.Min.Y+plot.Max.Y) / 2
+		strokeLine(chartPtr, pixelPoint{float64(plot.Min.X), middle}, pixelPoint{float64(plot.Max.X), middle}, 1, color.Gray{0xd0}, true)
+		write(chart.title, origin.X, origin.Y+ascent)
+
+		at := func(session int, percent float64) pixelPoint {
+			x := float64(plot.Min.X+plot.Max.X) / 2
+			if len(dates) > 1 {
+				x = float64(plot.Min.X) + float64(plot.Dx()-1)*float64(session)/float64(len(dates)-1)
+			}
+			return pixelPoint{x, float64(plot.Max.Y) - float64(plot.Dy())*percent/100}
+		}
+		for _, line := range []struct {
+			values []float64
+			c      color.Color
+		}{{chart.heard, hearsColor}, {chart.heardBy, heardByColor}} {
+			for j, v := range line.values {
+				switch {
+				case math.IsNaN(v):
+				case j > 0 && !math.IsNaN(line.values[j-1]):
+					strokeLine(chartPtr, at(j-1, line.values[j-1]), at(j, v), lineWidth*2, line.c, false)
+				case j == len(line.values)-1 || math.IsNaN(line.values[j+1]):
+					// A session on its own, with no line to show it
+					fillPolygons(chartPtr, [][]pixelPoint{circle(at(j, v), lineWidth*2)}, line.c)
+				}
+			}
+		}
+	}
+
+	meta := outputMetadata(title)
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, trendChartFile), func(w io.Writer) error { return encodePNG(w, chartPtr, meta) })
+	if err != nil {
+		fatalf("Failed to write trend chart: %s", err)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Largest upload the upload page accepts, in bytes
+const maxUpload = 32 << 20
+
+// Function uploadPage serves the page for making maps from uploaded files
+func (s *mapServer) uploadPage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := uploadTemplate.Execute(w, cfg); err != nil {
+		log.Println("can't write the upload page", err)
+	}
+}
+
+// Function upload makes maps from an uploaded report file, and operator file if there is one (otherwise the
+// server's is used), and sends them back as a zip file. A file that can't be loaded gets the reason back, rather
+// than stopping the server.
+func (s *mapServer) upload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseMultipartForm(maxUpload); err != nil {
+		http.Error(w, "can't read the upload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	mapType := r.FormValue("type")
+	if mapType != xmitMapType && mapType != rcvrMapType {
+		http.Error(w, fmt.Sprintf("unknown map type %q; use %q or %q", mapType, xmitMapType, rcvrMapType), http.StatusBadRequest)
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "reception-upload")
+	if err != nil {
+		http.Error(w, "can't save the upload", http.StatusInternalServerError)
+		log.Println("can't make a directory for an upload", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	badFile := func(what string, err error) { // Without the server's temporary directory in the message
+		http.Error(w, what+": "+strings.ReplaceAll(err.Error(), dir+string(filepath.Separator), ""), http.StatusBadRequest)
+	}
+
+	reportFile, err := saveUpload(r, "reports", dir)
+	if err == nil && reportFile == "" {
+		err = fmt.Errorf("choose a report file")
+	}
+	if err != nil {
+		badFile("report file", err)
+		return
+	}
+	operatorFile, err := saveUpload(r, "operators", dir)
+	if err != nil {
+		badFile("operator file", err)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.refresh()
+	operators := s.operators
+	if operatorFile != "" {
+		s.baseMaps[0].use()
+		if operators, err = readOperators(operatorFile); err != nil {
+			badFile("operator file", err)
+			return
+		}
+	}
+	cfg.RcvMapFlag = mapType == rcvrMapType
+	loaded := &mapReports{}
+	loaded.reports, loaded.receivers, loaded.transmitters, err = readReports(reportFile)
+	if err != nil {
+		badFile("report file", err)
+		return
+	}
+
+	calls := sortedCalls(loaded.transmitters)
+	if wanted := strings.ToUpper(strings.ReplaceAll(r.FormValue("calls"), " ", "")); wanted != "" && wanted != "ALL" {
+		calls = nil
+		for _, call := range strings.Split(wanted, ",") {
+			if loaded.transmitters[call] {
+				calls = append(calls, call)
+			}
+		}
+	}
+	if len(calls) == 0 {
+		http.Error(w, "none of those call signs have reports", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="reception-maps.zip"`)
+	zw := zip.NewWriter(w)
+	for _, call := range calls {
+		mapFile := outputName(call, mapType)
+		for _, renderer := range outputRenderers() {
+			file := strings.TrimSuffix(mapFile, ".png") + renderer.extension()
+			header := &zip.FileHeader{Name: file, Method: zip.Deflate, Modified: startTime}
+			if renderer.extension() == ".png" {
+				header.Method = zip.Store // PNGs are already compressed
+			}
+			fw, err := zw.CreateHeader(header)
+			if err == nil {
+				_, err = fw.Write(s.draw(call, mapType, loaded, operators, renderer, filepath.Base(mapFile)))
+			}
+			if err != nil {
+				log.Println("can't send", file, err)
+				return
+			}
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Println("can't send zip file", err)
+	}
+}
+
+// Function saveUpload saves an uploaded file into a directory, and returns its name, or "" if none was uploaded
+func saveUpload(r *http.Request, field, dir string) (string, error) {
+	f, _, err := r.FormFile(field)
+	if err == http.ErrMissingFile {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	file := filepath.Join(dir, field+".csv")
+	out, err := os.Create(file)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, f); err != nil {
+		return "", err
+	}
+	return file, out.Close()
+}
+
+// Template for the upload page. The form posts to /upload, which sends back a zip file of the maps.
+var uploadTemplate = template.Must(template.New("upload").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Reception Maps</title>
+<style>
+body { font-family: sans-serif; margin: 2em; max-width: 40em; }
+label { display: block; margin: 1em 0 0.3em; font-weight: bold; }
+label.choice { display: inline; font-weight: normal; }
+.hint { font-size: small; color: #555; }
+button { margin-top: 1.5em; padding: 0.5em 1.5em; }
+</style>
+</head>
+<body>
+<h1>Reception Maps</h1>
+<p>Frequency: {{.Frequency}}</p>
+<form action="/upload" method="post" enctype="multipart/form-data">
+<label for="reports">Report file (CSV)</label>
+<input type="file" id="reports" name="reports" accept=".csv,text/csv" required>
+<div class="hint">One report per line: receiver, transmitter, report</div>
+
+<label for="operators">Operator file (CSV)</label>
+<input type="file" id="operators" name="operators" accept=".csv,text/csv">
+<div class="hint">Leave this out to use the club's roster</div>
+
+<label>Maps</label>
+<input type="radio" id="xmit" name="type" value="xmit" checked><label class="choice" for="xmit">Transmit (who can hear each station)</label><br>
+<input type="radio" id="rcvr" name="type" value="rcvr"><label class="choice" for="rcvr">Receive (who each station can hear)</label>
+
+<label for="calls">Call signs</label>
+<input type="text" id="calls" name="calls" size="40" placeholder="all">
+<div class="hint">Comma-separated; leave empty for every station in the report file</div>
+
+<button type="submit">Make maps</button>
+<div class="hint">The maps come back as a zip file; a large net can take a minute.</div>
+</form>
+</body>
+</html>
+`))
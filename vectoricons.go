@@ -0,0 +1,96 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"math"
+	"strings"
+)
+
+// A built-in icon drawn for a report when VectorIconFlag is set, from a [[VectorIcons]] table in reception.cfg
+type vectorIcon struct {
+	Report string  // Report (icon name) the icon is drawn for, e.g. "1", or TransIcon for the transmitter
+	Shape  string  // "circle", "triangle", "diamond", "square" or "star"
+	Color  string  // Fill color, as "#rrggbb"
+	Scale  float64 // Size relative to IconSize; 0 = IconSize
+}
+
+// Icons drawn when VectorIconFlag is set and reception.cfg has no [[VectorIcons]] tables, in the colors of the
+// PNG icons that come with the program
+var defaultVectorIcons = []vectorIcon{
+	{Report: "1", Shape: "circle", Color: "#0f9d58"},
+	{Report: "2", Shape: "triangle", Color: "#ffd600"},
+	{Report: "3", Shape: "diamond", Color: "#a52714"},
+	{Report: "Trans", Shape: "star", Color: "#0288d1"},
+}
+
+// Built-in icons for Palette = "colorblind", in colors from Okabe and Ito's palette that stay distinct with
+// red-green color blindness, on shapes that differ more than the default ones do
+var colorblindVectorIcons = []vectorIcon{
+	{Report: "1", Shape: "circle", Color: "#0072b2"},
+	{Report: "2", Shape: "triangle", Color: "#f0e442"},
+	{Report: "3", Shape: "square", Color: "#d55e00"},
+	{Report: "Trans", Shape: "star", Color: "#000000"},
+}
+
+// Color palettes for the built-in icons
+const (
+	paletteDefault    = "default"
+	paletteColorblind = "colorblind"
+)
+
+// Shapes the built-in icons can have
+var vectorShapes = map[string]shape{
+	"circle":   reportShapes[0],
+	"triangle": reportShapes[1],
+	"diamond":  regularPolygon(4, 0.95, -math.Pi/2),
+	"square":   reportShapes[2],
+	"star":     transmitterShape,
+}
+
+// Function vectorIcons draws the built-in icons, so no icon files are needed. [[VectorIcons]] tables in
+// reception.cfg replace the palette's icons.
+func vectorIcons() map[string]image.Image {
+	specs := cfg.VectorIcons
+	if len(specs) == 0 {
+		switch cfg.Palette {
+		case "", paletteDefault:
+			specs = defaultVectorIcons
+		case paletteColorblind:
+			specs = colorblindVectorIcons
+		default:
+			fatalf("unknown Palette %q; use %q or %q", cfg.Palette, paletteDefault, paletteColorblind)
+		}
+	}
+
+	icons := make(map[string]image.Image)
+	for _, v := range specs {
+		s, ok := vectorShapes[strings.ToLower(v.Shape)]
+		if !ok {
+			fatalf("unknown Shape %q for report %q in reception.cfg; use circle, triangle, diamond, square or star", v.Shape, v.Report)
+		}
+		fill, err := parseColor(v.Color)
+		if err != nil {
+			fatalf("bad Color for report %q in reception.cfg: %s", v.Report, err)
+		}
+		scale := v.Scale
+		if scale == 0 {
+			scale = 1
+		}
+		icons[v.Report] = fillShape(s, int(float64(cfg.IconSize)*scale+0.5), fill)
+	}
+	return icons
+}
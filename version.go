@@ -0,0 +1,130 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version of the program. Release builds set this with -ldflags "-X main.version=v1.2.3"; anything else is
+// a development build.
+var version = "dev"
+
+// GitHub API endpoint describing the most recent release
+const latestReleaseURL = "https://api.github.com/repos/fthiess/reception/releases/latest"
+
+// Function versionCommand prints the program version and build information, and if asked, checks GitHub for
+// a newer release. Update checks are opt-in: they happen only with -check or when UpdateCheck is set in
+// reception.cfg.
+func versionCommand(args []string) {
+	flags := flag.NewFlagSet("version", flag.ExitOnError)
+	check := flags.Bool("check", cfg.UpdateCheck, "Check GitHub for a newer release")
+	flags.Parse(args)
+
+	fmt.Printf("reception %s\n", version)
+	fmt.Printf("  go:       %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				fmt.Printf("  revision: %s\n", setting.Value)
+			case "vcs.time":
+				fmt.Printf("  built:    %s\n", setting.Value)
+			case "vcs.modified":
+				if setting.Value == "true" {
+					fmt.Println("  modified: source had uncommitted changes")
+				}
+			}
+		}
+	}
+
+	if *check {
+		checkForUpdate()
+	}
+}
+
+// Function drawWatermark stamps the lower left corner of a map with when it was generated and by which version
+// of the program, if WatermarkFlag is set, so an old map forwarded around by email can be recognized as one. It
+// returns the part of the map it covered.
+func drawWatermark(dstPtr *image.RGBA) image.Rectangle {
+	if !cfg.WatermarkFlag {
+		return image.Rectangle{}
+	}
+	return drawCornerText(dstPtr, fmt.Sprintf("Generated %s by reception %s", startTime.Format("2006-01-02 15:04 MST"), version), true)
+}
+
+// Function checkForUpdate asks GitHub for the latest release and reports whether it's newer than this one.
+// Failures are reported but aren't fatal; not being able to reach GitHub shouldn't stop anyone's work.
+func checkForUpdate() {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(latestReleaseURL)
+	if err != nil {
+		fmt.Println("Can't check for updates:", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Println("Can't check for updates:", resp.Status)
+		return
+	}
+
+	var release struct {
+		TagName string `json:"tag_name"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		fmt.Println("Can't check for updates:", err)
+		return
+	}
+
+	switch {
+	case version == "dev":
+		fmt.Printf("Latest release is %s (this is a development build)\n", release.TagName)
+	case compareVersions(release.TagName, version) > 0:
+		fmt.Printf("A newer release, %s, is available at %s\n", release.TagName, release.HTMLURL)
+	default:
+		fmt.Println("You have the latest release")
+	}
+}
+
+// Function compareVersions compares two version strings of the form v1.2.3, returning a positive number if a is
+// newer than b, a negative number if it's older, and zero if they're the same. Missing or non-numeric parts
+// count as zero.
+func compareVersions(a, b string) int {
+	aParts := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bParts := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var aNum, bNum int
+		if i < len(aParts) {
+			aNum, _ = strconv.Atoi(aParts[i])
+		}
+		if i < len(bParts) {
+			bNum, _ = strconv.Atoi(bParts[i])
+		}
+		if aNum != bNum {
+			return aNum - bNum
+		}
+	}
+	return 0
+}
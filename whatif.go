@@ -0,0 +1,208 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang/freetype"
+)
+
+// What-if gap report and map in the output directory, and the report's columns
+const (
+	whatIfFile    = "whatif.csv"
+	whatIfMapFile = "whatif-map.png"
+)
+
+var whatIfHeadings = []string{"Station", "Status", "Group Size Before", "Group Size After"}
+
+// What becomes of each station when others are taken out of the network
+const (
+	removedStation = "removed"
+	cutOffStation  = "cut off"
+	netStation     = "still in the net"
+	aloneStation   = "already cut off"
+)
+
+// Color of the ring around stations that would be cut off, and the cross over stations taken out
+var (
+	cutOffColor  = color.RGBA{0xd0, 0x20, 0x20, 0xff}
+	removedColor = color.RGBA{0x60, 0x60, 0x60, 0xff}
+)
+
+// What happens to one station in a what-if: its status, whether it was in the net to begin with, and the size
+// of its group before and after
+type whatIfStation struct {
+	call          string
+	status        string
+	wasInNet      bool
+	before, after int
+}
+
+// Function whatIf works out what happens to the net if some stations are unavailable. The net is the largest
+// group of stations that can all get messages to each other; afterwards it's whichever group keeps the most of
+// them. Stations are in call sign order.
+func whatIf(g reportGraph, removed []string) []whatIfStation {
+	groupSizes := func(groups [][]string) map[string]int {
+		sizes := make(map[string]int)
+		for _, group := range groups {
+			for _, station := range group {
+				sizes[station] = len(group)
+			}
+		}
+		return sizes
+	}
+
+	before := g.components()
+	net := make(map[string]bool)
+	for _, station := range before[0] {
+		net[station] = true
+	}
+	rest := g
+	out := make(map[string]bool)
+	for _, station := range removed {
+		rest = rest.without(station)
+		out[station] = true
+	}
+	after := rest.components()
+	var netAfter []string
+	for _, group := range after {
+		kept := 0
+		for _, station := range group {
+			if net[station] {
+				kept++
+			}
+		}
+		if kept > len(netAfter) {
+			netAfter = group
+		}
+	}
+	stillIn := make(map[string]bool)
+	for _, station := range netAfter {
+		stillIn[station] = true
+	}
+
+	sizeBefore, sizeAfter := groupSizes(before), groupSizes(after)
+	var stations []whatIfStation
+	for _, station := range g.stations() {
+		s := whatIfStation{call: station, wasInNet: net[station], before: sizeBefore[station], after: sizeAfter[station]}
+		switch {
+		case out[station]:
+			s.status = removedStation
+		case stillIn[station]:
+			s.status = netStation
+		case net[station]:
+			s.status = cutOffStation
+		default:
+			s.status = aloneStation
+		}
+		stations = append(stations, s)
+	}
+	return stations
+}
+
+// Function writeWhatIf writes the gap report: what becomes of each station, cut off stations first
+func writeWhatIf(stations []whatIfStation) {
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, whatIfFile), func(w io.Writer) error {
+		out := csv.NewWriter(w)
+		out.Write(whatIfHeadings)
+		for _, status := range []string{cutOffStation, removedStation, aloneStation, netStation} {
+			for _, s := range stations {
+				if s.status == status {
+					out.Write([]string{s.call, s.status, strconv.Itoa(s.before), strconv.Itoa(s.after)})
+				}
+			}
+		}
+		out.Flush()
+		return out.Error()
+	})
+	if err != nil {
+		fatalf("Failed to write what-if report: %s", err)
+	}
+}
+
+// Function writeWhatIfMap draws the net as it would be without the stations taken out: stations still in it
+// with the best report's icon, those cut off with the worst's and ringed, and those taken out crossed out
+func writeWhatIfMap(baseMap image.Image, icons map[string]image.Image, operators map[string]operatorData, stations []whatIfStation, removed []string) {
+	mapPtr := image.NewRGBA(baseMap.Bounds())
+	draw.Draw(mapPtr, mapPtr.Bounds(), baseMap, baseMap.Bounds().Min, draw.Src)
+	ctxPtr := newContext(mapPtr)
+
+	best, worst := cfg.TransIcon, cfg.TransIcon
+	if levels := reportLevels(icons); len(levels) > 0 {
+		best, worst = levels[0], levels[len(levels)-1]
+	}
+	size := float64(cfg.IconSize)
+	cutOff := 0
+	for _, s := range stations {
+		op := lookupOperator(operators, s.call)
+		if op.callsign == "" {
+			continue
+		}
+		p := toPixelPoint(op.pixel)
+		switch s.status {
+		case removedStation:
+			r := size / 2
+			strokeLine(mapPtr, pixelPoint{p.x - r, p.y - r}, pixelPoint{p.x + r, p.y + r}, size/6, removedColor, false)
+			strokeLine(mapPtr, pixelPoint{p.x - r, p.y + r}, pixelPoint{p.x + r, p.y - r}, size/6, removedColor, false)
+			pt := labelPoint(op.callsign, op.pixel, image.Pt(int(cfg.IconSize), int(cfg.IconSize)), mapPtr.Bounds())
+			if _, err := ctxPtr.DrawString(op.callsign, pt); err != nil {
+				fatalln("can't plot what-if map label", err)
+			}
+		case netStation:
+			plotIcon(mapPtr, icons[best], best, op, ctxPtr)
+		default:
+			if s.status == cutOffStation {
+				cutOff++
+				strokeRing(mapPtr, circle(p, size*0.75), size/8, cutOffColor)
+			}
+			plotIcon(mapPtr, icons[worst], worst, op, ctxPtr)
+		}
+	}
+
+	legend := []string{"Without " + strings.Join(removed, ", ") + ": " + cfg.Frequency,
+		fmt.Sprintf("Ringed: %d stations cut off from the net; crossed out: unavailable", cutOff)}
+	_, fontSize := legendFont()
+	setFont(ctxPtr, legendFont)
+	ascent := int(fontSize*cfg.FontDPI/72.0 + 0.5)
+	for i, line := range legend {
+		pt := freetype.Pt(ascent, ascent*2+i*int(float64(ascent)*cfg.FontLineSpacing+0.5))
+		if _, err := ctxPtr.DrawString(line, pt); err != nil {
+			fatalln("can't plot what-if map legend", err)
+		}
+	}
+	if cfg.ScaleBarFlag {
+		drawScaleBar(mapPtr, newGeoref(baseMap).metersPerPixel())
+	}
+	if cfg.NorthArrowFlag {
+		drawNorthArrow(mapPtr)
+	}
+	drawAttribution(mapPtr)
+	drawWatermark(mapPtr)
+
+	meta := outputMetadata(legend[0], pngText{"Cut off", fmt.Sprint(cutOff)})
+	err := writeFileAtomic(filepath.Join(cfg.OutputDirectory, whatIfMapFile), func(w io.Writer) error { return encodePNG(w, mapPtr, meta) })
+	if err != nil {
+		fatalf("Failed to write what-if map: %s", err)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Function writeZip packs files from the output directory into a single timestamped zip archive in the output
+// directory, ready to attach to the after-net email, and returns the archive's path. File names are relative
+// to the output directory, and keep their relative paths inside the archive so the index page's links work.
+func writeZip(files []string) string {
+	zipFile := filepath.Join(cfg.OutputDirectory, "reception-"+startTime.Format("20060102-150405")+".zip")
+
+	err := writeFileAtomic(zipFile, func(w io.Writer) error {
+		zw := zip.NewWriter(w)
+		for _, file := range files {
+			addToZip(zw, file)
+		}
+		return zw.Close()
+	})
+	if err != nil {
+		fatalf("Failed to write zip file: %s", err)
+	}
+
+	return zipFile
+}
+
+// Function addToZip copies one file from the output directory into a zip archive
+func addToZip(zw *zip.Writer, file string) {
+	addFileToZip(zw, filepath.Join(cfg.OutputDirectory, file), file)
+}
+
+// Function addFileToZip copies a file into a zip archive under the given name, which is "/"-separated
+func addFileToZip(zw *zip.Writer, source, file string) {
+	r, err := os.Open(source)
+	if err != nil {
+		fatalln("can't open", file, "for zip file", err)
+	}
+	defer r.Close()
+
+	info, err := r.Stat()
+	if err != nil {
+		fatalln("can't read", file, "for zip file", err)
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		fatalln("can't add", file, "to zip file", err)
+	}
+	header.Name = file
+	header.Method = zip.Deflate
+	if filepath.Ext(file) == ".png" {
+		header.Method = zip.Store // PNGs are already compressed
+	}
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		fatalln("can't add", file, "to zip file", err)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		fatalln("can't add", file, "to zip file", err)
+	}
+}